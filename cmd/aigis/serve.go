@@ -21,7 +21,14 @@ var serveCmd = &cobra.Command{
 			logLevel = "info"
 		}
 
-		globalLogger, err := logger.New(logLevel)
+		// log.file 为空时输出到 stdout
+		globalLogger, err := logger.NewWithOptions(logger.Options{
+			Level:      logLevel,
+			Format:     viper.GetString("log.format"),
+			File:       viper.GetString("log.file"),
+			MaxSizeMB:  viper.GetInt("log.max_size_mb"),
+			MaxBackups: viper.GetInt("log.max_backups"),
+		})
 		if err != nil {
 			return fmt.Errorf("failed to initialize logger: %w", err)
 		}
@@ -9,7 +9,10 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/spf13/viper"
 
+	"aigis/internal/core"
+	"aigis/internal/core/audit"
 	"aigis/internal/core/engine"
+	"aigis/internal/core/vault"
 )
 
 // findEnvFile 向上递归查找 .env 文件
@@ -74,6 +77,17 @@ func Init(cfgFile string) {
 	}
 }
 
+// Reload 重新读取配置文件（未使用配置文件时，例如测试中直接注入的配置，不做任何事）
+func Reload() error {
+	if viper.ConfigFileUsed() == "" {
+		return nil
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to reload config file: %w", err)
+	}
+	return nil
+}
+
 // LoadEngineConfig loads and returns the engine configuration from viper
 func LoadEngineConfig() (*engine.EngineConfig, error) {
 	var config engine.EngineConfig
@@ -85,3 +99,36 @@ func LoadEngineConfig() (*engine.EngineConfig, error) {
 
 	return &config, nil
 }
+
+// LoadAuditConfig loads and returns the audit webhook configuration from viper
+func LoadAuditConfig() (*audit.Config, error) {
+	var config audit.Config
+
+	if err := viper.UnmarshalKey("audit", &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal audit config: %w", err)
+	}
+
+	return &config, nil
+}
+
+// LoadPipelineConfig loads and returns the processor pipeline configuration from viper
+func LoadPipelineConfig() (*core.PipelineConfig, error) {
+	var config core.PipelineConfig
+
+	if err := viper.UnmarshalKey("pipeline", &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pipeline config: %w", err)
+	}
+
+	return &config, nil
+}
+
+// LoadVaultConfig loads and returns the secret vault backend configuration from viper
+func LoadVaultConfig() (*vault.Config, error) {
+	var config vault.Config
+
+	if err := viper.UnmarshalKey("vault", &config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault config: %w", err)
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"aigis/internal/core/engine"
+)
+
+// Validate checks the engine configuration for mistakes that would otherwise only surface
+// as failed requests at runtime: missing base URLs, unknown transform types and auth
+// strategies, and credentials whose environment variables are not set. Every problem is
+// reported (prefixed with the offending route) in a single joined error. gatewayAuth tells
+// whether inbound API keys (server.api_keys) are enabled, which rules out passthrough auth.
+func Validate(cfg *engine.EngineConfig, gatewayAuth bool) error {
+	var problems []error
+	for i, route := range cfg.Routes {
+		name := route.ID
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+			problems = append(problems, fmt.Errorf("route %s: missing id", name))
+		}
+		report := func(format string, args ...any) {
+			problems = append(problems, fmt.Errorf("route %s: "+format, append([]any{name}, args...)...))
+		}
+		validate := func(field string, upstream engine.Upstream) {
+			validateUpstream(field, upstream, report)
+			// The client's Authorization header carries the gateway key when gateway auth is on,
+			// so passing it through would hand that key to the upstream
+			if gatewayAuth && upstream.AuthStrategy == engine.AuthStrategyPassthrough {
+				report("%s: auth_strategy %q cannot be used with gateway authentication (server.api_keys)", field, upstream.AuthStrategy)
+			}
+		}
+
+		if len(route.Upstreams) == 0 {
+			validate("upstream", route.Upstream)
+		}
+		for j, upstream := range route.Upstreams {
+			validate(fmt.Sprintf("upstreams[%d]", j), upstream.Upstream)
+		}
+		for j, upstream := range route.FanOut {
+			validate(fmt.Sprintf("fan_out[%d]", j), upstream)
+		}
+		if route.Fallback != nil {
+			validate("fallback", *route.Fallback)
+		}
+		if route.Shadow != nil {
+			validate("shadow", *route.Shadow)
+		}
+
+		if route.MaxConcurrency < 0 || route.ConcurrencyWaitMs < 0 {
+			report("max_concurrency and concurrency_wait_ms must not be negative")
+		}
+
+		for j, step := range route.Transforms {
+			if !engine.KnownTransformType(step.Type) {
+				report("transforms[%d]: unknown transform type %q", j, step.Type)
+			}
+			// Skipping a timed-out PII step would forward the secrets unmasked
+			if step.FailOpen && engine.IsPIITransform(step.Type) {
+				report("transforms[%d]: fail_open cannot be used with %s (PII steps always fail closed)", j, step.Type)
+			}
+			switch step.Type {
+			case engine.TransformTypeRegexReplace:
+				if _, err := step.RegexPattern(); err != nil {
+					report("transforms[%d]: %v", j, err)
+				}
+			case engine.TransformTypeClampParam:
+				if _, err := strconv.ParseFloat(step.Config["max"], 64); err != nil {
+					report("transforms[%d]: %s requires a numeric max, got %q", j, step.Type, step.Config["max"])
+				}
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// validateUpstream reports configuration problems of a single upstream
+func validateUpstream(field string, upstream engine.Upstream, report func(format string, args ...any)) {
+	if upstream.BaseURL == "" {
+		report("%s: missing base_url", field)
+	}
+	// env:VAR references in any field must point at set variables
+	if _, err := upstream.Resolve(); err != nil {
+		report("%s: %v", field, err)
+	}
+
+	requireEnv := func(key, name string) {
+		if name == "" {
+			report("%s: %s is required for auth_strategy %q", field, key, upstream.AuthStrategy)
+		} else if os.Getenv(name) == "" {
+			report("%s: environment variable %s (%s) is not set", field, name, key)
+		}
+	}
+
+	switch upstream.AuthStrategy {
+	case engine.AuthStrategyBearer, engine.AuthStrategyHeader, engine.AuthStrategyQuery:
+		requireEnv("token_env", upstream.TokenEnv)
+	case "":
+		// No explicit strategy: a configured token is sent as a bearer token, so it must exist
+		if upstream.TokenEnv != "" {
+			requireEnv("token_env", upstream.TokenEnv)
+		}
+	case engine.AuthStrategyAWSSigV4:
+		if upstream.AWSRegion == "" {
+			report("%s: aws_region is required for auth_strategy %q", field, upstream.AuthStrategy)
+		}
+		requireEnv("access_key_env", envOrDefault(upstream.AccessKeyEnv, "AWS_ACCESS_KEY_ID"))
+		requireEnv("secret_key_env", envOrDefault(upstream.SecretKeyEnv, "AWS_SECRET_ACCESS_KEY"))
+	case engine.AuthStrategyOAuth2:
+		if upstream.TokenURL == "" {
+			report("%s: token_url is required for auth_strategy %q", field, upstream.AuthStrategy)
+		}
+		requireEnv("client_id_env", upstream.ClientIDEnv)
+		requireEnv("client_secret_env", upstream.ClientSecretEnv)
+	}
+	if (upstream.TLSClientCert == "") != (upstream.TLSClientKey == "") {
+		report("%s: tls_client_cert and tls_client_key must be set together", field)
+	}
+	if upstream.SignatureSecretEnv != "" {
+		requireEnv("signature_secret_env", upstream.SignatureSecretEnv)
+		switch upstream.SignatureAlgorithm {
+		case "", engine.SignatureHMACSHA256, engine.SignatureHMACSHA512:
+		default:
+			report("%s: unknown signature_algorithm %q", field, upstream.SignatureAlgorithm)
+		}
+	}
+	if !engine.KnownAuthStrategy(upstream.AuthStrategy) {
+		report("%s: unknown auth_strategy %q", field, upstream.AuthStrategy)
+	}
+}
+
+// envOrDefault returns name, or def when name is empty
+func envOrDefault(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"aigis/internal/core/engine"
+)
+
+func validRoute(id string) engine.Route {
+	return engine.Route{
+		ID:       id,
+		Upstream: engine.Upstream{BaseURL: "https://api.example.com"},
+	}
+}
+
+func TestValidateAcceptsValidConfig(t *testing.T) {
+	t.Setenv("VALIDATE_TEST_TOKEN", "secret")
+	route := validRoute("ok")
+	route.Upstream.AuthStrategy = engine.AuthStrategyBearer
+	route.Upstream.TokenEnv = "VALIDATE_TEST_TOKEN"
+	route.Transforms = []engine.TransformStep{{Type: engine.TransformTypePII}, {Type: engine.TransformTypeFieldMap}}
+
+	none := validRoute("none")
+	none.Upstream.AuthStrategy = engine.AuthStrategyNone
+
+	passthrough := validRoute("passthrough")
+	passthrough.Upstream.AuthStrategy = engine.AuthStrategyPassthrough
+
+	if err := Validate(&engine.EngineConfig{Routes: []engine.Route{route, validRoute("plain"), none, passthrough}}, false); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateReportsProblems(t *testing.T) {
+	t.Setenv("VALIDATE_TEST_UNSET", "")
+
+	tests := []struct {
+		name   string
+		mutate func(*engine.Route)
+		want   string
+	}{
+		{"missing base url", func(r *engine.Route) { r.Upstream.BaseURL = "" }, "route bad: upstream: missing base_url"},
+		{"unknown transform", func(r *engine.Route) {
+			r.Transforms = []engine.TransformStep{{Type: engine.TransformTypePII}, {Type: "pii_magic"}}
+		}, `route bad: transforms[1]: unknown transform type "pii_magic"`},
+		{"invalid regex_replace", func(r *engine.Route) {
+			r.Transforms = []engine.TransformStep{{Type: engine.TransformTypeRegexReplace, Config: map[string]string{"path": "prompt", "pattern": "(x"}}}
+		}, "route bad: transforms[0]: invalid regex_replace pattern"},
+		{"clamp without max", func(r *engine.Route) {
+			r.Transforms = []engine.TransformStep{{Type: engine.TransformTypeClampParam, Config: map[string]string{"path": "max_tokens"}}}
+		}, `route bad: transforms[0]: clamp_param requires a numeric max, got ""`},
+		{"fail_open pii", func(r *engine.Route) {
+			r.Transforms = []engine.TransformStep{{Type: engine.TransformTypePIIClaude, TimeoutMs: 100, FailOpen: true}}
+		}, "route bad: transforms[0]: fail_open cannot be used with pii_claude"},
+		{"unknown auth strategy", func(r *engine.Route) { r.Upstream.AuthStrategy = "basic" }, `route bad: upstream: unknown auth_strategy "basic"`},
+		{"unset signing secret", func(r *engine.Route) { r.Upstream.SignatureSecretEnv = "VALIDATE_TEST_UNSET" },
+			"environment variable VALIDATE_TEST_UNSET (signature_secret_env) is not set"},
+		{"unknown signature algorithm", func(r *engine.Route) {
+			t.Setenv("VALIDATE_TEST_SIGNING", "secret")
+			r.Upstream.SignatureSecretEnv = "VALIDATE_TEST_SIGNING"
+			r.Upstream.SignatureAlgorithm = "md5"
+		}, `route bad: upstream: unknown signature_algorithm "md5"`},
+		{"missing token env", func(r *engine.Route) {
+			r.Upstream.AuthStrategy = engine.AuthStrategyHeader
+			r.Upstream.TokenEnv = "VALIDATE_TEST_UNSET"
+		}, "route bad: upstream: environment variable VALIDATE_TEST_UNSET (token_env) is not set"},
+		{"token env not configured", func(r *engine.Route) { r.Upstream.AuthStrategy = engine.AuthStrategyQuery }, `token_env is required for auth_strategy "query"`},
+		{"default strategy with unset token", func(r *engine.Route) { r.Upstream.TokenEnv = "VALIDATE_TEST_UNSET" }, "VALIDATE_TEST_UNSET (token_env) is not set"},
+		{"unset env reference", func(r *engine.Route) { r.Upstream.BaseURL = "env:VALIDATE_TEST_UNSET" }, "VALIDATE_TEST_UNSET"},
+		{"oauth2 without token url", func(r *engine.Route) {
+			r.Upstream.AuthStrategy = engine.AuthStrategyOAuth2
+		}, `token_url is required for auth_strategy "oauth2"`},
+		{"sigv4 without region", func(r *engine.Route) {
+			r.Upstream.AuthStrategy = engine.AuthStrategyAWSSigV4
+		}, `aws_region is required for auth_strategy "aws_sigv4"`},
+		{"weighted upstream", func(r *engine.Route) {
+			r.Upstreams = []engine.WeightedUpstream{{Upstream: engine.Upstream{BaseURL: "https://a"}}, {}}
+		}, "route bad: upstreams[1]: missing base_url"},
+		{"fallback", func(r *engine.Route) { r.Fallback = &engine.Upstream{} }, "route bad: fallback: missing base_url"},
+		{"missing id", func(r *engine.Route) { r.ID = "" }, "route #0: missing id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := validRoute("bad")
+			tt.mutate(&route)
+			err := Validate(&engine.EngineConfig{Routes: []engine.Route{route}}, false)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsPassthroughWithGatewayAuth(t *testing.T) {
+	route := validRoute("passthrough")
+	route.Upstream.AuthStrategy = engine.AuthStrategyPassthrough
+	fallback := route
+	fallback.ID = "fallback"
+	fallback.Upstream.AuthStrategy = ""
+	fallback.Fallback = &engine.Upstream{BaseURL: "https://fallback", AuthStrategy: engine.AuthStrategyPassthrough}
+	cfg := &engine.EngineConfig{Routes: []engine.Route{route, fallback}}
+
+	if err := Validate(cfg, false); err != nil {
+		t.Errorf("passthrough without gateway auth should be valid, got %v", err)
+	}
+	err := Validate(cfg, true)
+	for _, want := range []string{
+		`route passthrough: upstream: auth_strategy "passthrough" cannot be used with gateway authentication`,
+		`route fallback: fallback: auth_strategy "passthrough" cannot be used with gateway authentication`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() = %v, want error containing %q", err, want)
+		}
+	}
+}
+
+func TestValidateAggregatesAllProblems(t *testing.T) {
+	first := validRoute("first")
+	first.Upstream.BaseURL = ""
+	second := validRoute("second")
+	second.Upstream.AuthStrategy = "magic"
+	second.Transforms = []engine.TransformStep{{Type: "nope"}}
+
+	err := Validate(&engine.EngineConfig{Routes: []engine.Route{first, validRoute("fine"), second}}, false)
+	if err == nil {
+		t.Fatal("Validate() = nil, want errors")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 problems, got %d:\n%v", len(lines), err)
+	}
+	for _, want := range []string{"route first:", "route second: upstream: unknown", "route second: transforms[0]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+)
+
+// EventTypePIIDetected is emitted when masking found sensitive data in a request
+const EventTypePIIDetected = "pii_detected"
+
+// Config defines the audit webhook configuration
+type Config struct {
+	// WebhookURL is the endpoint audit events are POSTed to (empty disables auditing)
+	WebhookURL string `mapstructure:"webhook_url"`
+	// Rules limits which scanner rules trigger events (empty means all rules)
+	Rules []string `mapstructure:"rules"`
+	// QueueSize bounds the number of pending events (default: 1000)
+	QueueSize int `mapstructure:"queue_size"`
+	// MaxRetries is the number of retries after a failed delivery (default: 3, negative disables retries)
+	MaxRetries int `mapstructure:"max_retries"`
+	// TimeoutMs is the per-delivery HTTP timeout in milliseconds (default: 5000)
+	TimeoutMs int `mapstructure:"timeout_ms"`
+}
+
+// Event is an audit record. It carries rule names and counts only - never the detected values.
+type Event struct {
+	Type      string         `json:"type"`
+	RequestID string         `json:"request_id"`
+	TraceID   string         `json:"trace_id"`
+	RouteID   string         `json:"route_id"`
+	Rules     map[string]int `json:"rules"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// Emitter delivers audit events to a webhook asynchronously.
+// Events are queued in a bounded buffer and dropped when it is full,
+// so webhook slowness never impacts request latency.
+type Emitter struct {
+	config  Config
+	rules   map[string]bool
+	client  *http.Client
+	queue   chan Event
+	log     *zap.Logger
+	backoff time.Duration
+	done    chan struct{}
+	// mu guards closed: Emit holds the read lock while queueing so Close never closes the
+	// queue under a pending send
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewEmitter creates an emitter and starts its delivery worker
+func NewEmitter(config Config, log *zap.Logger) *Emitter {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+	if config.TimeoutMs <= 0 {
+		config.TimeoutMs = 5000
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+
+	rules := make(map[string]bool, len(config.Rules))
+	for _, rule := range config.Rules {
+		rules[rule] = true
+	}
+
+	e := &Emitter{
+		config:  config,
+		rules:   rules,
+		client:  &http.Client{Timeout: time.Duration(config.TimeoutMs) * time.Millisecond},
+		queue:   make(chan Event, config.QueueSize),
+		log:     log,
+		backoff: 200 * time.Millisecond,
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Emit queues a PII detection event built from the request's mask counts.
+// It never blocks; returns false if nothing was detected or the queue is full.
+func (e *Emitter) Emit(ctx *core.AIGisContext, routeID string) bool {
+	counts := make(map[string]int)
+	for rule, count := range ctx.MaskCounts() {
+		if len(e.rules) == 0 || e.rules[rule] {
+			counts[rule] = count
+		}
+	}
+	if len(counts) == 0 {
+		return false
+	}
+
+	event := Event{
+		Type:      EventTypePIIDetected,
+		RequestID: ctx.RequestID,
+		TraceID:   ctx.TraceID,
+		RouteID:   routeID,
+		Rules:     counts,
+		Timestamp: time.Now().UTC(),
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		e.log.Warn("Audit emitter closed, dropping event",
+			zap.String("request_id", event.RequestID),
+		)
+		return false
+	}
+	select {
+	case e.queue <- event:
+		return true
+	default:
+		e.log.Warn("Audit queue full, dropping event",
+			zap.String("request_id", event.RequestID),
+		)
+		return false
+	}
+}
+
+// Close stops accepting events and waits for queued events to be delivered. Events emitted
+// afterwards (e.g. by requests still finishing during shutdown) are dropped.
+func (e *Emitter) Close() {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+}
+
+// run delivers queued events until the queue is closed
+func (e *Emitter) run() {
+	defer close(e.done)
+	for event := range e.queue {
+		if err := e.deliver(event); err != nil {
+			e.log.Error("Failed to deliver audit event",
+				zap.String("request_id", event.RequestID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// deliver POSTs an event, retrying with exponential backoff
+func (e *Emitter) deliver(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	backoff := e.backoff
+	for attempt := 0; ; attempt++ {
+		err = e.post(payload)
+		if err == nil {
+			return nil
+		}
+		if attempt >= e.config.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a single delivery attempt
+func (e *Emitter) post(payload []byte) error {
+	resp, err := e.client.Post(e.config.WebhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
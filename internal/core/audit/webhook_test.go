@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+	"aigis/internal/core/security"
+)
+
+// newMaskedContext returns a context whose vault and mask counts were populated by the scanner
+func newMaskedContext(text string) *core.AIGisContext {
+	ctx := core.NewGatewayContext(context.Background(), zap.NewNop())
+	ctx.RequestID = "req_audit"
+	ctx.TraceID = "trace-audit"
+	security.NewScanner().Mask(ctx, text, nil)
+	return ctx
+}
+
+func TestEmitterDeliversValueFreeEvent(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer webhook.Close()
+
+	emitter := NewEmitter(Config{WebhookURL: webhook.URL}, nil)
+	defer emitter.Close()
+
+	ctx := newMaskedContext("Mail alice@example.com or bob@example.com, call 13800138000")
+	if !emitter.Emit(ctx, "openai-default") {
+		t.Fatal("Emit should queue an event when PII was masked")
+	}
+
+	var raw []byte
+	select {
+	case raw = <-bodies:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Webhook was not called")
+	}
+
+	var event Event
+	if err := json.Unmarshal(raw, &event); err != nil {
+		t.Fatalf("Invalid event payload: %v", err)
+	}
+	if event.Type != EventTypePIIDetected || event.RequestID != "req_audit" || event.RouteID != "openai-default" {
+		t.Errorf("Unexpected event metadata: %+v", event)
+	}
+	if event.Rules["Email"] != 2 || event.Rules["Mobile Phone"] != 1 {
+		t.Errorf("Unexpected rule counts: %v", event.Rules)
+	}
+	for _, secret := range []string{"alice@example.com", "bob@example.com", "13800138000"} {
+		if strings.Contains(string(raw), secret) {
+			t.Errorf("Event payload must not contain detected values, found %q", secret)
+		}
+	}
+}
+
+func TestEmitterRuleFilterAndRetry(t *testing.T) {
+	var attempts atomic.Int32
+	bodies := make(chan []byte, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer webhook.Close()
+
+	emitter := NewEmitter(Config{WebhookURL: webhook.URL, Rules: []string{"Email"}}, nil)
+	emitter.backoff = time.Millisecond
+	defer emitter.Close()
+
+	// Only phone numbers: filtered out, no event
+	if emitter.Emit(newMaskedContext("call 13800138000"), "r1") {
+		t.Error("Emit should skip events for rules that are not configured")
+	}
+
+	if !emitter.Emit(newMaskedContext("mail alice@example.com, call 13800138000"), "r1") {
+		t.Fatal("Emit should queue an event for a configured rule")
+	}
+
+	select {
+	case raw := <-bodies:
+		var event Event
+		json.Unmarshal(raw, &event)
+		if _, ok := event.Rules["Mobile Phone"]; ok {
+			t.Errorf("Filtered rules should not be reported: %v", event.Rules)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Webhook was not called after retry")
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("Expected 2 delivery attempts, got %d", got)
+	}
+}
+
+func TestEmitterDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer webhook.Close()
+	defer close(release)
+
+	emitter := NewEmitter(Config{WebhookURL: webhook.URL, QueueSize: 1, MaxRetries: -1}, nil)
+
+	ctx := newMaskedContext("mail alice@example.com")
+	start := time.Now()
+	queued := 0
+	for i := 0; i < 5; i++ {
+		if emitter.Emit(ctx, "r1") {
+			queued++
+		}
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Emit must not block on a slow webhook")
+	}
+	if queued >= 5 {
+		t.Error("Events beyond the queue capacity should be dropped")
+	}
+}
+
+func TestEmitterDropsEventsAfterClose(t *testing.T) {
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer webhook.Close()
+
+	emitter := NewEmitter(Config{WebhookURL: webhook.URL, MaxRetries: -1}, nil)
+	ctx := newMaskedContext("mail alice@example.com")
+
+	// Requests still finishing during shutdown may emit concurrently with Close
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			emitter.Emit(ctx, "r1")
+		}()
+	}
+	emitter.Close()
+	wg.Wait()
+
+	if emitter.Emit(ctx, "r1") {
+		t.Error("Emit after Close should drop the event")
+	}
+	emitter.Close() // idempotent
+}
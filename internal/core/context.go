@@ -2,12 +2,34 @@ package core
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// Well-known metadata keys
+const (
+	// MetaUnmaskHits counts placeholders restored from the vault during unmasking
+	MetaUnmaskHits = "unmask_hits"
+	// MetaUnmaskMisses counts placeholders that were not found in the vault
+	MetaUnmaskMisses = "unmask_misses"
+	// MetaClientModel holds the model name the client originally requested
+	MetaClientModel = "client_model"
+	// MetaMaskingMissed is set to true when a PII step expecting masking masked nothing
+	MetaMaskingMissed = "masking_missed"
+	// MetaRequestMethod holds the HTTP method of the client request
+	MetaRequestMethod = "request_method"
+	// MetaRequestPath holds the client-facing path of the request, e.g. /v1/embeddings
+	MetaRequestPath = "request_path"
+	// MetaResponseStatus holds the HTTP status code finally returned to the client
+	MetaResponseStatus = "response_status"
+	// MetaError holds the client-safe message of the error that failed the request
+	MetaError = "error"
+)
+
 // AIGisContext extends standard context with gateway-specific fields
 type AIGisContext struct {
 	context.Context
@@ -22,8 +44,27 @@ type AIGisContext struct {
 
 	// Vault stores placeholder -> original secret mappings for bidirectional tokenization
 	// Map: "__AIGIS_SEC_a1b2c3d4e5f6__" -> "sk-real-key"
-	secretVault map[string]string
+	secretVault Vault
 	vaultMu     sync.RWMutex
+	// vaultLimit caps the number of vault entries (0 = unlimited); secrets beyond it are
+	// counted in vaultOverflow and left unmasked, or fail the request with failOnOverflow
+	vaultLimit     int
+	vaultOverflow  int
+	failOnOverflow bool
+
+	// maskCounts tallies masked secrets per scanner rule for this request
+	maskCounts map[string]int
+
+	// responseHeaders are extra headers to send back to the client (guarded by mu)
+	responseHeaders http.Header
+
+	// sanitizer redacts logged bodies with the matched route's rules (guarded by mu)
+	sanitizer Sanitizer
+}
+
+// Sanitizer redacts sensitive values from text that is about to be logged
+type Sanitizer interface {
+	Sanitize(input string) string
 }
 
 // NewGatewayContext creates a new GatewayContext
@@ -33,10 +74,42 @@ func NewGatewayContext(ctx context.Context, logger *zap.Logger) *AIGisContext {
 		StartTime:   time.Now(),
 		Log:         logger,
 		metadata:    make(map[string]interface{}),
-		secretVault: make(map[string]string),
+		secretVault: NewMemoryVault(),
+		maskCounts:  make(map[string]int),
+
+		responseHeaders: make(http.Header),
 	}
 }
 
+// SetResponseHeader sets a header to be returned to the client (thread-safe)
+func (c *AIGisContext) SetResponseHeader(key, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responseHeaders.Set(key, value)
+}
+
+// ResponseHeaders returns a copy of the headers to be returned to the client (thread-safe)
+func (c *AIGisContext) ResponseHeaders() http.Header {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.responseHeaders.Clone()
+}
+
+// SetSanitizer sets the sanitizer for logged bodies, normally the matched route's scanner
+// so its custom rules apply (thread-safe)
+func (c *AIGisContext) SetSanitizer(sanitizer Sanitizer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sanitizer = sanitizer
+}
+
+// Sanitizer returns the sanitizer for logged bodies, or nil if none was set (thread-safe)
+func (c *AIGisContext) Sanitizer() Sanitizer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sanitizer
+}
+
 // SetMetadata sets a metadata value (thread-safe)
 func (c *AIGisContext) SetMetadata(key string, value interface{}) {
 	c.mu.Lock()
@@ -52,6 +125,17 @@ func (c *AIGisContext) GetMetadata(key string) (interface{}, bool) {
 	return v, ok
 }
 
+// IncrMetadata adds delta to an integer metadata value and returns the new value (thread-safe)
+// Missing or non-integer values are treated as zero
+func (c *AIGisContext) IncrMetadata(key string, delta int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current, _ := c.metadata[key].(int)
+	current += delta
+	c.metadata[key] = current
+	return current
+}
+
 // Metadata returns a copy of all metadata (thread-safe)
 func (c *AIGisContext) Metadata() map[string]interface{} {
 	c.mu.RLock()
@@ -63,31 +147,139 @@ func (c *AIGisContext) Metadata() map[string]interface{} {
 	return copy
 }
 
-// VaultStore stores a placeholder -> original secret mapping (thread-safe)
+// SetVault replaces the context's vault (in-memory by default), e.g. with a shared backend
+// keyed by the request ID. Call it before anything is masked (thread-safe)
+func (c *AIGisContext) SetVault(vault Vault) {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+	c.secretVault = vault
+}
+
+// SetVaultLimit caps the number of vault entries for this request (0 = unlimited).
+// With failOnOverflow, VaultOverflowError reports the request as failed once the cap is hit.
+func (c *AIGisContext) SetVaultLimit(limit int, failOnOverflow bool) {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+	c.vaultLimit = limit
+	c.failOnOverflow = failOnOverflow
+}
+
+// VaultAdmit reports whether placeholder can be stored: it is already in the vault or the
+// vault is below its limit. Rejected placeholders are counted as overflow and the first one
+// logs a warning (thread-safe)
+func (c *AIGisContext) VaultAdmit(placeholder string) bool {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+	return c.admitLocked(placeholder)
+}
+
+func (c *AIGisContext) admitLocked(placeholder string) bool {
+	if c.vaultLimit <= 0 || c.secretVault.Len() < c.vaultLimit {
+		return true
+	}
+	if _, exists := c.secretVault.Get(placeholder); exists {
+		return true
+	}
+	c.vaultOverflow++
+	if c.vaultOverflow == 1 && c.Log != nil {
+		c.Log.Warn("Vault entry limit reached, further secrets are not masked",
+			zap.String("request_id", c.RequestID),
+			zap.Int("max_vault_entries", c.vaultLimit),
+		)
+	}
+	return false
+}
+
+// VaultOverflow returns the number of secrets rejected because the vault was full (thread-safe)
+func (c *AIGisContext) VaultOverflow() int {
+	c.vaultMu.RLock()
+	defer c.vaultMu.RUnlock()
+	return c.vaultOverflow
+}
+
+// VaultOverflowError returns a validation error when the vault overflowed and the limit was
+// set with failOnOverflow, nil otherwise (thread-safe)
+func (c *AIGisContext) VaultOverflowError() error {
+	c.vaultMu.RLock()
+	defer c.vaultMu.RUnlock()
+	if c.vaultOverflow == 0 || !c.failOnOverflow {
+		return nil
+	}
+	return NewGatewayError(ErrCategoryValidation, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("request contains more than %d secrets to mask", c.vaultLimit), nil)
+}
+
+// VaultStore stores a placeholder -> original secret mapping (thread-safe).
+// New placeholders are dropped once the vault limit is reached (see VaultAdmit)
 func (c *AIGisContext) VaultStore(placeholder, original string) {
 	c.vaultMu.Lock()
 	defer c.vaultMu.Unlock()
-	c.secretVault[placeholder] = original
+	if c.admitLocked(placeholder) {
+		c.secretVault.Store(placeholder, original)
+	}
+}
+
+// VaultClear removes all vault mappings and resets the overflow count, so a reused context
+// starts with an empty vault; the limit and mask counts are kept (thread-safe)
+func (c *AIGisContext) VaultClear() {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+	c.secretVault.Clear()
+	c.vaultOverflow = 0
 }
 
 // VaultGet retrieves the original secret for a placeholder (thread-safe)
 // Returns (original, true) if found, ("", false) otherwise
 func (c *AIGisContext) VaultGet(placeholder string) (string, bool) {
-	c.vaultMu.RLock()
-	defer c.vaultMu.RUnlock()
-	original, ok := c.secretVault[placeholder]
-	return original, ok
+	// Vaults are safe for concurrent use; a shared backend may read over the network on a
+	// miss, which must not hold up other lookups and stores
+	return c.vault().Get(placeholder)
 }
 
 // VaultGetAll returns a copy of all vault mappings (thread-safe)
 // For debug/logging purposes
 func (c *AIGisContext) VaultGetAll() map[string]string {
+	return c.vault().All()
+}
+
+// VaultFlush persists the mappings a shared vault buffered while the request was masked, in
+// one round trip, so another instance can Open them. Call it once masking is done (thread-safe)
+func (c *AIGisContext) VaultFlush() {
+	c.vault().Flush()
+}
+
+// vault returns the current vault (thread-safe)
+func (c *AIGisContext) vault() Vault {
 	c.vaultMu.RLock()
 	defer c.vaultMu.RUnlock()
-	copy := make(map[string]string, len(c.secretVault))
-	for k, v := range c.secretVault {
+	return c.secretVault
+}
+
+// RecordMask increments the masked secret count for a scanner rule (thread-safe)
+func (c *AIGisContext) RecordMask(rule string) {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+	c.maskCounts[rule]++
+}
+
+// MaskCounts returns a copy of the per-rule masked secret counts (thread-safe)
+func (c *AIGisContext) MaskCounts() map[string]int {
+	c.vaultMu.RLock()
+	defer c.vaultMu.RUnlock()
+	copy := make(map[string]int, len(c.maskCounts))
+	for k, v := range c.maskCounts {
 		copy[k] = v
 	}
 	return copy
 }
 
+// MaskedTotal returns the total number of secrets masked in this request (thread-safe)
+func (c *AIGisContext) MaskedTotal() int {
+	c.vaultMu.RLock()
+	defer c.vaultMu.RUnlock()
+	total := 0
+	for _, count := range c.maskCounts {
+		total += count
+	}
+	return total
+}
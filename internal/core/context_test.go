@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestVaultLimitBoundary(t *testing.T) {
+	ctx := NewGatewayContext(context.Background(), zap.NewNop())
+	ctx.SetVaultLimit(2, false)
+
+	for i := 0; i < 2; i++ {
+		placeholder := fmt.Sprintf("p%d", i)
+		if !ctx.VaultAdmit(placeholder) {
+			t.Fatalf("entry %d should be admitted below the limit", i)
+		}
+		ctx.VaultStore(placeholder, "secret")
+	}
+
+	// At the limit: existing placeholders are still accepted, new ones are not
+	if !ctx.VaultAdmit("p0") {
+		t.Error("an existing placeholder should be admitted at the limit")
+	}
+	if ctx.VaultAdmit("p2") {
+		t.Error("a new placeholder should be rejected at the limit")
+	}
+	ctx.VaultStore("p3", "secret")
+	if _, ok := ctx.VaultGet("p3"); ok {
+		t.Error("VaultStore should drop entries beyond the limit")
+	}
+	if got := len(ctx.VaultGetAll()); got != 2 {
+		t.Errorf("vault has %d entries, want 2", got)
+	}
+	if got := ctx.VaultOverflow(); got != 2 {
+		t.Errorf("VaultOverflow() = %d, want 2", got)
+	}
+	if err := ctx.VaultOverflowError(); err != nil {
+		t.Errorf("skip mode should not fail the request, got %v", err)
+	}
+}
+
+func TestVaultOverflowError(t *testing.T) {
+	ctx := NewGatewayContext(context.Background(), zap.NewNop())
+	ctx.SetVaultLimit(1, true)
+	ctx.VaultStore("p0", "secret")
+	if err := ctx.VaultOverflowError(); err != nil {
+		t.Fatalf("no overflow yet, got %v", err)
+	}
+
+	ctx.VaultStore("p1", "secret")
+	var gwErr *GatewayError
+	if err := ctx.VaultOverflowError(); !errors.As(err, &gwErr) || gwErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a 413 gateway error, got %v", err)
+	}
+}
+
+func TestVaultClear(t *testing.T) {
+	ctx := NewGatewayContext(context.Background(), zap.NewNop())
+	ctx.SetVaultLimit(1, false)
+	ctx.VaultStore("p0", "secret")
+	ctx.VaultStore("p1", "secret")
+
+	ctx.VaultClear()
+	if len(ctx.VaultGetAll()) != 0 || ctx.VaultOverflow() != 0 {
+		t.Fatal("VaultClear should empty the vault and reset the overflow count")
+	}
+
+	// The limit still applies after clearing
+	ctx.VaultStore("p2", "secret")
+	ctx.VaultStore("p3", "secret")
+	if _, ok := ctx.VaultGet("p2"); !ok {
+		t.Error("cleared vault should accept new entries")
+	}
+	if _, ok := ctx.VaultGet("p3"); ok {
+		t.Error("limit should still apply after VaultClear")
+	}
+}
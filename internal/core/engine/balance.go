@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+)
+
+// weight returns the upstream's configured weight, defaulting to 1
+func (u WeightedUpstream) weight() int {
+	if u.Weight == 0 {
+		return 1
+	}
+	return u.Weight
+}
+
+// prepareUpstreams validates a route's weighted upstreams and caches their total weight
+func (r *Route) prepareUpstreams() error {
+	if len(r.Upstreams) == 0 {
+		return nil
+	}
+	if r.Upstream.BaseURL != "" {
+		return fmt.Errorf("route %s: set either upstream or upstreams, not both", r.ID)
+	}
+	r.totalWeight = 0
+	for i, upstream := range r.Upstreams {
+		if upstream.Weight < 0 {
+			return fmt.Errorf("route %s: upstreams[%d] has negative weight %d", r.ID, i, upstream.Weight)
+		}
+		r.totalWeight += upstream.weight()
+	}
+	return nil
+}
+
+// PickUpstream selects the upstream for one request and returns it with its pooled client.
+// Routes with weighted Upstreams pick one at random in proportion to the weights; otherwise
+// the single Upstream is used. Callers must use the returned upstream for the whole request
+// so URL construction and auth headers stay consistent.
+func (r *Route) PickUpstream() (Upstream, *http.Client) {
+	if len(r.Upstreams) == 0 {
+		return r.Upstream, r.Client()
+	}
+
+	total := r.totalWeight
+	if total == 0 {
+		// Route was not prepared by NewEngine
+		for _, upstream := range r.Upstreams {
+			total += upstream.weight()
+		}
+	}
+	n := rand.IntN(total)
+	i := 0
+	for ; i < len(r.Upstreams)-1; i++ {
+		n -= r.Upstreams[i].weight()
+		if n < 0 {
+			break
+		}
+	}
+
+	if i < len(r.upstreamClients) {
+		return r.Upstreams[i].Upstream, r.upstreamClients[i]
+	}
+	return r.Upstreams[i].Upstream, UpstreamClient(r.Upstreams[i].Upstream)
+}
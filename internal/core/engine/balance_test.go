@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPickUpstreamFollowsWeights(t *testing.T) {
+	config := &EngineConfig{Routes: []Route{{
+		ID: "balanced",
+		Upstreams: []WeightedUpstream{
+			{Upstream: Upstream{BaseURL: "https://a.example.com"}, Weight: 1},
+			{Upstream: Upstream{BaseURL: "https://b.example.com"}, Weight: 3},
+			{Upstream: Upstream{BaseURL: "https://c.example.com", TimeoutSeconds: 5}, Weight: 6},
+		},
+	}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	route := &config.Routes[0]
+
+	const picks = 50000
+	counts := make(map[string]int)
+	for i := 0; i < picks; i++ {
+		upstream, client := route.PickUpstream()
+		if client != UpstreamClient(upstream) {
+			t.Fatalf("Client for %s does not match the picked upstream", upstream.BaseURL)
+		}
+		counts[upstream.BaseURL]++
+	}
+
+	for url, want := range map[string]float64{
+		"https://a.example.com": 0.1,
+		"https://b.example.com": 0.3,
+		"https://c.example.com": 0.6,
+	} {
+		if got := float64(counts[url]) / picks; math.Abs(got-want) > 0.02 {
+			t.Errorf("%s share = %.3f, want %.1f", url, got, want)
+		}
+	}
+}
+
+func TestPickUpstreamSingleUpstream(t *testing.T) {
+	route := &Route{ID: "single", Upstream: Upstream{BaseURL: "https://a.example.com"}}
+	if upstream, client := route.PickUpstream(); upstream.BaseURL != "https://a.example.com" || client != route.Client() {
+		t.Errorf("Expected the route's upstream, got %s", upstream.BaseURL)
+	}
+
+	// Omitted weights default to 1
+	route = &Route{ID: "even", Upstreams: []WeightedUpstream{
+		{Upstream: Upstream{BaseURL: "https://a.example.com"}},
+		{Upstream: Upstream{BaseURL: "https://b.example.com"}},
+	}}
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		upstream, _ := route.PickUpstream()
+		seen[upstream.BaseURL] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Expected both upstreams to be picked, got %v", seen)
+	}
+}
+
+func TestNewEngineRejectsInvalidUpstreams(t *testing.T) {
+	for name, route := range map[string]Route{
+		"negative weight": {ID: "neg", Upstreams: []WeightedUpstream{{Upstream: Upstream{BaseURL: "https://a"}, Weight: -1}}},
+		"both forms": {
+			ID:        "both",
+			Upstream:  Upstream{BaseURL: "https://a"},
+			Upstreams: []WeightedUpstream{{Upstream: Upstream{BaseURL: "https://b"}}},
+		},
+	} {
+		if _, err := NewEngine(&EngineConfig{Routes: []Route{route}}); err == nil {
+			t.Errorf("%s: expected NewEngine to fail", name)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"aigis/internal/pkg/certs"
+)
+
+// DefaultUpstreamTimeout applies when an upstream does not set timeout_seconds
+const DefaultUpstreamTimeout = 60 * time.Second
+
+// Connection pool tuning for upstream transports. LLM gateways talk to few hosts with many
+// concurrent requests, so keep plenty of idle connections per host.
+const (
+	upstreamMaxIdleConns        = 256
+	upstreamMaxIdleConnsPerHost = 64
+	upstreamIdleConnTimeout     = 90 * time.Second
+)
+
+// clientKey identifies upstream clients that can share one connection pool
+type clientKey struct {
+	timeout    time.Duration
+	serverName string
+	// mTLS client certificate and private CA; the CA file's modification time is part of the
+	// key so a config reload picks up a replaced CA bundle
+	certFile, keyFile string
+	caFile            string
+	caModTime         time.Time
+}
+
+// upstreamClients caches clients by timeout and TLS settings so routes with the same
+// settings reuse connections
+var upstreamClients sync.Map // clientKey -> *http.Client
+
+// UpstreamClient returns the pooled HTTP client for an upstream, applying its timeout and
+// TLS overrides such as SNI, a client certificate and a private CA. Upstreams with the same
+// settings share one client. If the TLS files cannot be loaded, every request through the
+// returned client fails with that error (NewEngine reports it at startup).
+func UpstreamClient(upstream Upstream) *http.Client {
+	client, err := upstreamClient(upstream)
+	if err != nil {
+		return &http.Client{Transport: errTransport{err}}
+	}
+	return client
+}
+
+// upstreamClient returns the pooled client for an upstream, creating it on first use
+func upstreamClient(upstream Upstream) (*http.Client, error) {
+	key := clientKey{
+		timeout:  upstream.Timeout(),
+		certFile: ResolveEnv(upstream.TLSClientCert),
+		keyFile:  ResolveEnv(upstream.TLSClientKey),
+		caFile:   ResolveEnv(upstream.TLSCACert),
+	}
+	if host := ResolveEnv(upstream.Host); host != "" {
+		// TLS ServerName must not carry a port
+		key.serverName = host
+		if host, _, err := net.SplitHostPort(key.serverName); err == nil {
+			key.serverName = host
+		}
+	}
+	if (key.certFile == "") != (key.keyFile == "") {
+		return nil, fmt.Errorf("tls_client_cert and tls_client_key must be set together")
+	}
+	if key.caFile != "" {
+		info, err := os.Stat(key.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca_cert: %w", err)
+		}
+		key.caModTime = info.ModTime()
+	}
+	if client, ok := upstreamClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	tlsConfig, err := key.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = upstreamMaxIdleConns
+	transport.MaxIdleConnsPerHost = upstreamMaxIdleConnsPerHost
+	transport.IdleConnTimeout = upstreamIdleConnTimeout
+	transport.TLSClientConfig = tlsConfig
+	client, _ := upstreamClients.LoadOrStore(key, &http.Client{
+		Timeout:   key.timeout,
+		Transport: transport,
+	})
+	return client.(*http.Client), nil
+}
+
+// tlsConfig builds the transport TLS config for the key (nil when nothing is overridden)
+func (k clientKey) tlsConfig() (*tls.Config, error) {
+	if k.serverName == "" && k.certFile == "" && k.caFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: k.serverName}
+
+	if k.certFile != "" {
+		// The certificate is re-read when rotated on disk, without a config reload
+		reloader, err := certs.NewReloader(k.certFile, k.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("upstream client certificate: %w", err)
+		}
+		config.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	if k.caFile != "" {
+		pem, err := os.ReadFile(k.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca_cert: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls_ca_cert %s contains no PEM certificates", k.caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// errTransport fails every request, for upstreams whose TLS settings could not be loaded
+type errTransport struct{ err error }
+
+func (t errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// Timeout returns the bound on a single request to the upstream (timeout_seconds or the default)
+func (u Upstream) Timeout() time.Duration {
+	if u.TimeoutSeconds > 0 {
+		return time.Duration(u.TimeoutSeconds) * time.Second
+	}
+	return DefaultUpstreamTimeout
+}
+
+// attemptsTimeout bounds an upstream call including all of its retries
+func (u Upstream) attemptsTimeout() time.Duration {
+	return u.Timeout() * time.Duration(u.MaxRetries+1)
+}
+
+// ResponseTimeout is the longest a request on this route may wait for its upstreams: the
+// slowest primary, weighted or fan-out upstream with its retries, followed by the fallback.
+// The server uses it as the write deadline of non-streaming responses.
+func (r *Route) ResponseTimeout() time.Duration {
+	timeout := r.Upstream.attemptsTimeout()
+	for _, u := range r.Upstreams {
+		timeout = max(timeout, u.attemptsTimeout())
+	}
+	for _, u := range r.FanOut {
+		timeout = max(timeout, u.attemptsTimeout())
+	}
+	if r.Fallback != nil {
+		timeout += r.Fallback.attemptsTimeout()
+	}
+	return timeout
+}
+
+// Client returns the HTTP client for the route's primary upstream (resolved by NewEngine)
+func (r *Route) Client() *http.Client {
+	if r.client != nil {
+		return r.client
+	}
+	return UpstreamClient(r.Upstream)
+}
+
+// FanOutClient returns the HTTP client for the i-th fan-out upstream
+func (r *Route) FanOutClient(i int) *http.Client {
+	if i < len(r.fanOutClients) {
+		return r.fanOutClients[i]
+	}
+	return UpstreamClient(r.FanOut[i])
+}
+
+// FallbackClient returns the HTTP client for the route's fallback upstream (nil without one)
+func (r *Route) FallbackClient() *http.Client {
+	if r.Fallback == nil {
+		return nil
+	}
+	if r.fallbackClient != nil {
+		return r.fallbackClient
+	}
+	return UpstreamClient(*r.Fallback)
+}
+
+// ShadowClient returns the HTTP client for the route's shadow upstream (nil without one)
+func (r *Route) ShadowClient() *http.Client {
+	if r.Shadow == nil {
+		return nil
+	}
+	if r.shadowClient != nil {
+		return r.shadowClient
+	}
+	return UpstreamClient(*r.Shadow)
+}
+
+// resolveClients looks up the pooled clients for all of a route's upstreams once, so the
+// request path never builds clients. Unloadable TLS files fail here, at startup or reload.
+func (r *Route) resolveClients() error {
+	resolve := func(field string, upstream Upstream) (*http.Client, error) {
+		client, err := upstreamClient(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %s: %w", r.ID, field, err)
+		}
+		return client, nil
+	}
+
+	var err error
+	if r.client, err = resolve("upstream", r.Upstream); err != nil {
+		return err
+	}
+	r.upstreamClients = nil
+	for i, upstream := range r.Upstreams {
+		client, err := resolve(fmt.Sprintf("upstreams[%d]", i), upstream.Upstream)
+		if err != nil {
+			return err
+		}
+		r.upstreamClients = append(r.upstreamClients, client)
+	}
+	r.fanOutClients = nil
+	for i, upstream := range r.FanOut {
+		client, err := resolve(fmt.Sprintf("fan_out[%d]", i), upstream)
+		if err != nil {
+			return err
+		}
+		r.fanOutClients = append(r.fanOutClients, client)
+	}
+	if r.Fallback != nil {
+		if r.fallbackClient, err = resolve("fallback", *r.Fallback); err != nil {
+			return err
+		}
+	}
+	if r.Shadow != nil {
+		if r.shadowClient, err = resolve("shadow", *r.Shadow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
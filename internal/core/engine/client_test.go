@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpstreamClientsSharedBySettings(t *testing.T) {
+	a := UpstreamClient(Upstream{BaseURL: "https://a.example.com"})
+	b := UpstreamClient(Upstream{BaseURL: "https://b.example.com", TimeoutSeconds: 60})
+	if a != b {
+		t.Error("Upstreams with the same settings should share a client")
+	}
+	if a.Timeout != DefaultUpstreamTimeout {
+		t.Errorf("Default timeout = %v, want %v", a.Timeout, DefaultUpstreamTimeout)
+	}
+	if transport := a.Transport.(*http.Transport); transport.MaxIdleConnsPerHost != upstreamMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, upstreamMaxIdleConnsPerHost)
+	}
+
+	long := UpstreamClient(Upstream{TimeoutSeconds: 300})
+	if long == a || long.Timeout != 300*time.Second {
+		t.Errorf("A different timeout needs its own client, got timeout %v", long.Timeout)
+	}
+	if sni := UpstreamClient(Upstream{Host: "api.example.com:443"}); sni == a {
+		t.Error("A TLS server name override needs its own client")
+	}
+}
+
+func TestNewEngineResolvesRouteClients(t *testing.T) {
+	config := &EngineConfig{Routes: []Route{{
+		ID:       "pooled",
+		Upstream: Upstream{BaseURL: "https://a.example.com", TimeoutSeconds: 5},
+		FanOut:   []Upstream{{BaseURL: "https://b.example.com"}},
+		Shadow:   &Upstream{BaseURL: "https://c.example.com", TimeoutSeconds: 5},
+	}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	route := &config.Routes[0]
+	if route.client == nil || route.Client() != route.client {
+		t.Error("NewEngine should resolve the primary client once")
+	}
+	if route.Client().Timeout != 5*time.Second {
+		t.Errorf("Client timeout = %v, want 5s", route.Client().Timeout)
+	}
+	if route.FanOutClient(0) != UpstreamClient(route.FanOut[0]) {
+		t.Error("Fan-out client should come from the shared pool")
+	}
+	if route.ShadowClient() != route.Client() {
+		t.Error("Shadow with the same settings should share the primary client")
+	}
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// prepareConcurrency creates the route's in-flight request semaphore when max_concurrency is set
+func (r *Route) prepareConcurrency() {
+	if r.MaxConcurrency > 0 {
+		r.slots = make(chan struct{}, r.MaxConcurrency)
+	}
+}
+
+// AcquireSlot reserves one of the route's max_concurrency in-flight slots. When the route is
+// full it waits up to concurrency_wait_ms (0 = not at all) or until ctx is done. It returns a
+// release function (safe to call more than once) and false if no slot became available.
+// Routes without a limit, or not prepared by NewEngine, always succeed.
+func (r *Route) AcquireSlot(ctx context.Context) (func(), bool) {
+	if r.slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case r.slots <- struct{}{}:
+	default:
+		if r.ConcurrencyWaitMs <= 0 {
+			return nil, false
+		}
+		timer := time.NewTimer(time.Duration(r.ConcurrencyWaitMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case r.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-r.slots })
+	}, true
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAcquireSlotCapsConcurrency(t *testing.T) {
+	config := &EngineConfig{Routes: []Route{{ID: "limited", MaxConcurrency: 2}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	route := &config.Routes[0]
+
+	first, ok := route.AcquireSlot(context.Background())
+	if !ok {
+		t.Fatal("first slot should be granted")
+	}
+	if _, ok := route.AcquireSlot(context.Background()); !ok {
+		t.Fatal("second slot should be granted")
+	}
+	if _, ok := route.AcquireSlot(context.Background()); ok {
+		t.Fatal("third request should be rejected without concurrency_wait_ms")
+	}
+
+	// Releasing twice must only free one slot
+	first()
+	first()
+	if _, ok := route.AcquireSlot(context.Background()); !ok {
+		t.Fatal("released slot should be reusable")
+	}
+	if _, ok := route.AcquireSlot(context.Background()); ok {
+		t.Fatal("double release should not free an extra slot")
+	}
+}
+
+func TestAcquireSlotWaits(t *testing.T) {
+	config := &EngineConfig{Routes: []Route{{ID: "limited", MaxConcurrency: 1, ConcurrencyWaitMs: 50}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	route := &config.Routes[0]
+
+	release, _ := route.AcquireSlot(context.Background())
+	start := time.Now()
+	if _, ok := route.AcquireSlot(context.Background()); ok {
+		t.Fatal("waiting request should time out while the slot is held")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("request gave up after %v, before concurrency_wait_ms", elapsed)
+	}
+
+	// A slot freed during the wait is handed to the waiting request
+	time.AfterFunc(10*time.Millisecond, release)
+	if _, ok := route.AcquireSlot(context.Background()); !ok {
+		t.Fatal("waiting request should get the released slot")
+	}
+
+	// A cancelled request stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := route.AcquireSlot(ctx); ok {
+		t.Fatal("cancelled request should not get a slot")
+	}
+}
+
+func TestAcquireSlotUnlimited(t *testing.T) {
+	route := &Route{ID: "open"}
+	for i := 0; i < 10; i++ {
+		if _, ok := route.AcquireSlot(context.Background()); !ok {
+			t.Fatal("routes without max_concurrency should never reject")
+		}
+	}
+}
@@ -1,54 +1,225 @@
 package engine
 
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+
+	"aigis/internal/core/security"
+)
+
 // EngineConfig defines the configuration for the transformation engine
 type EngineConfig struct {
 	Routes []Route `mapstructure:"routes"`
+	// DefaultHeaderPolicy is merged into every route's HeaderPolicy at engine init;
+	// route-level Set entries override the defaults
+	DefaultHeaderPolicy HeaderPolicy `mapstructure:"default_header_policy"`
+	// MaxRouteEvaluations caps how many routes FindRoute evaluates per request (0 = no cap)
+	MaxRouteEvaluations int `mapstructure:"max_route_evaluations"`
+	// SlowMatchThreshold logs a warning when route matching takes longer (e.g. "5ms"; 0 = off)
+	SlowMatchThreshold time.Duration `mapstructure:"slow_match_threshold"`
 }
 
 // Route defines a routing rule with matcher, upstream, and transformations
 type Route struct {
 	// ID is the unique identifier for this route
 	ID string `mapstructure:"id"`
-	// Matcher maps JSON path (e.g., "model") to regex pattern (e.g., "gpt-.*")
+	// Matcher maps JSON path (e.g., "model") to regex pattern (e.g., "gpt-.*") or numeric
+	// comparison (e.g., ">8000", "<=0.5"; operators >, >=, <, <=, ==).
+	// Keys with the "header:" prefix match request headers instead (e.g., "header:X-Tenant").
+	// A route without matchers is the default route (see Default)
 	Matcher map[string]string `mapstructure:"matcher"`
+	// Priority orders route evaluation: lower numbers are evaluated first, like processor
+	// priorities; routes with equal priority keep their config order (default 0)
+	Priority int `mapstructure:"priority"`
+	// Default marks the catch-all route used when no other route matches. It is always
+	// evaluated last, regardless of its position in the config. At most one route may be
+	// the default, whether marked explicitly or by having an empty Matcher
+	Default bool `mapstructure:"default"`
 	// Upstream defines the target backend service
 	Upstream Upstream `mapstructure:"upstream"`
+	// Upstreams splits traffic across several weighted upstreams (e.g. multiple API keys or
+	// mirror endpoints). When set it replaces Upstream and one is picked per request
+	Upstreams []WeightedUpstream `mapstructure:"upstreams"`
 	// Transforms is the pipeline of transformations to apply
 	Transforms []TransformStep `mapstructure:"transforms"`
 	// HeaderPolicy defines how to handle HTTP headers
 	HeaderPolicy HeaderPolicy `mapstructure:"header_policy"`
+	// Fallback is tried when the upstream fails with a 5xx status or a connection error
+	// (after its retries); it uses its own URL, auth strategy and token
+	Fallback *Upstream `mapstructure:"fallback"`
+	// Shadow optionally mirrors each request to a second upstream whose response is
+	// only logged and compared, never returned (useful for evaluating a migration). Each
+	// mirror is a single attempt bounded by the shadow's timeout_seconds; mirrors are dropped
+	// while too many are in flight.
+	Shadow *Upstream `mapstructure:"shadow"`
+	// FanOut lists additional upstreams queried concurrently with Upstream; their
+	// OpenAI-style choices are merged into a single response (ensemble routes)
+	FanOut []Upstream `mapstructure:"fan_out"`
+	// ResponseRewrite hides upstream naming in the response (model and id fields)
+	ResponseRewrite ResponseRewrite `mapstructure:"response_rewrite"`
+	// BodyFormat controls how the upstream request body is serialized:
+	// "minified" (default) or "stable" (minified with object keys sorted, for reproducible bodies)
+	BodyFormat string `mapstructure:"body_format"`
+	// RequestSchema optionally validates the transformed request body against a JSON Schema
+	RequestSchema *RequestSchema `mapstructure:"request_schema"`
+	// Scanner customizes PII detection for this route (rules, disabled rules, allowlist, tags)
+	Scanner *ScannerConfig `mapstructure:"scanner"`
+	// MaxConcurrency caps the requests in flight on this route (0 = unlimited)
+	MaxConcurrency int `mapstructure:"max_concurrency"`
+	// ConcurrencyWaitMs is how long a request waits for a free slot once MaxConcurrency is
+	// reached; 0 rejects it immediately with 429
+	ConcurrencyWaitMs int `mapstructure:"concurrency_wait_ms"`
+
+	// requestSchema is compiled from RequestSchema by NewEngine
+	requestSchema *jsonschema.Schema
+	// scanner is built once from Scanner and the PII steps' settings by NewEngine
+	scanner *security.Scanner
+	// Pooled upstream clients resolved by NewEngine
+	client          *http.Client
+	upstreamClients []*http.Client
+	fanOutClients   []*http.Client
+	fallbackClient  *http.Client
+	shadowClient    *http.Client
+	// slots is the MaxConcurrency semaphore created by NewEngine
+	slots chan struct{}
+	// totalWeight is the sum of the Upstreams weights, computed by NewEngine
+	totalWeight int
 }
 
 // HeaderPolicy defines rules for handling HTTP headers
 type HeaderPolicy struct {
-	// Allow lists headers to pass through from client requests
+	// Allow lists headers to pass through from client requests.
+	// Entries are matched case-insensitively and may be globs (e.g., "X-Custom-*")
 	Allow []string `mapstructure:"allow"`
+	// Rename maps client header names to the names sent upstream (from -> to), e.g.
+	// "X-Api-Version": "anthropic-version". The client's value is forwarded under the new
+	// name (even if the original is not in Allow) and the original is dropped. Rename runs
+	// after Allow and before Set and Remove, so a Set or Remove on the target name wins.
+	Rename map[string]string `mapstructure:"rename"`
 	// Set maps headers to force set (supports "env:VAR" syntax for env vars)
 	Set map[string]string `mapstructure:"set"`
-	// Remove lists headers to exclude from upstream requests
+	// Remove lists headers to exclude from upstream requests (globs supported, like Allow)
 	Remove []string `mapstructure:"remove"`
+	// ForwardClientIP appends the client's IP to X-Forwarded-For (keeping any chain the
+	// client sent, like a standard proxy); RealIP additionally sets X-Real-IP
+	ForwardClientIP bool `mapstructure:"forward_client_ip"`
+	RealIP          bool `mapstructure:"real_ip"`
+	// ResponseAllow lists upstream response headers returned to the client, e.g.
+	// "x-ratelimit-*", "x-request-id", "retry-after" (globs supported, like Allow).
+	// Body framing headers (Content-Length, Content-Type, ...) are never copied.
+	ResponseAllow []string `mapstructure:"response_allow"`
+}
+
+// ResponseRewrite controls how identifying fields of upstream responses are rewritten
+type ResponseRewrite struct {
+	// Model restores the client-requested model name in the response "model" field
+	Model bool `mapstructure:"model"`
+	// ID rewrites the response "id": "request_id" replaces it with the gateway request id,
+	// "prefix" prepends the request id to the upstream id; empty leaves it unchanged
+	ID string `mapstructure:"id"`
 }
 
+// HeaderMatcherPrefix marks Matcher keys that match a request header rather than a JSON path
+const HeaderMatcherPrefix = "header:"
+
+// BodyFormat values
+const (
+	BodyFormatMinified = "minified"
+	BodyFormatStable   = "stable"
+)
+
+// ResponseRewrite ID modes
+const (
+	ResponseIDRequestID = "request_id"
+	ResponseIDPrefix    = "prefix"
+)
+
 // Upstream defines the backend service configuration
 type Upstream struct {
 	// BaseURL is the base URL for the upstream service (e.g., "https://api.openai.com/v1")
 	BaseURL string `mapstructure:"base_url"`
 	// Path is the endpoint path (e.g., "/chat/completions")
 	Path string `mapstructure:"path"`
-	// AuthStrategy defines how to authenticate: "bearer", "header", "query"
+	// EmbeddingsPath is the endpoint path used for /v1/embeddings requests (default: "/embeddings")
+	EmbeddingsPath string `mapstructure:"embeddings_path"`
+	// AuthStrategy defines how to authenticate: "bearer", "header", "query", "none", "passthrough",
+	// "aws_sigv4", "oauth2"
 	AuthStrategy string `mapstructure:"auth_strategy"`
 	// TokenEnv is the environment variable name to read the token from
 	TokenEnv string `mapstructure:"token_env"`
 	// HeaderName is the header name for "header" auth strategy (default: "Authorization")
 	HeaderName string `mapstructure:"header_name"`
+	// QueryParam is the query parameter name for "query" auth strategy (default: "api_key"),
+	// e.g. "key" for Google APIs
+	QueryParam string `mapstructure:"query_param"`
+	// Host overrides the Host header and TLS SNI server name (e.g., for CDN or shared-IP setups)
+	Host string `mapstructure:"host"`
+	// RateLimitHeaders maps upstream rate-limit response headers to standardized names
+	// returned to the client, e.g. "x-ratelimit-remaining-requests": "Remaining-Requests"
+	// becomes "X-AIGis-RateLimit-Remaining-Requests"
+	RateLimitHeaders map[string]string `mapstructure:"rate_limit_headers"`
+	// TimeoutSeconds bounds each upstream request, including reading the response (default 60);
+	// for streams it only bounds the wait for the response headers
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+	// MaxRetries retries connection failures and 429/502/503 responses (0 = no retries)
+	MaxRetries int `mapstructure:"max_retries"`
+	// BackoffMs is the base delay for jittered exponential backoff between retries (default 200)
+	BackoffMs int `mapstructure:"backoff_ms"`
+	// AWSRegion and AWSService scope SigV4 signing for the "aws_sigv4" strategy
+	// (service defaults to "bedrock")
+	AWSRegion  string `mapstructure:"aws_region"`
+	AWSService string `mapstructure:"aws_service"`
+	// AccessKeyEnv, SecretKeyEnv and SessionTokenEnv name the env vars holding AWS credentials
+	// (defaults: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN)
+	AccessKeyEnv    string `mapstructure:"access_key_env"`
+	SecretKeyEnv    string `mapstructure:"secret_key_env"`
+	SessionTokenEnv string `mapstructure:"session_token_env"`
+	// TokenURL, ClientIDEnv, ClientSecretEnv and Scope configure the "oauth2" strategy
+	// (client-credentials grant); the access token is cached until shortly before it expires
+	TokenURL        string `mapstructure:"token_url"`
+	ClientIDEnv     string `mapstructure:"client_id_env"`
+	ClientSecretEnv string `mapstructure:"client_secret_env"`
+	Scope           string `mapstructure:"scope"`
+	// SignatureSecretEnv enables HMAC signing of the final request body with the secret from this
+	// env var, for upstreams that verify body integrity. SignatureHeader carries the hex digest
+	// (default "X-Signature"); SignatureAlgorithm is "hmac-sha256" (default) or "hmac-sha512"
+	SignatureSecretEnv string `mapstructure:"signature_secret_env"`
+	SignatureHeader    string `mapstructure:"signature_header"`
+	SignatureAlgorithm string `mapstructure:"signature_algorithm"`
+	// TLSClientCert and TLSClientKey are the PEM files of a client certificate presented to the
+	// upstream (mTLS); the pair is reloaded when the files change. TLSCACert optionally replaces
+	// the system roots for verifying the upstream's certificate (e.g. an internal CA)
+	TLSClientCert string `mapstructure:"tls_client_cert"`
+	TLSClientKey  string `mapstructure:"tls_client_key"`
+	TLSCACert     string `mapstructure:"tls_ca_cert"`
+}
+
+// WeightedUpstream is an upstream with a relative share of a route's traffic
+type WeightedUpstream struct {
+	Upstream `mapstructure:",squash"`
+	// Weight is the relative share of requests sent to this upstream (default 1)
+	Weight int `mapstructure:"weight"`
 }
 
 // TransformStep defines a single transformation in the pipeline
 type TransformStep struct {
-	// Type is the transformation type: "pii", "field_map", "template"
+	// Type is the transformation type (see the TransformType constants)
 	Type string `mapstructure:"type"`
 	// Config contains type-specific configuration
 	Config map[string]string `mapstructure:"config"`
+	// TimeoutMs bounds how long this step may run (0 = no limit)
+	TimeoutMs int `mapstructure:"timeout_ms"`
+	// FailOpen skips the step on timeout instead of failing the request. PII steps always
+	// fail closed: skipping one would forward the secrets unmasked
+	FailOpen bool `mapstructure:"fail_open"`
+	// Rules adds custom scanner rules for PII steps, on top of the built-in rules
+	Rules []ScannerRule `mapstructure:"rules"`
+
+	// pattern is the regex_replace pattern compiled by NewEngine
+	pattern *regexp.Regexp
 }
 
 // AuthStrategy constants
@@ -56,12 +227,66 @@ const (
 	AuthStrategyBearer = "bearer" // Authorization: Bearer <token>
 	AuthStrategyHeader = "header" // Custom header with token value
 	AuthStrategyQuery  = "query"  // Query parameter with token value
+	AuthStrategyNone   = "none"   // No auth added by the gateway (e.g. credentials set via header_policy)
+
+	AuthStrategyPassthrough = "passthrough" // The client's own Authorization header, forwarded unchanged
+
+	AuthStrategyAWSSigV4 = "aws_sigv4" // AWS Signature Version 4 request signing (e.g. Bedrock)
+	AuthStrategyOAuth2   = "oauth2"    // Bearer token from an OAuth2 client-credentials grant
+)
+
+// Request body signature algorithms (see Upstream.SignatureAlgorithm)
+const (
+	SignatureHMACSHA256 = "hmac-sha256"
+	SignatureHMACSHA512 = "hmac-sha512"
 )
 
 // TransformType constants
 const (
-	TransformTypePII      = "pii"       // PII redaction (OpenAI format)
-	TransformTypePIIClaude = "pii_claude" // PII redaction (Claude/Anthropic format)
-	TransformTypeFieldMap = "field_map" // Field mapping using gjson/sjson
-	TransformTypeTemplate = "template"  // Go text/template transformation
+	TransformTypePII          = "pii"           // PII redaction (OpenAI format)
+	TransformTypePIIClaude    = "pii_claude"    // PII redaction (Claude/Anthropic format)
+	TransformTypePIIGemini    = "pii_gemini"    // PII redaction (Google Gemini format)
+	TransformTypePIIOllama    = "pii_ollama"    // PII redaction (Ollama native /api/chat and /api/generate)
+	TransformTypeFieldMap     = "field_map"     // Field mapping using gjson/sjson
+	TransformTypeTemplate     = "template"      // Go text/template transformation
+	TransformTypeRedactPaths  = "redact_paths"  // Redact string values at explicit gjson paths
+	TransformTypeInjectIDs    = "inject_ids"    // Write trace/request ids into the body
+	TransformTypeRegexReplace = "regex_replace" // Regex substitution on a string field at a gjson path
+	TransformTypeFieldDelete  = "field_delete"  // Remove fields at gjson paths before forwarding
+	TransformTypeModelAlias   = "model_alias"   // Rename the top-level model to the upstream's name
+	TransformTypeClampParam   = "clamp_param"   // Cap a numeric parameter (e.g. max_tokens) at a ceiling
+
+	TransformTypeMergeSystemIntoUser = "merge_system_into_user" // Fold system messages into the first user message
+	TransformTypeOpenAIToClaude      = "openai_to_claude"       // Convert an OpenAI chat body to the Claude Messages API (and the response back)
 )
+
+// IsPIITransform reports whether t is one of the PII redaction transform types
+func IsPIITransform(t string) bool {
+	switch t {
+	case TransformTypePII, TransformTypePIIClaude, TransformTypePIIGemini, TransformTypePIIOllama:
+		return true
+	}
+	return false
+}
+
+// KnownTransformType reports whether t is a transform type the providers implement
+func KnownTransformType(t string) bool {
+	switch t {
+	case TransformTypePII, TransformTypePIIClaude, TransformTypePIIGemini, TransformTypePIIOllama,
+		TransformTypeFieldMap, TransformTypeTemplate, TransformTypeRedactPaths, TransformTypeInjectIDs,
+		TransformTypeRegexReplace, TransformTypeFieldDelete, TransformTypeModelAlias, TransformTypeClampParam,
+		TransformTypeMergeSystemIntoUser, TransformTypeOpenAIToClaude:
+		return true
+	}
+	return false
+}
+
+// KnownAuthStrategy reports whether s is a supported auth strategy ("" defaults to bearer)
+func KnownAuthStrategy(s string) bool {
+	switch s {
+	case "", AuthStrategyBearer, AuthStrategyHeader, AuthStrategyQuery, AuthStrategyNone, AuthStrategyPassthrough,
+		AuthStrategyAWSSigV4, AuthStrategyOAuth2:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EnvPrefix marks config values read from an environment variable, e.g. "env:OPENAI_BASE_URL"
+const EnvPrefix = "env:"
+
+// ResolveEnv expands an "env:VAR" value to the value of VAR; other values are returned
+// unchanged. An unset VAR yields "".
+func ResolveEnv(value string) string {
+	name, ok := strings.CutPrefix(value, EnvPrefix)
+	if !ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// Resolve returns a copy of the upstream with "env:VAR" references expanded in all of its
+// string fields. Referencing an unset (or empty) variable is an error rather than silently
+// producing e.g. an empty base URL.
+func (u Upstream) Resolve() (Upstream, error) {
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"base_url", &u.BaseURL},
+		{"path", &u.Path},
+		{"auth_strategy", &u.AuthStrategy},
+		{"token_env", &u.TokenEnv},
+		{"header_name", &u.HeaderName},
+		{"query_param", &u.QueryParam},
+		{"host", &u.Host},
+		{"aws_region", &u.AWSRegion},
+		{"aws_service", &u.AWSService},
+		{"access_key_env", &u.AccessKeyEnv},
+		{"secret_key_env", &u.SecretKeyEnv},
+		{"session_token_env", &u.SessionTokenEnv},
+		{"token_url", &u.TokenURL},
+		{"client_id_env", &u.ClientIDEnv},
+		{"client_secret_env", &u.ClientSecretEnv},
+		{"scope", &u.Scope},
+		{"signature_secret_env", &u.SignatureSecretEnv},
+		{"signature_header", &u.SignatureHeader},
+		{"signature_algorithm", &u.SignatureAlgorithm},
+		{"tls_client_cert", &u.TLSClientCert},
+		{"tls_client_key", &u.TLSClientKey},
+		{"tls_ca_cert", &u.TLSCACert},
+	} {
+		name, ok := strings.CutPrefix(*field.value, EnvPrefix)
+		if !ok {
+			continue
+		}
+		value := os.Getenv(name)
+		if value == "" {
+			return u, fmt.Errorf("upstream %s references environment variable %s, which is not set", field.name, name)
+		}
+		*field.value = value
+	}
+	return u, nil
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("AIGIS_TEST_ENV_VALUE", "resolved")
+	t.Setenv("AIGIS_TEST_ENV_EMPTY", "")
+
+	for value, want := range map[string]string{
+		"literal":                   "literal",
+		"env:AIGIS_TEST_ENV_VALUE":  "resolved",
+		"env:AIGIS_TEST_ENV_EMPTY":  "",
+		"env:AIGIS_TEST_ENV_UNSET":  "",
+		"prefix env:AIGIS_TEST_ENV": "prefix env:AIGIS_TEST_ENV",
+	} {
+		if got := ResolveEnv(value); got != want {
+			t.Errorf("ResolveEnv(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestUpstreamResolve(t *testing.T) {
+	t.Setenv("AIGIS_TEST_BASE_URL", "https://api.example.com/v1")
+	t.Setenv("AIGIS_TEST_PATH", "/deployments/gpt-4o/chat/completions")
+	t.Setenv("AIGIS_TEST_HEADER", "api-key")
+
+	upstream, err := Upstream{
+		BaseURL:    "env:AIGIS_TEST_BASE_URL",
+		Path:       "env:AIGIS_TEST_PATH",
+		HeaderName: "env:AIGIS_TEST_HEADER",
+		TokenEnv:   "AZURE_KEY",
+	}.Resolve()
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if upstream.BaseURL != "https://api.example.com/v1" || upstream.Path != "/deployments/gpt-4o/chat/completions" || upstream.HeaderName != "api-key" {
+		t.Errorf("Unexpected resolved upstream: %+v", upstream)
+	}
+	if upstream.TokenEnv != "AZURE_KEY" {
+		t.Errorf("Literal fields should be unchanged, got %q", upstream.TokenEnv)
+	}
+
+	_, err = Upstream{BaseURL: "env:AIGIS_TEST_UNSET_BASE_URL"}.Resolve()
+	if err == nil || !strings.Contains(err.Error(), "AIGIS_TEST_UNSET_BASE_URL") || !strings.Contains(err.Error(), "base_url") {
+		t.Errorf("Expected an error naming the field and variable, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// valueMatcher tests a request value (JSON body value or header) against a route matcher.
+// *regexp.Regexp satisfies it, so regex matchers are used directly.
+type valueMatcher interface {
+	MatchString(value string) bool
+}
+
+// comparisonOperators lists the numeric comparison prefixes, longest first so ">=" wins over ">"
+var comparisonOperators = []string{">=", "<=", "==", ">", "<"}
+
+// numericMatcher compares a numeric value against a threshold (e.g. ">8000")
+type numericMatcher struct {
+	op        string
+	threshold float64
+}
+
+// MatchString parses value as a number and compares it; non-numeric values never match
+func (m numericMatcher) MatchString(value string) bool {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return false
+	}
+	switch m.op {
+	case ">":
+		return n > m.threshold
+	case ">=":
+		return n >= m.threshold
+	case "<":
+		return n < m.threshold
+	case "<=":
+		return n <= m.threshold
+	default:
+		return n == m.threshold
+	}
+}
+
+// compileMatcher turns a matcher expression into a valueMatcher. Expressions of the form
+// "<op><number>" with op one of >, >=, <, <=, == are numeric comparisons (e.g. ">8000",
+// "<= 0.5"); anything else is compiled as a regex.
+func compileMatcher(pattern string) (valueMatcher, error) {
+	expr := strings.TrimSpace(pattern)
+	for _, op := range comparisonOperators {
+		if rest, ok := strings.CutPrefix(expr, op); ok {
+			if threshold, err := strconv.ParseFloat(strings.TrimSpace(rest), 64); err == nil {
+				return numericMatcher{op: op, threshold: threshold}, nil
+			}
+			break
+		}
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestCompileMatcherNumericComparisons(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{">8000", "8001", true},
+		{">8000", "8000", false},
+		{">=8000", "8000", true},
+		{">= 8000", "7999", false},
+		{"<0.5", "0.25", true},
+		{"<0.5", "0.5", false},
+		{"<=0.5", "0.5", true},
+		{"<=0.5", "0.51", false},
+		{"==1", "1", true},
+		{"==1", "1.0", true},
+		{"==0.7", "0.70", true},
+		{"==0.7", "0.71", false},
+		{">1e3", "1001", true},
+		{">8000", "lots", false},
+	} {
+		matcher, err := compileMatcher(tc.pattern)
+		if err != nil {
+			t.Fatalf("compileMatcher(%q) failed: %v", tc.pattern, err)
+		}
+		if _, ok := matcher.(numericMatcher); !ok {
+			t.Fatalf("compileMatcher(%q) should be a numeric comparison", tc.pattern)
+		}
+		if got := matcher.MatchString(tc.value); got != tc.want {
+			t.Errorf("%q matches %q = %v, want %v", tc.pattern, tc.value, got, tc.want)
+		}
+	}
+}
+
+func TestCompileMatcherFallsBackToRegex(t *testing.T) {
+	for _, pattern := range []string{"^gpt-.*", ">abc", "=5", "<[0-9]+>"} {
+		matcher, err := compileMatcher(pattern)
+		if err != nil {
+			t.Fatalf("compileMatcher(%q) failed: %v", pattern, err)
+		}
+		if _, ok := matcher.(*regexp.Regexp); !ok {
+			t.Errorf("compileMatcher(%q) should be a regex, got %T", pattern, matcher)
+		}
+	}
+}
+
+func TestFindRouteNumericMatcher(t *testing.T) {
+	e, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "big-context", Matcher: map[string]string{"max_tokens": ">8000"}},
+		{ID: "creative", Matcher: map[string]string{"temperature": ">=1.2"}},
+		{ID: "small", Matcher: map[string]string{"max_tokens": "<=8000"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	for body, want := range map[string]string{
+		`{"max_tokens":16000}`:                  "big-context",
+		`{"max_tokens":8000}`:                   "small",
+		`{"max_tokens":8000.5}`:                 "big-context",
+		`{"temperature":1.5}`:                   "creative",
+		`{"temperature":1.2,"max_tokens":4096}`: "creative",
+	} {
+		route, err := e.FindRoute([]byte(body), nil)
+		if err != nil || route == nil || route.ID != want {
+			t.Errorf("FindRoute(%s) = %v, %v; want %s", body, route, err, want)
+		}
+	}
+	if route, _ := e.FindRoute([]byte(`{"temperature":0.2}`), nil); route != nil {
+		t.Errorf("Expected no route, got %s", route.ID)
+	}
+}
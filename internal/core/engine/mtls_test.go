@@ -0,0 +1,155 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates for mTLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// writeClientCert issues a client certificate with the given common name into certFile/keyFile
+func (ca *testCA) writeClientCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func TestUpstreamClientPresentsClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "upstream-ca.pem")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writePEM(t, caFile, "CERTIFICATE", upstream.Certificate().Raw)
+	ca.writeClientCert(t, certFile, keyFile, "gateway-a")
+
+	get := func(client *http.Client) (string, error) {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	config := &EngineConfig{Routes: []Route{{
+		ID:       "mtls",
+		Upstream: Upstream{BaseURL: upstream.URL, TLSClientCert: certFile, TLSClientKey: keyFile, TLSCACert: caFile},
+	}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	client := config.Routes[0].Client()
+	if cn, err := get(client); err != nil || cn != "gateway-a" {
+		t.Fatalf("upstream saw client certificate %q, err %v", cn, err)
+	}
+
+	// Without a client certificate the upstream rejects the handshake
+	if _, err := get(UpstreamClient(Upstream{TLSCACert: caFile})); err == nil {
+		t.Error("request without a client certificate should fail")
+	}
+
+	// A certificate rotated on disk is used for new connections
+	ca.writeClientCert(t, certFile, keyFile, "gateway-b")
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	client.CloseIdleConnections()
+	if cn, err := get(client); err != nil || cn != "gateway-b" {
+		t.Errorf("upstream saw client certificate %q after rotation, err %v", cn, err)
+	}
+}
+
+func TestNewEngineRejectsUnreadableClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	config := &EngineConfig{Routes: []Route{{
+		ID: "mtls",
+		Upstream: Upstream{
+			BaseURL:       "https://internal.example.com",
+			TLSClientCert: filepath.Join(dir, "missing.pem"),
+			TLSClientKey:  filepath.Join(dir, "missing-key.pem"),
+		},
+	}}}
+	if _, err := NewEngine(config); err == nil {
+		t.Error("NewEngine should fail when the client certificate cannot be loaded")
+	}
+
+	config.Routes[0].Upstream.TLSClientKey = ""
+	if _, err := NewEngine(config); err == nil {
+		t.Error("NewEngine should fail when only tls_client_cert is set")
+	}
+}
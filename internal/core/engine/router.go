@@ -2,61 +2,227 @@ package engine
 
 import (
 	"fmt"
-	"regexp"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bytedance/sonic"
+	"go.uber.org/zap"
+
+	"aigis/internal/pkg/metrics"
 )
 
 // Engine is the core transformation engine that handles routing and transformations
 type Engine struct {
 	config   *EngineConfig
-	matchers map[string]map[string]*regexp.Regexp // routeID -> jsonPath -> compiled regex
-	mu       sync.RWMutex
+	matchers map[string]map[string]valueMatcher // routeID -> jsonPath -> compiled matcher
+	// order lists config.Routes indexes in evaluation order (by priority, then config order)
+	order []int
+	// defaultRoute is the index of the catch-all route in config.Routes (-1 if none)
+	defaultRoute int
+	mu           sync.RWMutex
+	log          *zap.Logger
 }
 
 // NewEngine creates a new transformation engine with the given configuration
 func NewEngine(config *EngineConfig) (*Engine, error) {
 	e := &Engine{
-		config:   config,
-		matchers: make(map[string]map[string]*regexp.Regexp),
+		config:       config,
+		matchers:     make(map[string]map[string]valueMatcher),
+		defaultRoute: -1,
+		log:          zap.NewNop(),
 	}
 
-	// Pre-compile all regex matchers
-	for _, route := range config.Routes {
-		routeMatchers := make(map[string]*regexp.Regexp)
+	// Pre-compile all regex matchers, transform patterns and request schemas
+	for i := range config.Routes {
+		route := &config.Routes[i]
+		route.HeaderPolicy = mergeHeaderPolicy(config.DefaultHeaderPolicy, route.HeaderPolicy)
+		if route.IsDefault() {
+			if route.Default && len(route.Matcher) > 0 {
+				return nil, fmt.Errorf("default route %s must not declare matchers", route.ID)
+			}
+			if e.defaultRoute >= 0 {
+				return nil, fmt.Errorf("routes %s and %s are both default routes (explicit default or empty matcher); at most one is allowed",
+					config.Routes[e.defaultRoute].ID, route.ID)
+			}
+			e.defaultRoute = i
+		}
+		routeMatchers := make(map[string]valueMatcher)
 		for jsonPath, pattern := range route.Matcher {
-			re, err := regexp.Compile(pattern)
+			matcher, err := compileMatcher(pattern)
 			if err != nil {
 				return nil, fmt.Errorf("invalid regex pattern for route %s, path %s: %w", route.ID, jsonPath, err)
 			}
-			routeMatchers[jsonPath] = re
+			routeMatchers[jsonPath] = matcher
 		}
 		e.matchers[route.ID] = routeMatchers
+
+		if err := route.prepareUpstreams(); err != nil {
+			return nil, err
+		}
+
+		if err := compileTransformPatterns(route); err != nil {
+			return nil, err
+		}
+
+		scanner, err := buildScanner(route)
+		if err != nil {
+			return nil, err
+		}
+		route.scanner = scanner
+		if err := route.resolveClients(); err != nil {
+			return nil, err
+		}
+		route.prepareConcurrency()
+
+		schema, err := compileRequestSchema(route.ID, route.RequestSchema)
+		if err != nil {
+			return nil, err
+		}
+		route.requestSchema = schema
 	}
 
+	// Evaluate routes by priority (lower number = evaluated first), keeping config order for ties
+	e.order = make([]int, len(config.Routes))
+	for i := range e.order {
+		e.order[i] = i
+	}
+	sort.SliceStable(e.order, func(a, b int) bool {
+		return config.Routes[e.order[a]].Priority < config.Routes[e.order[b]].Priority
+	})
+
 	return e, nil
 }
 
-// FindRoute finds the first matching route for the given request body
-func (e *Engine) FindRoute(body []byte) (*Route, error) {
+// SetLogger sets the logger used for routing diagnostics such as slow-match warnings
+func (e *Engine) SetLogger(log *zap.Logger) {
+	if log != nil {
+		e.log = log
+	}
+}
+
+// mergeHeaderPolicy combines the global default policy with a route's policy.
+// Allow and Remove lists are unioned; for Rename and Set, route entries win over defaults.
+// Client IP forwarding is enabled if either policy enables it.
+// Header names are compared case-insensitively.
+func mergeHeaderPolicy(defaults, route HeaderPolicy) HeaderPolicy {
+	merged := HeaderPolicy{
+		Allow:           mergeHeaderList(defaults.Allow, route.Allow),
+		Remove:          mergeHeaderList(defaults.Remove, route.Remove),
+		ResponseAllow:   mergeHeaderList(defaults.ResponseAllow, route.ResponseAllow),
+		ForwardClientIP: defaults.ForwardClientIP || route.ForwardClientIP,
+		RealIP:          defaults.RealIP || route.RealIP,
+	}
+
+	if len(defaults.Rename) > 0 || len(route.Rename) > 0 {
+		merged.Rename = make(map[string]string, len(defaults.Rename)+len(route.Rename))
+		for from, to := range defaults.Rename {
+			merged.Rename[strings.ToLower(from)] = to
+		}
+		for from, to := range route.Rename {
+			merged.Rename[strings.ToLower(from)] = to
+		}
+	}
+
+	if len(defaults.Set) > 0 || len(route.Set) > 0 {
+		merged.Set = make(map[string]string, len(defaults.Set)+len(route.Set))
+		for name, value := range defaults.Set {
+			merged.Set[strings.ToLower(name)] = value
+		}
+		for name, value := range route.Set {
+			merged.Set[strings.ToLower(name)] = value
+		}
+	}
+
+	return merged
+}
+
+// mergeHeaderList returns the case-insensitive union of two header lists, keeping order
+func mergeHeaderList(defaults, route []string) []string {
+	if len(defaults) == 0 {
+		return route
+	}
+	seen := make(map[string]bool, len(defaults)+len(route))
+	var merged []string
+	for _, name := range append(append([]string{}, defaults...), route...) {
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, name)
+	}
+	return merged
+}
+
+// IsDefault reports whether the route is the catch-all route: explicitly marked or without matchers
+func (r *Route) IsDefault() bool {
+	return r.Default || len(r.Matcher) == 0
+}
+
+// FindRoute finds the first matching route for the given request body and headers.
+// The default route, if any, is returned only when no other route matches.
+func (e *Engine) FindRoute(body []byte, headers http.Header) (*Route, error) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
+	start := time.Now()
+	evaluated := 0
+	routesChecked := 0
+	defer func() {
+		metrics.RouteMatchersEvaluated.Observe(float64(evaluated))
+		if threshold := e.config.SlowMatchThreshold; threshold > 0 {
+			if elapsed := time.Since(start); elapsed > threshold {
+				e.log.Warn("Slow route matching",
+					zap.Duration("elapsed", elapsed),
+					zap.Int("routes_checked", routesChecked),
+					zap.Int("matchers_evaluated", evaluated),
+				)
+			}
+		}
+	}()
+
 	// Parse body using sonic
 	root, err := sonic.Get(body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse request body: %w", err)
 	}
 
-	// Iterate through routes in order
-	for i := range e.config.Routes {
+	// Iterate through routes in priority order
+	for _, i := range e.order {
+		if max := e.config.MaxRouteEvaluations; max > 0 && routesChecked >= max {
+			e.log.Warn("Route evaluation cap reached, giving up",
+				zap.Int("max_route_evaluations", max),
+				zap.Int("routes", len(e.config.Routes)),
+			)
+			return nil, nil
+		}
+		routesChecked++
+
+		// The default route is evaluated after all specific routes
+		if i == e.defaultRoute {
+			continue
+		}
+
 		route := &e.config.Routes[i]
 		routeMatchers := e.matchers[route.ID]
 
 		// Check if all matchers match
 		allMatch := true
 		for jsonPath, re := range routeMatchers {
+			evaluated++
+
+			// Header matchers match if any value of the header matches
+			if name, ok := strings.CutPrefix(jsonPath, HeaderMatcherPrefix); ok {
+				if !matchHeader(headers, name, re) {
+					allMatch = false
+					break
+				}
+				continue
+			}
+
 			// Get value at JSON path
 			node := root.Get(jsonPath)
 			if err := node.Check(); err != nil {
@@ -73,7 +239,7 @@ func (e *Engine) FindRoute(body []byte) (*Route, error) {
 				value = rawValue
 			}
 
-			// Check if value matches regex
+			// Check if value matches the regex or numeric comparison
 			if !re.MatchString(value) {
 				allMatch = false
 				break
@@ -85,9 +251,23 @@ func (e *Engine) FindRoute(body []byte) (*Route, error) {
 		}
 	}
 
+	if e.defaultRoute >= 0 {
+		return &e.config.Routes[e.defaultRoute], nil
+	}
+
 	return nil, nil // No matching route found
 }
 
+// matchHeader reports whether any value of the named header matches re (false if absent)
+func matchHeader(headers http.Header, name string, re valueMatcher) bool {
+	for _, value := range headers.Values(name) {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetConfig returns the engine configuration
 func (e *Engine) GetConfig() *EngineConfig {
 	return e.config
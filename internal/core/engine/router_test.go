@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"aigis/internal/pkg/metrics"
+)
+
+// manyRoutes builds n routes where only the last one matches "target"
+func manyRoutes(n int) *EngineConfig {
+	config := &EngineConfig{}
+	for i := 0; i < n-1; i++ {
+		config.Routes = append(config.Routes, Route{
+			ID:      fmt.Sprintf("route-%d", i),
+			Matcher: map[string]string{"model": fmt.Sprintf("^model-%d$", i)},
+		})
+	}
+	config.Routes = append(config.Routes, Route{ID: "target", Matcher: map[string]string{"model": "^target$"}})
+	return config
+}
+
+func matchersEvaluatedSamples(t *testing.T) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.RouteMatchersEvaluated.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestFindRouteRecordsEvaluationsAndSlowMatch(t *testing.T) {
+	config := manyRoutes(500)
+	config.SlowMatchThreshold = time.Nanosecond // every match is "slow"
+	e, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	observed, logs := observer.New(zap.WarnLevel)
+	e.SetLogger(zap.New(observed))
+
+	countBefore, sumBefore := matchersEvaluatedSamples(t)
+	route, err := e.FindRoute([]byte(`{"model":"target"}`), nil)
+	if err != nil || route == nil || route.ID != "target" {
+		t.Fatalf("Expected target route, got %v, %v", route, err)
+	}
+
+	count, sum := matchersEvaluatedSamples(t)
+	if count != countBefore+1 {
+		t.Errorf("Expected one evaluation sample, got %d", count-countBefore)
+	}
+	if sum-sumBefore != 500 {
+		t.Errorf("Expected 500 matchers evaluated, got %v", sum-sumBefore)
+	}
+
+	entries := logs.FilterMessage("Slow route matching").All()
+	if len(entries) != 1 {
+		t.Fatalf("Expected slow-match warning, got %d", len(entries))
+	}
+	if got := entries[0].ContextMap()["routes_checked"]; got != int64(500) {
+		t.Errorf("routes_checked = %v, want 500", got)
+	}
+}
+
+func TestFindRouteEvaluationCap(t *testing.T) {
+	config := manyRoutes(100)
+	config.MaxRouteEvaluations = 10
+	e, err := NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	observed, logs := observer.New(zap.WarnLevel)
+	e.SetLogger(zap.New(observed))
+
+	if route, _ := e.FindRoute([]byte(`{"model":"target"}`), nil); route != nil {
+		t.Errorf("Route beyond the cap should not be matched, got %s", route.ID)
+	}
+	if logs.FilterMessage("Route evaluation cap reached, giving up").Len() != 1 {
+		t.Error("Expected a warning when the cap is reached")
+	}
+	if route, _ := e.FindRoute([]byte(`{"model":"model-5"}`), nil); route == nil || route.ID != "route-5" {
+		t.Errorf("Routes within the cap should still match, got %v", route)
+	}
+}
+
+func TestFindRouteDefaultRouteEvaluatedLast(t *testing.T) {
+	for name, fallback := range map[string]Route{
+		"empty matcher":    {ID: "fallback"},
+		"explicit default": {ID: "fallback", Default: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The default route comes first in config order but must not shadow specific routes
+			e, err := NewEngine(&EngineConfig{Routes: []Route{
+				fallback,
+				{ID: "gpt", Matcher: map[string]string{"model": "^gpt-"}},
+			}})
+			if err != nil {
+				t.Fatalf("NewEngine failed: %v", err)
+			}
+
+			if route, err := e.FindRoute([]byte(`{"model":"gpt-4"}`), nil); err != nil || route == nil || route.ID != "gpt" {
+				t.Errorf("Specific route should win, got %v, %v", route, err)
+			}
+			if route, err := e.FindRoute([]byte(`{"model":"llama-3"}`), nil); err != nil || route == nil || route.ID != "fallback" {
+				t.Errorf("Unmatched request should use the default route, got %v, %v", route, err)
+			}
+		})
+	}
+}
+
+func TestNewEngineRejectsInvalidDefaultRoutes(t *testing.T) {
+	for name, routes := range map[string][]Route{
+		"two empty matchers": {{ID: "a"}, {ID: "b"}},
+		"explicit and empty": {{ID: "a", Default: true}, {ID: "b"}},
+		"default with matcher": {
+			{ID: "a", Default: true, Matcher: map[string]string{"model": "^gpt-"}},
+		},
+	} {
+		if _, err := NewEngine(&EngineConfig{Routes: routes}); err == nil {
+			t.Errorf("%s: expected NewEngine to fail", name)
+		}
+	}
+}
+
+func TestFindRouteHeaderMatcher(t *testing.T) {
+	e, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "acme", Matcher: map[string]string{"header:X-Tenant": "^acme-.*", "model": "^gpt-"}},
+		{ID: "gpt", Matcher: map[string]string{"model": "^gpt-"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	body := []byte(`{"model":"gpt-4"}`)
+	for _, tc := range []struct {
+		name    string
+		headers http.Header
+		want    string
+	}{
+		{"matching header", http.Header{"X-Tenant": {"acme-prod"}}, "acme"},
+		{"any value matches", http.Header{"X-Tenant": {"other", "acme-dev"}}, "acme"},
+		{"non-matching header", http.Header{"X-Tenant": {"globex"}}, "gpt"},
+		{"missing header", http.Header{}, "gpt"},
+		{"nil headers", nil, "gpt"},
+	} {
+		route, err := e.FindRoute(body, tc.headers)
+		if err != nil || route == nil || route.ID != tc.want {
+			t.Errorf("%s: got %v, %v; want %s", tc.name, route, err, tc.want)
+		}
+	}
+
+	// Header names are case-insensitive when set through the canonical API
+	headers := http.Header{}
+	headers.Set("x-tenant", "acme-prod")
+	if route, _ := e.FindRoute(body, headers); route == nil || route.ID != "acme" {
+		t.Errorf("Expected acme route for lowercase header name, got %v", route)
+	}
+}
+
+func TestFindRoutePriority(t *testing.T) {
+	e, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "generic", Matcher: map[string]string{"model": ".*"}, Priority: 10},
+		{ID: "also-generic", Matcher: map[string]string{"model": "^gpt-"}, Priority: 10},
+		{ID: "gpt4", Matcher: map[string]string{"model": "^gpt-4"}, Priority: 1},
+	}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	// The later route wins because its priority number is lower
+	if route, _ := e.FindRoute([]byte(`{"model":"gpt-4o"}`), nil); route == nil || route.ID != "gpt4" {
+		t.Errorf("Expected gpt4 route, got %v", route)
+	}
+	// Equal priorities keep config order
+	if route, _ := e.FindRoute([]byte(`{"model":"gpt-3.5"}`), nil); route == nil || route.ID != "generic" {
+		t.Errorf("Expected generic route, got %v", route)
+	}
+	// The config itself is left in its original order
+	if id := e.GetConfig().Routes[0].ID; id != "generic" {
+		t.Errorf("Config routes should not be reordered, first is %s", id)
+	}
+}
@@ -0,0 +1,125 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"aigis/internal/core/security"
+)
+
+// ScannerConfig customizes PII detection for a route
+type ScannerConfig struct {
+	// Rules adds custom detection rules on top of the built-in rules
+	Rules []ScannerRule `mapstructure:"rules"`
+	// EnableRules turns on opt-in built-in rules (e.g. "US Phone")
+	EnableRules []string `mapstructure:"enable_rules"`
+	// DisabledRules removes built-in or custom rules by name
+	DisabledRules []string `mapstructure:"disabled_rules"`
+	// Allowlist lists exact values that are never masked
+	Allowlist []string `mapstructure:"allowlist"`
+	// Tags restricts masking to the named rules ("all" = every rule that is not opt-in); naming
+	// an opt-in rule also enables it for sanitizing (log bodies, redact_response, /v1/scan)
+	Tags []string `mapstructure:"tags"`
+	// AdaptiveOrdering periodically reorders rules within their priority tier by hit count,
+	// so the rules that match this route's traffic most are tried first
+	AdaptiveOrdering bool `mapstructure:"adaptive_ordering"`
+}
+
+// ScannerRule declares a custom detection rule
+type ScannerRule struct {
+	// Name identifies the rule in logs, metrics and audit events
+	Name string `mapstructure:"name"`
+	// Pattern is the regular expression matching the sensitive value
+	Pattern string `mapstructure:"pattern"`
+	// Replacement is the text used when sanitizing instead of tokenizing (default: "[REDACTED]")
+	Replacement string `mapstructure:"replacement"`
+	// Partial is the partial-masking template used by Scanner.SanitizePartial, with {first},
+	// {last4} and {domain} taken from the match (e.g. "****-{last4}")
+	Partial string `mapstructure:"partial"`
+}
+
+// PIIScanner returns the route's scanner. Routes prepared by NewEngine share the scanner it
+// built; other routes (e.g. built directly in tests) get a fresh one per call, and an invalid
+// scanner configuration is reported instead of silently falling back to the default rules.
+func (r *Route) PIIScanner() (*security.Scanner, error) {
+	if r.scanner != nil {
+		return r.scanner, nil
+	}
+	return buildScanner(r)
+}
+
+// ScannerTags returns the rule tags PII steps of this route mask with (nil = default rules)
+func (r *Route) ScannerTags() []string {
+	if r.Scanner == nil {
+		return nil
+	}
+	return r.Scanner.Tags
+}
+
+// buildScanner creates the scanner for a route from its scanner block and the settings of
+// its PII steps (rules, enable_rules, disabled_rules, allowlist). Invalid patterns and
+// unknown rule names fail here so misconfigured routes are caught at startup.
+func buildScanner(route *Route) (*security.Scanner, error) {
+	config := ScannerConfig{}
+	if route.Scanner != nil {
+		config = *route.Scanner
+	}
+	for _, step := range route.Transforms {
+		config.Rules = append(config.Rules, step.Rules...)
+		config.EnableRules = append(config.EnableRules, splitList(step.Config["enable_rules"])...)
+		config.DisabledRules = append(config.DisabledRules, splitList(step.Config["disabled_rules"])...)
+		config.Allowlist = append(config.Allowlist, splitList(step.Config["allowlist"])...)
+	}
+
+	scanner := security.NewScanner()
+	for _, rule := range config.Rules {
+		if rule.Name == "" || rule.Pattern == "" {
+			return nil, fmt.Errorf("invalid scanner rule for route %s: name and pattern are required", route.ID)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = "[REDACTED]"
+		}
+		if err := scanner.AddRule(rule.Name, rule.Pattern, replacement); err != nil {
+			return nil, fmt.Errorf("invalid scanner rule %q for route %s: %w", rule.Name, route.ID, err)
+		}
+		if err := scanner.SetPartialFormat(rule.Name, rule.Partial); err != nil {
+			return nil, fmt.Errorf("invalid scanner rule %q for route %s: %w", rule.Name, route.ID, err)
+		}
+	}
+	for _, name := range config.EnableRules {
+		if err := scanner.EnableRule(name); err != nil {
+			return nil, fmt.Errorf("invalid enable_rules for route %s: %w", route.ID, err)
+		}
+	}
+	// Opt-in rules named in tags are masked by the route's PII steps, so Sanitize and Redact
+	// (log bodies, redact_response, /v1/scan) must apply them too
+	for _, rule := range scanner.GetRules() {
+		if rule.OptIn && slices.Contains(config.Tags, rule.Name) {
+			scanner.EnableRule(rule.Name)
+		}
+	}
+	for _, name := range config.DisabledRules {
+		if !scanner.DisableRule(name) {
+			return nil, fmt.Errorf("invalid disabled_rules for route %s: rule %q not found", route.ID, name)
+		}
+	}
+	scanner.AddAllowlist(config.Allowlist...)
+	if config.AdaptiveOrdering {
+		scanner.EnableAdaptiveOrdering(0)
+	}
+
+	return scanner, nil
+}
+
+// splitList parses a comma-separated step config value, trimming whitespace and empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,93 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// RequestSchema references a JSON Schema used to validate request bodies for a route.
+// Exactly one of File or Inline should be set.
+type RequestSchema struct {
+	// File is the path to a JSON Schema document
+	File string `mapstructure:"file"`
+	// Inline is a JSON Schema document embedded in the config as a JSON string.
+	// A string is used (rather than a YAML map) because config keys are case-folded
+	Inline string `mapstructure:"inline"`
+}
+
+// compileRequestSchema compiles the route's request schema, if any
+func compileRequestSchema(routeID string, rs *RequestSchema) (*jsonschema.Schema, error) {
+	if rs == nil || (rs.File == "" && rs.Inline == "") {
+		return nil, nil
+	}
+	if rs.File != "" && rs.Inline != "" {
+		return nil, fmt.Errorf("route %s: request_schema must set only one of file or inline", routeID)
+	}
+
+	compiler := jsonschema.NewCompiler()
+	location := rs.File
+	if rs.Inline != "" {
+		doc, err := jsonschema.UnmarshalJSON(strings.NewReader(rs.Inline))
+		if err != nil {
+			return nil, fmt.Errorf("route %s: invalid inline request_schema: %w", routeID, err)
+		}
+		location = fmt.Sprintf("aigis://routes/%s/request_schema.json", routeID)
+		if err := compiler.AddResource(location, doc); err != nil {
+			return nil, fmt.Errorf("route %s: invalid inline request_schema: %w", routeID, err)
+		}
+	}
+
+	schema, err := compiler.Compile(location)
+	if err != nil {
+		return nil, fmt.Errorf("route %s: failed to compile request_schema: %w", routeID, err)
+	}
+	return schema, nil
+}
+
+// ValidateRequestBody validates body against the route's compiled request schema.
+// It returns nil when the route has no schema. On failure the returned
+// *SchemaViolationError lists every violation found.
+func (r *Route) ValidateRequestBody(body []byte) error {
+	if r.requestSchema == nil {
+		return nil
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+	if err != nil {
+		return &SchemaViolationError{Violations: []string{"body is not valid JSON"}}
+	}
+
+	err = r.requestSchema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	var violations []string
+	for _, unit := range validationErr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		violations = append(violations, fmt.Sprintf("%s: %s", location, unit.Error.String()))
+	}
+	return &SchemaViolationError{Violations: violations}
+}
+
+// SchemaViolationError reports the schema violations of a request body
+type SchemaViolationError struct {
+	Violations []string
+}
+
+func (e *SchemaViolationError) Error() string {
+	return "request body does not match schema: " + strings.Join(e.Violations, "; ")
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["model", "messages"],
+	"properties": {
+		"model": {"type": "string"},
+		"max_tokens": {"type": "integer"},
+		"messages": {"type": "array"}
+	}
+}`
+
+func TestValidateRequestBody(t *testing.T) {
+	e, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "inline", RequestSchema: &RequestSchema{Inline: testSchema}},
+	}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	route := &e.GetConfig().Routes[0]
+
+	testCases := []struct {
+		name      string
+		body      string
+		violation string
+	}{
+		{"valid body", `{"model":"gpt-4","messages":[],"max_tokens":10}`, ""},
+		{"missing required field", `{"model":"gpt-4"}`, "messages"},
+		{"wrong type", `{"model":"gpt-4","messages":[],"max_tokens":"ten"}`, "/max_tokens"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := route.ValidateRequestBody([]byte(tc.body))
+			if tc.violation == "" {
+				if err != nil {
+					t.Fatalf("Expected valid body, got %v", err)
+				}
+				return
+			}
+
+			var violation *SchemaViolationError
+			if !errors.As(err, &violation) {
+				t.Fatalf("Expected SchemaViolationError, got %v", err)
+			}
+			if !strings.Contains(violation.Error(), tc.violation) {
+				t.Errorf("Violation should mention %q, got %q", tc.violation, violation.Error())
+			}
+		})
+	}
+}
+
+func TestRequestSchemaFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(testSchema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "file", RequestSchema: &RequestSchema{File: path}},
+		{ID: "none", Matcher: map[string]string{"model": "^none$"}},
+	}})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	routes := e.GetConfig().Routes
+	if err := routes[0].ValidateRequestBody([]byte(`{"model":1}`)); err == nil {
+		t.Error("Expected schema violation from file schema")
+	}
+	if err := routes[1].ValidateRequestBody([]byte(`{"model":1}`)); err != nil {
+		t.Errorf("Routes without a schema should accept any body, got %v", err)
+	}
+}
+
+func TestNewEngineRejectsInvalidSchema(t *testing.T) {
+	_, err := NewEngine(&EngineConfig{Routes: []Route{
+		{ID: "broken", RequestSchema: &RequestSchema{Inline: `{"type": 5}`}},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected compile error naming the route, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compileTransformPatterns precompiles the patterns of regex_replace steps so invalid
+// expressions fail at startup and requests share one compiled regexp
+func compileTransformPatterns(route *Route) error {
+	for i := range route.Transforms {
+		step := &route.Transforms[i]
+		if step.Type != TransformTypeRegexReplace {
+			continue
+		}
+		pattern, err := step.compilePattern()
+		if err != nil {
+			return fmt.Errorf("route %s: transforms[%d]: %w", route.ID, i, err)
+		}
+		step.pattern = pattern
+	}
+	return nil
+}
+
+// RegexPattern returns the compiled pattern of a regex_replace step. Steps prepared by
+// NewEngine reuse the precompiled pattern; others (e.g. built directly in tests) compile it per call.
+func (s TransformStep) RegexPattern() (*regexp.Regexp, error) {
+	if s.pattern != nil {
+		return s.pattern, nil
+	}
+	return s.compilePattern()
+}
+
+// compilePattern checks the regex_replace config and compiles its pattern
+func (s TransformStep) compilePattern() (*regexp.Regexp, error) {
+	if s.Config["path"] == "" || s.Config["pattern"] == "" {
+		return nil, fmt.Errorf("%s requires path and pattern", TransformTypeRegexReplace)
+	}
+	pattern, err := regexp.Compile(s.Config["pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", TransformTypeRegexReplace, err)
+	}
+	return pattern, nil
+}
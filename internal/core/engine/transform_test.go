@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewEngineCompilesRegexReplacePatterns(t *testing.T) {
+	config := &EngineConfig{Routes: []Route{{
+		ID: "regex",
+		Transforms: []TransformStep{
+			{Type: TransformTypePII},
+			{Type: TransformTypeRegexReplace, Config: map[string]string{"path": "prompt", "pattern": `\s+`}},
+		},
+	}}}
+	if _, err := NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	step := config.Routes[0].Transforms[1]
+	if step.pattern == nil {
+		t.Fatal("regex_replace pattern should be precompiled")
+	}
+	if pattern, err := step.RegexPattern(); err != nil || pattern != step.pattern {
+		t.Errorf("RegexPattern() should return the precompiled pattern, got %v, %v", pattern, err)
+	}
+}
+
+func TestNewEngineRejectsInvalidRegexReplace(t *testing.T) {
+	for name, stepConfig := range map[string]map[string]string{
+		"invalid pattern": {"path": "prompt", "pattern": `(unclosed`},
+		"missing path":    {"pattern": `\s+`},
+		"missing pattern": {"path": "prompt"},
+	} {
+		_, err := NewEngine(&EngineConfig{Routes: []Route{{
+			ID:         "regex",
+			Transforms: []TransformStep{{Type: TransformTypeRegexReplace, Config: stepConfig}},
+		}}})
+		if err == nil || !strings.Contains(err.Error(), "route regex: transforms[0]") {
+			t.Errorf("%s: expected an error naming the route and step, got: %v", name, err)
+		}
+	}
+}
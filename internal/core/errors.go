@@ -0,0 +1,120 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCategory classifies gateway errors so they can be mapped to HTTP statuses uniformly
+type ErrorCategory string
+
+// ErrorCategory constants
+const (
+	ErrCategoryValidation ErrorCategory = "validation" // Malformed or unacceptable client request
+	ErrCategoryAuth       ErrorCategory = "auth"       // Missing or invalid credentials
+	ErrCategoryUpstream   ErrorCategory = "upstream"   // Upstream service failed or rejected the request
+	ErrCategoryTimeout    ErrorCategory = "timeout"    // An operation exceeded its deadline
+	ErrCategoryInternal   ErrorCategory = "internal"   // Unexpected gateway failure
+)
+
+// GatewayError is the standardized error type used throughout the pipeline and providers.
+// Message is safe to return to clients; Err carries the underlying cause for logging only.
+type GatewayError struct {
+	Category ErrorCategory
+	Status   int
+	Message  string
+	Err      error
+}
+
+// NewGatewayError creates a GatewayError. A zero status uses the category's default status.
+func NewGatewayError(category ErrorCategory, status int, message string, err error) *GatewayError {
+	if status == 0 {
+		status = StatusForCategory(category)
+	}
+	return &GatewayError{
+		Category: category,
+		Status:   status,
+		Message:  message,
+		Err:      err,
+	}
+}
+
+// NewValidationError creates a validation error (400)
+func NewValidationError(message string, err error) *GatewayError {
+	return NewGatewayError(ErrCategoryValidation, 0, message, err)
+}
+
+// NewAuthError creates an authentication error (401)
+func NewAuthError(message string, err error) *GatewayError {
+	return NewGatewayError(ErrCategoryAuth, 0, message, err)
+}
+
+// NewUpstreamError creates an upstream error (502)
+func NewUpstreamError(message string, err error) *GatewayError {
+	return NewGatewayError(ErrCategoryUpstream, 0, message, err)
+}
+
+// NewTimeoutError creates a timeout error (504)
+func NewTimeoutError(message string, err error) *GatewayError {
+	return NewGatewayError(ErrCategoryTimeout, 0, message, err)
+}
+
+// NewInternalError creates an internal error (500)
+func NewInternalError(message string, err error) *GatewayError {
+	return NewGatewayError(ErrCategoryInternal, 0, message, err)
+}
+
+// Error implements the error interface, including the underlying cause
+func (e *GatewayError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying cause
+func (e *GatewayError) Unwrap() error {
+	return e.Err
+}
+
+// UpstreamStatusError records a non-200 upstream response. It is the cause of the
+// GatewayError returned for the response, so callers can recover the upstream status.
+type UpstreamStatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Error implements the error interface
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, string(e.Body))
+}
+
+// StatusForCategory returns the default HTTP status for an error category
+func StatusForCategory(category ErrorCategory) int {
+	switch category {
+	case ErrCategoryValidation:
+		return http.StatusBadRequest
+	case ErrCategoryAuth:
+		return http.StatusUnauthorized
+	case ErrCategoryUpstream:
+		return http.StatusBadGateway
+	case ErrCategoryTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// AsGatewayError finds a GatewayError in err's chain.
+// Errors that are not GatewayErrors are wrapped as internal errors.
+func AsGatewayError(err error) *GatewayError {
+	if err == nil {
+		return nil
+	}
+	var gwErr *GatewayError
+	if errors.As(err, &gwErr) {
+		return gwErr
+	}
+	return NewInternalError("internal gateway error", err)
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGatewayErrorStatusMapping(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      *GatewayError
+		category ErrorCategory
+		status   int
+	}{
+		{"validation", NewValidationError("bad body", nil), ErrCategoryValidation, http.StatusBadRequest},
+		{"auth", NewAuthError("missing key", nil), ErrCategoryAuth, http.StatusUnauthorized},
+		{"upstream", NewUpstreamError("upstream down", nil), ErrCategoryUpstream, http.StatusBadGateway},
+		{"timeout", NewTimeoutError("too slow", nil), ErrCategoryTimeout, http.StatusGatewayTimeout},
+		{"internal", NewInternalError("boom", nil), ErrCategoryInternal, http.StatusInternalServerError},
+		{"explicit status", NewGatewayError(ErrCategoryValidation, http.StatusNotFound, "no route", nil), ErrCategoryValidation, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.err.Category != tc.category {
+				t.Errorf("Category = %s, want %s", tc.err.Category, tc.category)
+			}
+			if tc.err.Status != tc.status {
+				t.Errorf("Status = %d, want %d", tc.err.Status, tc.status)
+			}
+
+			// Mapping must survive wrapping
+			wrapped := fmt.Errorf("context: %w", tc.err)
+			if got := AsGatewayError(wrapped); got.Status != tc.status {
+				t.Errorf("AsGatewayError(wrapped).Status = %d, want %d", got.Status, tc.status)
+			}
+		})
+	}
+}
+
+func TestAsGatewayErrorPlainError(t *testing.T) {
+	cause := errors.New("something broke")
+	gwErr := AsGatewayError(cause)
+
+	if gwErr.Category != ErrCategoryInternal {
+		t.Errorf("Plain errors should map to internal, got %s", gwErr.Category)
+	}
+	if gwErr.Status != http.StatusInternalServerError {
+		t.Errorf("Plain errors should map to 500, got %d", gwErr.Status)
+	}
+	if !errors.Is(gwErr, cause) {
+		t.Error("Wrapped error should unwrap to the original cause")
+	}
+	if AsGatewayError(nil) != nil {
+		t.Error("AsGatewayError(nil) should return nil")
+	}
+}
+
+func TestGatewayErrorMessageHidesCause(t *testing.T) {
+	gwErr := NewUpstreamError("failed to send upstream request", errors.New("dial tcp 10.0.0.1:443: refused"))
+
+	if gwErr.Message != "failed to send upstream request" {
+		t.Errorf("Message should be client-safe, got %q", gwErr.Message)
+	}
+	if gwErr.Error() == gwErr.Message {
+		t.Error("Error() should include the underlying cause for logging")
+	}
+}
@@ -1,12 +1,31 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sort"
+	"time"
+
+	"go.uber.org/zap"
 )
 
+// ErrStepTimeout is returned when a processor or transform step exceeds its deadline
+var ErrStepTimeout = errors.New("step timed out")
+
+// PipelineConfig configures processor execution limits
+type PipelineConfig struct {
+	// StepTimeoutMs bounds each processor call (0 disables the limit)
+	StepTimeoutMs int `mapstructure:"step_timeout_ms"`
+	// FailOpen skips a timed-out processor instead of failing the request
+	FailOpen bool `mapstructure:"fail_open"`
+}
+
 // Pipeline holds a collection of processors and manages their execution
 type Pipeline struct {
-	processors []Processor
+	processors  []Processor
+	stepTimeout time.Duration
+	failOpen    bool
 }
 
 // NewPipeline creates a new pipeline instance
@@ -21,6 +40,14 @@ func (p *Pipeline) AddProcessor(processor Processor) {
 	p.processors = append(p.processors, processor)
 }
 
+// SetStepTimeout bounds how long each processor may run. When a processor exceeds the
+// timeout it is abandoned; with failOpen the pipeline skips it and continues with the
+// previous body, otherwise the request fails with a timeout error naming the processor.
+func (p *Pipeline) SetStepTimeout(timeout time.Duration, failOpen bool) {
+	p.stepTimeout = timeout
+	p.failOpen = failOpen
+}
+
 // ExecuteRequest executes all processors' OnRequest methods in priority order
 func (p *Pipeline) ExecuteRequest(ctx *AIGisContext, body []byte) ([]byte, error) {
 	// Create a copy of processors to avoid modifying the original slice
@@ -35,11 +62,14 @@ func (p *Pipeline) ExecuteRequest(ctx *AIGisContext, body []byte) ([]byte, error
 	// Execute each processor's OnRequest method
 	result := body
 	for _, processor := range sortedProcessors {
-		var err error
-		result, err = processor.OnRequest(ctx, result)
+		current := result // captured by value: an abandoned step must not observe later updates
+		next, err := p.runStep(ctx, processor, current, func() ([]byte, error) {
+			return processor.OnRequest(ctx, current)
+		})
 		if err != nil {
 			return nil, err
 		}
+		result = next
 	}
 
 	return result, nil
@@ -59,12 +89,84 @@ func (p *Pipeline) ExecuteResponse(ctx *AIGisContext, body []byte) ([]byte, erro
 	// Execute each processor's OnResponse method
 	result := body
 	for _, processor := range sortedProcessors {
-		var err error
-		result, err = processor.OnResponse(ctx, result)
+		current := result
+		next, err := p.runStep(ctx, processor, current, func() ([]byte, error) {
+			return processor.OnResponse(ctx, current)
+		})
 		if err != nil {
 			return nil, err
 		}
+		result = next
 	}
 
 	return result, nil
 }
+
+// runStep runs a single processor call under the configured step timeout.
+// On a fail-open timeout the current body is returned unchanged.
+func (p *Pipeline) runStep(ctx *AIGisContext, processor Processor, current []byte, step func() ([]byte, error)) ([]byte, error) {
+	result, err := RunWithTimeout(ctx, p.stepTimeout, step)
+	if err == nil {
+		return result, nil
+	}
+
+	if errors.Is(err, ErrStepTimeout) {
+		if p.failOpen {
+			if ctx.Log != nil {
+				ctx.Log.Warn("Processor timed out, skipping",
+					zap.String("processor", processor.Name()),
+					zap.Duration("timeout", p.stepTimeout),
+				)
+			}
+			return current, nil
+		}
+		return nil, NewTimeoutError(
+			fmt.Sprintf("processor %s timed out", processor.Name()),
+			fmt.Errorf("processor %s after %s: %w", processor.Name(), p.stepTimeout, err),
+		)
+	}
+	return nil, wrapProcessorError(processor, err)
+}
+
+// RunWithTimeout runs step with a deadline derived from ctx. If the deadline passes first,
+// the step is abandoned (its eventual result is discarded) and ErrStepTimeout is returned.
+// A timeout of zero or less runs step directly.
+func RunWithTimeout(ctx *AIGisContext, timeout time.Duration, step func() ([]byte, error)) ([]byte, error) {
+	if timeout <= 0 {
+		return step()
+	}
+
+	stepCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type stepResult struct {
+		body []byte
+		err  error
+	}
+	done := make(chan stepResult, 1) // buffered so an abandoned step never blocks
+	go func() {
+		body, err := step()
+		done <- stepResult{body, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.body, r.err
+	case <-stepCtx.Done():
+		// The request itself was cancelled or expired - not this step's fault
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrStepTimeout
+	}
+}
+
+// wrapProcessorError converts a processor failure into a GatewayError.
+// Processors may return their own GatewayError to control the client-facing status.
+func wrapProcessorError(processor Processor, err error) error {
+	var gwErr *GatewayError
+	if errors.As(err, &gwErr) {
+		return err
+	}
+	return NewInternalError("request processing failed", fmt.Errorf("processor %s: %w", processor.Name(), err))
+}
@@ -0,0 +1,74 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// stubProcessor is a configurable processor for pipeline tests
+type stubProcessor struct {
+	name     string
+	priority int
+	delay    time.Duration
+	suffix   string
+}
+
+func (p *stubProcessor) Name() string  { return p.name }
+func (p *stubProcessor) Priority() int { return p.priority }
+
+func (p *stubProcessor) OnRequest(ctx *AIGisContext, body []byte) ([]byte, error) {
+	time.Sleep(p.delay)
+	return append(append([]byte{}, body...), p.suffix...), nil
+}
+
+func (p *stubProcessor) OnResponse(ctx *AIGisContext, body []byte) ([]byte, error) {
+	return p.OnRequest(ctx, body)
+}
+
+func newSlowPipeline(failOpen bool) *Pipeline {
+	pipeline := NewPipeline()
+	pipeline.AddProcessor(&stubProcessor{name: "fast", priority: 1, suffix: "-fast"})
+	pipeline.AddProcessor(&stubProcessor{name: "slow", priority: 2, delay: time.Second, suffix: "-slow"})
+	pipeline.AddProcessor(&stubProcessor{name: "last", priority: 3, suffix: "-last"})
+	pipeline.SetStepTimeout(20*time.Millisecond, failOpen)
+	return pipeline
+}
+
+func TestPipelineStepTimeoutFailClosed(t *testing.T) {
+	ctx := NewGatewayContext(context.Background(), zap.NewNop())
+
+	start := time.Now()
+	_, err := newSlowPipeline(false).ExecuteRequest(ctx, []byte("body"))
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Timeout should bound latency, took %s", elapsed)
+	}
+
+	gwErr := AsGatewayError(err)
+	if gwErr == nil || gwErr.Status != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504 timeout error, got %v", err)
+	}
+	if !strings.Contains(gwErr.Message, "slow") {
+		t.Errorf("Error should name the step, got %q", gwErr.Message)
+	}
+	if !errors.Is(err, ErrStepTimeout) {
+		t.Errorf("Error should wrap ErrStepTimeout, got %v", err)
+	}
+}
+
+func TestPipelineStepTimeoutFailOpen(t *testing.T) {
+	ctx := NewGatewayContext(context.Background(), zap.NewNop())
+
+	result, err := newSlowPipeline(true).ExecuteRequest(ctx, []byte("body"))
+	if err != nil {
+		t.Fatalf("Fail-open should skip the slow step, got %v", err)
+	}
+	if string(result) != "body-fast-last" {
+		t.Errorf("Result = %q, want %q", result, "body-fast-last")
+	}
+}
@@ -1,6 +1,7 @@
 package processors
 
 import (
+	"net/http"
 	"time"
 
 	"aigis/internal/core"
@@ -13,6 +14,8 @@ import (
 type RequestLogger struct {
 	name     string
 	priority int
+	// logBodies 开启时同时记录请求体和响应体，记录前总是先经过 Sanitize 清理敏感信息
+	logBodies bool
 }
 
 // NewRequestLogger 创建一个新的请求日志处理器
@@ -23,6 +26,26 @@ func NewRequestLogger() *RequestLogger {
 	}
 }
 
+// SetLogBodies 开启或关闭请求体/响应体日志（log.log_bodies），需在处理请求前调用
+// 请求体是脱敏前的原文、响应体是还原后的内容，因此记录前一律经过 ctx.Sanitizer() 清理，
+// 即匹配路由的 scanner（含自定义规则）
+func (r *RequestLogger) SetLogBodies(enabled bool) {
+	r.logBodies = enabled
+}
+
+// bodyField 返回清理后的 body 日志字段；未开启 body 日志或 ctx 上没有 sanitizer 时返回 false，
+// 宁可不记录也不写入未清理的内容
+func (r *RequestLogger) bodyField(ctx *core.AIGisContext, body []byte) (zap.Field, bool) {
+	if !r.logBodies {
+		return zap.Skip(), false
+	}
+	sanitizer := ctx.Sanitizer()
+	if sanitizer == nil {
+		return zap.Skip(), false
+	}
+	return zap.String("body", sanitizer.Sanitize(string(body))), true
+}
+
 // Name 返回处理器名称
 func (r *RequestLogger) Name() string {
 	return r.name
@@ -39,12 +62,23 @@ func (r *RequestLogger) OnRequest(ctx *core.AIGisContext, body []byte) ([]byte,
 	model, _ := sonic.Get(body, "model")
 	modelStr, _ := model.String()
 
-	// 记录请求开始 - logger 会自动获取调用者信息
-	ctx.Log.Info("Request Started",
-		zap.String("method", "POST"),
-		zap.String("path", "/v1/chat/completions"),
+	// 方法和路径由服务端写入 metadata，未设置时记录为空
+	method, _ := ctx.GetMetadata(core.MetaRequestMethod)
+	path, _ := ctx.GetMetadata(core.MetaRequestPath)
+	methodStr, _ := method.(string)
+	pathStr, _ := path.(string)
+
+	fields := []zap.Field{
+		zap.String("method", methodStr),
+		zap.String("path", pathStr),
 		zap.String("model", modelStr),
-	)
+	}
+	if field, ok := r.bodyField(ctx, body); ok {
+		fields = append(fields, field)
+	}
+
+	// 记录请求开始 - logger 会自动获取调用者信息
+	ctx.Log.Info("Request Started", fields...)
 
 	// 直接返回原始 body，不做修改
 	return body, nil
@@ -61,12 +95,60 @@ func (r *RequestLogger) OnResponse(ctx *core.AIGisContext, body []byte) ([]byte,
 	// 四舍五入到三位小数
 	latencyMs = float64(int64(latencyMs*1000+0.5)) / 1000
 
-	// 记录请求完成 - logger 会自动获取调用者信息
-	ctx.Log.Info("Request Finished",
+	// 最终状态码由服务端写入 metadata，未设置时视为 200
+	statusCode := http.StatusOK
+	if value, ok := ctx.GetMetadata(core.MetaResponseStatus); ok {
+		if code, ok := value.(int); ok {
+			statusCode = code
+		}
+	}
+	status := "Success"
+	if statusCode >= http.StatusBadRequest {
+		status = "Failed"
+	}
+
+	fields := []zap.Field{
 		zap.Float64("latency_ms", latencyMs),
-		zap.String("status", "Success"),
-	)
+		zap.String("status", status),
+		zap.Int("status_code", statusCode),
+	}
+	if value, ok := ctx.GetMetadata(core.MetaError); ok {
+		if message, ok := value.(string); ok && message != "" {
+			fields = append(fields, zap.String("error", message))
+		}
+	}
+	// 本次请求中各规则脱敏的数量，例如 {"Email": 2, "Credit Card": 1}；不含被脱敏的原值
+	if counts := ctx.MaskCounts(); len(counts) > 0 {
+		fields = append(fields, zap.Any("masked", counts))
+	}
+	// expect_masking: strict 的 PII 步骤有内容却未脱敏任何内容
+	if missed, _ := ctx.GetMetadata(core.MetaMaskingMissed); missed == true {
+		fields = append(fields, zap.Bool("masking_missed", true))
+	}
+	fields = append(fields, usageFields(body)...)
+	if field, ok := r.bodyField(ctx, body); ok {
+		fields = append(fields, field)
+	}
+
+	// 记录请求完成 - logger 会自动获取调用者信息
+	ctx.Log.Info("Request Finished", fields...)
 
 	// 直接返回原始 body，不做修改
 	return body, nil
 }
+
+// usageFields 从响应体的 usage 对象中提取 token 用量，用于成本统计
+// 响应不是 JSON、没有 usage（如流式或出错的请求）或缺少某个字段时，不记录对应字段
+func usageFields(body []byte) []zap.Field {
+	var fields []zap.Field
+	for _, key := range []string{"prompt_tokens", "completion_tokens", "total_tokens"} {
+		node, err := sonic.Get(body, "usage", key)
+		if err != nil {
+			continue
+		}
+		if tokens, err := node.Int64(); err == nil {
+			fields = append(fields, zap.Int64(key, tokens))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,235 @@
+package processors
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+	"aigis/internal/core/security"
+)
+
+func TestRequestLoggerLogsMaskSummary(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	ctx.RecordMask("Email")
+	ctx.RecordMask("Email")
+	ctx.RecordMask("Credit Card")
+
+	if _, err := NewRequestLogger().OnResponse(ctx, []byte(`{}`)); err != nil {
+		t.Fatalf("OnResponse 失败: %v", err)
+	}
+
+	entries := logs.FilterMessage("Request Finished").All()
+	if len(entries) != 1 {
+		t.Fatalf("期望 1 条完成日志，得到 %d", len(entries))
+	}
+	masked, ok := entries[0].ContextMap()["masked"].(map[string]int)
+	if !ok {
+		t.Fatalf("完成日志缺少 masked 字段: %v", entries[0].ContextMap())
+	}
+	if masked["Email"] != 2 || masked["Credit Card"] != 1 || len(masked) != 2 {
+		t.Errorf("masked = %v, 期望 Email=2, Credit Card=1", masked)
+	}
+}
+
+func TestRequestLoggerLogsMaskingMissed(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	logger := NewRequestLogger()
+
+	logger.OnResponse(ctx, []byte(`{}`))
+	if _, ok := logs.FilterMessage("Request Finished").All()[0].ContextMap()["masking_missed"]; ok {
+		t.Error("未设置标记时不应记录 masking_missed 字段")
+	}
+
+	ctx.SetMetadata(core.MetaMaskingMissed, true)
+	logger.OnResponse(ctx, []byte(`{}`))
+	if missed := logs.FilterMessage("Request Finished").All()[1].ContextMap()["masking_missed"]; missed != true {
+		t.Errorf("strict 模式未脱敏时应记录 masking_missed，得到 %v", missed)
+	}
+}
+
+func TestRequestLoggerOmitsEmptyMaskSummary(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+
+	NewRequestLogger().OnResponse(ctx, []byte(`{}`))
+
+	for _, entry := range logs.All() {
+		if _, ok := entry.ContextMap()["masked"]; ok {
+			t.Errorf("未脱敏时不应记录 masked 字段: %v", entry.ContextMap())
+		}
+	}
+}
+
+func TestRequestLoggerLogsSanitizedBodies(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	// 服务端把匹配路由的 scanner 放到 ctx 上，路由的自定义规则同样生效
+	scanner := security.NewScanner()
+	if err := scanner.AddRule("Employee ID", `\bEMP-\d{6}\b`, "[EMPLOYEE_ID]"); err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetSanitizer(scanner)
+	logger := NewRequestLogger()
+	logger.SetLogBodies(true)
+
+	logger.OnRequest(ctx, []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"mail alice@corp.io about EMP-123456"}]}`))
+	logger.OnResponse(ctx, []byte(`{"choices":[{"message":{"content":"sent to alice@corp.io"}}]}`))
+
+	for _, message := range []string{"Request Started", "Request Finished"} {
+		entries := logs.FilterMessage(message).All()
+		if len(entries) != 1 {
+			t.Fatalf("期望 1 条 %s 日志，得到 %d", message, len(entries))
+		}
+		body, _ := entries[0].ContextMap()["body"].(string)
+		if !strings.Contains(body, "[EMAIL_REDACTED]") || strings.Contains(body, "alice@corp.io") {
+			t.Errorf("%s 日志中的 body 应已清理敏感信息，得到 %q", message, body)
+		}
+	}
+	if body, _ := logs.FilterMessage("Request Started").All()[0].ContextMap()["body"].(string); !strings.Contains(body, "[EMPLOYEE_ID]") {
+		t.Errorf("请求体应按路由的自定义规则清理，得到 %q", body)
+	}
+}
+
+func TestRequestLoggerOmitsBodiesWithoutSanitizer(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	logger := NewRequestLogger()
+	logger.SetLogBodies(true)
+
+	logger.OnRequest(ctx, []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"mail alice@corp.io"}]}`))
+
+	for _, entry := range logs.All() {
+		if _, ok := entry.ContextMap()["body"]; ok {
+			t.Errorf("没有 sanitizer 时不应记录 body: %v", entry.ContextMap())
+		}
+	}
+}
+
+func TestRequestLoggerOmitsBodiesByDefault(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+
+	NewRequestLogger().OnRequest(ctx, []byte(`{"model":"gpt-4"}`))
+	NewRequestLogger().OnResponse(ctx, []byte(`{}`))
+
+	for _, entry := range logs.All() {
+		if _, ok := entry.ContextMap()["body"]; ok {
+			t.Errorf("默认不应记录 body: %v", entry.ContextMap())
+		}
+	}
+}
+
+func TestRequestLoggerLogsRequestMethodAndPath(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	ctx.SetMetadata(core.MetaRequestMethod, "GET")
+	ctx.SetMetadata(core.MetaRequestPath, "/v1/models")
+
+	NewRequestLogger().OnRequest(ctx, nil)
+
+	entries := logs.FilterMessage("Request Started").All()
+	if len(entries) != 1 {
+		t.Fatalf("期望 1 条开始日志，得到 %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["method"] != "GET" || fields["path"] != "/v1/models" {
+		t.Errorf("应记录实际的方法和路径，得到 method=%v path=%v", fields["method"], fields["path"])
+	}
+}
+
+func TestRequestLoggerLogsFailedStatus(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	ctx.SetMetadata(core.MetaResponseStatus, 502)
+	ctx.SetMetadata(core.MetaError, "upstream unavailable")
+
+	NewRequestLogger().OnResponse(ctx, nil)
+
+	entries := logs.FilterMessage("Request Finished").All()
+	if len(entries) != 1 {
+		t.Fatalf("期望 1 条完成日志，得到 %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["status"] != "Failed" || fields["status_code"] != int64(502) || fields["error"] != "upstream unavailable" {
+		t.Errorf("失败请求应记录失败状态和错误，得到 %v", fields)
+	}
+}
+
+func TestRequestLoggerDefaultsToSuccessStatus(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+
+	NewRequestLogger().OnResponse(ctx, []byte(`{}`))
+
+	fields := logs.FilterMessage("Request Finished").All()[0].ContextMap()
+	if fields["status"] != "Success" || fields["status_code"] != int64(200) {
+		t.Errorf("未设置状态码时应视为成功，得到 %v", fields)
+	}
+	if _, ok := fields["error"]; ok {
+		t.Errorf("成功请求不应记录 error 字段: %v", fields)
+	}
+}
+
+func TestRequestLoggerLogsTokenUsage(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+
+	NewRequestLogger().OnResponse(ctx, []byte(`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":34,"total_tokens":46}}`))
+
+	fields := logs.FilterMessage("Request Finished").All()[0].ContextMap()
+	if fields["prompt_tokens"] != int64(12) || fields["completion_tokens"] != int64(34) || fields["total_tokens"] != int64(46) {
+		t.Errorf("应记录 usage 中的 token 用量，得到 %v", fields)
+	}
+	if _, ok := fields["latency_ms"]; !ok {
+		t.Errorf("完成日志缺少 latency_ms 字段: %v", fields)
+	}
+}
+
+func TestRequestLoggerWithoutTokenUsage(t *testing.T) {
+	// 没有 usage 对象、usage 不完整、以及非 JSON 响应都不应报错
+	for _, body := range []string{`{"choices":[]}`, `{"usage":{"prompt_tokens":5}}`, `<html>bad gateway</html>`, ``} {
+		logCore, logs := observer.New(zapcore.InfoLevel)
+		ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+
+		if _, err := NewRequestLogger().OnResponse(ctx, []byte(body)); err != nil {
+			t.Fatalf("OnResponse(%q) 失败: %v", body, err)
+		}
+
+		fields := logs.FilterMessage("Request Finished").All()[0].ContextMap()
+		if _, ok := fields["completion_tokens"]; ok {
+			t.Errorf("响应 %q 没有 completion_tokens，不应记录该字段: %v", body, fields)
+		}
+		if _, ok := fields["prompt_tokens"]; ok != (body == `{"usage":{"prompt_tokens":5}}`) {
+			t.Errorf("响应 %q 的 prompt_tokens 字段记录有误: %v", body, fields)
+		}
+	}
+}
+
+func TestRequestLoggerSanitizesTagEnabledRules(t *testing.T) {
+	logCore, logs := observer.New(zapcore.InfoLevel)
+	ctx := core.NewGatewayContext(context.Background(), zap.New(logCore))
+	// 路由通过 tags 启用可选规则时，该规则脱敏的内容同样不能以明文写入日志
+	route := &engine.Route{ID: "us", Scanner: &engine.ScannerConfig{Tags: []string{"US Phone"}}}
+	scanner, err := route.PIIScanner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.SetSanitizer(scanner)
+	logger := NewRequestLogger()
+	logger.SetLogBodies(true)
+
+	logger.OnRequest(ctx, []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"call (415) 555-0132"}]}`))
+
+	body, _ := logs.FilterMessage("Request Started").All()[0].ContextMap()["body"].(string)
+	if strings.Contains(body, "555-0132") || !strings.Contains(body, "[PHONE_REDACTED]") {
+		t.Errorf("tags 启用的可选规则应同样用于清理日志，得到 %q", body)
+	}
+}
@@ -1,10 +1,17 @@
 package core
 
 import (
-	"context"
 	"net/http"
 )
 
+// Gateway deployment modes (top-level "mode" config key)
+const (
+	// ModeEnforce masks and blocks according to the configured routes (default)
+	ModeEnforce = "enforce"
+	// ModeObserve runs detection for metrics and logs but forwards the original body and never blocks
+	ModeObserve = "observe"
+)
+
 // Provider is the LLM adapter interface
 type Provider interface {
 	// ID returns the unique identifier for this provider
@@ -12,5 +19,12 @@ type Provider interface {
 	// Send sends a raw request body with original headers and returns the raw response body
 	Send(ctx *AIGisContext, body []byte, originalHeaders http.Header) ([]byte, error)
 	// Stream sends a request and returns a channel for streaming chunks
-	Stream(ctx context.Context, body []byte, originalHeaders http.Header) (<-chan []byte, error)
+	Stream(ctx *AIGisContext, body []byte, originalHeaders http.Header) (<-chan []byte, error)
+}
+
+// RawStreamer is implemented by providers whose Stream chunks can be complete SSE frames
+// (e.g. Claude's named events). When RawStream reports true, the server writes each chunk
+// unchanged instead of wrapping it in a "data:" line, and sends no [DONE] terminator.
+type RawStreamer interface {
+	RawStream() bool
 }
@@ -0,0 +1,279 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"aigis/internal/core/engine"
+
+	"github.com/tidwall/gjson"
+)
+
+// defaultClaudeMaxTokens is used when the OpenAI request sets no limit (Claude requires one)
+const defaultClaudeMaxTokens = 4096
+
+// applyOpenAIToClaudeTransform rewrites an OpenAI chat completion body into the Anthropic
+// Messages API shape, so OpenAI clients can use Claude routes. Place it before pii_claude so
+// masking runs on the converted body; the response is converted back by convertClaudeResponse.
+//   - system messages move to the top-level "system" string
+//   - max_tokens (or max_completion_tokens) is mapped; Claude requires it
+//   - image_url parts become image blocks, tool_calls become tool_use blocks and
+//     tool messages become tool_result blocks in a user message
+//   - stop, tools, tool_choice and user are mapped; other OpenAI-only parameters are dropped
+//
+// Only non-streaming responses are converted back; streams keep Claude's event format.
+//
+// Config:
+//
+//	max_tokens: "4096" // used when the request sets no limit (default 4096)
+func (p *UniversalProvider) applyOpenAIToClaudeTransform(body []byte, config map[string]string) ([]byte, error) {
+	if !gjson.ValidBytes(body) || !gjson.GetBytes(body, "messages").IsArray() {
+		return body, nil
+	}
+	req := gjson.ParseBytes(body)
+
+	out := map[string]any{}
+	for _, field := range []string{"model", "temperature", "top_p", "top_k", "stream"} {
+		if value := req.Get(field); value.Exists() {
+			out[field] = value.Value()
+		}
+	}
+
+	maxTokens := req.Get("max_tokens")
+	if !maxTokens.Exists() {
+		maxTokens = req.Get("max_completion_tokens")
+	}
+	if maxTokens.Exists() {
+		out["max_tokens"] = maxTokens.Int()
+	} else {
+		out["max_tokens"] = defaultClaudeMaxTokens
+		if limit, err := strconv.Atoi(config["max_tokens"]); err == nil && limit > 0 {
+			out["max_tokens"] = limit
+		}
+	}
+
+	if stop := req.Get("stop"); stop.Exists() {
+		if stop.IsArray() {
+			out["stop_sequences"] = stop.Value()
+		} else {
+			out["stop_sequences"] = []string{stop.String()}
+		}
+	}
+	if user := req.Get("user"); user.Exists() {
+		out["metadata"] = map[string]any{"user_id": user.String()}
+	}
+	if tools := claudeTools(req.Get("tools")); len(tools) > 0 {
+		out["tools"] = tools
+		if choice := claudeToolChoice(req.Get("tool_choice")); choice != nil {
+			out["tool_choice"] = choice
+		}
+	}
+
+	var systemTexts []string
+	messages := []map[string]any{}
+	for _, msg := range req.Get("messages").Array() {
+		switch role := msg.Get("role").String(); role {
+		case "system", "developer":
+			if text := messageText(msg.Get("content")); text != "" {
+				systemTexts = append(systemTexts, text)
+			}
+		case "tool":
+			messages = append(messages, map[string]any{
+				"role": "user",
+				"content": []any{map[string]any{
+					"type":        "tool_result",
+					"tool_use_id": msg.Get("tool_call_id").String(),
+					"content":     messageText(msg.Get("content")),
+				}},
+			})
+		default:
+			messages = append(messages, map[string]any{"role": role, "content": claudeContent(msg)})
+		}
+	}
+	if len(systemTexts) > 0 {
+		out["system"] = strings.Join(systemTexts, "\n\n")
+	}
+	out["messages"] = messages
+
+	return json.Marshal(out)
+}
+
+// claudeContent converts the content of an OpenAI user or assistant message. Plain strings are
+// kept as-is; parts and tool calls become Claude content blocks.
+func claudeContent(msg gjson.Result) any {
+	content := msg.Get("content")
+	toolCalls := msg.Get("tool_calls").Array()
+	if !content.IsArray() && len(toolCalls) == 0 {
+		return content.String()
+	}
+
+	var blocks []any
+	if content.IsArray() {
+		for _, part := range content.Array() {
+			switch part.Get("type").String() {
+			case "text":
+				blocks = append(blocks, map[string]any{"type": "text", "text": part.Get("text").String()})
+			case "image_url":
+				blocks = append(blocks, claudeImage(part.Get("image_url.url").String()))
+			}
+		}
+	} else if text := content.String(); text != "" {
+		blocks = append(blocks, map[string]any{"type": "text", "text": text})
+	}
+
+	for _, call := range toolCalls {
+		// Arguments arrive as a JSON string; Claude expects an object
+		input := map[string]any{}
+		if args := call.Get("function.arguments").String(); args != "" {
+			json.Unmarshal([]byte(args), &input)
+		}
+		blocks = append(blocks, map[string]any{
+			"type":  "tool_use",
+			"id":    call.Get("id").String(),
+			"name":  call.Get("function.name").String(),
+			"input": input,
+		})
+	}
+	return blocks
+}
+
+// claudeImage converts an OpenAI image URL (a data URL or a remote URL) into a Claude image block
+func claudeImage(url string) map[string]any {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok {
+			return map[string]any{
+				"type": "image",
+				"source": map[string]any{
+					"type":       "base64",
+					"media_type": strings.TrimSuffix(meta, ";base64"),
+					"data":       data,
+				},
+			}
+		}
+	}
+	return map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": url}}
+}
+
+// claudeTools converts OpenAI function tools into Claude tool definitions
+func claudeTools(tools gjson.Result) []any {
+	var out []any
+	for _, tool := range tools.Array() {
+		if tool.Get("type").String() != "function" {
+			continue
+		}
+		def := map[string]any{"name": tool.Get("function.name").String()}
+		if description := tool.Get("function.description"); description.Exists() {
+			def["description"] = description.String()
+		}
+		if params := tool.Get("function.parameters"); params.Exists() {
+			def["input_schema"] = params.Value()
+		} else {
+			def["input_schema"] = map[string]any{"type": "object"}
+		}
+		out = append(out, def)
+	}
+	return out
+}
+
+// claudeToolChoice converts an OpenAI tool_choice; "none" has no equivalent and returns nil
+func claudeToolChoice(choice gjson.Result) any {
+	switch {
+	case choice.Get("function.name").Exists():
+		return map[string]any{"type": "tool", "name": choice.Get("function.name").String()}
+	case choice.String() == "required":
+		return map[string]any{"type": "any"}
+	case choice.String() == "auto":
+		return map[string]any{"type": "auto"}
+	}
+	return nil
+}
+
+// claudeFinishReasons maps Claude stop_reason values to OpenAI finish_reason values
+var claudeFinishReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+}
+
+// convertsToClaude reports whether the route converts OpenAI requests to the Claude format
+func (p *UniversalProvider) convertsToClaude() bool {
+	for _, step := range p.route.Transforms {
+		if step.Type == engine.TransformTypeOpenAIToClaude {
+			return true
+		}
+	}
+	return false
+}
+
+// convertClaudeResponse converts a Claude Messages API response into an OpenAI chat completion.
+// Bodies that are not Claude messages (e.g. errors) are returned unchanged.
+func convertClaudeResponse(body []byte) ([]byte, error) {
+	resp := gjson.ParseBytes(body)
+	if resp.Get("type").String() != "message" {
+		return body, nil
+	}
+
+	var texts []string
+	var toolCalls []any
+	for _, block := range resp.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			texts = append(texts, block.Get("text").String())
+		case "tool_use":
+			arguments := block.Get("input").Raw
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, map[string]any{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]any{
+					"name":      block.Get("name").String(),
+					"arguments": arguments,
+				},
+			})
+		}
+	}
+
+	message := map[string]any{"role": "assistant", "content": strings.Join(texts, "")}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+		if len(texts) == 0 {
+			message["content"] = nil
+		}
+	}
+
+	finishReason, ok := claudeFinishReasons[resp.Get("stop_reason").String()]
+	if !ok {
+		finishReason = "stop"
+	}
+
+	inputTokens := resp.Get("usage.input_tokens").Int()
+	outputTokens := resp.Get("usage.output_tokens").Int()
+	out := map[string]any{
+		"id":      resp.Get("id").String(),
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   resp.Get("model").String(),
+		"choices": []any{map[string]any{
+			"index":         0,
+			"message":       message,
+			"finish_reason": finishReason,
+		}},
+		"usage": map[string]any{
+			"prompt_tokens":     inputTokens,
+			"completion_tokens": outputTokens,
+			"total_tokens":      inputTokens + outputTokens,
+		},
+	}
+
+	result, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert Claude response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aigis/internal/core/engine"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestOpenAIToClaudeRoundTrip(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer like the Messages API, echoing the (masked) last user turn
+		upstreamBody, _ = io.ReadAll(r.Body)
+		last := gjson.GetBytes(upstreamBody, "messages.@reverse.0.content").String()
+		resp, _ := json.Marshal(map[string]any{
+			"id":          "msg_01",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       "claude-3-5-sonnet",
+			"content":     []any{map[string]any{"type": "text", "text": "Noted: " + last}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]any{"input_tokens": 20, "output_tokens": 5},
+		})
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:       "claude",
+		Upstream: engine.Upstream{BaseURL: upstream.URL, Path: "/messages"},
+		Transforms: []engine.TransformStep{
+			{Type: engine.TransformTypeOpenAIToClaude},
+			{Type: engine.TransformTypePIIClaude},
+		},
+	}
+	body := []byte(`{
+		"model": "claude-3-5-sonnet",
+		"max_tokens": 256,
+		"temperature": 0.2,
+		"frequency_penalty": 0.5,
+		"messages": [
+			{"role": "system", "content": "You are terse."},
+			{"role": "user", "content": "Hi, I am Bob"},
+			{"role": "assistant", "content": "Hello Bob"},
+			{"role": "user", "content": "Mail me at bob@home.net"}
+		]
+	}`)
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	// Request: Claude shape, masked
+	req := gjson.ParseBytes(upstreamBody)
+	if req.Get("system").String() != "You are terse." || req.Get("max_tokens").Int() != 256 || req.Get("temperature").Float() != 0.2 {
+		t.Errorf("Unexpected upstream request: %s", upstreamBody)
+	}
+	if req.Get("frequency_penalty").Exists() {
+		t.Error("OpenAI-only parameters should be dropped")
+	}
+	if roles := req.Get("messages.#.role").String(); roles != `["user","assistant","user"]` {
+		t.Errorf("roles = %s", roles)
+	}
+	if strings.Contains(string(upstreamBody), "bob@home.net") {
+		t.Errorf("Upstream received unmasked text: %s", upstreamBody)
+	}
+
+	// Response: OpenAI shape, unmasked
+	if got := gjson.GetBytes(resp, "choices.0.message.content").String(); got != "Noted: Mail me at bob@home.net" {
+		t.Errorf("content = %q", got)
+	}
+	if got := gjson.GetBytes(resp, "choices.0.finish_reason").String(); got != "stop" {
+		t.Errorf("finish_reason = %q", got)
+	}
+	if gjson.GetBytes(resp, "object").String() != "chat.completion" || gjson.GetBytes(resp, "usage.total_tokens").Int() != 25 {
+		t.Errorf("Unexpected response: %s", resp)
+	}
+}
+
+func TestOpenAIToClaudeTransformContent(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "claude"}, nil)
+	body := []byte(`{
+		"model": "claude-3-5-sonnet",
+		"stop": "END",
+		"tools": [{"type": "function", "function": {"name": "lookup", "parameters": {"type": "object"}}}],
+		"tool_choice": "required",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,aW1n"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"cat\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "a cat"}
+		]
+	}`)
+
+	result, err := p.applyOpenAIToClaudeTransform(body, map[string]string{"max_tokens": "1000"})
+	if err != nil {
+		t.Fatalf("openai_to_claude transform failed: %v", err)
+	}
+
+	checks := map[string]string{
+		"max_tokens":                             "1000",
+		"stop_sequences.0":                       "END",
+		"tools.0.name":                           "lookup",
+		"tool_choice.type":                       "any",
+		"messages.0.content.1.source.type":       "base64",
+		"messages.0.content.1.source.data":       "aW1n",
+		"messages.0.content.1.source.media_type": "image/png",
+		"messages.1.content.0.type":              "tool_use",
+		"messages.1.content.0.input.q":           "cat",
+		"messages.2.role":                        "user",
+		"messages.2.content.0.type":              "tool_result",
+		"messages.2.content.0.tool_use_id":       "call_1",
+		"messages.2.content.0.content":           "a cat",
+	}
+	for path, want := range checks {
+		if got := gjson.GetBytes(result, path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestConvertClaudeResponseToolUse(t *testing.T) {
+	body := []byte(`{"id":"msg_01","type":"message","model":"claude","content":[{"type":"tool_use","id":"toolu_1","name":"lookup","input":{"q":"cat"}}],"stop_reason":"tool_use","usage":{"input_tokens":1,"output_tokens":2}}`)
+	result, err := convertClaudeResponse(body)
+	if err != nil {
+		t.Fatalf("convertClaudeResponse failed: %v", err)
+	}
+
+	message := gjson.GetBytes(result, "choices.0.message")
+	if message.Get("content").Type != gjson.Null {
+		t.Errorf("content should be null for tool-only replies, got %s", message.Get("content").Raw)
+	}
+	if got := message.Get("tool_calls.0.function.arguments").String(); got != `{"q":"cat"}` {
+		t.Errorf("arguments = %q", got)
+	}
+	if got := gjson.GetBytes(result, "choices.0.finish_reason").String(); got != "tool_calls" {
+		t.Errorf("finish_reason = %q", got)
+	}
+
+	// Non-message bodies pass through
+	errBody := []byte(`{"type":"error","error":{"type":"overloaded_error"}}`)
+	if result, _ := convertClaudeResponse(errBody); string(result) != string(errBody) {
+		t.Errorf("Error body changed: %s", result)
+	}
+}
@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"aigis/internal/core"
+	"aigis/internal/core/security"
+)
+
+// claudeStreamUnmasker restores placeholders in an Anthropic Messages SSE stream. Claude
+// events are named (event: content_block_delta) and carry text in delta.text of text_delta
+// frames rather than in choices[].delta, so the unmasker works on the raw event stream: only
+// the data line of text deltas is rewritten, and event names, ids, pings and every other frame
+// pass through byte for byte. Each content block's text is buffered independently until a
+// placeholder boundary is safe to emit; held-back text is sent in an extra text_delta event
+// just before the block's content_block_stop.
+type claudeStreamUnmasker struct {
+	scanner *security.Scanner
+	ctx     *core.AIGisContext
+	blocks  map[int64]*security.StreamUnmasker
+	// line holds an incomplete line; event the complete lines of the current event
+	line  []byte
+	event [][]byte
+	stats security.UnmaskStats
+	// redact also redacts PII the model produced itself in text deltas (redact_response)
+	redact bool
+}
+
+func newClaudeStreamUnmasker(scanner *security.Scanner, ctx *core.AIGisContext) *claudeStreamUnmasker {
+	return &claudeStreamUnmasker{
+		scanner: scanner,
+		ctx:     ctx,
+		blocks:  make(map[int64]*security.StreamUnmasker),
+	}
+}
+
+// Write consumes raw stream bytes, split anywhere, and returns the bytes that are ready to be
+// forwarded: every event completed by p, rewritten. Partial events are held until complete.
+func (u *claudeStreamUnmasker) Write(p []byte) ([]byte, error) {
+	var out []byte
+	u.line = append(u.line, p...)
+	for {
+		end := bytes.IndexByte(u.line, '\n')
+		if end < 0 {
+			return out, nil
+		}
+		line := u.line[:end+1]
+		u.line = u.line[end+1:]
+
+		if len(bytes.TrimRight(line, "\r\n")) > 0 {
+			u.event = append(u.event, bytes.Clone(line))
+			continue
+		}
+		// A blank line ends the event
+		event, err := u.processEvent(u.event)
+		if err != nil {
+			return nil, err
+		}
+		u.event = nil
+		out = append(out, event...)
+		out = append(out, line...)
+	}
+}
+
+// Flush returns whatever is still held when the stream ends: an unterminated event, and the
+// text of content blocks that never reported content_block_stop
+func (u *claudeStreamUnmasker) Flush() ([]byte, error) {
+	if len(u.line) > 0 {
+		u.event = append(u.event, u.line)
+		u.line = nil
+	}
+	out, err := u.processEvent(u.event)
+	if err != nil {
+		return nil, err
+	}
+	u.event = nil
+
+	indexes := make([]int64, 0, len(u.blocks))
+	for index := range u.blocks {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, index := range indexes {
+		tail, err := u.flushBlock(index)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tail...)
+	}
+	return out, nil
+}
+
+// processEvent rewrites one event given as its lines (with terminators) and returns it
+func (u *claudeStreamUnmasker) processEvent(lines [][]byte) ([]byte, error) {
+	var out []byte
+	for _, line := range lines {
+		content := bytes.TrimRight(line, "\r\n")
+		data, ok := bytes.CutPrefix(content, []byte("data:"))
+		if !ok || !gjson.ValidBytes(data) {
+			out = append(out, line...)
+			continue
+		}
+
+		payload := gjson.ParseBytes(data)
+		index := payload.Get("index").Int()
+		switch payload.Get("type").String() {
+		case "content_block_delta":
+			text := payload.Get("delta.text")
+			if payload.Get("delta.type").String() != "text_delta" || text.Type != gjson.String {
+				break
+			}
+			rewritten, err := sjson.SetBytes(bytes.TrimSpace(data), "delta.text", u.block(index).Write(text.String()))
+			if err != nil {
+				return nil, err
+			}
+			line = append(append([]byte("data: "), rewritten...), line[len(content):]...)
+		case "content_block_stop":
+			// The held-back text of the block has to reach the client before the stop event
+			tail, err := u.flushBlock(index)
+			if err != nil {
+				return nil, err
+			}
+			out = append(tail, out...)
+		}
+		out = append(out, line...)
+	}
+	return out, nil
+}
+
+// flushBlock releases the text held back for a content block as a text_delta event
+func (u *claudeStreamUnmasker) flushBlock(index int64) ([]byte, error) {
+	unmasker, ok := u.blocks[index]
+	if !ok {
+		return nil, nil
+	}
+	delete(u.blocks, index)
+
+	rest := unmasker.Flush()
+	stats := unmasker.Stats()
+	u.stats.Found += stats.Found
+	u.stats.Restored += stats.Restored
+	u.stats.Missed += stats.Missed
+	if rest == "" {
+		return nil, nil
+	}
+
+	data, err := sjson.SetBytes([]byte(`{"type":"content_block_delta"}`), "index", index)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = sjson.SetBytes(data, "delta", map[string]string{"type": "text_delta", "text": rest}); err != nil {
+		return nil, err
+	}
+	event := append([]byte("event: content_block_delta\ndata: "), data...)
+	return append(event, "\n\n"...), nil
+}
+
+// Stats returns the unmask statistics of all content blocks flushed so far
+func (u *claudeStreamUnmasker) Stats() security.UnmaskStats {
+	return u.stats
+}
+
+// block returns the stream unmasker for a content block, creating it on first use
+func (u *claudeStreamUnmasker) block(index int64) *security.StreamUnmasker {
+	if u.blocks[index] == nil {
+		u.blocks[index] = newTextUnmasker(u.scanner, u.ctx, u.redact)
+	}
+	return u.blocks[index]
+}
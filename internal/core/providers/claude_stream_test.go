@@ -0,0 +1,196 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"aigis/internal/core/engine"
+)
+
+// recordedClaudeStream is an Anthropic Messages stream whose text block carries a placeholder
+// split across two text_delta frames, followed by a tool_use block
+const recordedClaudeStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_01","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5","stop_reason":null,"usage":{"input_tokens":25,"output_tokens":1}}}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+	"event: ping\n" +
+	`data: {"type": "ping"}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Write to {{FIRST}}"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"{{SECOND}} today."}}` + "\n\n" +
+	"event: content_block_stop\n" +
+	`data: {"type":"content_block_stop","index":0}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_01","name":"send_mail","input":{}}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"to\": \"x\"}"}}` + "\n\n" +
+	"event: content_block_stop\n" +
+	`data: {"type":"content_block_stop","index":1}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":15}}` + "\n\n" +
+	"event: message_stop\n" +
+	`data: {"type":"message_stop"}` + "\n\n"
+
+// sseEvent is one parsed event of a stream
+type sseEvent struct {
+	name string
+	data string
+}
+
+func parseSSE(t *testing.T, stream string) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	for _, block := range strings.Split(strings.TrimSuffix(stream, "\n\n"), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event.name = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				event.data = data
+			} else {
+				t.Fatalf("Unexpected line %q in event %q", line, block)
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestClaudeStreamUnmaskerRecordedStream(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "claude-stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "alice@corp.io")
+	stream := strings.NewReplacer("{{FIRST}}", placeholder[:9], "{{SECOND}}", placeholder[9:]).Replace(recordedClaudeStream)
+	original := parseSSE(t, stream)
+
+	// Network reads split the stream anywhere, including inside event and data lines
+	for _, size := range []int{1, 7, 64, len(stream)} {
+		unmasker := newClaudeStreamUnmasker(p.scanner, ctx)
+		var out strings.Builder
+		for start := 0; start < len(stream); start += size {
+			chunk, err := unmasker.Write([]byte(stream[start:min(start+size, len(stream))]))
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			out.Write(chunk)
+		}
+		tail, err := unmasker.Flush()
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		out.Write(tail)
+
+		if strings.Contains(out.String(), "__AIGIS") {
+			t.Errorf("size %d: no frame should expose a placeholder fragment:\n%s", size, out.String())
+		}
+
+		events := parseSSE(t, out.String())
+		if len(events) != len(original) {
+			t.Fatalf("size %d: got %d events, want %d:\n%s", size, len(events), len(original), out.String())
+		}
+		var text strings.Builder
+		for i, event := range events {
+			if event.name != original[i].name {
+				t.Errorf("size %d: event %d is %q, want %q", size, i, event.name, original[i].name)
+			}
+			if gjson.Get(event.data, "delta.type").String() == "text_delta" {
+				text.WriteString(gjson.Get(event.data, "delta.text").String())
+				continue
+			}
+			// Every other frame passes through unchanged
+			if event.data != original[i].data {
+				t.Errorf("size %d: event %d changed:\n got %s\nwant %s", size, i, event.data, original[i].data)
+			}
+		}
+		if text.String() != "Write to alice@corp.io today." {
+			t.Errorf("size %d: reassembled text = %q", size, text.String())
+		}
+		if stats := unmasker.Stats(); stats.Restored != 1 {
+			t.Errorf("size %d: stats = %+v, want 1 restored", size, stats)
+		}
+	}
+}
+
+func TestClaudeStreamUnmaskerFlushesBeforeBlockStop(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "claude-stream"}, nil)
+	ctx := newTestContext()
+
+	// The block ends with text that could start a placeholder, so it is held back until the stop
+	stream := "event: content_block_delta\r\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"see __AIGIS"}}` + "\r\n\r\n" +
+		"event: content_block_stop\r\n" +
+		`data: {"type":"content_block_stop","index":0}` + "\r\n\r\n"
+
+	unmasker := newClaudeStreamUnmasker(p.scanner, ctx)
+	out, err := unmasker.Write([]byte(stream))
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	got := string(out)
+
+	held := strings.Index(got, `"text":"see "`)
+	flushed := strings.Index(got, `"text":"__AIGIS"`)
+	stop := strings.Index(got, "event: content_block_stop\r\n")
+	if held < 0 || flushed < held || stop < flushed {
+		t.Errorf("Held-back text should be flushed in its own delta before content_block_stop:\n%s", got)
+	}
+	if !strings.HasSuffix(got, `{"type":"content_block_stop","index":0}`+"\r\n\r\n") {
+		t.Errorf("Upstream line endings should be kept:\n%q", got)
+	}
+
+	if tail, _ := unmasker.Flush(); len(tail) != 0 {
+		t.Errorf("Nothing should remain after the block stopped, got %q", tail)
+	}
+}
+
+func TestClaudeStreamUnmaskerFlushWithoutBlockStop(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "claude-stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "bob@corp.io")
+
+	unmasker := newClaudeStreamUnmasker(p.scanner, ctx)
+	out, _ := unmasker.Write([]byte("event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi ` + placeholder[:5] + `"}}` + "\n\n"))
+	tail, err := unmasker.Flush()
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var text strings.Builder
+	for _, event := range parseSSE(t, string(out)+string(tail)) {
+		text.WriteString(gjson.Get(event.data, "delta.text").String())
+	}
+	if text.String() != "hi "+placeholder[:5] {
+		t.Errorf("Truncated stream should release held text as-is, got %q", text.String())
+	}
+}
+
+func TestClaudeStreamUnmaskerRedactsModelGeneratedPII(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "claude-stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "a@b.co")
+
+	unmasker := newClaudeStreamUnmasker(p.scanner, ctx)
+	unmasker.redact = true
+	var stream string
+	for _, text := range []string{"Reply to " + placeholder + " and cc ops@inte", "rnal.corp"} {
+		stream += "event: content_block_delta\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` + text + `"}}` + "\n\n"
+	}
+	stream += "event: content_block_stop\n" + `data: {"type":"content_block_stop","index":0}` + "\n\n"
+
+	out, err := unmasker.Write([]byte(stream))
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	var text strings.Builder
+	for _, event := range parseSSE(t, string(out)) {
+		text.WriteString(gjson.Get(event.data, "delta.text").String())
+	}
+	if text.String() != "Reply to a@b.co and cc [EMAIL_REDACTED]" {
+		t.Errorf("Streamed text = %q", text.String())
+	}
+}
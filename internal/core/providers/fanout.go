@@ -0,0 +1,113 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+)
+
+// fanOutResult is the outcome of one upstream call in a fan-out
+type fanOutResult struct {
+	resp *upstreamResponse
+	err  error
+}
+
+// sendFanOut sends the request to the route's upstream and every fan-out upstream concurrently,
+// then merges the successful responses. The first successful response is used as the base and
+// the choices of the others are appended (re-indexed). If some upstreams fail, the merged
+// response carries an "aigis_notes" array describing the failures; if all fail, the first
+// error is returned.
+func (p *UniversalProvider) sendFanOut(ctx context.Context, body []byte, originalHeaders http.Header) (*upstreamResponse, error) {
+	upstreams := append([]engine.Upstream{p.upstream}, p.route.FanOut...)
+	clients := append([]*http.Client{p.client}, p.fanOutClients...)
+
+	results := make([]fanOutResult, len(upstreams))
+	var wg sync.WaitGroup
+	for i := range upstreams {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := p.callUpstream(ctx, upstreams[i], clients[i], body, originalHeaders)
+			results[i] = fanOutResult{resp: resp, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	var merged *upstreamResponse
+	var notes []string
+	var firstErr error
+	for i, result := range results {
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			// Only the client-safe message goes into the response
+			notes = append(notes, fmt.Sprintf("upstream %d failed: %s", i, core.AsGatewayError(result.err).Message))
+			p.log.Warn("Fan-out upstream failed",
+				zap.String("route_id", p.route.ID),
+				zap.String("upstream", upstreams[i].BaseURL),
+				zap.Error(result.err),
+			)
+			continue
+		}
+
+		if merged == nil {
+			base := *result.resp
+			merged = &base
+			continue
+		}
+
+		mergedBody, err := appendChoices(merged.Body, result.resp.Body)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("upstream %d returned an unmergeable response", i))
+			continue
+		}
+		merged.Body = mergedBody
+		if result.resp.Latency > merged.Latency {
+			merged.Latency = result.resp.Latency
+		}
+	}
+
+	if merged == nil {
+		return nil, firstErr
+	}
+
+	if len(notes) > 0 {
+		withNotes, err := sjson.SetBytes(merged.Body, "aigis_notes", notes)
+		if err == nil {
+			merged.Body = withNotes
+		}
+	}
+
+	return merged, nil
+}
+
+// appendChoices appends the OpenAI-style choices of extra to base, re-numbering their index
+func appendChoices(base, extra []byte) ([]byte, error) {
+	if !gjson.ValidBytes(extra) {
+		return nil, fmt.Errorf("invalid JSON response")
+	}
+
+	result := base
+	next := len(gjson.GetBytes(base, "choices").Array())
+	for _, choice := range gjson.GetBytes(extra, "choices").Array() {
+		raw, err := sjson.Set(choice.Raw, "index", next)
+		if err != nil {
+			return nil, err
+		}
+		result, err = sjson.SetRawBytes(result, "choices.-1", []byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		next++
+	}
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+)
+
+// OAuth2 token lifetimes
+const (
+	// oauth2RefreshMargin refreshes tokens this long before they expire
+	oauth2RefreshMargin = 60 * time.Second
+	// oauth2DefaultLifetime applies when the token response has no expires_in
+	oauth2DefaultLifetime = 5 * time.Minute
+	// oauth2TokenTimeout bounds a token request
+	oauth2TokenTimeout = 30 * time.Second
+)
+
+// oauth2Client fetches tokens. The token endpoint is usually a different host from the
+// upstream, so the upstream's client (SNI override, mTLS certificate, private CA) must not be used.
+var oauth2Client = &http.Client{Timeout: oauth2TokenTimeout}
+
+// oauth2Key identifies a token: the same client on the same endpoint and scope shares it
+type oauth2Key struct {
+	tokenURL string
+	clientID string
+	scope    string
+}
+
+// oauth2Source caches one access token. mu is held while fetching, so concurrent requests
+// wait for a single token call instead of each fetching their own.
+type oauth2Source struct {
+	mu          sync.Mutex
+	accessToken string
+	refreshAt   time.Time
+}
+
+// oauth2Sources caches token sources process-wide (providers are created per request)
+var oauth2Sources sync.Map // oauth2Key -> *oauth2Source
+
+// oauth2Token returns a valid access token for the upstream, fetching one with the
+// client-credentials grant when none is cached or the cached one is about to expire
+func oauth2Token(ctx context.Context, upstream engine.Upstream) (string, error) {
+	clientID := os.Getenv(upstream.ClientIDEnv)
+	clientSecret := os.Getenv(upstream.ClientSecretEnv)
+	if upstream.TokenURL == "" || clientID == "" || clientSecret == "" {
+		return "", core.NewInternalError("oauth2 auth requires token_url and client credentials", nil)
+	}
+
+	key := oauth2Key{tokenURL: upstream.TokenURL, clientID: clientID, scope: upstream.Scope}
+	value, _ := oauth2Sources.LoadOrStore(key, &oauth2Source{})
+	source := value.(*oauth2Source)
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.accessToken != "" && time.Now().Before(source.refreshAt) {
+		return source.accessToken, nil
+	}
+
+	token, lifetime, err := fetchOAuth2Token(ctx, oauth2Client, upstream.TokenURL, clientID, clientSecret, upstream.Scope)
+	if err != nil {
+		return "", core.NewUpstreamError("failed to obtain OAuth2 access token", err)
+	}
+	margin := oauth2RefreshMargin
+	if lifetime < 2*margin {
+		margin = lifetime / 2
+	}
+	source.accessToken = token
+	source.refreshAt = time.Now().Add(lifetime - margin)
+	return token, nil
+}
+
+// dropOAuth2Token forgets the cached token a request to an oauth2 upstream carried once the
+// upstream rejects it with 401 (e.g. revoked or rotated early), so the next request fetches a
+// fresh token instead of failing until the cached one would have been refreshed
+func dropOAuth2Token(upstream engine.Upstream, req *http.Request, status int) {
+	if status != http.StatusUnauthorized || upstream.AuthStrategy != engine.AuthStrategyOAuth2 {
+		return
+	}
+	resolved, err := upstream.Resolve()
+	if err != nil {
+		return
+	}
+	key := oauth2Key{tokenURL: resolved.TokenURL, clientID: os.Getenv(resolved.ClientIDEnv), scope: resolved.Scope}
+	value, ok := oauth2Sources.Load(key)
+	if !ok {
+		return
+	}
+	source := value.(*oauth2Source)
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	// A concurrent request may already have replaced the rejected token
+	if "Bearer "+source.accessToken == req.Header.Get("Authorization") {
+		source.accessToken = ""
+	}
+}
+
+// fetchOAuth2Token performs the client-credentials grant and returns the access token and
+// its lifetime
+func fetchOAuth2Token(ctx context.Context, client *http.Client, tokenURL, clientID, clientSecret, scope string) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, truncateBody(body))
+	}
+
+	token := gjson.GetBytes(body, "access_token").String()
+	if token == "" {
+		return "", 0, fmt.Errorf("token response has no access_token")
+	}
+	// expires_in is a number per RFC 6749, but some providers (e.g. Azure AD v1) send a string
+	lifetime := oauth2DefaultLifetime
+	if expiresIn := gjson.GetBytes(body, "expires_in").Int(); expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	return token, lifetime, nil
+}
+
+// truncateBody shortens a response body for error messages
+func truncateBody(body []byte) string {
+	const maxLen = 200
+	if len(body) > maxLen {
+		return string(body[:maxLen]) + "..."
+	}
+	return string(body)
+}
@@ -0,0 +1,151 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aigis/internal/core/engine"
+)
+
+// newTokenEndpoint starts a fake OAuth2 token endpoint issuing token-1, token-2, ...
+func newTokenEndpoint(t *testing.T, expiresIn string) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_secret") != "s3cret" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		n := calls.Add(1)
+		time.Sleep(20 * time.Millisecond) // widen the window for concurrent callers
+		w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) + `","token_type":"Bearer","expires_in":` + expiresIn + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// newOAuth2Route builds a route whose upstream records the Authorization header it receives
+func newOAuth2Route(t *testing.T, tokenURL, scope string) (*engine.Route, chan string) {
+	t.Setenv("AIGIS_TEST_CLIENT_ID", "client")
+	t.Setenv("AIGIS_TEST_CLIENT_SECRET", "s3cret")
+	auth := make(chan string, 100)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+	return &engine.Route{ID: "oauth2", Upstream: engine.Upstream{
+		BaseURL:         upstream.URL,
+		AuthStrategy:    engine.AuthStrategyOAuth2,
+		TokenURL:        tokenURL,
+		ClientIDEnv:     "AIGIS_TEST_CLIENT_ID",
+		ClientSecretEnv: "AIGIS_TEST_CLIENT_SECRET",
+		Scope:           scope,
+	}}, auth
+}
+
+func TestOAuth2TokenCachedAcrossConcurrentRequests(t *testing.T) {
+	tokenEndpoint, calls := newTokenEndpoint(t, "3600")
+	route, auth := newOAuth2Route(t, tokenEndpoint.URL, "api://burst/.default")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+				t.Errorf("Send failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(auth)
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Token endpoint calls = %d, want 1", n)
+	}
+	for got := range auth {
+		if got != "Bearer token-1" {
+			t.Errorf("Authorization = %q, want the cached token", got)
+		}
+	}
+}
+
+func TestOAuth2TokenRefreshedBeforeExpiry(t *testing.T) {
+	// Azure AD v1 style string expires_in
+	tokenEndpoint, calls := newTokenEndpoint(t, `"3600"`)
+	route, auth := newOAuth2Route(t, tokenEndpoint.URL, "api://refresh/.default")
+	send := func() string {
+		if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		return <-auth
+	}
+
+	if got := send(); got != "Bearer token-1" {
+		t.Fatalf("Authorization = %q", got)
+	}
+	value, ok := oauth2Sources.Load(oauth2Key{tokenURL: tokenEndpoint.URL, clientID: "client", scope: "api://refresh/.default"})
+	if !ok {
+		t.Fatal("Token source should be cached")
+	}
+	source := value.(*oauth2Source)
+	if remaining := time.Until(source.refreshAt); remaining < 58*time.Minute || remaining > 59*time.Minute {
+		t.Errorf("Token should refresh a minute before expiry, refreshes in %v", remaining)
+	}
+
+	// Simulate the token reaching its refresh time
+	source.mu.Lock()
+	source.refreshAt = time.Now().Add(-time.Second)
+	source.mu.Unlock()
+
+	if got := send(); got != "Bearer token-2" {
+		t.Errorf("Authorization = %q, want a refreshed token", got)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Token endpoint calls = %d, want 2", n)
+	}
+}
+
+func TestOAuth2TokenEndpointFailure(t *testing.T) {
+	tokenEndpoint, _ := newTokenEndpoint(t, "3600")
+	route, _ := newOAuth2Route(t, tokenEndpoint.URL, "api://failure/.default")
+	t.Setenv("AIGIS_TEST_CLIENT_SECRET", "wrong")
+
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err == nil {
+		t.Error("Expected an error when the token endpoint rejects the client")
+	}
+}
+
+func TestOAuth2TokenDroppedOnUpstream401(t *testing.T) {
+	tokenEndpoint, calls := newTokenEndpoint(t, "3600")
+	route, _ := newOAuth2Route(t, tokenEndpoint.URL, "api://revoked/.default")
+	// The upstream has revoked token-1 before its expiry
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			http.Error(w, `{"error":{"message":"invalid token"}}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+	route.Upstream.BaseURL = upstream.URL
+
+	send := func() error {
+		_, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+		return err
+	}
+	if err := send(); err == nil {
+		t.Fatal("Expected the revoked token to be rejected")
+	}
+	if err := send(); err != nil {
+		t.Errorf("Send with a fresh token failed: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Token endpoint calls = %d, want 2", n)
+	}
+}
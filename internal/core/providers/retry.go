@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"aigis/internal/core"
+)
+
+// defaultRetryBackoff is the base retry delay when an upstream sets max_retries without backoff_ms
+const defaultRetryBackoff = 200 * time.Millisecond
+
+// maxRetryBackoff caps the exponential delay between two attempts
+const maxRetryBackoff = 10 * time.Second
+
+// retryable reports whether a failed upstream attempt may be retried: connection failures and
+// 429/502/503 responses. Timeouts are not retried since they already consumed the time budget.
+func retryable(resp *upstreamResponse, err error) bool {
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+			return true
+		}
+		return false
+	}
+	return core.AsGatewayError(err).Category == core.ErrCategoryUpstream
+}
+
+// retryDelay returns the jittered exponential delay before retry number attempt (0-based):
+// a random duration in [d/2, d] where d = base * 2^attempt
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	d := base
+	for i := 0; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, maxRetryBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// waitRetry sleeps for delay unless ctx ends first or its deadline would pass before the
+// next attempt could start. It reports whether the caller should retry.
+func waitRetry(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,47 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"os"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+)
+
+// defaultSignatureHeader carries the body signature when signature_header is not set
+const defaultSignatureHeader = "X-Signature"
+
+// signRequestBody sets the upstream's signature header to the hex HMAC of body, keyed with
+// the secret from signature_secret_env. body must be the exact bytes sent upstream.
+func signRequestBody(req *http.Request, body []byte, upstream engine.Upstream) error {
+	secret := os.Getenv(upstream.SignatureSecretEnv)
+	if secret == "" {
+		return core.NewInternalError("request signing secret is not configured for upstream", nil)
+	}
+
+	var newHash func() hash.Hash
+	switch upstream.SignatureAlgorithm {
+	case "", engine.SignatureHMACSHA256:
+		newHash = sha256.New
+	case engine.SignatureHMACSHA512:
+		newHash = sha512.New
+	default:
+		return core.NewInternalError(fmt.Sprintf("unknown signature_algorithm %q", upstream.SignatureAlgorithm), nil)
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+
+	header := upstream.SignatureHeader
+	if header == "" {
+		header = defaultSignatureHeader
+	}
+	req.Header.Set(header, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"aigis/internal/core/engine"
+)
+
+func TestSendSignsRequestBody(t *testing.T) {
+	t.Setenv("AIGIS_TEST_SIGNING_SECRET", "s3cret")
+
+	for _, tc := range []struct {
+		algorithm string
+		header    string
+		newHash   func() hash.Hash
+	}{
+		{"", "", sha256.New},
+		{engine.SignatureHMACSHA512, "X-Body-Signature", sha512.New},
+	} {
+		t.Run("algorithm="+tc.algorithm, func(t *testing.T) {
+			var body []byte
+			var signature string
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				header := tc.header
+				if header == "" {
+					header = "X-Signature"
+				}
+				signature = r.Header.Get(header)
+				w.Write([]byte(`{"choices":[]}`))
+			}))
+			defer upstream.Close()
+
+			route := &engine.Route{
+				ID: "signed",
+				Upstream: engine.Upstream{
+					BaseURL:            upstream.URL,
+					SignatureSecretEnv: "AIGIS_TEST_SIGNING_SECRET",
+					SignatureHeader:    tc.header,
+					SignatureAlgorithm: tc.algorithm,
+				},
+				Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+			}
+			clientBody := []byte(`{"model": "gpt-4", "messages": [{"role": "user", "content": "mail bob@corp.io"}]}`)
+			if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), clientBody, http.Header{}); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+
+			// The signature covers the transformed bytes the upstream received
+			if strings.Contains(string(body), "bob@corp.io") {
+				t.Fatalf("body was not transformed: %s", body)
+			}
+			mac := hmac.New(tc.newHash, []byte("s3cret"))
+			mac.Write(body)
+			if want := hex.EncodeToString(mac.Sum(nil)); signature != want {
+				t.Errorf("signature = %q, want %q", signature, want)
+			}
+		})
+	}
+}
+
+func TestSendFailsWithoutSigningSecret(t *testing.T) {
+	t.Setenv("AIGIS_TEST_SIGNING_UNSET", "")
+	route := &engine.Route{
+		ID:       "signed",
+		Upstream: engine.Upstream{BaseURL: "http://127.0.0.1:1", SignatureSecretEnv: "AIGIS_TEST_SIGNING_UNSET"},
+	}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{}); err == nil {
+		t.Error("Send should fail when the signing secret is missing")
+	}
+}
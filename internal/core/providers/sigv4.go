@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+)
+
+// SigV4 constants
+const (
+	sigV4Algorithm      = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat     = "20060102T150405Z"
+	defaultAWSService   = "bedrock"
+	defaultAccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	defaultSecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+	defaultSessionEnv   = "AWS_SESSION_TOKEN"
+)
+
+// awsCredentials holds the keys used to sign a request
+type awsCredentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// envOr returns the value of the named env var, or of fallback when name is empty
+func envOr(name, fallback string) string {
+	if name == "" {
+		name = fallback
+	}
+	return os.Getenv(name)
+}
+
+// signAWSRequest signs an upstream request with SigV4 using the credentials from the
+// upstream's env vars. It must run after all headers are set, right before sending.
+func signAWSRequest(req *http.Request, body []byte, upstream engine.Upstream) error {
+	creds := awsCredentials{
+		AccessKey:    envOr(upstream.AccessKeyEnv, defaultAccessKeyEnv),
+		SecretKey:    envOr(upstream.SecretKeyEnv, defaultSecretKeyEnv),
+		SessionToken: envOr(upstream.SessionTokenEnv, defaultSessionEnv),
+	}
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return core.NewInternalError("AWS credentials are not configured for upstream", nil)
+	}
+	if upstream.AWSRegion == "" {
+		return core.NewInternalError("aws_region is required for the aws_sigv4 auth strategy", nil)
+	}
+	service := upstream.AWSService
+	if service == "" {
+		service = defaultAWSService
+	}
+	signSigV4(req, body, creds, upstream.AWSRegion, service, time.Now())
+	return nil
+}
+
+// signSigV4 adds the X-Amz-Date (and session token) headers and the SigV4 Authorization
+// header to req. Only host, content-type and x-amz-* headers are signed, so hop-by-hop
+// changes by proxies cannot invalidate the signature.
+func signSigV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(sigV4TimeFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Escape(req.URL.EscapedPath(), false),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, creds.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query string with keys and values escaped and sorted
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	pairs := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key, true)+"="+sigV4Escape(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything except unreserved characters (and "/" unless
+// encodeSlash). Paths are escaped on top of their URL encoding, as AWS expects for every
+// service except S3 (e.g. Bedrock model ids containing ":").
+func sigV4Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		unreserved := ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~'
+		if unreserved || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package providers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"aigis/internal/core/engine"
+)
+
+// Credentials and expected signature from the AWS SigV4 test suite ("get-vanilla")
+var testAWSCredentials = awsCredentials{
+	AccessKey: "AKIDEXAMPLE",
+	SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSignSigV4KnownVector(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signSigV4(req, nil, testAWSCredentials, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization:\n got: %s\nwant: %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestSendSignsBedrockRequest(t *testing.T) {
+	t.Setenv("AIGIS_TEST_AWS_AK", "AKIDEXAMPLE")
+	t.Setenv("AIGIS_TEST_AWS_SK", "secret")
+	t.Setenv("AIGIS_TEST_AWS_TOKEN", "session")
+
+	received := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{ID: "bedrock", Upstream: engine.Upstream{
+		BaseURL:         upstream.URL,
+		Path:            "/model/anthropic.claude-3-sonnet-20240229-v1:0/invoke",
+		AuthStrategy:    engine.AuthStrategyAWSSigV4,
+		AWSRegion:       "us-west-2",
+		AccessKeyEnv:    "AIGIS_TEST_AWS_AK",
+		SecretKeyEnv:    "AIGIS_TEST_AWS_SK",
+		SessionTokenEnv: "AIGIS_TEST_AWS_TOKEN",
+	}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"claude"}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	headers := <-received
+	pattern := regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/\d{8}/us-west-2/bedrock/aws4_request, ` +
+		`SignedHeaders=content-type;host;x-amz-date;x-amz-security-token, Signature=[0-9a-f]{64}$`)
+	if got := headers.Get("Authorization"); !pattern.MatchString(got) {
+		t.Errorf("Malformed SigV4 Authorization header: %s", got)
+	}
+	if headers.Get("X-Amz-Security-Token") != "session" {
+		t.Errorf("Session token header missing, got %q", headers.Get("X-Amz-Security-Token"))
+	}
+}
+
+func TestSendSigV4MissingCredentials(t *testing.T) {
+	t.Setenv("AIGIS_TEST_AWS_AK", "")
+	route := &engine.Route{ID: "bedrock", Upstream: engine.Upstream{
+		BaseURL:      "http://127.0.0.1:1",
+		AuthStrategy: engine.AuthStrategyAWSSigV4,
+		AWSRegion:    "us-west-2",
+		AccessKeyEnv: "AIGIS_TEST_AWS_AK",
+	}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{}); err == nil {
+		t.Error("Expected an error without AWS credentials")
+	}
+}
+
+func TestSigV4EscapePath(t *testing.T) {
+	if got := sigV4Escape("/model/anthropic.claude-v1:0/invoke", false); got != "/model/anthropic.claude-v1%3A0/invoke" {
+		t.Errorf("sigV4Escape = %s", got)
+	}
+	if got := sigV4Escape("a b/c", true); got != "a%20b%2Fc" {
+		t.Errorf("sigV4Escape = %s", got)
+	}
+}
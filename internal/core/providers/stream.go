@@ -0,0 +1,198 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"aigis/internal/core"
+	"aigis/internal/core/security"
+)
+
+// toolCallKey identifies a streamed tool call by choice index and tool-call index
+type toolCallKey struct {
+	choice int
+	tool   int
+}
+
+// openAIStreamUnmasker restores placeholders in OpenAI chat completion stream chunks.
+// Text content and tool-call arguments arrive as deltas, so a placeholder may be split
+// across chunks; each stream of deltas is buffered independently (per choice, and per
+// tool-call index) until a placeholder boundary is safe to emit.
+type openAIStreamUnmasker struct {
+	scanner  *security.Scanner
+	ctx      *core.AIGisContext
+	content  map[int]*security.StreamUnmasker
+	toolArgs map[toolCallKey]*security.StreamUnmasker
+	stats    security.UnmaskStats
+	// redact also redacts PII the model produced itself in text deltas (redact_response)
+	redact bool
+}
+
+func newOpenAIStreamUnmasker(scanner *security.Scanner, ctx *core.AIGisContext) *openAIStreamUnmasker {
+	return &openAIStreamUnmasker{
+		scanner:  scanner,
+		ctx:      ctx,
+		content:  make(map[int]*security.StreamUnmasker),
+		toolArgs: make(map[toolCallKey]*security.StreamUnmasker),
+	}
+}
+
+// ProcessChunk rewrites the JSON payload of one SSE data event. When a choice reports a
+// finish_reason, any text still held back for it is flushed into that chunk.
+func (u *openAIStreamUnmasker) ProcessChunk(data []byte) ([]byte, error) {
+	if !gjson.ValidBytes(data) {
+		return data, nil // e.g. "[DONE]"
+	}
+
+	result := data
+	var err error
+	for i, choice := range gjson.GetBytes(data, "choices").Array() {
+		choiceIndex := int(choice.Get("index").Int())
+		finished := choice.Get("finish_reason").Type == gjson.String
+
+		// Text content deltas
+		if content := choice.Get("delta.content"); content.Type == gjson.String || finished {
+			unmasker := u.contentUnmasker(choiceIndex)
+			text := unmasker.Write(content.String())
+			if finished {
+				text += unmasker.Flush()
+				u.finish(unmasker)
+				delete(u.content, choiceIndex)
+			}
+			if content.Exists() || text != "" {
+				if result, err = sjson.SetBytes(result, fmt.Sprintf("choices.%d.delta.content", i), text); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// Tool-call argument deltas
+		for j, call := range choice.Get("delta.tool_calls").Array() {
+			args := call.Get("function.arguments")
+			if args.Type != gjson.String {
+				continue
+			}
+			key := toolCallKey{choice: choiceIndex, tool: int(call.Get("index").Int())}
+			text := u.toolUnmasker(key).Write(args.String())
+			path := fmt.Sprintf("choices.%d.delta.tool_calls.%d.function.arguments", i, j)
+			if result, err = sjson.SetBytes(result, path, text); err != nil {
+				return nil, err
+			}
+		}
+
+		if finished {
+			if result, err = u.flushToolCalls(result, i, choiceIndex); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// flushToolCalls appends the held-back arguments of every tool call of a finished choice
+func (u *openAIStreamUnmasker) flushToolCalls(chunk []byte, position, choiceIndex int) ([]byte, error) {
+	for key, unmasker := range u.toolArgs {
+		if key.choice != choiceIndex {
+			continue
+		}
+		delete(u.toolArgs, key)
+
+		rest := unmasker.Flush()
+		u.finish(unmasker)
+		if rest == "" {
+			continue
+		}
+		call, _ := sjson.Set(`{}`, "index", key.tool)
+		call, _ = sjson.Set(call, "function.arguments", rest)
+		var err error
+		chunk, err = sjson.SetRawBytes(chunk, fmt.Sprintf("choices.%d.delta.tool_calls.-1", position), []byte(call))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chunk, nil
+}
+
+// Flush releases text still held back when the stream ends without a finish_reason for
+// some choices. It returns one extra chunk carrying the remaining deltas, or nil if none.
+func (u *openAIStreamUnmasker) Flush() ([]byte, error) {
+	chunk := []byte(`{"choices":[]}`)
+	position := make(map[int]int)
+	var err error
+
+	for choiceIndex, unmasker := range u.content {
+		rest := unmasker.Flush()
+		u.finish(unmasker)
+		if rest == "" {
+			continue
+		}
+		choice, _ := sjson.Set(`{}`, "index", choiceIndex)
+		choice, _ = sjson.Set(choice, "delta.content", rest)
+		if chunk, err = sjson.SetRawBytes(chunk, "choices.-1", []byte(choice)); err != nil {
+			return nil, err
+		}
+		position[choiceIndex] = len(position)
+	}
+	u.content = make(map[int]*security.StreamUnmasker)
+
+	for key := range u.toolArgs {
+		if _, ok := position[key.choice]; !ok {
+			choice, _ := sjson.Set(`{"delta":{}}`, "index", key.choice)
+			if chunk, err = sjson.SetRawBytes(chunk, "choices.-1", []byte(choice)); err != nil {
+				return nil, err
+			}
+			position[key.choice] = len(position)
+		}
+	}
+	for choiceIndex, i := range position {
+		if chunk, err = u.flushToolCalls(chunk, i, choiceIndex); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(position) == 0 {
+		return nil, nil
+	}
+	return chunk, nil
+}
+
+// Stats returns the unmask statistics of all deltas flushed so far
+func (u *openAIStreamUnmasker) Stats() security.UnmaskStats {
+	return u.stats
+}
+
+// finish adds the statistics of a completed delta stream to the totals
+func (u *openAIStreamUnmasker) finish(unmasker *security.StreamUnmasker) {
+	stats := unmasker.Stats()
+	u.stats.Found += stats.Found
+	u.stats.Restored += stats.Restored
+	u.stats.Missed += stats.Missed
+}
+
+// contentUnmasker returns the stream unmasker for a choice's text, creating it on first use
+func (u *openAIStreamUnmasker) contentUnmasker(choice int) *security.StreamUnmasker {
+	if u.content[choice] == nil {
+		u.content[choice] = newTextUnmasker(u.scanner, u.ctx, u.redact)
+	}
+	return u.content[choice]
+}
+
+// newTextUnmasker returns the stream unmasker for model text; with redact, PII the model
+// produced itself is redacted before placeholders are restored
+func newTextUnmasker(scanner *security.Scanner, ctx *core.AIGisContext, redact bool) *security.StreamUnmasker {
+	if redact {
+		return scanner.NewStreamRedactor(ctx)
+	}
+	return scanner.NewStreamUnmasker(ctx)
+}
+
+// toolUnmasker returns the stream unmasker for a tool call's arguments, creating it on first use
+func (u *openAIStreamUnmasker) toolUnmasker(key toolCallKey) *security.StreamUnmasker {
+	if u.toolArgs[key] == nil {
+		u.toolArgs[key] = u.scanner.NewStreamUnmasker(u.ctx)
+	}
+	return u.toolArgs[key]
+}
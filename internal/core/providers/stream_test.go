@@ -0,0 +1,141 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+
+	"aigis/internal/core/engine"
+)
+
+func TestOpenAIStreamUnmaskerToolCallArguments(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "alice@corp.io")
+
+	// The model streams a tool call whose arguments contain the placeholder split over three deltas
+	args := `{"to":"` + placeholder + `","subject":"hi"}`
+	parts := []string{args[:12], args[12:25], args[25:]}
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"send_mail","arguments":""}}]},"finish_reason":null}]}`,
+	}
+	for _, part := range parts {
+		chunk := `{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":""}}]},"finish_reason":null}]}`
+		chunk = strings.Replace(chunk, `"arguments":""`, `"arguments":`+quote(part), 1)
+		chunks = append(chunks, chunk)
+	}
+	chunks = append(chunks, `{"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`, `[DONE]`)
+
+	unmasker := newOpenAIStreamUnmasker(p.scanner, ctx)
+	var streamed strings.Builder
+	for _, chunk := range chunks {
+		out, err := unmasker.ProcessChunk([]byte(chunk))
+		if err != nil {
+			t.Fatalf("ProcessChunk failed: %v", err)
+		}
+		for _, call := range gjson.GetBytes(out, "choices.0.delta.tool_calls").Array() {
+			streamed.WriteString(call.Get("function.arguments").String())
+		}
+		if strings.Contains(string(out), "__AIGIS_SEC_") {
+			t.Errorf("No chunk should expose a placeholder fragment, got %s", out)
+		}
+	}
+
+	if want := `{"to":"alice@corp.io","subject":"hi"}`; streamed.String() != want {
+		t.Errorf("Reassembled arguments = %s, want %s", streamed.String(), want)
+	}
+}
+
+func TestOpenAIStreamUnmaskerContent(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "13800138000")
+
+	unmasker := newOpenAIStreamUnmasker(p.scanner, ctx)
+	var streamed strings.Builder
+	for _, delta := range []string{"Call " + placeholder[:5], placeholder[5:] + " now", ""} {
+		chunk := `{"choices":[{"index":0,"delta":{"content":` + quote(delta) + `},"finish_reason":null}]}`
+		if delta == "" {
+			chunk = `{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`
+		}
+		out, err := unmasker.ProcessChunk([]byte(chunk))
+		if err != nil {
+			t.Fatalf("ProcessChunk failed: %v", err)
+		}
+		streamed.WriteString(gjson.GetBytes(out, "choices.0.delta.content").String())
+	}
+
+	if streamed.String() != "Call 13800138000 now" {
+		t.Errorf("Streamed content = %q", streamed.String())
+	}
+}
+
+func TestOpenAIStreamUnmaskerEverySplitOffset(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "sk-proj-abc123def456789012345")
+	text := "key: " + placeholder + "."
+
+	// Split the text into two classic OpenAI deltas at every byte offset
+	for offset := 0; offset <= len(text); offset++ {
+		unmasker := newOpenAIStreamUnmasker(p.scanner, ctx)
+		var streamed strings.Builder
+		for _, delta := range []string{text[:offset], text[offset:]} {
+			chunk := `{"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":` + quote(delta) + `},"finish_reason":null}]}`
+			out, err := unmasker.ProcessChunk([]byte(chunk))
+			if err != nil {
+				t.Fatalf("ProcessChunk failed: %v", err)
+			}
+			if strings.Contains(string(out), "__AIGIS") {
+				t.Fatalf("offset %d: chunk exposes a placeholder fragment: %s", offset, out)
+			}
+			streamed.WriteString(gjson.GetBytes(out, "choices.0.delta.content").String())
+		}
+		out, err := unmasker.ProcessChunk([]byte(`{"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`))
+		if err != nil {
+			t.Fatalf("ProcessChunk failed: %v", err)
+		}
+		streamed.WriteString(gjson.GetBytes(out, "choices.0.delta.content").String())
+
+		if want := "key: sk-proj-abc123def456789012345."; streamed.String() != want {
+			t.Errorf("offset %d: streamed %q, want %q", offset, streamed.String(), want)
+		}
+		if stats := unmasker.Stats(); stats.Restored != 1 {
+			t.Errorf("offset %d: Restored = %d, want 1", offset, stats.Restored)
+		}
+	}
+}
+
+func TestOpenAIStreamUnmaskerFlushWithoutFinishReason(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "stream"}, nil)
+	ctx := newTestContext()
+	placeholder := p.scanner.Tokenize(ctx, "alice@corp.io")
+
+	unmasker := newOpenAIStreamUnmasker(p.scanner, ctx)
+	out, err := unmasker.ProcessChunk([]byte(`{"choices":[{"index":0,"delta":{"content":` + quote("to "+placeholder[:10]) + `}}]}`))
+	if err != nil {
+		t.Fatalf("ProcessChunk failed: %v", err)
+	}
+	if got := gjson.GetBytes(out, "choices.0.delta.content").String(); got != "to " {
+		t.Fatalf("Partial placeholder should be held back, got %q", got)
+	}
+
+	// The upstream stops without a finish_reason: the held text is released on Flush
+	tail, err := unmasker.Flush()
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if got := gjson.GetBytes(tail, "choices.0.delta.content").String(); got != placeholder[:10] {
+		t.Errorf("Flush content = %q, want %q", got, placeholder[:10])
+	}
+
+	if tail, _ := unmasker.Flush(); tail != nil {
+		t.Errorf("Second Flush should have nothing to release, got %s", tail)
+	}
+}
+
+// quote returns s as a JSON string literal
+func quote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
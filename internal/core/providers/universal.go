@@ -1,12 +1,21 @@
 package providers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"text/template"
 	"time"
 
@@ -20,14 +29,28 @@ import (
 	"aigis/internal/core/engine"
 	"aigis/internal/core/security"
 	"aigis/internal/pkg/logger"
+	"aigis/internal/pkg/metrics"
+	"aigis/internal/pkg/tracing"
+	"aigis/internal/pkg/version"
 )
 
 // UniversalProvider implements the core.Provider interface with configurable routing
 type UniversalProvider struct {
-	route   *engine.Route
-	client  *http.Client
-	scanner *security.Scanner
-	log     *logger.Logger
+	route *engine.Route
+	// upstream is the upstream chosen for this request (see Route.PickUpstream) and client its
+	// pooled HTTP client
+	upstream      engine.Upstream
+	client        *http.Client
+	fanOutClients []*http.Client
+	scanner       *security.Scanner
+	// scannerErr is the route's invalid scanner configuration; requests fail with it
+	scannerErr  error
+	log         *logger.Logger
+	observeOnly bool
+	clientIP    string
+	// embeddings marks requests from /v1/embeddings: they use the upstream's embeddings path
+	// and have no content to unmask in the response
+	embeddings bool
 }
 
 // NewUniversalProvider creates a new universal provider for the given route
@@ -37,47 +60,527 @@ func NewUniversalProvider(route *engine.Route, log *logger.Logger) *UniversalPro
 		zapLogger, _ := logger.New("info")
 		log = logger.NewLogger(zapLogger)
 	}
-	return &UniversalProvider{
-		route:   route,
-		scanner: security.NewScanner(),
-		log:     log,
-		client: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+	p := &UniversalProvider{
+		route: route,
+		log:   log,
+	}
+	if p.scanner, p.scannerErr = route.PIIScanner(); p.scannerErr != nil {
+		// Keep a scanner so nothing dereferences nil; prepareRequestBody fails every request
+		p.scanner = security.NewScanner()
+	}
+	p.upstream, p.client = route.PickUpstream()
+	for i := range route.FanOut {
+		p.fanOutClients = append(p.fanOutClients, route.FanOutClient(i))
+	}
+	return p
+}
+
+// rateLimitHeaderPrefix is the prefix of standardized rate-limit headers returned to clients
+const rateLimitHeaderPrefix = "X-AIGis-RateLimit-"
+
+// maskingMissedHeader tells the client that a PII step with expect_masking: strict masked nothing
+const maskingMissedHeader = "X-AIGis-Masking-Missed"
+
+// bodyFramingHeaders describe the upstream body, which the gateway re-encodes; they are never
+// copied to the client even when allowlisted
+var bodyFramingHeaders = map[string]bool{
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+	"Connection":        true,
+}
+
+// forwardResponseHeaders returns the normalized rate-limit headers and the upstream response
+// headers allowlisted by the route's header_policy.response_allow to the client
+func (p *UniversalProvider) forwardResponseHeaders(ctx *core.AIGisContext, header http.Header) {
+	forwardRateLimitHeaders(ctx, p.upstream, header)
+
+	allow := p.route.HeaderPolicy.ResponseAllow
+	if len(allow) == 0 {
+		return
+	}
+	for key, values := range header {
+		if len(values) == 0 || bodyFramingHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		for _, rule := range allow {
+			if matchHeaderGlob(rule, key) {
+				ctx.SetResponseHeader(key, values[0])
+				break
+			}
+		}
 	}
 }
 
+// forwardRateLimitHeaders copies the upstream's rate-limit headers to the client response
+// under standardized X-AIGis-RateLimit-* names, per the upstream's rate_limit_headers mapping
+func forwardRateLimitHeaders(ctx *core.AIGisContext, upstream engine.Upstream, header http.Header) {
+	for upstreamName, standardName := range upstream.RateLimitHeaders {
+		value := header.Get(upstreamName)
+		if value == "" || standardName == "" {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(standardName), strings.ToLower(rateLimitHeaderPrefix)) {
+			standardName = rateLimitHeaderPrefix + standardName
+		}
+		ctx.SetResponseHeader(standardName, value)
+	}
+}
+
+// SetObserveOnly switches the provider to observe mode: transforms still run so detections
+// reach metrics, logs and audit events, but the original body is forwarded and the upstream
+// response is returned as-is
+func (p *UniversalProvider) SetObserveOnly(observe bool) {
+	p.observeOnly = observe
+}
+
+// SetEmbeddings marks the request as an embeddings request, sent to the upstream's
+// embeddings_path (default "/embeddings"); the response is passed through without unmasking
+func (p *UniversalProvider) SetEmbeddings(embeddings bool) {
+	p.embeddings = embeddings
+}
+
+// SetClientIP sets the client's IP address, forwarded upstream when the route's
+// HeaderPolicy enables forward_client_ip
+func (p *UniversalProvider) SetClientIP(ip string) {
+	p.clientIP = ip
+}
+
 // ID returns the route ID as the provider identifier
 func (p *UniversalProvider) ID() string {
 	return p.route.ID
 }
 
+// Upstream returns the upstream this provider sends the request to
+func (p *UniversalProvider) Upstream() engine.Upstream {
+	return p.upstream
+}
+
 // Send sends a request through the transformation pipeline to the upstream with header handling
 func (p *UniversalProvider) Send(ctx *core.AIGisContext, body []byte, originalHeaders http.Header) ([]byte, error) {
+	// Remember the client-facing model before transforms may rename it
+	if model := gjson.GetBytes(body, "model"); model.Type == gjson.String {
+		ctx.SetMetadata(core.MetaClientModel, model.String())
+	}
+
 	// Step 1: Apply request transforms (with bidirectional tokenization)
-	transformedBody, err := p.applyRequestTransforms(ctx, body)
+	transformedBody, err := p.prepareRequestBody(ctx, body)
 	if err != nil {
-		return nil, fmt.Errorf("transform error: %w", err)
+		return nil, err
+	}
+	if p.observeOnly {
+		return p.sendObserved(ctx, transformedBody, originalHeaders)
 	}
 
 	// Step 2: Prepare and send request with headers
-	respBody, err := p.sendToUpstream(ctx.Context, transformedBody, originalHeaders)
+	var resp *upstreamResponse
+	if len(p.route.FanOut) > 0 {
+		resp, err = p.sendFanOut(ctx.Context, transformedBody, originalHeaders)
+	} else {
+		resp, err = p.sendToUpstream(ctx.Context, transformedBody, originalHeaders)
+	}
+
+	// Surface normalized rate-limit and allowlisted headers (also on errors such as 429)
+	if resp != nil {
+		p.forwardResponseHeaders(ctx, resp.Header)
+	}
+
+	// Mirror to the shadow upstream for comparison; it never affects the client
+	if p.route.Shadow != nil {
+		p.startShadow(ctx.Context, transformedBody, originalHeaders, resp)
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
+	// Non-JSON bodies (e.g. HTML from a proxy, or an empty body) cannot be transformed; pass
+	// them through with the upstream's content type instead of labelling them as JSON
+	if !isJSONResponse(resp.Header, resp.Body) {
+		p.logNonJSONResponse(resp.StatusCode, resp.Header, resp.Body)
+		if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+			ctx.SetResponseHeader("Content-Type", contentType)
+		}
+		return resp.Body, nil
+	}
+
 	// Step 3: Apply response transforms - unmask placeholders in response content
-	finalResp, err := p.applyResponseTransforms(ctx, respBody)
+	finalResp, err := p.applyResponseTransforms(ctx, resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("response transform error: %w", err)
+		return nil, core.NewInternalError("response transform failed", err)
 	}
 
 	return finalResp, nil
 }
 
-// Stream sends a streaming request (not implemented yet)
-func (p *UniversalProvider) Stream(ctx context.Context, body []byte, originalHeaders http.Header) (<-chan []byte, error) {
-	return nil, fmt.Errorf("streaming not implemented")
+// prepareRequestBody turns the client body into the body sent upstream: request transforms,
+// serialization and schema validation. In observe mode the transforms only feed detection
+// metrics and logs, and the original body is returned unmodified.
+func (p *UniversalProvider) prepareRequestBody(ctx *core.AIGisContext, body []byte) ([]byte, error) {
+	if p.scannerErr != nil {
+		return nil, core.NewInternalError("invalid scanner configuration", p.scannerErr)
+	}
+	if p.passthrough() {
+		return body, p.validateRequestBody(body)
+	}
+	if p.observeOnly {
+		p.observe(ctx, body)
+		return body, nil
+	}
+
+	transformedBody, err := p.applyRequestTransforms(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.VaultOverflowError(); err != nil {
+		return nil, err
+	}
+	// Masking is done: write a shared vault's mappings before the upstream can answer, so
+	// another instance can unmask callbacks of this request (see POST /v1/unmask)
+	ctx.VaultFlush()
+	p.recordDetections(ctx, core.ModeEnforce)
+
+	// Serialize the body in the route's configured format
+	transformedBody = serializeBody(transformedBody, p.route.BodyFormat)
+
+	// Validate the final body against the route's schema before it leaves the gateway
+	if err := p.validateRequestBody(transformedBody); err != nil {
+		return nil, err
+	}
+
+	return transformedBody, nil
+}
+
+// passthrough reports whether the client body can be forwarded byte-for-byte. Without
+// transforms there is nothing to rewrite, so the parse, re-marshal and compaction copies are
+// skipped and the upstream request reads directly from the buffer the handler already holds
+// (routing needs the buffered body). For large multi-image requests this avoids one to two
+// extra full-size copies per request. The "stable" body format still forces re-serialization.
+func (p *UniversalProvider) passthrough() bool {
+	return len(p.route.Transforms) == 0 && p.route.BodyFormat != engine.BodyFormatStable
+}
+
+// validateRequestBody checks body against the route's request schema, if any
+func (p *UniversalProvider) validateRequestBody(body []byte) error {
+	if err := p.route.ValidateRequestBody(body); err != nil {
+		var violation *engine.SchemaViolationError
+		if errors.As(err, &violation) {
+			return core.NewValidationError(violation.Error(), err)
+		}
+		return core.NewInternalError("request schema validation failed", err)
+	}
+	return nil
+}
+
+// observe runs the request transforms only to detect sensitive values. Transform and schema
+// failures are logged instead of blocking.
+func (p *UniversalProvider) observe(ctx *core.AIGisContext, body []byte) {
+	transformedBody, err := p.applyRequestTransforms(ctx, body)
+	if err != nil {
+		p.log.Warn("Observe mode: request transforms failed",
+			zap.String("route_id", p.route.ID),
+			zap.String("request_id", ctx.RequestID),
+			zap.Error(err),
+		)
+	} else if err := p.route.ValidateRequestBody(serializeBody(transformedBody, p.route.BodyFormat)); err != nil {
+		p.log.Warn("Observe mode: request would fail schema validation",
+			zap.String("route_id", p.route.ID),
+			zap.String("request_id", ctx.RequestID),
+			zap.Error(err),
+		)
+	}
+
+	if counts := p.recordDetections(ctx, core.ModeObserve); len(counts) > 0 {
+		p.log.Info("Observe mode: sensitive values detected, forwarding original body",
+			zap.String("route_id", p.route.ID),
+			zap.String("request_id", ctx.RequestID),
+			zap.Any("detections", counts),
+		)
+	}
+}
+
+// sendObserved forwards the original body and returns the upstream response as-is
+func (p *UniversalProvider) sendObserved(ctx *core.AIGisContext, body []byte, originalHeaders http.Header) ([]byte, error) {
+	resp, err := p.sendToUpstream(ctx.Context, body, originalHeaders)
+	if resp != nil {
+		p.forwardResponseHeaders(ctx, resp.Header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// recordDetections adds this request's per-rule detection counts to the detection metric
+func (p *UniversalProvider) recordDetections(ctx *core.AIGisContext, mode string) map[string]int {
+	counts := ctx.MaskCounts()
+	for rule, count := range counts {
+		metrics.PIIDetectedTotal.WithLabelValues(rule, mode).Add(float64(count))
+		if mode == core.ModeEnforce {
+			metrics.SecretsTotal.WithLabelValues(metrics.SecretsMasked).Add(float64(count))
+		}
+	}
+	return counts
+}
+
+// Stream sends a streaming request and returns the payloads of the upstream's SSE data events
+// in order, or its raw SSE frames on Claude-format routes (see RawStream). Upstream HTTP errors
+// are returned before any chunk is emitted. The channel is closed on "data: [DONE]", at EOF,
+// or when ctx is cancelled.
+func (p *UniversalProvider) Stream(ctx *core.AIGisContext, body []byte, originalHeaders http.Header) (<-chan []byte, error) {
+	if model := gjson.GetBytes(body, "model"); model.Type == gjson.String {
+		ctx.SetMetadata(core.MetaClientModel, model.String())
+	}
+
+	upstreamBody, err := p.prepareRequestBody(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The span covers the upstream call up to the response headers (time to first byte)
+	spanCtx, span := p.startUpstreamSpan(ctx.Context, p.upstream)
+	span.SetAttributes(tracing.Bool("aigis.stream", true))
+	defer span.End()
+
+	// Streams may legitimately outlive the upstream's timeout_seconds, so it only bounds the
+	// wait for the response headers; after that ctx bounds the stream
+	streamCtx, cancel := context.WithCancel(spanCtx)
+	var headerTimedOut atomic.Bool
+	headerTimer := time.AfterFunc(p.upstream.Timeout(), func() {
+		headerTimedOut.Store(true)
+		cancel()
+	})
+
+	httpReq, err := p.newUpstreamRequest(streamCtx, p.upstream, upstreamBody, originalHeaders)
+	if err != nil {
+		headerTimer.Stop()
+		cancel()
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{Transport: p.client.Transport}
+	resp, err := client.Do(httpReq)
+	if !headerTimer.Stop() && err == nil {
+		// The timer fired as the headers arrived; the body would fail on its first read
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		cancel()
+		if isTimeout(err) || headerTimedOut.Load() {
+			err = core.NewTimeoutError("upstream request timed out", err)
+		} else {
+			err = core.NewUpstreamError("failed to send upstream request", err)
+		}
+		p.recordUpstreamError(0, err)
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+	// Release the stream's context once the body is done with
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	dropOAuth2Token(p.upstream, httpReq, resp.StatusCode)
+
+	p.forwardResponseHeaders(ctx, resp.Header)
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		errBody, _ := io.ReadAll(resp.Body)
+		p.recordUpstreamError(resp.StatusCode, nil)
+		err := p.handleHTTPError(resp.StatusCode, resp.Header, errBody)
+		tracing.RecordError(span, err)
+		return nil, err
+	}
+
+	chunks := make(chan []byte)
+
+	// Claude streams have named events, so they are relayed as raw frames rather than data payloads
+	if p.RawStream() {
+		var unmasker *claudeStreamUnmasker
+		if !p.observeOnly {
+			unmasker = newClaudeStreamUnmasker(p.scanner, ctx)
+			unmasker.redact = p.redactsResponse()
+		}
+		go p.relayClaudeEvents(ctx, resp.Body, chunks, unmasker)
+		return chunks, nil
+	}
+
+	// Placeholders are restored before chunks reach the client; nothing was masked in observe mode
+	var unmasker *openAIStreamUnmasker
+	if !p.observeOnly {
+		unmasker = newOpenAIStreamUnmasker(p.scanner, ctx)
+		unmasker.redact = p.redactsResponse()
+	}
+
+	go p.readEvents(ctx, resp.Body, chunks, unmasker)
+	return chunks, nil
+}
+
+// cancelOnClose cancels a request's context when its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// RawStream reports whether Stream emits complete SSE frames (event lines included) that are
+// relayed unchanged, rather than data payloads: routes whose PII step handles the Claude format
+func (p *UniversalProvider) RawStream() bool {
+	for _, step := range p.route.Transforms {
+		if step.Type == engine.TransformTypePIIClaude {
+			return true
+		}
+	}
+	return false
+}
+
+// relayClaudeEvents forwards a Claude SSE body to chunks as raw frames and closes both when
+// done. With an unmasker, placeholders in text deltas are restored and held-back text is
+// flushed at the end.
+func (p *UniversalProvider) relayClaudeEvents(ctx *core.AIGisContext, body io.ReadCloser, chunks chan<- []byte, unmasker *claudeStreamUnmasker) {
+	defer close(chunks)
+	defer body.Close()
+
+	send := func(data []byte) bool {
+		if len(data) == 0 {
+			return true
+		}
+		select {
+		case chunks <- data:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if unmasker != nil {
+		defer func() {
+			p.recordUnmaskStats(ctx, unmasker.Stats())
+		}()
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			frames := bytes.Clone(buf[:n])
+			if unmasker != nil {
+				unmasked, uerr := unmasker.Write(frames)
+				if uerr != nil {
+					p.log.Warn("Failed to unmask stream chunk",
+						zap.String("route_id", p.route.ID),
+						zap.Error(uerr),
+					)
+					return
+				}
+				frames = unmasked
+			}
+			if !send(frames) {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				p.log.Warn("Upstream stream ended unexpectedly",
+					zap.String("route_id", p.route.ID),
+					zap.Error(err),
+				)
+			}
+			break
+		}
+	}
+
+	// Release an unterminated event and text held back for blocks that never stopped
+	if unmasker != nil && ctx.Err() == nil {
+		tail, err := unmasker.Flush()
+		if err != nil {
+			p.log.Warn("Failed to flush stream unmasker",
+				zap.String("route_id", p.route.ID),
+				zap.Error(err),
+			)
+			return
+		}
+		send(tail)
+	}
+}
+
+// readEvents forwards the data payloads of an SSE body to chunks and closes both when done.
+// With an unmasker, each payload is unmasked and held-back text is flushed at the end.
+func (p *UniversalProvider) readEvents(ctx *core.AIGisContext, body io.ReadCloser, chunks chan<- []byte, unmasker *openAIStreamUnmasker) {
+	defer close(chunks)
+	defer body.Close()
+
+	send := func(data []byte) bool {
+		select {
+		case chunks <- data:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	if unmasker != nil {
+		defer func() {
+			p.recordUnmaskStats(ctx, unmasker.Stats())
+		}()
+	}
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if data, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:")); ok {
+			data = bytes.TrimSpace(data)
+			if string(data) == "[DONE]" {
+				break
+			}
+			if unmasker != nil {
+				unmasked, uerr := unmasker.ProcessChunk(data)
+				if uerr != nil {
+					p.log.Warn("Failed to unmask stream chunk",
+						zap.String("route_id", p.route.ID),
+						zap.Error(uerr),
+					)
+				} else {
+					data = unmasked
+				}
+			}
+			if !send(data) {
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				p.log.Warn("Upstream stream ended unexpectedly",
+					zap.String("route_id", p.route.ID),
+					zap.Error(err),
+				)
+			}
+			break
+		}
+	}
+
+	// Release text still held back for choices that never reported a finish_reason
+	if unmasker != nil && ctx.Err() == nil {
+		tail, err := unmasker.Flush()
+		if err != nil {
+			p.log.Warn("Failed to flush stream unmasker",
+				zap.String("route_id", p.route.ID),
+				zap.Error(err),
+			)
+			return
+		}
+		if tail != nil {
+			send(tail)
+		}
+	}
 }
 
 // applyRequestTransforms applies all configured transformations to the request body
@@ -85,66 +588,166 @@ func (p *UniversalProvider) applyRequestTransforms(ctx *core.AIGisContext, body
 	result := body
 
 	for _, step := range p.route.Transforms {
-		var err error
-		switch step.Type {
-		case engine.TransformTypePII:
-			result, err = p.applyPIITransform(ctx, result, step.Config)
-		case engine.TransformTypePIIClaude:
-			result, err = p.applyClaudePIITransform(ctx, result, step.Config)
-		case engine.TransformTypeFieldMap:
-			result, err = p.applyFieldMapTransform(result, step.Config)
-		case engine.TransformTypeTemplate:
-			result, err = p.applyTemplateTransform(result, step.Config)
-		default:
-			// Unknown transform type, skip
-			continue
+		current := result
+		_, span := tracing.Start(ctx, "transform."+step.Type, tracing.KindInternal)
+		next, err := core.RunWithTimeout(ctx, time.Duration(step.TimeoutMs)*time.Millisecond, func() ([]byte, error) {
+			return p.applyRequestTransform(ctx, step, current)
+		})
+		tracing.RecordError(span, err)
+		span.End()
+		if errors.Is(err, core.ErrStepTimeout) {
+			if step.FailOpen && !engine.IsPIITransform(step.Type) {
+				p.log.Warn("Transform timed out, skipping",
+					zap.String("route_id", p.route.ID),
+					zap.String("transform", step.Type),
+					zap.Int("timeout_ms", step.TimeoutMs),
+				)
+				continue
+			}
+			return nil, core.NewTimeoutError(
+				fmt.Sprintf("transform %s timed out", step.Type),
+				fmt.Errorf("transform %s after %dms: %w", step.Type, step.TimeoutMs, err),
+			)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("transform %s failed: %w", step.Type, err)
+			var gwErr *core.GatewayError
+			if errors.As(err, &gwErr) {
+				return nil, err
+			}
+			return nil, core.NewInternalError("request transform failed", fmt.Errorf("transform %s failed: %w", step.Type, err))
 		}
+		result = next
 	}
 
 	return result, nil
 }
 
+// applyRequestTransform applies a single transform step; unknown types leave the body unchanged
+func (p *UniversalProvider) applyRequestTransform(ctx *core.AIGisContext, step engine.TransformStep, body []byte) ([]byte, error) {
+	switch step.Type {
+	case engine.TransformTypePII, engine.TransformTypePIIClaude, engine.TransformTypePIIGemini, engine.TransformTypePIIOllama:
+		before := ctx.MaskedTotal()
+		var result []byte
+		var err error
+		switch {
+		case step.Config["scan_all"] == "true":
+			result, err = p.applyDeepPIITransform(ctx, body, step.Config)
+		case step.Type == engine.TransformTypePII:
+			result, err = p.applyPIITransform(ctx, body, step.Config)
+		case step.Type == engine.TransformTypePIIClaude:
+			result, err = p.applyClaudePIITransform(ctx, body, step.Config)
+		case step.Type == engine.TransformTypePIIOllama:
+			result, err = p.applyOllamaPIITransform(ctx, body, step.Config)
+		default:
+			result, err = p.applyGeminiPIITransform(ctx, body, step.Config)
+		}
+		if err == nil {
+			p.checkExpectMasking(ctx, step, body, ctx.MaskedTotal()-before)
+		}
+		return result, err
+	case engine.TransformTypeFieldMap:
+		return p.applyFieldMapTransform(body, step.Config)
+	case engine.TransformTypeTemplate:
+		return p.applyTemplateTransform(body, step.Config)
+	case engine.TransformTypeRedactPaths:
+		return p.applyRedactPathsTransform(ctx, body, step.Config)
+	case engine.TransformTypeClampParam:
+		return p.applyClampParamTransform(body, step.Config)
+	case engine.TransformTypeModelAlias:
+		return p.applyModelAliasTransform(body, step.Config)
+	case engine.TransformTypeFieldDelete:
+		return p.applyFieldDeleteTransform(body, step.Config)
+	case engine.TransformTypeRegexReplace:
+		return p.applyRegexReplaceTransform(body, step)
+	case engine.TransformTypeInjectIDs:
+		return p.applyInjectIDsTransform(ctx, body, step.Config)
+	case engine.TransformTypeMergeSystemIntoUser:
+		return p.applyMergeSystemTransform(body, step.Config)
+	case engine.TransformTypeOpenAIToClaude:
+		return p.applyOpenAIToClaudeTransform(body, step.Config)
+	default:
+		// Unknown transform type, skip
+		return body, nil
+	}
+}
+
 // buildUpstreamHeaders constructs headers for upstream request based on HeaderPolicy
 func (p *UniversalProvider) buildUpstreamHeaders(originalHeaders http.Header, authHeader http.Header) http.Header {
 	upstreamHeaders := make(http.Header)
 
-	// 1. Allow: Copy headers from Allow list
+	// 0. Default User-Agent identifies the gateway, version and route in upstream logs.
+	// An allowed client User-Agent or a HeaderPolicy.Set entry replaces it.
+	upstreamHeaders.Set("User-Agent", fmt.Sprintf("AIGis/%s (route=%s)", version.Version, p.route.ID))
+
+	// 1. Allow: Copy headers from Allow list (supports globs like "X-Custom-*")
 	for _, headerName := range p.route.HeaderPolicy.Allow {
-		if value := originalHeaders.Get(headerName); value != "" {
-			upstreamHeaders.Set(headerName, value)
+		if !isHeaderGlob(headerName) {
+			if value := originalHeaders.Get(headerName); value != "" {
+				upstreamHeaders.Set(headerName, value)
+			}
+			continue
+		}
+		for key, values := range originalHeaders {
+			if matchHeaderGlob(headerName, key) && len(values) > 0 && values[0] != "" {
+				upstreamHeaders.Set(key, values[0])
+			}
+		}
+	}
+
+	// 2. Rename: forward client headers under the upstream's expected name
+	for from, to := range p.route.HeaderPolicy.Rename {
+		upstreamHeaders.Del(from)
+		if value := originalHeaders.Get(from); value != "" {
+			upstreamHeaders.Set(to, value)
 		}
 	}
 
-	// 2. Set: Force set headers from config
+	// 3. Client IP: append to the client's X-Forwarded-For chain (Set and Remove below still apply)
+	if p.route.HeaderPolicy.ForwardClientIP && p.clientIP != "" {
+		forwarded := p.clientIP
+		if chain := strings.Join(originalHeaders.Values("X-Forwarded-For"), ", "); chain != "" {
+			forwarded = chain + ", " + p.clientIP
+		}
+		upstreamHeaders.Set("X-Forwarded-For", forwarded)
+		if p.route.HeaderPolicy.RealIP {
+			upstreamHeaders.Set("X-Real-IP", p.clientIP)
+		}
+	}
+
+	// 4. Set: Force set headers from config (wins over Rename targets)
 	for key, value := range p.route.HeaderPolicy.Set {
-		// Check for env:VAR syntax
-		if len(value) >= 4 && value[:4] == "env:" {
-			envVar := value[4:]
-			envValue := os.Getenv(envVar)
-			if envValue != "" {
-				upstreamHeaders.Set(key, envValue)
-			}
-		} else {
-			// Literal value
-			upstreamHeaders.Set(key, value)
+		// Literal value or env:VAR; headers whose variable is unset are skipped
+		if resolved := engine.ResolveEnv(value); resolved != "" {
+			upstreamHeaders.Set(key, resolved)
 		}
 	}
 
-	// 3. Remove: Remove headers from Remove list
+	// 5. Remove: Remove headers from Remove list (supports globs like "X-Internal-*")
 	for _, headerName := range p.route.HeaderPolicy.Remove {
-		upstreamHeaders.Del(headerName)
+		if !isHeaderGlob(headerName) {
+			upstreamHeaders.Del(headerName)
+			continue
+		}
+		for key := range upstreamHeaders {
+			if matchHeaderGlob(headerName, key) {
+				upstreamHeaders.Del(key)
+			}
+		}
 	}
 
-	// 4. Auth: Add authentication headers (these override both Allow and Remove)
+	// 6. Auth: Add authentication headers (these override both Allow and Remove)
 	for key, values := range authHeader {
+		upstreamHeaders.Del(key)
 		for _, value := range values {
 			upstreamHeaders.Add(key, value)
 		}
 	}
 
+	// Body framing headers describe the client's original body, not the transformed one.
+	// Content-Length is recomputed per request in sendToUpstream.
+	upstreamHeaders.Del("Content-Length")
+	upstreamHeaders.Del("Transfer-Encoding")
+
 	// Always ensure Content-Type is set
 	if upstreamHeaders.Get("Content-Type") == "" {
 		upstreamHeaders.Set("Content-Type", "application/json")
@@ -153,10 +756,28 @@ func (p *UniversalProvider) buildUpstreamHeaders(originalHeaders http.Header, au
 	return upstreamHeaders
 }
 
-// buildAuthHeaders constructs authentication headers based on the route's AuthStrategy
-func (p *UniversalProvider) buildAuthHeaders() http.Header {
+// isHeaderGlob reports whether a header rule contains glob metacharacters
+func isHeaderGlob(rule string) bool {
+	return strings.ContainsAny(rule, "*?[")
+}
+
+// matchHeaderGlob matches a header name against a glob rule, case-insensitively
+func matchHeaderGlob(rule, name string) bool {
+	matched, err := path.Match(strings.ToLower(rule), strings.ToLower(name))
+	return err == nil && matched
+}
+
+// buildAuthHeaders constructs authentication headers based on the upstream's AuthStrategy.
+// With AuthStrategyPassthrough the client's Authorization header is used instead of token_env.
+func (p *UniversalProvider) buildAuthHeaders(upstream engine.Upstream, originalHeaders http.Header) http.Header {
 	headers := make(http.Header)
-	upstream := p.route.Upstream
+
+	if upstream.AuthStrategy == engine.AuthStrategyPassthrough {
+		if auth := originalHeaders.Get("Authorization"); auth != "" {
+			headers.Set("Authorization", auth)
+		}
+		return headers
+	}
 
 	token := os.Getenv(upstream.TokenEnv)
 	if token == "" {
@@ -172,6 +793,9 @@ func (p *UniversalProvider) buildAuthHeaders() http.Header {
 			headerName = "Authorization"
 		}
 		headers.Set(headerName, token)
+	// AuthStrategyAWSSigV4 and AuthStrategyOAuth2 are applied in newUpstreamRequest;
+	// AuthStrategyNone never adds credentials, even if token_env is set
+	case engine.AuthStrategyAWSSigV4, engine.AuthStrategyOAuth2, engine.AuthStrategyNone:
 	// AuthStrategyQuery is handled in buildUpstreamURL or query params, not headers
 	// We handle default (bearer) as well
 	default:
@@ -191,13 +815,27 @@ func (p *UniversalProvider) applyPIITransform(ctx *core.AIGisContext, body []byt
 		return body, nil // Return original if parse fails
 	}
 
+	// Some OpenAI-compatible APIs accept a top-level "system" string (parity with pii_claude)
+	skipSystem := config["skip_system"] == "true"
+	systemNode := root.Get("system")
+	if err := systemNode.Check(); err == nil && !skipSystem && systemNode.Type() == ast.V_STRING {
+		if systemStr, err := systemNode.String(); err == nil {
+			if masked := p.mask(ctx, systemStr, config); masked != systemStr {
+				root.Set("system", ast.NewString(masked))
+			}
+		}
+	}
+
+	// Embeddings (and other input-based APIs): "input" is a string or an array of strings
+	p.maskInput(ctx, &root, config)
+
 	messagesNode := root.Get("messages")
 	if err := messagesNode.Check(); err != nil {
-		return body, nil
+		return root.MarshalJSON()
 	}
 
 	if messagesNode.Type() != ast.V_ARRAY {
-		return body, nil
+		return root.MarshalJSON()
 	}
 
 	i := 0
@@ -207,12 +845,24 @@ func (p *UniversalProvider) applyPIITransform(ctx *core.AIGisContext, body []byt
 			break
 		}
 
+		if skipSystem && isSystemMessage(msgNode) {
+			i++
+			continue
+		}
+
 		contentNode := msgNode.Get("content")
 		if err := contentNode.Check(); err != nil {
 			i++
 			continue
 		}
 
+		// Content parts: [{"type":"text","text":"..."},{"type":"image_url",...}]
+		if contentNode.Type() == ast.V_ARRAY {
+			p.maskTextBlocks(ctx, contentNode, config)
+			i++
+			continue
+		}
+
 		if contentNode.Type() != ast.V_STRING {
 			i++
 			continue
@@ -225,7 +875,7 @@ func (p *UniversalProvider) applyPIITransform(ctx *core.AIGisContext, body []byt
 		}
 
 		// Use Mask() for bidirectional tokenization instead of Sanitize()
-		newContent := p.scanner.Mask(ctx, contentStr, nil)
+		newContent := p.mask(ctx, contentStr, config)
 
 		if newContent != contentStr {
 			msgNode.Set("content", ast.NewString(newContent))
@@ -237,40 +887,152 @@ func (p *UniversalProvider) applyPIITransform(ctx *core.AIGisContext, body []byt
 	return root.MarshalJSON()
 }
 
-// applyClaudePIITransform redacts PII from Claude/Anthropic format request body using bidirectional tokenization
-// Claude format:
-//
-//	{
-//	  "system": "...",  // optional top-level string
-//	  "messages": [
-//	    {
-//	      "role": "user",
-//	      "content": "..."  // can be string OR array of blocks
-//	    },
-//	    {
-//	      "role": "assistant",
-//	      "content": [
-//	        {"type": "text", "text": "..."},
-//	        {"type": "image", ...}
-//	      ]
-//	    }
-//	  ]
-//	}
-func (p *UniversalProvider) applyClaudePIITransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
-	// Helper function to redact using scanner with Mask()
-	redact := func(s string) string {
-		return p.scanner.Mask(ctx, s, nil)
+// maskInput masks the top-level "input" field when it is a string or an array of strings
+// (token arrays and other shapes are left untouched)
+func (p *UniversalProvider) maskInput(ctx *core.AIGisContext, root *ast.Node, config map[string]string) {
+	inputNode := root.Get("input")
+	if err := inputNode.Check(); err != nil {
+		return
 	}
 
-	// Parse the body as Sonic AST
-	root, err := sonic.Get(body)
-	if err != nil {
-		return body, nil // Return original if parse fails
+	switch inputNode.Type() {
+	case ast.V_STRING:
+		if inputStr, err := inputNode.String(); err == nil {
+			if masked := p.mask(ctx, inputStr, config); masked != inputStr {
+				root.Set("input", ast.NewString(masked))
+			}
+		}
+	case ast.V_ARRAY:
+		for i := 0; ; i++ {
+			itemNode := inputNode.Index(i)
+			if err := itemNode.Check(); err != nil {
+				break
+			}
+			itemStr, err := itemNode.String()
+			if err != nil || itemNode.Type() != ast.V_STRING {
+				continue
+			}
+			if masked := p.mask(ctx, itemStr, config); masked != itemStr {
+				inputNode.SetByIndex(i, ast.NewString(masked))
+			}
+		}
+	}
+}
+
+// checkExpectMasking flags PII steps configured with expect_masking that masked nothing
+// although the request had content - usually a sign of misconfigured rules or content in
+// an unexpected field. "warn" logs a warning; "strict" also sets MetaMaskingMissed, which the
+// request logger reports, and returns the X-AIGis-Masking-Missed header to the client.
+func (p *UniversalProvider) checkExpectMasking(ctx *core.AIGisContext, step engine.TransformStep, body []byte, masked int) {
+	mode := step.Config["expect_masking"]
+	if mode == "" || masked > 0 || !hasMessageContent(body) {
+		return
+	}
+
+	p.log.Warn("PII step expected masking but masked nothing",
+		zap.String("route_id", p.route.ID),
+		zap.String("request_id", ctx.RequestID),
+		zap.String("transform", step.Type),
+	)
+	if mode == "strict" {
+		ctx.SetMetadata(core.MetaMaskingMissed, true)
+		ctx.SetResponseHeader(maskingMissedHeader, "true")
+	}
+}
+
+// hasMessageContent reports whether the body carries any non-empty text for PII steps to scan
+func hasMessageContent(body []byte) bool {
+	if strings.TrimSpace(gjson.GetBytes(body, "system").String()) != "" {
+		return true
+	}
+	for _, content := range gjson.GetBytes(body, "messages.#.content").Array() {
+		if strings.TrimSpace(content.String()) != "" && content.String() != "[]" {
+			return true
+		}
+	}
+	// Ollama /api/generate: prompt; embeddings: input
+	for _, field := range []string{"prompt", "input"} {
+		if value := strings.TrimSpace(gjson.GetBytes(body, field).String()); value != "" && value != "[]" {
+			return true
+		}
+	}
+	// Gemini: contents[].parts[].text
+	for _, text := range gjson.GetBytes(body, "contents.#.parts.#.text|@flatten").Array() {
+		if strings.TrimSpace(text.String()) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isSystemMessage reports whether a message node has the "system" role
+func isSystemMessage(msgNode *ast.Node) bool {
+	role, err := msgNode.Get("role").String()
+	return err == nil && role == "system"
+}
+
+// mask tokenizes sensitive values in s according to the PII step config.
+// With format_preserving: "true", placeholders keep the shape of the original data
+// (e.g. emails stay emails) for rules that define a mask format. With skip_code_fences:
+// "true", values inside ``` fenced code blocks are sent as-is.
+//
+// Client text that already contains gateway placeholders is neutralized first so only
+// gateway-generated placeholders reach the vaulted body. client_placeholders selects the
+// behavior: "escape" (default) rewrites them, "strip" removes them, "allow" keeps them.
+func (p *UniversalProvider) mask(ctx *core.AIGisContext, s string, config map[string]string) string {
+	if mode := config["client_placeholders"]; mode != "allow" {
+		neutralized, count := p.scanner.NeutralizePlaceholders(s, mode == "strip")
+		if count > 0 {
+			p.log.Warn("Neutralized client-supplied placeholders",
+				zap.String("route_id", p.route.ID),
+				zap.String("request_id", ctx.RequestID),
+				zap.Int("count", count),
+			)
+			s = neutralized
+		}
+	}
+
+	return p.scanner.MaskWithOptions(ctx, s, p.route.ScannerTags(), security.MaskOptions{
+		PreserveFormat: config["format_preserving"] == "true",
+		SkipCodeFences: config["skip_code_fences"] == "true",
+	})
+}
+
+// applyClaudePIITransform redacts PII from Claude/Anthropic format request body using bidirectional tokenization
+// Claude format:
+//
+//	{
+//	  "system": "...",  // optional top-level string
+//	  "messages": [
+//	    {
+//	      "role": "user",
+//	      "content": "..."  // can be string OR array of blocks
+//	    },
+//	    {
+//	      "role": "assistant",
+//	      "content": [
+//	        {"type": "text", "text": "..."},
+//	        {"type": "image", ...}
+//	      ]
+//	    }
+//	  ]
+//	}
+func (p *UniversalProvider) applyClaudePIITransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	// Helper function to redact using scanner with Mask()
+	redact := func(s string) string {
+		return p.mask(ctx, s, config)
+	}
+
+	// Parse the body as Sonic AST
+	root, err := sonic.Get(body)
+	if err != nil {
+		return body, nil // Return original if parse fails
 	}
 
 	// 1. Handle top-level "system" field (if it exists and is a string)
+	skipSystem := config["skip_system"] == "true"
 	systemNode := root.Get("system")
-	if err := systemNode.Check(); err == nil && systemNode.Type() == ast.V_STRING {
+	if err := systemNode.Check(); err == nil && !skipSystem && systemNode.Type() == ast.V_STRING {
 		if systemStr, err := systemNode.String(); err == nil {
 			redactedSystem := redact(systemStr)
 			if redactedSystem != systemStr {
@@ -297,6 +1059,11 @@ func (p *UniversalProvider) applyClaudePIITransform(ctx *core.AIGisContext, body
 			break
 		}
 
+		if skipSystem && isSystemMessage(msgNode) {
+			msgIdx++
+			continue
+		}
+
 		// Get the "content" field of this message
 		contentNode := msgNode.Get("content")
 		if err := contentNode.Check(); err != nil {
@@ -318,34 +1085,7 @@ func (p *UniversalProvider) applyClaudePIITransform(ctx *core.AIGisContext, body
 			}
 		} else if contentNode.Type() == ast.V_ARRAY {
 			// Array of blocks (Claude format)
-			blockIdx := 0
-			for {
-				blockNode := contentNode.Index(blockIdx)
-				if err := blockNode.Check(); err != nil {
-					break
-				}
-
-				// Check if this is a text block
-				typeNode := blockNode.Get("type")
-				textNode := blockNode.Get("text")
-
-				typeNodeErr := typeNode.Check()
-				textNodeErr := textNode.Check()
-				if typeNodeErr == nil && textNodeErr == nil {
-					typeStr, typeErr := typeNode.String()
-					textStr, textErr := textNode.String()
-
-					if typeErr == nil && textErr == nil && typeStr == "text" {
-						// Redact the "text" field using Mask()
-						redactedText := redact(textStr)
-						if redactedText != textStr {
-							blockNode.Set("text", ast.NewString(redactedText))
-						}
-					}
-				}
-
-				blockIdx++
-			}
+			p.maskTextBlocks(ctx, contentNode, config)
 		}
 
 		msgIdx++
@@ -356,15 +1096,238 @@ func (p *UniversalProvider) applyClaudePIITransform(ctx *core.AIGisContext, body
 		return nil, err
 	}
 
-	// Debug logging after redaction
-	p.log.Debug("Claude PII transform applied",
-		// zap.String("original", string(body)),
-		zap.String("redacted", string(result)),
-	)
+	// Debug logging after redaction; values left unmasked (e.g. past the vault limit) are
+	// sanitized so secrets never reach the logs
+	if p.log.Core().Enabled(zap.DebugLevel) {
+		p.log.Debug("Claude PII transform applied",
+			zap.String("redacted", p.scanner.Sanitize(string(result))),
+		)
+	}
 
 	return result, nil
 }
 
+// applyOllamaPIITransform redacts PII from an Ollama native request body using bidirectional tokenization.
+// /api/chat uses OpenAI-style messages (images are base64 and left untouched);
+// /api/generate uses a flat "prompt" string plus an optional top-level "system" string.
+func (p *UniversalProvider) applyOllamaPIITransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	// messages[].content and the top-level system are handled by the OpenAI walk
+	body, err := p.applyPIITransform(ctx, body, config)
+	if err != nil {
+		return nil, err
+	}
+
+	prompt := gjson.GetBytes(body, "prompt")
+	if prompt.Type != gjson.String {
+		return body, nil
+	}
+	if masked := p.mask(ctx, prompt.String(), config); masked != prompt.String() {
+		return sjson.SetBytes(body, "prompt", masked)
+	}
+	return body, nil
+}
+
+// applyGeminiPIITransform redacts PII from a Google Gemini request body using bidirectional tokenization
+// Gemini format:
+//
+//	{
+//	  "systemInstruction": {"parts": [{"text": "..."}]},  // optional
+//	  "contents": [
+//	    {
+//	      "role": "user",
+//	      "parts": [
+//	        {"text": "..."},
+//	        {"inlineData": {...}}
+//	      ]
+//	    }
+//	  ]
+//	}
+func (p *UniversalProvider) applyGeminiPIITransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	root, err := sonic.Get(body)
+	if err != nil {
+		return body, nil // Return original if parse fails
+	}
+
+	// 1. System instruction (skipped with skip_system, like the other PII transforms)
+	if config["skip_system"] != "true" {
+		p.maskGeminiParts(ctx, root.GetByPath("systemInstruction", "parts"), config)
+	}
+
+	// 2. Conversation turns
+	contentsNode := root.Get("contents")
+	if err := contentsNode.Check(); err == nil && contentsNode.Type() == ast.V_ARRAY {
+		for i := 0; ; i++ {
+			turnNode := contentsNode.Index(i)
+			if err := turnNode.Check(); err != nil {
+				break
+			}
+			p.maskGeminiParts(ctx, turnNode.Get("parts"), config)
+		}
+	}
+
+	return root.MarshalJSON()
+}
+
+// maskGeminiParts masks the "text" field of every part in a Gemini parts array.
+// Parts without text (inline data, function calls, ...) are left untouched.
+func (p *UniversalProvider) maskGeminiParts(ctx *core.AIGisContext, partsNode *ast.Node, config map[string]string) {
+	if err := partsNode.Check(); err != nil || partsNode.Type() != ast.V_ARRAY {
+		return
+	}
+
+	for i := 0; ; i++ {
+		partNode := partsNode.Index(i)
+		if err := partNode.Check(); err != nil {
+			break
+		}
+		textStr, err := partNode.Get("text").String()
+		if err != nil {
+			continue
+		}
+		if redactedText := p.mask(ctx, textStr, config); redactedText != textStr {
+			partNode.Set("text", ast.NewString(redactedText))
+		}
+	}
+}
+
+// applyDeepPIITransform masks every string value in the body, not just message content, so
+// secrets in tool definitions, metadata or other fields do not leak (defense in depth).
+// It is selected with scan_all: "true" on any PII step and replaces the format-specific walk;
+// skip_system does not apply, use exclude_paths instead.
+// Config:
+//
+//	scan_all:      "true"
+//	exclude_paths: "model,messages.#.role"  // comma-separated dotted paths ("#" = any array index); subtrees are skipped
+func (p *UniversalProvider) applyDeepPIITransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	root, err := sonic.Get(body)
+	if err != nil {
+		return body, nil // Return original if parse fails
+	}
+
+	var exclude [][]string
+	for _, path := range splitList(config["exclude_paths"]) {
+		exclude = append(exclude, strings.Split(path, "."))
+	}
+	p.maskTree(ctx, &root, nil, exclude, config)
+
+	return root.MarshalJSON()
+}
+
+// maskTree masks the string values under node in place, skipping excluded paths
+func (p *UniversalProvider) maskTree(ctx *core.AIGisContext, node *ast.Node, path []string, exclude [][]string, config map[string]string) {
+	for _, excluded := range exclude {
+		if pathMatches(path, excluded) {
+			return
+		}
+	}
+
+	switch node.Type() {
+	case ast.V_STRING:
+		str, err := node.String()
+		if err != nil {
+			return
+		}
+		if masked := p.mask(ctx, str, config); masked != str {
+			*node = ast.NewString(masked)
+		}
+	case ast.V_ARRAY, ast.V_OBJECT:
+		node.ForEach(func(seq ast.Sequence, child *ast.Node) bool {
+			segment := strconv.Itoa(seq.Index)
+			if seq.Key != nil {
+				segment = *seq.Key
+			}
+			p.maskTree(ctx, child, append(path[:len(path):len(path)], segment), exclude, config)
+			return true
+		})
+	}
+}
+
+// pathMatches reports whether path equals pattern, where a "#" pattern segment matches any array index
+func pathMatches(path, pattern []string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i, segment := range pattern {
+		if segment == "#" {
+			if _, err := strconv.Atoi(path[i]); err == nil {
+				continue
+			}
+		}
+		if segment != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// maskTextBlocks masks the text payload of the blocks in a content array: the "text" field of
+// "text" and "input_text" blocks, and the content of "tool_result" blocks, which is either a
+// string or a nested block array. Other blocks (images, tool_use, ...) are left untouched.
+func (p *UniversalProvider) maskTextBlocks(ctx *core.AIGisContext, contentNode *ast.Node, config map[string]string) {
+	p.rewriteTextBlocks(contentNode, func(text string) string {
+		return p.mask(ctx, text, config)
+	})
+}
+
+// unmaskTextBlocks restores placeholders in the same block types maskTextBlocks masks, and in
+// the input of "tool_use" blocks, where the model may echo masked values. With redact, the
+// text is redacted first (see restoreText).
+func (p *UniversalProvider) unmaskTextBlocks(ctx *core.AIGisContext, contentNode *ast.Node, redact bool) {
+	p.rewriteTextBlocks(contentNode, func(text string) string {
+		return p.restoreText(ctx, text, redact)
+	})
+
+	blockIdx := 0
+	for {
+		blockNode := contentNode.Index(blockIdx)
+		if err := blockNode.Check(); err != nil {
+			break
+		}
+		if typeStr, _ := blockNode.Get("type").String(); typeStr == "tool_use" {
+			if inputNode := blockNode.Get("input"); inputNode.Check() == nil {
+				p.unmaskJSONStrings(ctx, inputNode)
+			}
+		}
+		blockIdx++
+	}
+}
+
+// rewriteTextBlocks applies rewrite to the text payload of each text-carrying block
+func (p *UniversalProvider) rewriteTextBlocks(contentNode *ast.Node, rewrite func(string) string) {
+	blockIdx := 0
+	for {
+		blockNode := contentNode.Index(blockIdx)
+		if err := blockNode.Check(); err != nil {
+			break
+		}
+
+		typeStr, _ := blockNode.Get("type").String()
+		switch typeStr {
+		case "text", "input_text":
+			if textStr, err := blockNode.Get("text").String(); err == nil {
+				if rewritten := rewrite(textStr); rewritten != textStr {
+					blockNode.Set("text", ast.NewString(rewritten))
+				}
+			}
+		case "tool_result":
+			// Tool results carry either a plain string or their own array of blocks
+			resultNode := blockNode.Get("content")
+			switch resultNode.TypeSafe() {
+			case ast.V_STRING:
+				if resultStr, err := resultNode.String(); err == nil {
+					if rewritten := rewrite(resultStr); rewritten != resultStr {
+						blockNode.Set("content", ast.NewString(rewritten))
+					}
+				}
+			case ast.V_ARRAY:
+				p.rewriteTextBlocks(resultNode, rewrite)
+			}
+		}
+
+		blockIdx++
+	}
+}
+
 // applyFieldMapTransform maps fields from source to target using gjson/sjson
 func (p *UniversalProvider) applyFieldMapTransform(body []byte, config map[string]string) ([]byte, error) {
 	result := body
@@ -383,7 +1346,8 @@ func (p *UniversalProvider) applyFieldMapTransform(body []byte, config map[strin
 		if value.Type == gjson.String {
 			result, err = sjson.SetBytes(result, targetPath, value.String())
 		} else if value.Type == gjson.Number {
-			result, err = sjson.SetBytes(result, targetPath, value.Float())
+			// Copy the number verbatim: integers stay integers and nothing is reformatted (e.g. as 1e+06)
+			result, err = sjson.SetRawBytes(result, targetPath, []byte(value.Raw))
 		} else if value.Type == gjson.True || value.Type == gjson.False {
 			result, err = sjson.SetBytes(result, targetPath, value.Bool())
 		} else {
@@ -399,7 +1363,76 @@ func (p *UniversalProvider) applyFieldMapTransform(body []byte, config map[strin
 	return result, nil
 }
 
-// applyTemplateTransform transforms the body using Go text/template
+// templateFuncs are the helper functions available in template transforms, in addition to
+// the text/template builtins (index, len, printf, ...):
+//
+//	toJson   {{toJson .messages}}             JSON-encode a value (use it for strings too, so they are quoted and escaped)
+//	default  {{.user | default "anonymous"}}  the fallback when the value is missing or empty
+//	upper, lower, trim                        string case and whitespace
+//	replace  {{replace "old" "new" .s}}       replace all occurrences
+//	join     {{join "," .stop}}               join list items with a separator
+//	now      {{now}}                          current time
+//	date     {{date "2006-01-02" now}}        format a time (or Unix seconds) with a Go layout
+var templateFuncs = template.FuncMap{
+	"toJson": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(fallback, v any) any {
+		if isEmptyValue(v) {
+			return fallback
+		}
+		return v
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"join": func(sep string, items []any) string {
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = fmt.Sprint(item)
+		}
+		return strings.Join(parts, sep)
+	},
+	"now": time.Now,
+	"date": func(layout string, v any) (string, error) {
+		switch t := v.(type) {
+		case time.Time:
+			return t.Format(layout), nil
+		case float64: // JSON numbers
+			return time.Unix(int64(t), 0).UTC().Format(layout), nil
+		case int64:
+			return time.Unix(t, 0).UTC().Format(layout), nil
+		}
+		return "", fmt.Errorf("date: unsupported value %v", v)
+	},
+}
+
+// isEmptyValue reports whether a template value is missing or empty (nil, "", 0, false, empty list or object)
+func isEmptyValue(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case bool:
+		return !t
+	case float64:
+		return t == 0
+	case int:
+		return t == 0
+	case []any:
+		return len(t) == 0
+	case map[string]any:
+		return len(t) == 0
+	}
+	return false
+}
+
+// applyTemplateTransform transforms the body using Go text/template with the templateFuncs helpers
 func (p *UniversalProvider) applyTemplateTransform(body []byte, config map[string]string) ([]byte, error) {
 	tmplStr := config["template"]
 	if tmplStr == "" {
@@ -409,11 +1442,11 @@ func (p *UniversalProvider) applyTemplateTransform(body []byte, config map[strin
 	// Parse input JSON to map for template data
 	var data map[string]interface{}
 	if err := sonic.Unmarshal(body, &data); err != nil {
-		return nil, fmt.Errorf("failed to parse body for template: %w", err)
+		return nil, core.NewValidationError("request body is not valid JSON", err)
 	}
 
 	// Parse and execute template
-	tmpl, err := template.New("transform").Parse(tmplStr)
+	tmpl, err := template.New("transform").Funcs(templateFuncs).Parse(tmplStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid template: %w", err)
 	}
@@ -432,9 +1465,318 @@ func (p *UniversalProvider) applyTemplateTransform(body []byte, config map[strin
 	return result, nil
 }
 
+// resolvePath returns the concrete paths and values a gjson path selects in body.
+// Array wildcard queries ("#") expand to one entry per element; missing paths return none.
+func resolvePath(body []byte, path string) ([]string, []gjson.Result, error) {
+	value := gjson.GetBytes(body, path)
+	if !value.Exists() {
+		return nil, nil, nil
+	}
+	if !strings.Contains(path, "#") || !value.IsArray() {
+		return []string{path}, []gjson.Result{value}, nil
+	}
+
+	targets := value.Paths(string(body))
+	values := value.Array()
+	if len(targets) != len(values) {
+		return nil, nil, fmt.Errorf("cannot resolve concrete paths for %s", path)
+	}
+	return targets, values, nil
+}
+
+// applyClampParamTransform caps the number at path to a ceiling to bound cost: values above
+// the ceiling, missing values and non-numbers are set to the ceiling.
+// Config:
+//
+//	path: "max_tokens"  // gjson path (default "max_tokens")
+//	max:  "1024"        // ceiling
+func (p *UniversalProvider) applyClampParamTransform(body []byte, config map[string]string) ([]byte, error) {
+	path := config["path"]
+	if path == "" {
+		path = "max_tokens"
+	}
+	ceiling, err := strconv.ParseFloat(config["max"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s max %q", engine.TransformTypeClampParam, config["max"])
+	}
+
+	value := gjson.GetBytes(body, path)
+	if value.Type == gjson.Number && value.Float() <= ceiling {
+		return body, nil
+	}
+	return sjson.SetRawBytes(body, path, []byte(strconv.FormatFloat(ceiling, 'f', -1, 64)))
+}
+
+// applyModelAliasTransform rewrites the top-level "model" to the upstream's name when it has
+// an alias; other models are left unchanged. Routing still matches on the client's name, and
+// response_rewrite.model restores it in the response.
+// Config (client model: upstream model):
+//
+//	"gpt-4": "gpt-4-0613"
+func (p *UniversalProvider) applyModelAliasTransform(body []byte, config map[string]string) ([]byte, error) {
+	model := gjson.GetBytes(body, "model")
+	if model.Type != gjson.String {
+		return body, nil
+	}
+	alias, ok := config[model.String()]
+	if !ok || alias == model.String() {
+		return body, nil
+	}
+	return sjson.SetBytes(body, "model", alias)
+}
+
+// applyFieldDeleteTransform removes the fields at the configured gjson paths, e.g. client-only
+// metadata or parameters the upstream rejects. Paths that do not exist are ignored.
+// Config:
+//
+//	paths: "metadata,logprobs,tools.#.function.strict"  // comma-separated gjson paths, "#" expands arrays
+func (p *UniversalProvider) applyFieldDeleteTransform(body []byte, config map[string]string) ([]byte, error) {
+	result := body
+	for _, path := range splitList(config["paths"]) {
+		targets, _, err := resolvePath(result, path)
+		if err != nil {
+			return nil, err
+		}
+		// Delete from the end so earlier array indexes stay valid
+		for i := len(targets) - 1; i >= 0; i-- {
+			if result, err = sjson.DeleteBytes(result, targets[i]); err != nil {
+				return nil, fmt.Errorf("failed to delete %s: %w", targets[i], err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// applyRegexReplaceTransform runs a regex substitution over the string value(s) at a gjson path.
+// The pattern is compiled by NewEngine; use inline flags such as (?s) or (?m) for multiline text.
+// Config:
+//
+//	path:        "messages.#.content"  // gjson path, "#" expands arrays; non-strings are skipped
+//	pattern:     "(?s)```.*?```"       // Go regexp syntax
+//	replacement: ""                    // may reference groups ($1, ${name})
+func (p *UniversalProvider) applyRegexReplaceTransform(body []byte, step engine.TransformStep) ([]byte, error) {
+	pattern, err := step.RegexPattern()
+	if err != nil {
+		return nil, err
+	}
+	targets, values, err := resolvePath(body, step.Config["path"])
+	if err != nil {
+		return nil, err
+	}
+
+	result := body
+	for i, target := range targets {
+		if values[i].Type != gjson.String {
+			continue
+		}
+		original := values[i].String()
+		replaced := pattern.ReplaceAllString(original, step.Config["replacement"])
+		if replaced == original {
+			continue
+		}
+		if result, err = sjson.SetBytes(result, target, replaced); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", target, err)
+		}
+	}
+	return result, nil
+}
+
+// applyRedactPathsTransform redacts string values at the configured gjson paths
+// Config:
+//
+//	paths: "user,messages.#.content"  // comma-separated gjson paths, "#" expands arrays
+//	mode:  "token" (default) or "mask" (vault placeholder, restored on response)
+//	token: "[REDACTED]"               // replacement for token mode
+func (p *UniversalProvider) applyRedactPathsTransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	token := config["token"]
+	if token == "" {
+		token = "[REDACTED]"
+	}
+	mode := config["mode"]
+
+	result := body
+	for _, path := range splitList(config["paths"]) {
+		targets, values, err := resolvePath(result, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, target := range targets {
+			if values[i].Type != gjson.String {
+				continue
+			}
+			replacement := token
+			if mode == "mask" {
+				replacement = p.scanner.Tokenize(ctx, values[i].String())
+			}
+
+			var err error
+			result, err = sjson.SetBytes(result, target, replacement)
+			if err != nil {
+				return nil, fmt.Errorf("failed to redact %s: %w", target, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// applyInjectIDsTransform writes the gateway's trace and request ids into the body,
+// for upstreams that log ids from the payload rather than headers
+// Config:
+//
+//	trace_id_path:   "metadata.trace_id"   // sjson path for ctx.TraceID
+//	request_id_path: "metadata.request_id" // sjson path for ctx.RequestID
+func (p *UniversalProvider) applyInjectIDsTransform(ctx *core.AIGisContext, body []byte, config map[string]string) ([]byte, error) {
+	result := body
+	for _, field := range []struct{ path, value string }{
+		{config["trace_id_path"], ctx.TraceID},
+		{config["request_id_path"], ctx.RequestID},
+	} {
+		if field.path == "" || field.value == "" {
+			continue
+		}
+		var err error
+		result, err = sjson.SetBytes(result, field.path, field.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inject id at %s: %w", field.path, err)
+		}
+	}
+	return result, nil
+}
+
+// serializeBody re-encodes a JSON body in the given format. Transforms re-marshal through
+// sonic, whose key order is not guaranteed; "stable" sorts object keys so identical requests
+// produce identical bytes. Bodies that are not valid JSON are returned unchanged.
+func serializeBody(body []byte, format string) []byte {
+	if format == engine.BodyFormatStable {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber() // keep numbers exactly as sent
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return body
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			return body
+		}
+		return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// applyMergeSystemTransform removes system-role messages and prepends their text to the
+// first user message, for upstreams that do not support system messages. If there is no
+// user message, one is created at the start of the conversation.
+// Config:
+//
+//	separator: "\n\n" // placed between the system text and the user content (default)
+func (p *UniversalProvider) applyMergeSystemTransform(body []byte, config map[string]string) ([]byte, error) {
+	messages := gjson.GetBytes(body, "messages")
+	if !messages.IsArray() {
+		return body, nil
+	}
+	separator, ok := config["separator"]
+	if !ok {
+		separator = "\n\n"
+	}
+
+	var systemTexts []string
+	var remaining []string
+	for _, msg := range messages.Array() {
+		if msg.Get("role").String() == "system" {
+			if text := messageText(msg.Get("content")); text != "" {
+				systemTexts = append(systemTexts, text)
+			}
+			continue
+		}
+		remaining = append(remaining, msg.Raw)
+	}
+	if len(remaining) == len(messages.Array()) {
+		return body, nil // no system messages
+	}
+	systemText := strings.Join(systemTexts, separator)
+
+	merged := false
+	for i, raw := range remaining {
+		if merged || systemText == "" || gjson.Get(raw, "role").String() != "user" {
+			continue
+		}
+		content := gjson.Get(raw, "content")
+		var err error
+		if content.IsArray() {
+			// Content blocks: prepend a text block
+			block, _ := sjson.Set(`{"type":"text"}`, "text", systemText)
+			blocks := append([]string{block}, rawItems(content)...)
+			remaining[i], err = sjson.SetRaw(raw, "content", "["+strings.Join(blocks, ",")+"]")
+		} else {
+			remaining[i], err = sjson.Set(raw, "content", systemText+separator+content.String())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge system prompt: %w", err)
+		}
+		merged = true
+	}
+
+	if !merged && systemText != "" {
+		userMsg, _ := sjson.Set(`{"role":"user"}`, "content", systemText)
+		remaining = append([]string{userMsg}, remaining...)
+	}
+
+	return sjson.SetRawBytes(body, "messages", []byte("["+strings.Join(remaining, ",")+"]"))
+}
+
+// messageText returns the text of a message content: the string itself, or the
+// newline-joined text of its text blocks
+func messageText(content gjson.Result) string {
+	if !content.IsArray() {
+		return content.String()
+	}
+	var texts []string
+	for _, block := range content.Array() {
+		if block.Get("type").String() == "text" {
+			texts = append(texts, block.Get("text").String())
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// rawItems returns the raw JSON of each element of an array result
+func rawItems(array gjson.Result) []string {
+	var items []string
+	for _, item := range array.Array() {
+		items = append(items, item.Raw)
+	}
+	return items
+}
+
+// splitList splits a comma-separated config value, trimming blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // applyResponseTransforms unmask placeholders in the response body
-// This restores the original secrets from the vault, only in content fields
+// This restores the original secrets from the vault, only in content fields and tool call arguments
+// (OpenAI, Claude, Ollama native and Gemini response shapes)
 func (p *UniversalProvider) applyResponseTransforms(ctx *core.AIGisContext, body []byte) ([]byte, error) {
+	// Embedding vectors carry no text; skip parsing the (often large) body
+	if p.embeddings {
+		return p.rewriteResponseIdentity(ctx, body)
+	}
+
 	// Parse the response body
 	root, err := sonic.Get(body)
 	if err != nil {
@@ -445,6 +1787,7 @@ func (p *UniversalProvider) applyResponseTransforms(ctx *core.AIGisContext, body
 
 	// 1. OpenAI format: choices[].message.content
 	choicesNode := root.Get("choices")
+	redact := p.redactsResponse()
 	if err := choicesNode.Check(); err == nil && choicesNode.Type() == ast.V_ARRAY {
 		i := 0
 		for {
@@ -460,78 +1803,414 @@ func (p *UniversalProvider) applyResponseTransforms(ctx *core.AIGisContext, body
 			}
 
 			contentNode := messageNode.Get("content")
-			if err := contentNode.Check(); err != nil {
-				i++
-				continue
-			}
-
-			if contentNode.Type() == ast.V_STRING {
+			if err := contentNode.Check(); err == nil && contentNode.Type() == ast.V_STRING {
 				if contentStr, err := contentNode.String(); err == nil {
 					// Unmask placeholders in content
-					unmaskedContent := p.scanner.Unmask(ctx, contentStr)
+					unmaskedContent := p.restoreText(ctx, contentStr, redact)
 					if unmaskedContent != contentStr {
 						messageNode.Set("content", ast.NewString(unmaskedContent))
 					}
 				}
 			}
 
+			// Tool calls may echo masked data in their arguments (content is usually null then)
+			p.unmaskToolCalls(ctx, messageNode.Get("tool_calls"))
+
 			i++
 		}
 	}
 
-	// 2. Claude format: content[].text (array of blocks)
+	// 2. Claude format: content[] blocks (text, tool_result, tool_use input)
 	contentNode := root.Get("content")
 	if err := contentNode.Check(); err == nil && contentNode.Type() == ast.V_ARRAY {
-		i := 0
-		for {
-			blockNode := contentNode.Index(i)
-			if err := blockNode.Check(); err != nil {
+		p.unmaskTextBlocks(ctx, contentNode, redact)
+	}
+
+	// 3. Ollama /api/chat: message.content; /api/generate: response
+	if messageNode := root.Get("message"); messageNode.Check() == nil {
+		if contentStr, err := messageNode.Get("content").String(); err == nil {
+			if unmaskedContent := p.restoreText(ctx, contentStr, redact); unmaskedContent != contentStr {
+				messageNode.Set("content", ast.NewString(unmaskedContent))
+			}
+		}
+		p.unmaskToolCalls(ctx, messageNode.Get("tool_calls"))
+	}
+	if responseStr, err := root.Get("response").String(); err == nil {
+		if unmaskedResponse := p.restoreText(ctx, responseStr, redact); unmaskedResponse != responseStr {
+			root.Set("response", ast.NewString(unmaskedResponse))
+		}
+	}
+
+	// 4. Gemini format: candidates[].content.parts[].text (and functionCall.args)
+	candidatesNode := root.Get("candidates")
+	if err := candidatesNode.Check(); err == nil && candidatesNode.Type() == ast.V_ARRAY {
+		for i := 0; ; i++ {
+			candidateNode := candidatesNode.Index(i)
+			if err := candidateNode.Check(); err != nil {
 				break
 			}
+			p.unmaskGeminiParts(ctx, candidateNode.GetByPath("content", "parts"), redact)
+		}
+	}
+
+	result, err := root.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
 
-			// Check if this is a text block
-			typeNode := blockNode.Get("type")
-			textNode := blockNode.Get("text")
+	// Routes that converted the request to Claude return the (unmasked) reply in OpenAI shape
+	if p.convertsToClaude() {
+		if result, err = convertClaudeResponse(result); err != nil {
+			return nil, err
+		}
+	}
+	return p.rewriteResponseIdentity(ctx, result)
+}
 
-			typeNodeErr := typeNode.Check()
-			textNodeErr := textNode.Check()
-			if typeNodeErr == nil && textNodeErr == nil {
-				typeStr, typeErr := typeNode.String()
-				textStr, textErr := textNode.String()
+// unmaskGeminiParts restores placeholders in the text and function call arguments of Gemini
+// parts. With redact, the text is redacted first (see restoreText).
+func (p *UniversalProvider) unmaskGeminiParts(ctx *core.AIGisContext, partsNode *ast.Node, redact bool) {
+	if err := partsNode.Check(); err != nil || partsNode.Type() != ast.V_ARRAY {
+		return
+	}
 
-				if typeErr == nil && textErr == nil && typeStr == "text" {
-					// Unmask placeholders in text
-					unmaskedText := p.scanner.Unmask(ctx, textStr)
-					if unmaskedText != textStr {
-						blockNode.Set("text", ast.NewString(unmaskedText))
-					}
-				}
+	for i := 0; ; i++ {
+		partNode := partsNode.Index(i)
+		if err := partNode.Check(); err != nil {
+			break
+		}
+		if textStr, err := partNode.Get("text").String(); err == nil {
+			if unmaskedText := p.restoreText(ctx, textStr, redact); unmaskedText != textStr {
+				partNode.Set("text", ast.NewString(unmaskedText))
 			}
+		}
+		// Unlike OpenAI, Gemini function call arguments are a JSON object rather than a string
+		if argsNode := partNode.GetByPath("functionCall", "args"); argsNode.Check() == nil {
+			p.unmaskJSONStrings(ctx, argsNode)
+		}
+	}
+}
 
-			i++
+// unmaskToolCalls restores placeholders in tool_calls[].function.arguments, which is a JSON
+// string in OpenAI responses and an object in Ollama responses
+func (p *UniversalProvider) unmaskToolCalls(ctx *core.AIGisContext, toolCallsNode *ast.Node) {
+	if err := toolCallsNode.Check(); err != nil || toolCallsNode.Type() != ast.V_ARRAY {
+		return
+	}
+
+	for i := 0; ; i++ {
+		functionNode := toolCallsNode.Index(i)
+		if err := functionNode.Check(); err != nil {
+			break
+		}
+		functionNode = functionNode.Get("function")
+		argsNode := functionNode.Get("arguments")
+		if err := argsNode.Check(); err != nil {
+			continue
+		}
+		if argsNode.Type() == ast.V_OBJECT {
+			p.unmaskJSONStrings(ctx, argsNode)
+			continue
+		}
+		if argsNode.Type() != ast.V_STRING {
+			continue
+		}
+		args, err := argsNode.String()
+		if err != nil {
+			continue
+		}
+		if unmasked := p.unmaskArguments(ctx, args); unmasked != args {
+			functionNode.Set("arguments", ast.NewString(unmasked))
 		}
 	}
+}
 
-	return root.MarshalJSON()
+// unmaskArguments restores placeholders in a tool call arguments string. When the arguments
+// are a JSON document only its string values are unmasked, so restored secrets containing
+// quotes or newlines are escaped and the arguments stay valid JSON.
+func (p *UniversalProvider) unmaskArguments(ctx *core.AIGisContext, args string) string {
+	if !gjson.Valid(args) {
+		return p.unmask(ctx, args)
+	}
+
+	root, err := sonic.GetFromString(args)
+	if err != nil || !p.unmaskJSONStrings(ctx, &root) {
+		return args
+	}
+	result, err := root.MarshalJSON()
+	if err != nil {
+		return args
+	}
+	return string(result)
+}
+
+// unmaskJSONStrings unmasks every string value under node in place and reports whether any changed
+func (p *UniversalProvider) unmaskJSONStrings(ctx *core.AIGisContext, node *ast.Node) bool {
+	switch node.Type() {
+	case ast.V_STRING:
+		str, err := node.String()
+		if err != nil {
+			return false
+		}
+		if unmasked := p.unmask(ctx, str); unmasked != str {
+			*node = ast.NewString(unmasked)
+			return true
+		}
+	case ast.V_ARRAY, ast.V_OBJECT:
+		changed := false
+		node.ForEach(func(_ ast.Sequence, child *ast.Node) bool {
+			if p.unmaskJSONStrings(ctx, child) {
+				changed = true
+			}
+			return true
+		})
+		return changed
+	}
+	return false
+}
+
+// rewriteResponseIdentity rewrites the response "model" back to the client-facing name and
+// the "id" to a gateway-traceable value, as configured by the route's response_rewrite
+func (p *UniversalProvider) rewriteResponseIdentity(ctx *core.AIGisContext, body []byte) ([]byte, error) {
+	rewrite := p.route.ResponseRewrite
+	result := body
+	var err error
+
+	if rewrite.Model && gjson.GetBytes(result, "model").Exists() {
+		if clientModel, ok := ctx.GetMetadata(core.MetaClientModel); ok {
+			if result, err = sjson.SetBytes(result, "model", clientModel); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if ctx.RequestID != "" {
+		upstreamID := gjson.GetBytes(result, "id").String()
+		switch rewrite.ID {
+		case engine.ResponseIDRequestID:
+			result, err = sjson.SetBytes(result, "id", ctx.RequestID)
+		case engine.ResponseIDPrefix:
+			result, err = sjson.SetBytes(result, "id", ctx.RequestID+"-"+upstreamID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// redactsResponse reports whether a PII step of the route sets redact_response: "true". The
+// response text (OpenAI choices[].message.content, Claude text blocks, Gemini text parts,
+// Ollama message.content and response, and text deltas of streams) is then scanned and
+// matches are replaced with the rule's [..._REDACTED] marker; placeholders from the request
+// are kept and unmasked as usual.
+func (p *UniversalProvider) redactsResponse() bool {
+	for _, step := range p.route.Transforms {
+		switch step.Type {
+		case engine.TransformTypePII, engine.TransformTypePIIClaude, engine.TransformTypePIIGemini, engine.TransformTypePIIOllama:
+			if step.Config["redact_response"] == "true" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// restoreText unmasks a response text. With redact, PII the model produced itself is
+// redacted first, so the secrets restored from the request are left alone.
+func (p *UniversalProvider) restoreText(ctx *core.AIGisContext, text string, redact bool) string {
+	if redact {
+		text = p.scanner.Redact(ctx, text)
+	}
+	return p.unmask(ctx, text)
 }
 
-// sendToUpstream sends the transformed request to the upstream service with header handling
-func (p *UniversalProvider) sendToUpstream(ctx context.Context, body []byte, originalHeaders http.Header) ([]byte, error) {
-	upstream := p.route.Upstream
+// unmask restores placeholders and records vault hit/miss statistics in metadata and metrics
+func (p *UniversalProvider) unmask(ctx *core.AIGisContext, input string) string {
+	result, stats := p.scanner.UnmaskWithStats(ctx, input)
+	p.recordUnmaskStats(ctx, stats)
+	return result
+}
+
+// recordUnmaskStats adds unmask statistics to the request metadata and metrics, and warns
+// about placeholders that could not be restored
+func (p *UniversalProvider) recordUnmaskStats(ctx *core.AIGisContext, stats security.UnmaskStats) {
+	if stats.Found > 0 {
+		ctx.IncrMetadata(core.MetaUnmaskHits, stats.Restored)
+		ctx.IncrMetadata(core.MetaUnmaskMisses, stats.Missed)
+		metrics.UnmaskTotal.WithLabelValues(metrics.UnmaskHit).Add(float64(stats.Restored))
+		metrics.UnmaskTotal.WithLabelValues(metrics.UnmaskMiss).Add(float64(stats.Missed))
+		metrics.SecretsTotal.WithLabelValues(metrics.SecretsUnmasked).Add(float64(stats.Restored))
+	}
+	if stats.Missed > 0 {
+		p.log.Warn("Unmask left placeholders unresolved",
+			zap.Int("found", stats.Found),
+			zap.Int("missed", stats.Missed),
+		)
+	}
+}
+
+// upstreamResponse holds the raw result of an upstream call
+type upstreamResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Latency    time.Duration
+}
+
+// sendToUpstream sends the transformed request to the route's upstream with header handling.
+// If the upstream fails with a 5xx status or a connection error and the route has a fallback,
+// the fallback's result is returned instead.
+// For non-200 statuses both the response and an error are returned.
+func (p *UniversalProvider) sendToUpstream(ctx context.Context, body []byte, originalHeaders http.Header) (*upstreamResponse, error) {
+	resp, err := p.callUpstream(ctx, p.upstream, p.client, body, originalHeaders)
+	if err == nil || p.route.Fallback == nil || ctx.Err() != nil || !shouldFallback(resp) {
+		return resp, err
+	}
+
+	p.log.Warn("Upstream failed, trying fallback",
+		zap.String("route_id", p.route.ID),
+		zap.String("upstream", p.upstream.BaseURL),
+		zap.String("fallback", p.route.Fallback.BaseURL),
+		zap.Error(err),
+	)
+	return p.callUpstream(ctx, *p.route.Fallback, p.route.FallbackClient(), body, originalHeaders)
+}
+
+// recordUpstreamError counts a failed upstream attempt: by HTTP status when the upstream
+// responded, otherwise by error category. Gateway-side failures (e.g. a misconfigured
+// upstream) never reached the upstream and are not counted.
+func (p *UniversalProvider) recordUpstreamError(status int, err error) {
+	reason := strconv.Itoa(status)
+	if status == 0 {
+		category := core.AsGatewayError(err).Category
+		if category != core.ErrCategoryUpstream && category != core.ErrCategoryTimeout {
+			return
+		}
+		reason = string(category)
+	}
+	metrics.UpstreamErrorsTotal.WithLabelValues(p.route.ID, reason).Inc()
+}
+
+// shouldFallback reports whether a failed upstream call warrants trying the fallback:
+// connection errors and timeouts (no response) or 5xx responses. 4xx responses are the
+// client's fault and would fail on the fallback too.
+func shouldFallback(resp *upstreamResponse) bool {
+	return resp == nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// callUpstream performs a request against the given upstream and maps non-200 statuses to errors.
+// For non-200 statuses both the response and an error are returned. Connection failures and
+// 429/502/503 responses are retried up to upstream.MaxRetries times with jittered backoff.
+func (p *UniversalProvider) callUpstream(ctx context.Context, upstream engine.Upstream, client *http.Client, body []byte, originalHeaders http.Header) (*upstreamResponse, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := p.doUpstream(ctx, upstream, client, body, originalHeaders)
+		if err == nil && resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		// Handle HTTP errors
+		if err == nil {
+			p.recordUpstreamError(resp.StatusCode, nil)
+			err = p.handleHTTPError(resp.StatusCode, resp.Header, resp.Body)
+		} else {
+			p.recordUpstreamError(0, err)
+		}
+
+		if attempt >= upstream.MaxRetries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		delay := retryDelay(time.Duration(upstream.BackoffMs)*time.Millisecond, attempt)
+		p.log.Warn("Retrying upstream request",
+			zap.String("route_id", p.route.ID),
+			zap.String("upstream", upstream.BaseURL),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", delay),
+			zap.Error(err),
+		)
+		if !waitRetry(ctx, delay) {
+			return resp, err
+		}
+	}
+}
+
+// doUpstream performs a single request against the given upstream and reads the full response,
+// recording it as a client span
+func (p *UniversalProvider) doUpstream(ctx context.Context, upstream engine.Upstream, client *http.Client, body []byte, originalHeaders http.Header) (*upstreamResponse, error) {
+	ctx, span := p.startUpstreamSpan(ctx, upstream)
+	resp, err := p.roundTrip(ctx, upstream, client, body, originalHeaders)
+	if resp != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	}
+	tracing.RecordError(span, err)
+	span.End()
+	return resp, err
+}
+
+// startUpstreamSpan starts the client span for one upstream attempt
+func (p *UniversalProvider) startUpstreamSpan(ctx context.Context, upstream engine.Upstream) (context.Context, tracing.Span) {
+	return tracing.Start(ctx, "upstream.request", tracing.KindClient,
+		tracing.String("aigis.route_id", p.route.ID),
+		tracing.String("server.address", upstream.BaseURL),
+	)
+}
+
+// roundTrip sends the request and reads the full response
+func (p *UniversalProvider) roundTrip(ctx context.Context, upstream engine.Upstream, client *http.Client, body []byte, originalHeaders http.Header) (*upstreamResponse, error) {
+	httpReq, err := p.newUpstreamRequest(ctx, upstream, body, originalHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute request
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, core.NewTimeoutError("upstream request timed out", err)
+		}
+		return nil, core.NewUpstreamError("failed to send upstream request", err)
+	}
+	defer resp.Body.Close()
+	dropOAuth2Token(upstream, httpReq, resp.StatusCode)
+
+	// Read response
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if isTimeout(err) {
+			return nil, core.NewTimeoutError("upstream response timed out", err)
+		}
+		return nil, core.NewUpstreamError("failed to read upstream response", err)
+	}
+
+	return &upstreamResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       respBody,
+		Latency:    time.Since(start),
+	}, nil
+}
 
-	// Build base URL (support env:VAR syntax)
-	baseURL := upstream.BaseURL
-	if len(baseURL) >= 4 && baseURL[:4] == "env:" {
-		envVar := baseURL[4:]
-		baseURL = os.Getenv(envVar)
+// newUpstreamRequest builds the HTTP request for an upstream: URL, auth and header policy
+func (p *UniversalProvider) newUpstreamRequest(ctx context.Context, upstream engine.Upstream, body []byte, originalHeaders http.Header) (*http.Request, error) {
+	// Expand env:VAR references in all upstream fields
+	upstream, err := upstream.Resolve()
+	if err != nil {
+		return nil, core.NewInternalError("upstream is misconfigured", err)
 	}
 
 	// Build URL
 	path := upstream.Path
-	if path == "" {
+	if p.embeddings {
+		path = upstream.EmbeddingsPath
+		if path == "" {
+			path = "/embeddings"
+		}
+	} else if path == "" {
 		path = "/chat/completions" // Default for OpenAI compatibility
 	}
-	url := baseURL + path
+	url := upstream.BaseURL + path
 
 	// Handle query params for AuthStrategyQuery
 	if upstream.AuthStrategy == engine.AuthStrategyQuery {
@@ -540,7 +2219,11 @@ func (p *UniversalProvider) sendToUpstream(ctx context.Context, body []byte, ori
 			// Parse URL and add query param
 			if reqURL, err := http.NewRequest(http.MethodPost, url, nil); err == nil {
 				q := reqURL.URL.Query()
-				q.Set("api_key", token) // Common query param name
+				param := upstream.QueryParam
+				if param == "" {
+					param = "api_key" // Common query param name
+				}
+				q.Set(param, token)
 				reqURL.URL.RawQuery = q.Encode()
 				url = reqURL.URL.String()
 			}
@@ -550,11 +2233,20 @@ func (p *UniversalProvider) sendToUpstream(ctx context.Context, body []byte, ori
 	// Create request
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, core.NewInternalError("failed to create upstream request", err)
+	}
+
+	// Transforms change the body size; always send the exact length of the final body
+	// rather than falling back to chunked transfer encoding
+	httpReq.ContentLength = int64(len(body))
+
+	// Override the Host header (e.g., shared IP or CDN fronting)
+	if upstream.Host != "" {
+		httpReq.Host = upstream.Host
 	}
 
 	// Build auth headers
-	authHeaders := p.buildAuthHeaders()
+	authHeaders := p.buildAuthHeaders(upstream, originalHeaders)
 
 	// Build all upstream headers using HeaderPolicy
 	upstreamHeaders := p.buildUpstreamHeaders(originalHeaders, authHeaders)
@@ -566,32 +2258,130 @@ func (p *UniversalProvider) sendToUpstream(ctx context.Context, body []byte, ori
 		}
 	}
 
-	// Execute request
-	resp, err := p.client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+	// Propagate the trace so the upstream's spans join it
+	tracing.Inject(ctx, httpReq.Header)
+
+	if upstream.AuthStrategy == engine.AuthStrategyOAuth2 {
+		token, err := oauth2Token(ctx, upstream)
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+token)
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	respBody, err := io.ReadAll(resp.Body)
+	// The body signature covers the exact bytes sent (after transforms and serialization)
+	if upstream.SignatureSecretEnv != "" {
+		if err := signRequestBody(httpReq, body, upstream); err != nil {
+			return nil, err
+		}
+	}
+
+	// SigV4 covers the final URL, headers and body, so it is computed last (and again
+	// for every retry, keeping the signature timestamp fresh)
+	if upstream.AuthStrategy == engine.AuthStrategyAWSSigV4 {
+		if err := signAWSRequest(httpReq, body, upstream); err != nil {
+			return nil, err
+		}
+	}
+
+	return httpReq, nil
+}
+
+// sendShadow mirrors the request to the shadow upstream and logs how its response
+// compares to the primary. The shadow response is never returned to the client.
+// maxShadowRequests bounds the shadow calls in flight across all routes
+const maxShadowRequests = 32
+
+// shadowSlots holds one token per shadow call in flight
+var shadowSlots = make(chan struct{}, maxShadowRequests)
+
+// startShadow mirrors the request to the shadow upstream in the background. When
+// maxShadowRequests calls are already in flight (e.g. a slow shadow under load) the mirror is
+// dropped, so the shadow never holds goroutines and bodies for the primary path.
+func (p *UniversalProvider) startShadow(ctx context.Context, body []byte, originalHeaders http.Header, primary *upstreamResponse) {
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		p.log.Warn("Shadow request dropped: too many shadow requests in flight",
+			zap.String("route_id", p.route.ID),
+			zap.String("shadow", p.route.Shadow.BaseURL),
+			zap.Int("limit", maxShadowRequests),
+		)
+		return
+	}
+	go func() {
+		defer func() { <-shadowSlots }()
+		p.sendShadow(ctx, body, originalHeaders, primary)
+	}()
+}
+
+func (p *UniversalProvider) sendShadow(ctx context.Context, body []byte, originalHeaders http.Header, primary *upstreamResponse) {
+	shadow := *p.route.Shadow
+
+	// Detach from the client request so the shadow call outlives it; the shadow is a single
+	// attempt bounded by its own timeout_seconds
+	shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shadow.Timeout())
+	defer cancel()
+
+	resp, err := p.doUpstream(shadowCtx, shadow, p.route.ShadowClient(), body, originalHeaders)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		p.log.Warn("Shadow upstream failed",
+			zap.String("shadow", shadow.BaseURL),
+			zap.Error(err),
+		)
+		return
 	}
 
-	// Handle HTTP errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, p.handleHTTPError(resp.StatusCode, respBody)
+	fields := []zap.Field{
+		zap.String("shadow", shadow.BaseURL),
+		zap.Int("shadow_status", resp.StatusCode),
+		zap.Int64("shadow_latency_ms", resp.Latency.Milliseconds()),
+	}
+	if primary != nil {
+		fields = append(fields,
+			zap.Int("primary_status", primary.StatusCode),
+			zap.Int64("primary_latency_ms", primary.Latency.Milliseconds()),
+			zap.Float64("body_similarity", bodySimilarity(primary.Body, resp.Body)),
+		)
+	}
+	p.log.Info("Shadow comparison", fields...)
+}
+
+// bodySimilarity returns the Jaccard similarity of the whitespace-separated tokens of two bodies
+func bodySimilarity(a, b []byte) float64 {
+	tokensA := make(map[string]bool)
+	for _, token := range strings.Fields(string(a)) {
+		tokensA[token] = true
+	}
+	tokensB := make(map[string]bool)
+	for _, token := range strings.Fields(string(b)) {
+		tokensB[token] = true
+	}
+	if len(tokensA) == 0 && len(tokensB) == 0 {
+		return 1
 	}
 
-	return respBody, nil
+	shared := 0
+	for token := range tokensA {
+		if tokensB[token] {
+			shared++
+		}
+	}
+	union := len(tokensA) + len(tokensB) - shared
+	return float64(shared) / float64(union)
 }
 
 // handleHTTPError handles HTTP error responses
-func (p *UniversalProvider) handleHTTPError(statusCode int, body []byte) error {
-	root, err := sonic.Get(body)
+func (p *UniversalProvider) handleHTTPError(statusCode int, header http.Header, body []byte) error {
+	// Proxies in front of the upstream often answer with HTML or an empty body; keep a snippet
+	// in the logs since the client only gets the status
+	isJSON := isJSONResponse(header, body)
+	if !isJSON {
+		p.logNonJSONResponse(statusCode, header, body)
+	}
+
 	var errMsg string
-	if err == nil {
+	if root, err := sonic.Get(body); isJSON && err == nil {
 		// Try OpenAI format first
 		errMsg, _ = root.Get("error").Get("message").String()
 		if errMsg == "" {
@@ -599,18 +2389,66 @@ func (p *UniversalProvider) handleHTTPError(statusCode int, body []byte) error {
 			errMsg, _ = root.Get("message").String()
 		}
 	}
+	cause := &core.UpstreamStatusError{StatusCode: statusCode, Body: body}
+
+	// Client errors (bad request, auth, rate limits, ...) keep the upstream status so clients
+	// can fix or retry the request; upstream server failures are reported as 502
+	status := http.StatusBadGateway
+	if statusCode >= 400 && statusCode < 500 {
+		status = statusCode
+	}
+
 	if errMsg == "" {
-		return fmt.Errorf("HTTP %d: %s", statusCode, string(body))
+		message := fmt.Sprintf("upstream returned HTTP %d", statusCode)
+		if contentType := header.Get("Content-Type"); !isJSON && contentType != "" {
+			message += " with a non-JSON " + contentType + " body"
+		}
+		return core.NewGatewayError(core.ErrCategoryUpstream, status, message, cause)
 	}
 
 	switch statusCode {
 	case http.StatusUnauthorized:
-		return fmt.Errorf("unauthorized: %s", errMsg)
+		return core.NewGatewayError(core.ErrCategoryUpstream, status, fmt.Sprintf("unauthorized: %s", errMsg), cause)
 	case http.StatusTooManyRequests:
-		return fmt.Errorf("rate limit exceeded: %s", errMsg)
+		return core.NewGatewayError(core.ErrCategoryUpstream, status, fmt.Sprintf("rate limit exceeded: %s", errMsg), cause)
 	case http.StatusBadRequest:
-		return fmt.Errorf("bad request: %s", errMsg)
+		return core.NewGatewayError(core.ErrCategoryUpstream, status, fmt.Sprintf("bad request: %s", errMsg), cause)
 	default:
-		return fmt.Errorf("HTTP %d: %s", statusCode, errMsg)
+		return core.NewGatewayError(core.ErrCategoryUpstream, status, fmt.Sprintf("HTTP %d: %s", statusCode, errMsg), cause)
+	}
+}
+
+// isJSONResponse reports whether an upstream response carries JSON: a non-empty body with a
+// JSON media type (application/json or application/*+json), or one that parses as JSON
+// (some upstreams label JSON as text/plain)
+func isJSONResponse(header http.Header, body []byte) bool {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		if mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")) {
+			return true
+		}
+	}
+	return gjson.ValidBytes(body)
+}
+
+// logNonJSONResponse warns about an upstream response that is not JSON, with a body snippet
+func (p *UniversalProvider) logNonJSONResponse(statusCode int, header http.Header, body []byte) {
+	p.log.Warn("Upstream returned a non-JSON response",
+		zap.String("route_id", p.route.ID),
+		zap.Int("status", statusCode),
+		zap.String("content_type", header.Get("Content-Type")),
+		zap.Int("body_bytes", len(body)),
+		zap.String("body_snippet", truncateBody(body)),
+	)
+}
+
+// isTimeout reports whether err was caused by a deadline or network timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
 	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
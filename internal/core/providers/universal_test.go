@@ -0,0 +1,2500 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"aigis/internal/core"
+	"aigis/internal/core/engine"
+	"aigis/internal/pkg/logger"
+	"aigis/internal/pkg/metrics"
+	"aigis/internal/pkg/version"
+)
+
+// newTestContext creates a gateway context suitable for provider tests
+func newTestContext() *core.AIGisContext {
+	ctx := core.NewGatewayContext(context.Background(), zap.NewNop())
+	ctx.RequestID = "req_test"
+	ctx.TraceID = "trace-test"
+	return ctx
+}
+
+func TestBuildUpstreamHeadersGlobAllow(t *testing.T) {
+	route := &engine.Route{
+		ID: "glob",
+		HeaderPolicy: engine.HeaderPolicy{
+			Allow:  []string{"X-Custom-*", "anthropic-version"},
+			Remove: []string{"x-custom-internal-*"},
+		},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	original := make(http.Header)
+	original.Set("X-Custom-Tenant", "acme")
+	original.Set("x-custom-region", "eu")
+	original.Set("X-Custom-Internal-Debug", "1")
+	original.Set("X-Other", "nope")
+	original.Set("Anthropic-Version", "2023-06-01")
+
+	headers := p.buildUpstreamHeaders(original, nil)
+
+	if got := headers.Get("X-Custom-Tenant"); got != "acme" {
+		t.Errorf("X-Custom-Tenant = %q, want %q", got, "acme")
+	}
+	if got := headers.Get("X-Custom-Region"); got != "eu" {
+		t.Errorf("X-Custom-Region = %q, want %q", got, "eu")
+	}
+	if got := headers.Get("X-Custom-Internal-Debug"); got != "" {
+		t.Errorf("X-Custom-Internal-Debug should be removed by glob, got %q", got)
+	}
+	if got := headers.Get("X-Other"); got != "" {
+		t.Errorf("X-Other should not be forwarded, got %q", got)
+	}
+	if got := headers.Get("Anthropic-Version"); got != "2023-06-01" {
+		t.Errorf("Exact allow rule should still work, got %q", got)
+	}
+}
+
+func TestBuildUpstreamHeadersRename(t *testing.T) {
+	route := &engine.Route{
+		ID: "rename",
+		HeaderPolicy: engine.HeaderPolicy{
+			Allow: []string{"X-Api-Version", "X-Team"},
+			Rename: map[string]string{
+				"X-Api-Version": "anthropic-version",
+				"X-Team":        "OpenAI-Organization",
+				"X-Beta":        "anthropic-beta",
+				"X-Debug":       "X-Upstream-Debug",
+			},
+			Set:    map[string]string{"OpenAI-Organization": "org-forced"},
+			Remove: []string{"X-Upstream-*"},
+		},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	original := make(http.Header)
+	original.Set("X-Api-Version", "2023-06-01")
+	original.Set("X-Team", "team-a")
+	original.Set("X-Beta", "tools-2024-04-04")
+	original.Set("X-Debug", "1")
+
+	headers := p.buildUpstreamHeaders(original, nil)
+
+	if got := headers.Get("anthropic-version"); got != "2023-06-01" {
+		t.Errorf("anthropic-version = %q, want the renamed client value", got)
+	}
+	if got := headers.Get("X-Api-Version"); got != "" {
+		t.Errorf("Original header should be dropped, got %q", got)
+	}
+	if got := headers.Get("anthropic-beta"); got != "tools-2024-04-04" {
+		t.Errorf("Rename should forward headers not in Allow, got %q", got)
+	}
+	if got := headers.Get("OpenAI-Organization"); got != "org-forced" {
+		t.Errorf("Set on the target name should win over Rename, got %q", got)
+	}
+	if got := headers.Get("X-Upstream-Debug"); got != "" {
+		t.Errorf("Remove should apply to renamed headers, got %q", got)
+	}
+}
+
+func TestBuildUpstreamHeadersForwardClientIP(t *testing.T) {
+	route := &engine.Route{
+		ID:           "xff",
+		HeaderPolicy: engine.HeaderPolicy{ForwardClientIP: true, RealIP: true},
+	}
+	p := NewUniversalProvider(route, nil)
+	p.SetClientIP("203.0.113.7")
+
+	// No existing header: set
+	headers := p.buildUpstreamHeaders(http.Header{}, nil)
+	if got := headers.Get("X-Forwarded-For"); got != "203.0.113.7" {
+		t.Errorf("X-Forwarded-For = %q, want the client IP", got)
+	}
+	if got := headers.Get("X-Real-IP"); got != "203.0.113.7" {
+		t.Errorf("X-Real-IP = %q, want the client IP", got)
+	}
+
+	// Existing chain (possibly split over several header lines): append
+	original := http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.1", "10.0.0.2"}}
+	headers = p.buildUpstreamHeaders(original, nil)
+	if got := headers.Get("X-Forwarded-For"); got != "198.51.100.1, 10.0.0.1, 10.0.0.2, 203.0.113.7" {
+		t.Errorf("X-Forwarded-For = %q, want the client IP appended to the chain", got)
+	}
+	if got := headers.Values("X-Forwarded-For"); len(got) != 1 {
+		t.Errorf("X-Forwarded-For should be a single header, got %v", got)
+	}
+
+	// Disabled: the client's header is not forwarded unless allowed
+	p = NewUniversalProvider(&engine.Route{ID: "off"}, nil)
+	p.SetClientIP("203.0.113.7")
+	headers = p.buildUpstreamHeaders(original, nil)
+	if got := headers.Get("X-Forwarded-For"); got != "" {
+		t.Errorf("X-Forwarded-For should not be set without forward_client_ip, got %q", got)
+	}
+}
+
+func TestSendHostAndSNIOverride(t *testing.T) {
+	var gotHost, gotServerName string
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	upstream.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			gotServerName = hello.ServerName
+			return nil, nil
+		},
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID: "sni",
+		Upstream: engine.Upstream{
+			BaseURL: upstream.URL,
+			Path:    "/chat/completions",
+			Host:    "example.com",
+		},
+	}
+	p := NewUniversalProvider(route, nil)
+	// Trust the test server certificate (valid for example.com)
+	testTransport := upstream.Client().Transport.(*http.Transport)
+	p.client.Transport.(*http.Transport).TLSClientConfig.RootCAs = testTransport.TLSClientConfig.RootCAs
+
+	if _, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if gotHost != "example.com" {
+		t.Errorf("Host header = %q, want %q", gotHost, "example.com")
+	}
+	if gotServerName != "example.com" {
+		t.Errorf("TLS ServerName = %q, want %q", gotServerName, "example.com")
+	}
+}
+
+func TestRedactPathsTransform(t *testing.T) {
+	route := &engine.Route{ID: "redact"}
+	p := NewUniversalProvider(route, nil)
+	ctx := newTestContext()
+
+	body := []byte(`{"user":"alice","messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hello"}],"max_tokens":10}`)
+
+	// Token mode: fixed replacement, including an array wildcard
+	result, err := p.applyRedactPathsTransform(ctx, body, map[string]string{
+		"paths": "user, messages.#.content, missing.path",
+	})
+	if err != nil {
+		t.Fatalf("redact_paths failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "user").String(); got != "[REDACTED]" {
+		t.Errorf("user = %q, want [REDACTED]", got)
+	}
+	for i, content := range gjson.GetBytes(result, "messages.#.content").Array() {
+		if content.String() != "[REDACTED]" {
+			t.Errorf("messages.%d.content = %q, want [REDACTED]", i, content.String())
+		}
+	}
+	if gjson.GetBytes(result, "max_tokens").Int() != 10 {
+		t.Errorf("Unrelated fields should be untouched, got %s", result)
+	}
+
+	// Mask mode: values go through the vault and can be restored
+	result, err = p.applyRedactPathsTransform(ctx, body, map[string]string{
+		"paths": "messages.1.content",
+		"mode":  "mask",
+	})
+	if err != nil {
+		t.Fatalf("redact_paths mask mode failed: %v", err)
+	}
+	masked := gjson.GetBytes(result, "messages.1.content").String()
+	if !strings.HasPrefix(masked, "__AIGIS_SEC_") {
+		t.Fatalf("Masked value should be a placeholder, got %q", masked)
+	}
+	if got := p.scanner.Unmask(ctx, masked); got != "hello" {
+		t.Errorf("Unmask = %q, want %q", got, "hello")
+	}
+	if got := gjson.GetBytes(result, "messages.0.content").String(); got != "be nice" {
+		t.Errorf("Non-targeted message should be untouched, got %q", got)
+	}
+}
+
+func TestSendContentLengthMatchesTransformedBody(t *testing.T) {
+	var gotLength int64
+	var gotHeader string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		gotHeader = r.Header.Get("Content-Length")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:       "length",
+		Upstream: engine.Upstream{BaseURL: upstream.URL},
+		// Even if a client Content-Length is allowed through, it must not reach the upstream
+		HeaderPolicy: engine.HeaderPolicy{Allow: []string{"Content-Length"}},
+		Transforms:   []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"mail a@b.co"}]}`)
+	original := http.Header{}
+	original.Set("Content-Length", strconv.Itoa(len(body)))
+
+	if _, err := p.Send(newTestContext(), body, original); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if len(gotBody) == len(body) {
+		t.Fatalf("Masking should change the body size for this test to be meaningful")
+	}
+	if gotLength != int64(len(gotBody)) {
+		t.Errorf("ContentLength = %d, want %d", gotLength, len(gotBody))
+	}
+	if gotHeader != strconv.Itoa(len(gotBody)) {
+		t.Errorf("Content-Length header = %q, want %d", gotHeader, len(gotBody))
+	}
+}
+
+func TestSendShadowUpstream(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"from primary"}}]}`))
+	}))
+	defer primary.Close()
+
+	shadowBodies := make(chan []byte, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		shadowBodies <- body
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"from shadow"}}]}`))
+	}))
+	defer shadow.Close()
+
+	route := &engine.Route{
+		ID:       "shadowed",
+		Upstream: engine.Upstream{BaseURL: primary.URL},
+		Shadow:   &engine.Upstream{BaseURL: shadow.URL},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`)
+	resp, err := p.Send(newTestContext(), body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(string(resp), "from primary") || strings.Contains(string(resp), "from shadow") {
+		t.Errorf("Client must only receive the primary response, got %s", resp)
+	}
+
+	select {
+	case got := <-shadowBodies:
+		if string(got) != string(body) {
+			t.Errorf("Shadow should receive the same request body, got %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shadow upstream was not called")
+	}
+}
+
+func TestShadowHonorsShadowTimeout(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"from primary"}}]}`))
+	}))
+	defer primary.Close()
+
+	release := make(chan struct{})
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	observed, logs := observer.New(zap.WarnLevel)
+	route := &engine.Route{
+		ID:       "shadowed",
+		Upstream: engine.Upstream{BaseURL: primary.URL},
+		Shadow:   &engine.Upstream{BaseURL: shadow.URL, TimeoutSeconds: 1},
+	}
+	p := NewUniversalProvider(route, logger.NewLogger(zap.New(observed)))
+
+	if _, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4","messages":[]}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.FilterMessage("Shadow upstream failed").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Shadow call should give up after the shadow's timeout_seconds")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestShadowDroppedWhenSlotsFull(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"from primary"}}]}`))
+	}))
+	defer primary.Close()
+
+	var shadowCalls atomic.Int32
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowCalls.Add(1)
+	}))
+	defer shadow.Close()
+
+	// Occupy every shadow slot as if slow shadow calls were in flight
+	for range maxShadowRequests {
+		shadowSlots <- struct{}{}
+	}
+	defer func() {
+		for range maxShadowRequests {
+			<-shadowSlots
+		}
+	}()
+
+	observed, logs := observer.New(zap.WarnLevel)
+	route := &engine.Route{
+		ID:       "shadowed",
+		Upstream: engine.Upstream{BaseURL: primary.URL},
+		Shadow:   &engine.Upstream{BaseURL: shadow.URL},
+	}
+	p := NewUniversalProvider(route, logger.NewLogger(zap.New(observed)))
+
+	resp, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4","messages":[]}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !strings.Contains(string(resp), "from primary") {
+		t.Errorf("Primary response should be unaffected, got %s", resp)
+	}
+	if n := logs.FilterMessage("Shadow request dropped: too many shadow requests in flight").Len(); n != 1 {
+		t.Errorf("Expected 1 drop warning, got %d", n)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n := shadowCalls.Load(); n != 0 {
+		t.Errorf("Shadow should not be called while its slots are full, got %d calls", n)
+	}
+}
+
+func TestSendFanOutMergesChoices(t *testing.T) {
+	stub := func(content string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"id":"x","choices":[{"index":0,"message":{"role":"assistant","content":"` + content + `"}}]}`))
+		}))
+	}
+	first := stub("from first")
+	defer first.Close()
+	second := stub("from second")
+	defer second.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	route := &engine.Route{
+		ID:       "ensemble",
+		Upstream: engine.Upstream{BaseURL: first.URL},
+		FanOut:   []engine.Upstream{{BaseURL: second.URL}},
+	}
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"ensemble"}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	choices := gjson.GetBytes(resp, "choices").Array()
+	if len(choices) != 2 {
+		t.Fatalf("Expected 2 merged choices, got %s", resp)
+	}
+	if choices[0].Get("message.content").String() != "from first" || choices[1].Get("message.content").String() != "from second" {
+		t.Errorf("Unexpected merged choices: %s", resp)
+	}
+	if choices[1].Get("index").Int() != 1 {
+		t.Errorf("Appended choice should be re-indexed, got %s", choices[1].Raw)
+	}
+	if gjson.GetBytes(resp, "aigis_notes").Exists() {
+		t.Errorf("No notes expected when all upstreams succeed, got %s", resp)
+	}
+
+	// Partial failure: the successful choices are returned with a note
+	route.FanOut = append(route.FanOut, engine.Upstream{BaseURL: failing.URL})
+	resp, err = NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"ensemble"}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Partial failure should not fail the request: %v", err)
+	}
+	if n := len(gjson.GetBytes(resp, "choices").Array()); n != 2 {
+		t.Errorf("Expected 2 choices from the successful upstreams, got %d", n)
+	}
+	notes := gjson.GetBytes(resp, "aigis_notes").Array()
+	if len(notes) != 1 || !strings.Contains(notes[0].String(), "upstream 2 failed") {
+		t.Errorf("Expected a note for the failed upstream, got %s", resp)
+	}
+}
+
+func TestPIITransformNeutralizesClientPlaceholders(t *testing.T) {
+	route := &engine.Route{ID: "spoof"}
+	p := NewUniversalProvider(route, nil)
+	ctx := newTestContext()
+
+	body := []byte(`{"messages":[{"role":"user","content":"my mail is a@b.co and __AIGIS_SEC_0123456789ab__"}]}`)
+
+	result, err := p.applyPIITransform(ctx, body, nil)
+	if err != nil {
+		t.Fatalf("pii transform failed: %v", err)
+	}
+	content := gjson.GetBytes(result, "messages.0.content").String()
+	if strings.Contains(content, "__AIGIS_SEC_0123456789ab__") {
+		t.Errorf("Client placeholder should be neutralized, got %q", content)
+	}
+	if !strings.Contains(content, "__AIGIS_ESC_0123456789ab__") {
+		t.Errorf("Client placeholder should be escaped by default, got %q", content)
+	}
+	// The gateway's own placeholder for the email must still be present and restorable
+	if got := p.scanner.Unmask(ctx, content); !strings.Contains(got, "a@b.co") {
+		t.Errorf("Gateway placeholder should still unmask, got %q", got)
+	}
+
+	result, err = p.applyPIITransform(ctx, body, map[string]string{"client_placeholders": "strip"})
+	if err != nil {
+		t.Fatalf("pii transform failed: %v", err)
+	}
+	content = gjson.GetBytes(result, "messages.0.content").String()
+	if strings.Contains(content, "0123456789ab") {
+		t.Errorf("Client placeholder should be stripped, got %q", content)
+	}
+}
+
+func TestResponseRedactionOfModelGeneratedPII(t *testing.T) {
+	route := &engine.Route{ID: "redact-response"}
+	step := engine.TransformStep{Type: engine.TransformTypePII}
+	route.Transforms = []engine.TransformStep{step}
+
+	run := func() string {
+		p := NewUniversalProvider(route, nil)
+		ctx := newTestContext()
+		masked, err := p.applyRequestTransforms(ctx, []byte(`{"messages":[{"role":"user","content":"mail a@b.co"}]}`))
+		if err != nil {
+			t.Fatalf("request transforms failed: %v", err)
+		}
+		placeholder := strings.TrimPrefix(gjson.GetBytes(masked, "messages.0.content").String(), "mail ")
+		resp := []byte(`{"choices":[{"message":{"role":"assistant","content":"Reply to ` + placeholder + ` and cc ops@internal.corp"}}]}`)
+		result, err := p.applyResponseTransforms(ctx, resp)
+		if err != nil {
+			t.Fatalf("response transforms failed: %v", err)
+		}
+		return gjson.GetBytes(result, "choices.0.message.content").String()
+	}
+
+	// Off by default: the hallucinated email reaches the client
+	if got := run(); got != "Reply to a@b.co and cc ops@internal.corp" {
+		t.Errorf("Without redact_response the content should only be unmasked, got %q", got)
+	}
+
+	// Enabled: the email that only appears in the response is redacted, the request's is restored
+	route.Transforms[0].Config = map[string]string{"redact_response": "true"}
+	if got := run(); got != "Reply to a@b.co and cc [EMAIL_REDACTED]" {
+		t.Errorf("Expected the response-only email redacted, got %q", got)
+	}
+}
+
+func TestResponseRedactionFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     string
+		response string
+		path     string
+	}{
+		{"claude", engine.TransformTypePIIClaude, `{"content":[{"type":"text","text":%s}]}`, "content.0.text"},
+		{"gemini", engine.TransformTypePIIGemini, `{"candidates":[{"content":{"parts":[{"text":%s}]}}]}`, "candidates.0.content.parts.0.text"},
+		{"ollama chat", engine.TransformTypePIIOllama, `{"message":{"role":"assistant","content":%s}}`, "message.content"},
+		{"ollama generate", engine.TransformTypePIIOllama, `{"response":%s}`, "response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &engine.Route{ID: "redact-response", Transforms: []engine.TransformStep{
+				{Type: tt.step, Config: map[string]string{"redact_response": "true"}},
+			}}
+			p := NewUniversalProvider(route, nil)
+			ctx := newTestContext()
+			placeholder := p.scanner.Tokenize(ctx, "a@b.co")
+
+			resp := fmt.Sprintf(tt.response, quote("Reply to "+placeholder+" and cc ops@internal.corp"))
+			result, err := p.applyResponseTransforms(ctx, []byte(resp))
+			if err != nil {
+				t.Fatalf("response transforms failed: %v", err)
+			}
+			if got := gjson.GetBytes(result, tt.path).String(); got != "Reply to a@b.co and cc [EMAIL_REDACTED]" {
+				t.Errorf("Expected the response-only email redacted, got %q", got)
+			}
+		})
+	}
+}
+
+func TestResponseRedactionOfTagEnabledRules(t *testing.T) {
+	// The route masks US phone numbers through scanner.tags; the model's own must be redacted too
+	route := &engine.Route{
+		ID:         "redact-tags",
+		Scanner:    &engine.ScannerConfig{Tags: []string{"US Phone"}},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII, Config: map[string]string{"redact_response": "true"}}},
+	}
+	p := NewUniversalProvider(route, nil)
+	ctx := newTestContext()
+
+	resp := []byte(`{"choices":[{"message":{"role":"assistant","content":"Call (415) 555-0132"}}]}`)
+	result, err := p.applyResponseTransforms(ctx, resp)
+	if err != nil {
+		t.Fatalf("response transforms failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "choices.0.message.content").String(); got != "Call [PHONE_REDACTED]" {
+		t.Errorf("Expected the tag-enabled rule to redact the response, got %q", got)
+	}
+}
+
+func TestStreamRedactsModelGeneratedPII(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the masked user content, then an email of the model's own split across frames
+		body, _ := io.ReadAll(r.Body)
+		content := gjson.GetBytes(body, "messages.0.content").String()
+		for _, part := range []string{content + " cc ops@inte", "rnal.corp"} {
+			chunk, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": part}}}})
+			w.Write([]byte("data: " + string(chunk) + "\n\n"))
+		}
+		w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "stream",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII, Config: map[string]string{"redact_response": "true"}}},
+	}
+	p := NewUniversalProvider(route, nil)
+	ctx := newTestContext()
+	chunks, err := p.Stream(ctx, []byte(`{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"mail bob@corp.io"}]}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	var streamed strings.Builder
+	for chunk := range chunks {
+		streamed.WriteString(gjson.GetBytes(chunk, "choices.0.delta.content").String())
+	}
+	if streamed.String() != "mail bob@corp.io cc [EMAIL_REDACTED]" {
+		t.Errorf("Streamed content = %q", streamed.String())
+	}
+}
+
+func TestDefaultHeaderPolicyMerge(t *testing.T) {
+	received := make(chan http.Header, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	config := &engine.EngineConfig{
+		DefaultHeaderPolicy: engine.HeaderPolicy{
+			Set: map[string]string{"User-Agent": "aigis-default", "anthropic-version": "2023-06-01"},
+		},
+		Routes: []engine.Route{
+			{ID: "inherits", Upstream: engine.Upstream{BaseURL: upstream.URL}},
+			{
+				ID:           "overrides",
+				Matcher:      map[string]string{"model": "^claude"},
+				Upstream:     engine.Upstream{BaseURL: upstream.URL},
+				HeaderPolicy: engine.HeaderPolicy{Set: map[string]string{"anthropic-version": "2024-01-01"}},
+			},
+		},
+	}
+	if _, err := engine.NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		route   int
+		version string
+	}{{0, "2023-06-01"}, {1, "2024-01-01"}} {
+		p := NewUniversalProvider(&config.Routes[tc.route], nil)
+		if _, err := p.Send(newTestContext(), []byte(`{"model":"x"}`), http.Header{}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		headers := <-received
+		if got := headers.Get("User-Agent"); got != "aigis-default" {
+			t.Errorf("route %s: User-Agent = %q, want global default", config.Routes[tc.route].ID, got)
+		}
+		if got := headers.Get("Anthropic-Version"); got != tc.version {
+			t.Errorf("route %s: anthropic-version = %q, want %q", config.Routes[tc.route].ID, got, tc.version)
+		}
+	}
+}
+
+func TestSendInjectsTraceIDIntoBody(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	body := []byte(`{"model":"gpt-4","messages":[]}`)
+
+	route := &engine.Route{
+		ID:       "inject",
+		Upstream: engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{
+			Type:   engine.TransformTypeInjectIDs,
+			Config: map[string]string{"trace_id_path": "metadata.trace_id", "request_id_path": "metadata.request_id"},
+		}},
+	}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	got := <-bodies
+	if v := gjson.GetBytes(got, "metadata.trace_id").String(); v != "trace-test" {
+		t.Errorf("metadata.trace_id = %q, want %q", v, "trace-test")
+	}
+	if v := gjson.GetBytes(got, "metadata.request_id").String(); v != "req_test" {
+		t.Errorf("metadata.request_id = %q, want %q", v, "req_test")
+	}
+
+	// Unconfigured routes must not carry the ids
+	route = &engine.Route{ID: "plain", Upstream: engine.Upstream{BaseURL: upstream.URL}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := <-bodies; strings.Contains(string(got), "trace-test") {
+		t.Errorf("Trace id should not be injected without config, got %s", got)
+	}
+}
+
+func TestResponseRewriteModelAndID(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		model := gjson.GetBytes(body, "model").String()
+		w.Write([]byte(`{"id":"chatcmpl-internal","model":"` + model + `-2024-08-06","choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:              "rewrite",
+		Upstream:        engine.Upstream{BaseURL: upstream.URL},
+		Transforms:      []engine.TransformStep{{Type: engine.TransformTypeTemplate, Config: map[string]string{"template": `{"model":"internal-llm"}`}}},
+		ResponseRewrite: engine.ResponseRewrite{Model: true, ID: engine.ResponseIDPrefix},
+	}
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4o"}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if got := gjson.GetBytes(resp, "model").String(); got != "gpt-4o" {
+		t.Errorf("model = %q, want client-facing %q", got, "gpt-4o")
+	}
+	if got := gjson.GetBytes(resp, "id").String(); got != "req_test-chatcmpl-internal" {
+		t.Errorf("id = %q, want request-id prefix", got)
+	}
+
+	// Without the rewrite the upstream values pass through
+	route.ResponseRewrite = engine.ResponseRewrite{}
+	resp, err = NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4o"}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := gjson.GetBytes(resp, "model").String(); got != "internal-llm-2024-08-06" {
+		t.Errorf("model should be untouched without rewrite, got %q", got)
+	}
+}
+
+func TestExpectMaskingWarnsWhenNothingMasked(t *testing.T) {
+	observed, logs := observer.New(zap.WarnLevel)
+	route := &engine.Route{
+		ID: "sensitive",
+		Transforms: []engine.TransformStep{{
+			Type:   engine.TransformTypePII,
+			Config: map[string]string{"expect_masking": "strict"},
+		}},
+	}
+	p := NewUniversalProvider(route, logger.NewLogger(zap.New(observed)))
+
+	// Content present but nothing to mask: warning and flag
+	ctx := newTestContext()
+	if _, err := p.applyRequestTransforms(ctx, []byte(`{"messages":[{"role":"user","content":"hello there"}]}`)); err != nil {
+		t.Fatalf("transforms failed: %v", err)
+	}
+	if n := logs.FilterMessage("PII step expected masking but masked nothing").Len(); n != 1 {
+		t.Errorf("Expected 1 warning, got %d", n)
+	}
+	if flagged, _ := ctx.GetMetadata(core.MetaMaskingMissed); flagged != true {
+		t.Error("Strict mode should set the masking_missed flag")
+	}
+	if got := ctx.ResponseHeaders().Get("X-AIGis-Masking-Missed"); got != "true" {
+		t.Errorf("Strict mode should return X-AIGis-Masking-Missed to the client, got %q", got)
+	}
+
+	// Something masked: no warning
+	ctx = newTestContext()
+	if _, err := p.applyRequestTransforms(ctx, []byte(`{"messages":[{"role":"user","content":"mail a@b.co"}]}`)); err != nil {
+		t.Fatalf("transforms failed: %v", err)
+	}
+	if n := logs.FilterMessage("PII step expected masking but masked nothing").Len(); n != 1 {
+		t.Errorf("No new warning expected when masking happened, got %d total", n)
+	}
+	if _, flagged := ctx.GetMetadata(core.MetaMaskingMissed); flagged {
+		t.Error("Flag should not be set when masking happened")
+	}
+	if got := ctx.ResponseHeaders().Get("X-AIGis-Masking-Missed"); got != "" {
+		t.Errorf("No header expected when masking happened, got %q", got)
+	}
+}
+
+func TestSendStableBodyFormat(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "stable",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		BodyFormat: engine.BodyFormatStable,
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	body := []byte(`{
+		"temperature": 0.70,
+		"model": "gpt-4",
+		"messages": [{"role": "user", "content": "a < b", "name": "x"}],
+		"max_tokens": 12345678901234567890
+	}`)
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	want := `{"max_tokens":12345678901234567890,"messages":[{"content":"a < b","name":"x","role":"user"}],"model":"gpt-4","temperature":0.70}`
+	if got := string(<-bodies); got != want {
+		t.Errorf("Stable body:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestSerializeBodyMinifiedByDefault(t *testing.T) {
+	got := serializeBody([]byte("{\n  \"model\": \"gpt-4\",\n  \"n\": 1\n}"), "")
+	if string(got) != `{"model":"gpt-4","n":1}` {
+		t.Errorf("Default format should be minified, got %s", got)
+	}
+	if got := serializeBody([]byte("not json"), engine.BodyFormatStable); string(got) != "not json" {
+		t.Errorf("Invalid JSON should pass through unchanged, got %s", got)
+	}
+}
+
+func TestSendPassthroughWithoutTransforms(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{ID: "passthrough", Upstream: engine.Upstream{BaseURL: upstream.URL}}
+	// Whitespace, key order, escapes and number formatting must all survive untouched
+	body := []byte("{\n  \"stream\": false,\n  \"model\": \"gpt-4\",\n  \"messages\": [{\"role\": \"user\", \"content\": \"\\u003cimg\\u003e a@b.co\"}],\n  \"temperature\": 0.70\n}\n")
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if got := <-bodies; !bytes.Equal(got, body) {
+		t.Errorf("Passthrough body changed:\n got: %q\nwant: %q", got, body)
+	}
+}
+
+func TestSendNonJSONUpstreamResponses(t *testing.T) {
+	observed, logs := observer.New(zap.WarnLevel)
+	route := &engine.Route{
+		ID:         "non-json",
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	body := []byte(`{"messages":[{"role":"user","content":"mail a@b.co"}]}`)
+
+	// HTML 502 from a proxy: a readable gateway error, snippet logged
+	htmlGateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html><body><h1>502 Bad Gateway</h1></body></html>"))
+	}))
+	defer htmlGateway.Close()
+	route.Upstream = engine.Upstream{BaseURL: htmlGateway.URL}
+	p := NewUniversalProvider(route, logger.NewLogger(zap.New(observed)))
+	_, err := p.Send(newTestContext(), body, http.Header{})
+	gwErr := core.AsGatewayError(err)
+	if gwErr == nil || gwErr.Status != http.StatusBadGateway {
+		t.Fatalf("Expected a 502 gateway error, got %v", err)
+	}
+	if !strings.Contains(gwErr.Message, "non-JSON text/html") {
+		t.Errorf("Error should mention the content type, got %q", gwErr.Message)
+	}
+	entries := logs.FilterMessage("Upstream returned a non-JSON response").All()
+	if len(entries) != 1 || !strings.Contains(entries[0].ContextMap()["body_snippet"].(string), "502 Bad Gateway") {
+		t.Errorf("Expected one warning with a body snippet, got %v", entries)
+	}
+
+	// Empty 200: passed through as-is instead of failing the JSON transforms
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer empty.Close()
+	route.Upstream = engine.Upstream{BaseURL: empty.URL}
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{})
+	if err != nil || len(resp) != 0 {
+		t.Errorf("Expected an empty pass-through, got %q, %v", resp, err)
+	}
+
+	// Non-JSON 200: body untouched, upstream content type kept for the client
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>maintenance</p>"))
+	}))
+	defer plain.Close()
+	route.Upstream = engine.Upstream{BaseURL: plain.URL}
+	ctx := newTestContext()
+	resp, err = NewUniversalProvider(route, nil).Send(ctx, body, http.Header{})
+	if err != nil || string(resp) != "<p>maintenance</p>" {
+		t.Errorf("Expected the HTML body untouched, got %q, %v", resp, err)
+	}
+	if got := ctx.ResponseHeaders().Get("Content-Type"); got != "text/html" {
+		t.Errorf("Content-Type = %q, want text/html", got)
+	}
+}
+
+func TestIsJSONResponse(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"application/json", `{"a":1}`, true},
+		{"application/problem+json; charset=utf-8", `{"a":1}`, true},
+		{"text/plain; charset=utf-8", `{"a":1}`, true},
+		{"application/json", "", false},
+		{"text/html", "<html></html>", false},
+		{"", "upstream connect error", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{"Content-Type": {tt.contentType}}
+		if got := isJSONResponse(header, []byte(tt.body)); got != tt.want {
+			t.Errorf("isJSONResponse(%q, %q) = %v, want %v", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestSendDefaultUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{ID: "ua-route", Upstream: engine.Upstream{BaseURL: upstream.URL}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got, want := <-agents, "AIGis/"+version.Version+" (route=ua-route)"; got != want {
+		t.Errorf("User-Agent = %q, want %q", got, want)
+	}
+
+	route.HeaderPolicy.Set = map[string]string{"User-Agent": "custom-agent"}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := <-agents; got != "custom-agent" {
+		t.Errorf("HeaderPolicy.Set should override the default User-Agent, got %q", got)
+	}
+}
+
+func TestPIITransformSkipSystem(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "skip-system"}, nil)
+	config := map[string]string{"skip_system": "true"}
+
+	openAI := []byte(`{"messages":[{"role":"system","content":"Escalate to ops@corp.io"},{"role":"user","content":"I am bob@home.net"}]}`)
+	result, err := p.applyPIITransform(newTestContext(), openAI, config)
+	if err != nil {
+		t.Fatalf("pii transform failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "messages.0.content").String(); got != "Escalate to ops@corp.io" {
+		t.Errorf("System content should pass through, got %q", got)
+	}
+	if got := gjson.GetBytes(result, "messages.1.content").String(); strings.Contains(got, "bob@home.net") {
+		t.Errorf("User content must still be masked, got %q", got)
+	}
+
+	claude := []byte(`{"system":"Escalate to ops@corp.io","messages":[{"role":"user","content":[{"type":"text","text":"I am bob@home.net"}]}]}`)
+	result, err = p.applyClaudePIITransform(newTestContext(), claude, config)
+	if err != nil {
+		t.Fatalf("pii_claude transform failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "system").String(); got != "Escalate to ops@corp.io" {
+		t.Errorf("Claude system should pass through, got %q", got)
+	}
+	if got := gjson.GetBytes(result, "messages.0.content.0.text").String(); strings.Contains(got, "bob@home.net") {
+		t.Errorf("Claude user content must still be masked, got %q", got)
+	}
+
+	// Without the toggle the system prompt is masked as before
+	result, _ = p.applyPIITransform(newTestContext(), openAI, nil)
+	if got := gjson.GetBytes(result, "messages.0.content").String(); strings.Contains(got, "ops@corp.io") {
+		t.Errorf("System content should be masked by default, got %q", got)
+	}
+}
+
+func TestPIITransformSkipCodeFences(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "skip-code-fences"}, nil)
+	body := []byte(`{"messages":[{"role":"user","content":"Is this valid?\n` + "```" + `\nkey = sk-12345678901234567890\n` + "```" + `\nMine is sk-abcdefghijabcdefghij"}]}`)
+
+	result, err := p.applyPIITransform(newTestContext(), body, map[string]string{"skip_code_fences": "true"})
+	if err != nil {
+		t.Fatalf("pii transform failed: %v", err)
+	}
+	got := gjson.GetBytes(result, "messages.0.content").String()
+	if !strings.Contains(got, "sk-12345678901234567890") || strings.Contains(got, "sk-abcdefghijabcdefghij") {
+		t.Errorf("Only the key outside the code fence should be masked, got %q", got)
+	}
+
+	// Without the toggle the example key is masked as before
+	result, _ = p.applyPIITransform(newTestContext(), body, nil)
+	if got := gjson.GetBytes(result, "messages.0.content").String(); strings.Contains(got, "sk-12345678901234567890") {
+		t.Errorf("Code fences should be masked by default, got %q", got)
+	}
+}
+
+func TestGeminiPIIRoundTrip(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the masked user text back as a Gemini candidate with text and a function call
+		upstreamBody, _ = io.ReadAll(r.Body)
+		text := gjson.GetBytes(upstreamBody, "contents.0.parts.0.text").String()
+		resp, _ := json.Marshal(map[string]any{
+			"candidates": []any{map[string]any{
+				"content": map[string]any{
+					"role": "model",
+					"parts": []any{
+						map[string]any{"text": "You said: " + text},
+						map[string]any{"functionCall": map[string]any{"name": "send_mail", "args": map[string]any{"to": []any{text}}}},
+					},
+				},
+				"finishReason": "STOP",
+			}},
+			"usageMetadata": map[string]any{"totalTokenCount": 12},
+		})
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "gemini",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePIIGemini}},
+	}
+	body := []byte(`{
+		"systemInstruction": {"parts": [{"text": "Escalate to ops@corp.io"}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "bob@home.net"}, {"inlineData": {"mimeType": "image/png", "data": "bob@home.net"}}]}
+		]
+	}`)
+	ctx := newTestContext()
+	resp, err := NewUniversalProvider(route, nil).Send(ctx, body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if strings.Contains(gjson.GetBytes(upstreamBody, "contents.0.parts.0.text").String(), "bob@home.net") ||
+		strings.Contains(gjson.GetBytes(upstreamBody, "systemInstruction.parts.0.text").String(), "ops@corp.io") {
+		t.Errorf("Upstream received unmasked text: %s", upstreamBody)
+	}
+	if got := gjson.GetBytes(upstreamBody, "contents.0.parts.1.inlineData.data").String(); got != "bob@home.net" {
+		t.Errorf("Non-text parts should pass through, got %q", got)
+	}
+
+	parts := gjson.GetBytes(resp, "candidates.0.content.parts")
+	if got := parts.Get("0.text").String(); got != "You said: bob@home.net" {
+		t.Errorf("Response text = %q", got)
+	}
+	if got := parts.Get("1.functionCall.args.to.0").String(); got != "bob@home.net" {
+		t.Errorf("Function call args = %q", got)
+	}
+	if got := gjson.GetBytes(resp, "usageMetadata.totalTokenCount").Int(); got != 12 {
+		t.Errorf("usageMetadata should be preserved, got %d", got)
+	}
+	if hits, _ := ctx.GetMetadata(core.MetaUnmaskHits); hits != 2 {
+		t.Errorf("unmask_hits = %v, want 2", hits)
+	}
+}
+
+func TestGeminiPIITransformSkipSystem(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "gemini"}, nil)
+	body := []byte(`{"systemInstruction":{"parts":[{"text":"Escalate to ops@corp.io"}]},"contents":[{"role":"user","parts":[{"text":"I am bob@home.net"}]}]}`)
+
+	result, err := p.applyGeminiPIITransform(newTestContext(), body, map[string]string{"skip_system": "true"})
+	if err != nil {
+		t.Fatalf("pii_gemini transform failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "systemInstruction.parts.0.text").String(); got != "Escalate to ops@corp.io" {
+		t.Errorf("System instruction should pass through, got %q", got)
+	}
+	if got := gjson.GetBytes(result, "contents.0.parts.0.text").String(); strings.Contains(got, "bob@home.net") {
+		t.Errorf("User text must still be masked, got %q", got)
+	}
+	if !hasMessageContent(body) {
+		t.Error("Gemini contents should count as message content for expect_masking")
+	}
+}
+
+func TestOllamaPIIRoundTrip(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the masked text back in the native Ollama response shape of each endpoint
+		upstreamBody, _ = io.ReadAll(r.Body)
+		var resp []byte
+		if r.URL.Path == "/api/generate" {
+			resp, _ = json.Marshal(map[string]any{
+				"model":    "llama3",
+				"response": "You said: " + gjson.GetBytes(upstreamBody, "prompt").String(),
+				"done":     true,
+			})
+		} else {
+			text := gjson.GetBytes(upstreamBody, "messages.0.content").String()
+			resp, _ = json.Marshal(map[string]any{
+				"model": "llama3",
+				"message": map[string]any{
+					"role":       "assistant",
+					"content":    "You said: " + text,
+					"tool_calls": []any{map[string]any{"function": map[string]any{"name": "send_mail", "arguments": map[string]any{"to": text}}}},
+				},
+				"done": true,
+			})
+		}
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	newProvider := func(path string) *UniversalProvider {
+		return NewUniversalProvider(&engine.Route{
+			ID:         "ollama",
+			Upstream:   engine.Upstream{BaseURL: upstream.URL, Path: path},
+			Transforms: []engine.TransformStep{{Type: engine.TransformTypePIIOllama}},
+		}, nil)
+	}
+
+	t.Run("generate", func(t *testing.T) {
+		ctx := newTestContext()
+		body := []byte(`{"model":"llama3","system":"Escalate to ops@corp.io","prompt":"bob@home.net","stream":false}`)
+		resp, err := newProvider("/api/generate").Send(ctx, body, http.Header{})
+		if err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if strings.Contains(string(upstreamBody), "bob@home.net") || strings.Contains(string(upstreamBody), "ops@corp.io") {
+			t.Errorf("Upstream received unmasked text: %s", upstreamBody)
+		}
+		if got := gjson.GetBytes(resp, "response").String(); got != "You said: bob@home.net" {
+			t.Errorf("response = %q", got)
+		}
+		if !gjson.GetBytes(resp, "done").Bool() {
+			t.Error("done should be preserved")
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		ctx := newTestContext()
+		body := []byte(`{"model":"llama3","messages":[{"role":"user","content":"bob@home.net","images":["aW1n"]}],"stream":false}`)
+		resp, err := newProvider("/api/chat").Send(ctx, body, http.Header{})
+		if err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if strings.Contains(string(upstreamBody), "bob@home.net") {
+			t.Errorf("Upstream received unmasked text: %s", upstreamBody)
+		}
+		if got := gjson.GetBytes(upstreamBody, "messages.0.images.0").String(); got != "aW1n" {
+			t.Errorf("Images should pass through, got %q", got)
+		}
+		if got := gjson.GetBytes(resp, "message.content").String(); got != "You said: bob@home.net" {
+			t.Errorf("message.content = %q", got)
+		}
+		if got := gjson.GetBytes(resp, "message.tool_calls.0.function.arguments.to").String(); got != "bob@home.net" {
+			t.Errorf("Tool call arguments = %q", got)
+		}
+		if hits, _ := ctx.GetMetadata(core.MetaUnmaskHits); hits != 2 {
+			t.Errorf("unmask_hits = %v, want 2", hits)
+		}
+	})
+}
+
+func TestRegexReplaceTransform(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "regex"}, nil)
+	body := []byte(`{"messages":[{"role":"user","content":"Look:\n` + "```go\\nfmt.Println(1)\\n```" + `\nDone.   Thanks"},{"role":"user","content":42}],"prompt":"a   b"}`)
+
+	tests := []struct {
+		name   string
+		config map[string]string
+		path   string
+		want   string
+	}{
+		{"existing path", map[string]string{"path": "prompt", "pattern": `\s+`, "replacement": " "}, "prompt", "a b"},
+		{"group reference", map[string]string{"path": "prompt", "pattern": `(a)\s+(b)`, "replacement": "$2-$1"}, "prompt", "b-a"},
+		{"multiline pattern over array path", map[string]string{"path": "messages.#.content", "pattern": "(?s)```.*?```\\n?"}, "messages.0.content", "Look:\nDone.   Thanks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := engine.TransformStep{Type: engine.TransformTypeRegexReplace, Config: tt.config}
+			result, err := p.applyRegexReplaceTransform(body, step)
+			if err != nil {
+				t.Fatalf("regex_replace failed: %v", err)
+			}
+			if got := gjson.GetBytes(result, tt.path).String(); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.path, got, tt.want)
+			}
+			if got := gjson.GetBytes(result, "messages.1.content").Int(); got != 42 {
+				t.Errorf("Non-string values should be skipped, got %d", got)
+			}
+		})
+	}
+
+	t.Run("missing path", func(t *testing.T) {
+		step := engine.TransformStep{Type: engine.TransformTypeRegexReplace, Config: map[string]string{"path": "input", "pattern": `\s+`}}
+		result, err := p.applyRegexReplaceTransform(body, step)
+		if err != nil {
+			t.Fatalf("regex_replace failed: %v", err)
+		}
+		if string(result) != string(body) {
+			t.Errorf("Body should be unchanged, got %s", result)
+		}
+	})
+}
+
+func TestFieldDeleteTransform(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "delete"}, nil)
+	body := []byte(`{"model":"gpt-4","metadata":{"trace":"x"},"logprobs":true,` +
+		`"messages":[{"role":"system","content":"a"},{"role":"user","content":"b"},{"role":"user","content":"c"}],` +
+		`"tools":[{"function":{"name":"f","strict":true}},{"function":{"name":"g","strict":false}}]}`)
+
+	result, err := p.applyFieldDeleteTransform(body, map[string]string{
+		"paths": "metadata, logprobs, messages.1, tools.#.function.strict, missing.field, messages.9",
+	})
+	if err != nil {
+		t.Fatalf("field_delete failed: %v", err)
+	}
+
+	for _, path := range []string{"metadata", "logprobs", "tools.0.function.strict", "tools.1.function.strict"} {
+		if gjson.GetBytes(result, path).Exists() {
+			t.Errorf("%s should be deleted: %s", path, result)
+		}
+	}
+	if got := gjson.GetBytes(result, "messages.#.content").String(); got != `["a","c"]` {
+		t.Errorf("messages.1 should be removed, got %s", got)
+	}
+	if got := gjson.GetBytes(result, "tools.#.function.name").String(); got != `["f","g"]` {
+		t.Errorf("Other tool fields should be kept, got %s", got)
+	}
+	if gjson.GetBytes(result, "model").String() != "gpt-4" || !gjson.ValidBytes(result) {
+		t.Errorf("Unexpected body: %s", result)
+	}
+}
+
+func TestModelAliasTransform(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "alias"}, nil)
+	config := map[string]string{"gpt-4": "gpt-4-0613", "gpt-4o": "prod-gpt4o-deployment"}
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"model":"gpt-4","messages":[]}`, "gpt-4-0613"},
+		{`{"model":"gpt-4o","messages":[]}`, "prod-gpt4o-deployment"},
+		{`{"model":"gpt-4-turbo","messages":[]}`, "gpt-4-turbo"},
+		{`{"messages":[]}`, ""},
+	}
+	for _, tt := range tests {
+		result, err := p.applyModelAliasTransform([]byte(tt.body), config)
+		if err != nil {
+			t.Fatalf("model_alias failed: %v", err)
+		}
+		if got := gjson.GetBytes(result, "model").String(); got != tt.want {
+			t.Errorf("%s: model = %q, want %q", tt.body, got, tt.want)
+		}
+		if tt.want == "" && string(result) != tt.body {
+			t.Errorf("Body without model should be unchanged, got %s", result)
+		}
+	}
+}
+
+func TestClampParamTransform(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "clamp"}, nil)
+	config := map[string]string{"path": "max_tokens", "max": "1024"}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"over limit", `{"model":"gpt-4","max_tokens":100000}`, "1024"},
+		{"under limit", `{"model":"gpt-4","max_tokens":256}`, "256"},
+		{"at limit", `{"model":"gpt-4","max_tokens":1024}`, "1024"},
+		{"missing", `{"model":"gpt-4"}`, "1024"},
+		{"not a number", `{"model":"gpt-4","max_tokens":"lots"}`, "1024"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := p.applyClampParamTransform([]byte(tt.body), config)
+			if err != nil {
+				t.Fatalf("clamp_param failed: %v", err)
+			}
+			if got := gjson.GetBytes(result, "max_tokens").Raw; got != tt.want {
+				t.Errorf("max_tokens = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Nested paths and the default path
+	result, _ := p.applyClampParamTransform([]byte(`{"options":{"num_predict":9000}}`), map[string]string{"path": "options.num_predict", "max": "512"})
+	if got := gjson.GetBytes(result, "options.num_predict").Int(); got != 512 {
+		t.Errorf("options.num_predict = %d, want 512", got)
+	}
+	result, _ = p.applyClampParamTransform([]byte(`{"max_tokens":9000}`), map[string]string{"max": "512"})
+	if got := gjson.GetBytes(result, "max_tokens").Int(); got != 512 {
+		t.Errorf("default path: max_tokens = %d, want 512", got)
+	}
+	if _, err := p.applyClampParamTransform([]byte(`{}`), map[string]string{"max": "many"}); err == nil {
+		t.Error("A non-numeric max should fail")
+	}
+}
+
+func TestFieldMapPreservesNumbers(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "field-map"}, nil)
+	body := []byte(`{"max_tokens":100,"budget":1000000,"seed":9007199254740993,"temperature":0.7,"scale":1.50}`)
+	config := map[string]string{
+		"params.max_tokens":  "max_tokens",
+		"params.budget":      "budget",
+		"params.seed":        "seed",
+		"params.temperature": "temperature",
+		"params.scale":       "scale",
+	}
+
+	result, err := p.applyFieldMapTransform(body, config)
+	if err != nil {
+		t.Fatalf("field_map failed: %v", err)
+	}
+
+	for target, want := range map[string]string{
+		"params.max_tokens":  "100",
+		"params.budget":      "1000000",
+		"params.seed":        "9007199254740993",
+		"params.temperature": "0.7",
+		"params.scale":       "1.50",
+	} {
+		if got := gjson.GetBytes(result, target).Raw; got != want {
+			t.Errorf("%s = %s, want %s", target, got, want)
+		}
+	}
+}
+
+func TestTemplateTransformFuncs(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "template"}, nil)
+	body := []byte(`{"model":"gpt-4","created":0,"stop":["END","STOP"],"messages":[{"role":"user","content":"  Say \"hi\"\n  "}]}`)
+	tmpl := `{
+		"inputs": {
+			"query": {{index .messages 0 "content" | trim | toJson}},
+			"history": {{toJson .messages}},
+			"meta": {{toJson (index .messages 0)}}
+		},
+		"model": {{.model | upper | toJson}},
+		"user": {{.user | default "anonymous" | toJson}},
+		"stop": {{join "|" .stop | lower | toJson}},
+		"day": {{date "2006-01-02" .created | toJson}}
+	}`
+
+	result, err := p.applyTemplateTransform(body, map[string]string{"template": tmpl})
+	if err != nil {
+		t.Fatalf("template transform failed: %v", err)
+	}
+
+	checks := map[string]string{
+		"inputs.query":          `Say "hi"`,
+		"inputs.history.0.role": "user",
+		"inputs.meta.content":   "  Say \"hi\"\n  ",
+		"model":                 "GPT-4",
+		"user":                  "anonymous",
+		"stop":                  "end|stop",
+		"day":                   "1970-01-01",
+	}
+	for path, want := range checks {
+		if got := gjson.GetBytes(result, path).String(); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+	if !gjson.GetBytes(result, "inputs.history").IsArray() {
+		t.Errorf("toJson should produce a nested array: %s", result)
+	}
+}
+
+func TestSendNormalizesRateLimitHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Anthropic-Ratelimit-Requests-Remaining", "42")
+		w.Header().Set("X-Unmapped", "nope")
+		w.Write([]byte(`{"content":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID: "claude",
+		Upstream: engine.Upstream{
+			BaseURL: upstream.URL,
+			RateLimitHeaders: map[string]string{
+				"anthropic-ratelimit-requests-remaining": "Remaining-Requests",
+				"anthropic-ratelimit-tokens-remaining":   "Remaining-Tokens",
+			},
+		},
+	}
+	ctx := newTestContext()
+	if _, err := NewUniversalProvider(route, nil).Send(ctx, []byte(`{}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	headers := ctx.ResponseHeaders()
+	if got := headers.Get("X-AIGis-RateLimit-Remaining-Requests"); got != "42" {
+		t.Errorf("X-AIGis-RateLimit-Remaining-Requests = %q, want 42", got)
+	}
+	if len(headers) != 1 {
+		t.Errorf("Only mapped, present headers should be forwarded, got %v", headers)
+	}
+}
+
+func TestMergeSystemIntoUserTransform(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "merge"}, nil)
+
+	body := []byte(`{"model":"m","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello"},{"role":"user","content":"Bye"}]}`)
+	result, err := p.applyMergeSystemTransform(body, map[string]string{"separator": "\n---\n"})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	messages := gjson.GetBytes(result, "messages").Array()
+	if len(messages) != 3 {
+		t.Fatalf("System message should be removed, got %s", result)
+	}
+	if got := messages[0].Get("content").String(); got != "Be brief.\n---\nHi" {
+		t.Errorf("First user content = %q", got)
+	}
+	if got := messages[2].Get("content").String(); got != "Bye" {
+		t.Errorf("Later user messages should be untouched, got %q", got)
+	}
+
+	// Content blocks get a leading text block
+	body = []byte(`{"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":[{"type":"image_url","image_url":{"url":"x"}}]}]}`)
+	result, err = p.applyMergeSystemTransform(body, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if got := gjson.GetBytes(result, "messages.0.content.0.text").String(); got != "Be brief." {
+		t.Errorf("Expected a leading text block, got %s", result)
+	}
+	if gjson.GetBytes(result, "messages.0.content.1.type").String() != "image_url" {
+		t.Errorf("Existing blocks should be kept, got %s", result)
+	}
+}
+
+func TestMergeSystemIntoUserCreatesUserMessage(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "merge"}, nil)
+
+	body := []byte(`{"messages":[{"role":"system","content":"Rule one."},{"role":"system","content":"Rule two."}]}`)
+	result, err := p.applyMergeSystemTransform(body, nil)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	messages := gjson.GetBytes(result, "messages").Array()
+	if len(messages) != 1 || messages[0].Get("role").String() != "user" {
+		t.Fatalf("Expected a single created user message, got %s", result)
+	}
+	if got := messages[0].Get("content").String(); got != "Rule one.\n\nRule two." {
+		t.Errorf("Created user content = %q", got)
+	}
+}
+
+func TestSendObserveOnlyForwardsOriginalBody(t *testing.T) {
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"id":"chatcmpl-1","model":"gpt-4","choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:              "observed",
+		Upstream:        engine.Upstream{BaseURL: upstream.URL},
+		Transforms:      []engine.TransformStep{{Type: engine.TransformTypePII}},
+		ResponseRewrite: engine.ResponseRewrite{ID: engine.ResponseIDRequestID},
+	}
+	p := NewUniversalProvider(route, nil)
+	p.SetObserveOnly(true)
+
+	detected := func() float64 {
+		var m dto.Metric
+		if err := metrics.PIIDetectedTotal.WithLabelValues("Email", core.ModeObserve).Write(&m); err != nil {
+			t.Fatalf("read metric: %v", err)
+		}
+		return m.GetCounter().GetValue()
+	}
+	before := detected()
+
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"mail a@b.co"}]}`)
+	ctx := newTestContext()
+	resp, err := p.Send(ctx, body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if string(gotBody) != string(body) {
+		t.Errorf("Upstream should receive the original body, got: %s", gotBody)
+	}
+	if got := detected() - before; got != 1 {
+		t.Errorf("Detection metric increased by %v, want 1", got)
+	}
+	if ctx.MaskCounts()["Email"] != 1 {
+		t.Errorf("Detections should still be recorded on the context, got: %v", ctx.MaskCounts())
+	}
+	// The response is passed through untouched
+	if id := gjson.GetBytes(resp, "id").String(); id != "chatcmpl-1" {
+		t.Errorf("Response should not be rewritten, got id %q", id)
+	}
+}
+
+func TestStreamForwardsDataChunks(t *testing.T) {
+	var gotAccept string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": keep-alive\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n"))
+		w.Write([]byte("event: message\r\ndata:{\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\r\n\r\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+		w.Write([]byte("data: {\"after\":\"done\"}\n\n"))
+	}))
+	defer upstream.Close()
+
+	p := NewUniversalProvider(&engine.Route{ID: "stream", Upstream: engine.Upstream{BaseURL: upstream.URL}}, nil)
+	chunks, err := p.Stream(newTestContext(), []byte(`{"model":"gpt-4","stream":true}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	var got []string
+	for chunk := range chunks {
+		got = append(got, string(chunk))
+	}
+	want := []string{`{"choices":[{"delta":{"content":"Hel"}}]}`, `{"choices":[{"delta":{"content":"lo"}}]}`}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Chunks = %v, want %v", got, want)
+	}
+	if gotAccept != "text/event-stream" {
+		t.Errorf("Accept = %q, want text/event-stream", gotAccept)
+	}
+}
+
+func TestStreamUpstreamErrorBeforeFirstChunk(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":{"message":"slow down"}}`))
+	}))
+	defer upstream.Close()
+
+	p := NewUniversalProvider(&engine.Route{ID: "stream", Upstream: engine.Upstream{BaseURL: upstream.URL}}, nil)
+	chunks, err := p.Stream(newTestContext(), []byte(`{"model":"gpt-4","stream":true}`), http.Header{})
+	if err == nil {
+		t.Fatal("Expected an upstream error")
+	}
+	if chunks != nil {
+		t.Error("No channel should be returned on error")
+	}
+	if gwErr := core.AsGatewayError(err); gwErr.Category != core.ErrCategoryUpstream {
+		t.Errorf("Category = %v, want upstream", gwErr.Category)
+	}
+}
+
+func TestStreamClosesOnCancel(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {\"n\":1}\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(unblock)
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	ctx := core.NewGatewayContext(cancelCtx, zap.NewNop())
+
+	p := NewUniversalProvider(&engine.Route{ID: "stream", Upstream: engine.Upstream{BaseURL: upstream.URL}}, nil)
+	chunks, err := p.Stream(ctx, []byte(`{"model":"gpt-4","stream":true}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if first := <-chunks; string(first) != `{"n":1}` {
+		t.Fatalf("First chunk = %s", first)
+	}
+
+	cancel()
+	select {
+	case _, open := <-chunks:
+		if open {
+			t.Error("No further chunks expected after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Channel was not closed after cancel")
+	}
+}
+
+func TestStreamHeaderTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stall") == "" {
+			// Headers arrive at once; the stream itself outlives timeout_seconds
+			w.Write([]byte("data: {\"n\":1}\n\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(1500 * time.Millisecond)
+			w.Write([]byte("data: {\"n\":2}\n\ndata: [DONE]\n\n"))
+			return
+		}
+		// Accepts the connection but never sends headers
+		select {
+		case <-unblock:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(unblock)
+
+	stream := func(query string) (<-chan []byte, error) {
+		route := &engine.Route{ID: "stream", Upstream: engine.Upstream{BaseURL: upstream.URL, Path: "/" + query, TimeoutSeconds: 1}}
+		return NewUniversalProvider(route, nil).Stream(newTestContext(), []byte(`{"model":"gpt-4","stream":true}`), http.Header{})
+	}
+
+	start := time.Now()
+	_, err := stream("?stall=1")
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Category != core.ErrCategoryTimeout {
+		t.Fatalf("Expected a timeout error while waiting for headers, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Header wait should be bounded by timeout_seconds, took %v", elapsed)
+	}
+
+	chunks, err := stream("")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	var got []string
+	for chunk := range chunks {
+		got = append(got, string(chunk))
+	}
+	if len(got) != 2 || got[1] != `{"n":2}` {
+		t.Errorf("A stream whose headers arrived should not be cut off by timeout_seconds, got %v", got)
+	}
+}
+
+func TestStreamUnmasksSplitPlaceholders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the masked user content back, split mid-placeholder across two frames
+		body, _ := io.ReadAll(r.Body)
+		content := gjson.GetBytes(body, "messages.0.content").String()
+		cut := strings.Index(content, "__AIGIS_SEC_") + 7
+		for _, part := range []string{content[:cut], content[cut:]} {
+			chunk, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": part}}}})
+			w.Write([]byte("data: " + string(chunk) + "\n\n"))
+		}
+		w.Write([]byte("data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "stream",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	p := NewUniversalProvider(route, nil)
+	ctx := newTestContext()
+	chunks, err := p.Stream(ctx, []byte(`{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"mail bob@corp.io now"}]}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	var streamed strings.Builder
+	for chunk := range chunks {
+		if strings.Contains(string(chunk), "__AIGIS") {
+			t.Errorf("Chunk exposes a placeholder fragment: %s", chunk)
+		}
+		streamed.WriteString(gjson.GetBytes(chunk, "choices.0.delta.content").String())
+	}
+
+	if streamed.String() != "mail bob@corp.io now" {
+		t.Errorf("Streamed content = %q", streamed.String())
+	}
+	if hits, _ := ctx.GetMetadata(core.MetaUnmaskHits); hits != 1 {
+		t.Errorf("unmask_hits = %v, want 1", hits)
+	}
+}
+
+func TestResponseUnmasksToolCallArguments(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "tools"}, nil)
+	ctx := newTestContext()
+	ctx.VaultStore("__AIGIS_SEC_0123456789ab__", "bob@corp.io")
+	ctx.VaultStore("__AIGIS_SEC_ba9876543210__", "pa\"ss\nword")
+
+	args, _ := json.Marshal(map[string]any{
+		"to":    "__AIGIS_SEC_0123456789ab__",
+		"notes": []any{"secret: __AIGIS_SEC_ba9876543210__", 42},
+	})
+	body, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{
+		"index": 0,
+		"message": map[string]any{
+			"role":    "assistant",
+			"content": nil,
+			"tool_calls": []any{
+				map[string]any{"id": "call_1", "type": "function", "function": map[string]any{"name": "send_mail", "arguments": string(args)}},
+				map[string]any{"id": "call_2", "type": "function", "function": map[string]any{"name": "raw", "arguments": "to=__AIGIS_SEC_0123456789ab__"}},
+			},
+		},
+	}}})
+
+	result, err := p.applyResponseTransforms(ctx, body)
+	if err != nil {
+		t.Fatalf("applyResponseTransforms failed: %v", err)
+	}
+
+	toolCalls := gjson.GetBytes(result, "choices.0.message.tool_calls")
+	arguments := toolCalls.Get("0.function.arguments").String()
+	if !gjson.Valid(arguments) {
+		t.Fatalf("Arguments are no longer valid JSON: %s", arguments)
+	}
+	if got := gjson.Get(arguments, "to").String(); got != "bob@corp.io" {
+		t.Errorf("to = %q", got)
+	}
+	if got := gjson.Get(arguments, "notes.0").String(); got != "secret: pa\"ss\nword" {
+		t.Errorf("notes.0 = %q", got)
+	}
+	if got := gjson.Get(arguments, "notes.1").Int(); got != 42 {
+		t.Errorf("notes.1 = %d", got)
+	}
+	if got := toolCalls.Get("1.function.arguments").String(); got != "to=bob@corp.io" {
+		t.Errorf("Non-JSON arguments = %q", got)
+	}
+	if !gjson.GetBytes(result, "choices.0.message.content").Exists() {
+		t.Error("Null content should be preserved")
+	}
+	if hits, _ := ctx.GetMetadata(core.MetaUnmaskHits); hits != 3 {
+		t.Errorf("unmask_hits = %v, want 3", hits)
+	}
+}
+
+func TestPIITransformScanAll(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Echo the masked tool description back as the assistant reply
+		upstreamBody, _ = io.ReadAll(r.Body)
+		description := gjson.GetBytes(upstreamBody, "tools.0.function.parameters.properties.to.description").String()
+		resp, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": description}}}})
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:       "deep",
+		Upstream: engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{
+			Type:   engine.TransformTypePII,
+			Config: map[string]string{"scan_all": "true", "exclude_paths": "model,metadata.#"},
+		}},
+	}
+	body := []byte(`{
+		"model": "ops@corp.io",
+		"messages": [{"role": "user", "content": "hi from bob@home.net"}],
+		"metadata": ["keep@corp.io"],
+		"extra": {"owner": "alice@corp.io"},
+		"tools": [{"type": "function", "function": {"name": "mail", "parameters": {"type": "object",
+			"properties": {"to": {"type": "string", "description": "Defaults to admin@corp.io"}}}}}]
+	}`)
+	ctx := newTestContext()
+	resp, err := NewUniversalProvider(route, nil).Send(ctx, body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for _, secret := range []string{"bob@home.net", "alice@corp.io", "admin@corp.io"} {
+		if strings.Contains(string(upstreamBody), secret) {
+			t.Errorf("Upstream received %s: %s", secret, upstreamBody)
+		}
+	}
+	if got := gjson.GetBytes(upstreamBody, "model").String(); got != "ops@corp.io" {
+		t.Errorf("Excluded path model should pass through, got %q", got)
+	}
+	if got := gjson.GetBytes(upstreamBody, "metadata.0").String(); got != "keep@corp.io" {
+		t.Errorf("Excluded path metadata.# should pass through, got %q", got)
+	}
+	if got := gjson.GetBytes(resp, "choices.0.message.content").String(); got != "Defaults to admin@corp.io" {
+		t.Errorf("Deeply nested secret was not restored, got %q", got)
+	}
+}
+
+func TestPIITransformContentArray(t *testing.T) {
+	p := NewUniversalProvider(&engine.Route{ID: "parts"}, nil)
+	ctx := newTestContext()
+
+	body := []byte(`{"messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"I am bob@home.net"},` +
+		`{"type":"image_url","image_url":{"url":"https://img.example.com/bob@home.net.png"}},` +
+		`{"type":"text","text":"call 13800138000"}]}]}`)
+	result, err := p.applyPIITransform(ctx, body, nil)
+	if err != nil {
+		t.Fatalf("pii transform failed: %v", err)
+	}
+
+	for _, path := range []string{"messages.0.content.0.text", "messages.0.content.2.text"} {
+		got := gjson.GetBytes(result, path).String()
+		if !strings.Contains(got, "__AIGIS_SEC_") {
+			t.Errorf("%s should be masked, got %q", path, got)
+		}
+	}
+	// Non-text parts are left untouched
+	if got := gjson.GetBytes(result, "messages.0.content.1.image_url.url").String(); got != "https://img.example.com/bob@home.net.png" {
+		t.Errorf("Image part should be untouched, got %q", got)
+	}
+
+	if unmasked := p.unmask(ctx, gjson.GetBytes(result, "messages.0.content.0.text").String()); unmasked != "I am bob@home.net" {
+		t.Errorf("Round trip failed, got %q", unmasked)
+	}
+}
+
+func TestPIITransformTopLevelSystem(t *testing.T) {
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		// Echo the (masked) system prompt back to the client
+		system := gjson.GetBytes(gotBody, "system").String()
+		resp, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{"message": map[string]any{"role": "assistant", "content": "You said: " + system}}}})
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "system",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	body := []byte(`{"model":"gpt-4","system":"Use key sk-proj-abc123def456789012345","messages":[{"role":"user","content":"hi"}]}`)
+	resp, err := p.Send(newTestContext(), body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if got := gjson.GetBytes(gotBody, "system").String(); strings.Contains(got, "sk-proj-") || !strings.Contains(got, "__AIGIS_SEC_") {
+		t.Errorf("System prompt should be tokenized upstream, got %q", got)
+	}
+	if got := gjson.GetBytes(resp, "choices.0.message.content").String(); got != "You said: Use key sk-proj-abc123def456789012345" {
+		t.Errorf("Response should restore the secret, got %q", got)
+	}
+}
+
+func TestPIITransformDisabledRules(t *testing.T) {
+	route := &engine.Route{
+		ID: "no-phones",
+		Transforms: []engine.TransformStep{{
+			Type:   engine.TransformTypePII,
+			Config: map[string]string{"disabled_rules": "Mobile Phone"},
+		}},
+	}
+	p := NewUniversalProvider(route, nil)
+
+	body := []byte(`{"messages":[{"role":"user","content":"mail a@b.co or call 13800138000"}]}`)
+	result, err := p.applyRequestTransforms(newTestContext(), body)
+	if err != nil {
+		t.Fatalf("transforms failed: %v", err)
+	}
+	got := gjson.GetBytes(result, "messages.0.content").String()
+	if !strings.Contains(got, "13800138000") || strings.Contains(got, "a@b.co") {
+		t.Errorf("Only the email should be masked, got %q", got)
+	}
+}
+
+func TestPerRouteScannerRules(t *testing.T) {
+	config := &engine.EngineConfig{Routes: []engine.Route{
+		{
+			// Internal logging API: aggressive redaction, including employee ids
+			ID:         "logging",
+			Matcher:    map[string]string{"model": "^logger$"},
+			Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+			Scanner: &engine.ScannerConfig{
+				Rules: []engine.ScannerRule{{Name: "Employee ID", Pattern: `\bEMP-\d{6}\b`}},
+			},
+		},
+		{
+			// Translation API: only keys are masked
+			ID:         "translate",
+			Matcher:    map[string]string{"model": "^translator$"},
+			Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+			Scanner:    &engine.ScannerConfig{Tags: []string{"OpenAI API Key"}},
+		},
+	}}
+	eng, err := engine.NewEngine(config)
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	content := "EMP-004211 mailed a@b.co using sk-proj-abc123def456789012345"
+	mask := func(model string) string {
+		body := []byte(`{"model":"` + model + `","messages":[{"role":"user","content":"` + content + `"}]}`)
+		route, err := eng.FindRoute(body, nil)
+		if err != nil || route == nil {
+			t.Fatalf("FindRoute(%s) = %v, %v", model, route, err)
+		}
+		result, err := NewUniversalProvider(route, nil).applyRequestTransforms(newTestContext(), body)
+		if err != nil {
+			t.Fatalf("transforms failed: %v", err)
+		}
+		return gjson.GetBytes(result, "messages.0.content").String()
+	}
+
+	logged := mask("logger")
+	for _, secret := range []string{"EMP-004211", "a@b.co", "sk-proj-"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("logging route should mask %q, got %q", secret, logged)
+		}
+	}
+
+	translated := mask("translator")
+	if !strings.Contains(translated, "EMP-004211") || !strings.Contains(translated, "a@b.co") || strings.Contains(translated, "sk-proj-") {
+		t.Errorf("translate route should only mask the key, got %q", translated)
+	}
+}
+
+func TestSendFailsOnInvalidScannerConfig(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	// Without NewEngine the scanner is built on demand; a bad disabled rule must not fall back
+	// to the default rules and forward the request
+	route := &engine.Route{
+		ID:         "bad-scanner",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+		Scanner:    &engine.ScannerConfig{DisabledRules: []string{"No Such Rule"}},
+	}
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"mail a@b.co"}]}`)
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{}); err == nil {
+		t.Error("Send should fail when the route's scanner config is invalid")
+	}
+	if called {
+		t.Error("The request must not reach the upstream")
+	}
+}
+
+func TestSendPreservesUpstreamClientErrorStatus(t *testing.T) {
+	testCases := []struct {
+		upstream int
+		want     int
+	}{
+		{http.StatusTooManyRequests, http.StatusTooManyRequests},
+		{http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.StatusBadRequest, http.StatusBadRequest},
+		{http.StatusServiceUnavailable, http.StatusBadGateway},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strconv.Itoa(tc.upstream), func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.upstream)
+				w.Write([]byte(`{"error":{"message":"nope"}}`))
+			}))
+			defer upstream.Close()
+
+			p := NewUniversalProvider(&engine.Route{ID: "status", Upstream: engine.Upstream{BaseURL: upstream.URL}}, nil)
+			_, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+
+			if gwErr := core.AsGatewayError(err); gwErr.Status != tc.want {
+				t.Errorf("Status = %d, want %d", gwErr.Status, tc.want)
+			}
+			var statusErr *core.UpstreamStatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tc.upstream {
+				t.Errorf("Error should carry the upstream status %d, got %v", tc.upstream, err)
+			}
+		})
+	}
+}
+
+func TestSendRetriesTransientUpstreamFailures(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch attempts.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+		}
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{ID: "flaky", Upstream: engine.Upstream{BaseURL: upstream.URL, MaxRetries: 2, BackoffMs: 1}}
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+	if err != nil {
+		t.Fatalf("Send should succeed within the retry budget: %v", err)
+	}
+	if got := gjson.GetBytes(resp, "choices.0.message.content").String(); got != "ok" {
+		t.Errorf("Unexpected response: %s", resp)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("Attempts = %d, want 3", n)
+	}
+}
+
+func TestSendRetryLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		retries  int
+		attempts int32
+	}{
+		{"client error is not retried", http.StatusBadRequest, 3, 1},
+		{"auth error is not retried", http.StatusUnauthorized, 3, 1},
+		{"budget exhausted", http.StatusBadGateway, 2, 3},
+		{"retries disabled", http.StatusServiceUnavailable, 0, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tc.status)
+			}))
+			defer upstream.Close()
+
+			route := &engine.Route{ID: "retry", Upstream: engine.Upstream{BaseURL: upstream.URL, MaxRetries: tc.retries, BackoffMs: 1}}
+			if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{}); err == nil {
+				t.Fatal("Expected an error")
+			}
+			if n := attempts.Load(); n != tc.attempts {
+				t.Errorf("Attempts = %d, want %d", n, tc.attempts)
+			}
+		})
+	}
+}
+
+func TestSendRetryRespectsContextDeadline(t *testing.T) {
+	var attempts atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	deadline, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	ctx := core.NewGatewayContext(deadline, zap.NewNop())
+
+	// The backoff (>= 5s) cannot fit before the deadline, so no retry is attempted
+	route := &engine.Route{ID: "deadline", Upstream: engine.Upstream{BaseURL: upstream.URL, MaxRetries: 5, BackoffMs: 10000}}
+	start := time.Now()
+	if _, err := NewUniversalProvider(route, nil).Send(ctx, []byte(`{}`), http.Header{}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("Attempts = %d, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Send should give up early, took %v", elapsed)
+	}
+}
+
+func TestRetryDelayBounds(t *testing.T) {
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 10; attempt++ {
+		d := min(base<<attempt, maxRetryBackoff)
+		for i := 0; i < 20; i++ {
+			if got := retryDelay(base, attempt); got < d/2 || got > d {
+				t.Fatalf("retryDelay(%v, %d) = %v, want within [%v, %v]", base, attempt, got, d/2, d)
+			}
+		}
+	}
+}
+
+func TestSendUpstreamTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	route := &engine.Route{ID: "classify", Upstream: engine.Upstream{BaseURL: upstream.URL, TimeoutSeconds: 1}}
+	start := time.Now()
+	_, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{}`), http.Header{})
+
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Category != core.ErrCategoryTimeout {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Request should abort after about 1s, took %v", elapsed)
+	}
+}
+
+// newCountingServer starts an upstream that counts the TCP connections it accepts
+func newCountingServer(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	var conns atomic.Int64
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"}}]}`))
+	}))
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	upstream.Start()
+	tb.Cleanup(upstream.Close)
+	return upstream, &conns
+}
+
+func TestSendReusesPooledConnections(t *testing.T) {
+	upstream, conns := newCountingServer(t)
+	config := &engine.EngineConfig{Routes: []engine.Route{{ID: "pooled", Upstream: engine.Upstream{BaseURL: upstream.URL}}}}
+	if _, err := engine.NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	// One provider per request, as in the HTTP handler
+	for i := 0; i < 5; i++ {
+		p := NewUniversalProvider(&config.Routes[0], nil)
+		if _, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Upstream connections = %d, want 1 (keep-alive reuse)", n)
+	}
+}
+
+// BenchmarkSendKeepAlive compares a fresh client per request (the previous behavior) with the
+// pooled route client; conns/op shows how many TCP connections each request opened
+func BenchmarkSendKeepAlive(b *testing.B) {
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"hello"}]}`)
+
+	b.Run("fresh_client", func(b *testing.B) {
+		upstream, conns := newCountingServer(b)
+		route := &engine.Route{ID: "fresh", Upstream: engine.Upstream{BaseURL: upstream.URL}}
+		p := NewUniversalProvider(route, nil)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			client := &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+			if _, err := p.callUpstream(context.Background(), route.Upstream, client, body, http.Header{}); err != nil {
+				b.Fatal(err)
+			}
+			client.CloseIdleConnections()
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+
+	b.Run("pooled_client", func(b *testing.B) {
+		upstream, conns := newCountingServer(b)
+		config := &engine.EngineConfig{Routes: []engine.Route{{ID: "pooled", Upstream: engine.Upstream{BaseURL: upstream.URL}}}}
+		if _, err := engine.NewEngine(config); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p := NewUniversalProvider(&config.Routes[0], nil)
+			if _, err := p.Send(newTestContext(), body, http.Header{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+	})
+}
+
+func TestSendWeightedUpstreamsUseMatchingAuth(t *testing.T) {
+	t.Setenv("AIGIS_TEST_KEY_A", "key-a")
+	t.Setenv("AIGIS_TEST_KEY_B", "key-b")
+
+	var mu sync.Mutex
+	seen := make(map[string]map[string]int) // upstream -> Authorization -> count
+	newUpstream := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if seen[name] == nil {
+				seen[name] = make(map[string]int)
+			}
+			seen[name][r.Header.Get("Authorization")]++
+			mu.Unlock()
+			w.Write([]byte(`{"choices":[]}`))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	a, b := newUpstream("a"), newUpstream("b")
+
+	config := &engine.EngineConfig{Routes: []engine.Route{{
+		ID: "balanced",
+		Upstreams: []engine.WeightedUpstream{
+			{Upstream: engine.Upstream{BaseURL: a.URL, AuthStrategy: engine.AuthStrategyBearer, TokenEnv: "AIGIS_TEST_KEY_A"}, Weight: 1},
+			{Upstream: engine.Upstream{BaseURL: b.URL, AuthStrategy: engine.AuthStrategyBearer, TokenEnv: "AIGIS_TEST_KEY_B"}, Weight: 1},
+		},
+	}}}
+	if _, err := engine.NewEngine(config); err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		p := NewUniversalProvider(&config.Routes[0], nil)
+		if _, err := p.Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	for name, want := range map[string]string{"a": "Bearer key-a", "b": "Bearer key-b"} {
+		if len(seen[name]) != 1 || seen[name][want] == 0 {
+			t.Errorf("Upstream %s received Authorization %v, want only %q", name, seen[name], want)
+		}
+	}
+}
+
+func TestSendFallsBackOnUpstreamFailure(t *testing.T) {
+	t.Setenv("AIGIS_TEST_PRIMARY_KEY", "primary-key")
+	t.Setenv("AIGIS_TEST_FALLBACK_KEY", "fallback-key")
+
+	var fallbackAuth atomic.Value
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackAuth.Store(r.Header.Get("x-api-key"))
+		w.Write([]byte(`{"choices":[{"message":{"content":"from fallback"}}]}`))
+	}))
+	defer fallback.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close() // connection refused
+
+	fallbackUpstream := &engine.Upstream{
+		BaseURL:      fallback.URL,
+		AuthStrategy: engine.AuthStrategyHeader,
+		HeaderName:   "x-api-key",
+		TokenEnv:     "AIGIS_TEST_FALLBACK_KEY",
+	}
+	for name, primaryURL := range map[string]string{"connection error": down.URL, "5xx": unavailable.URL} {
+		t.Run(name, func(t *testing.T) {
+			route := &engine.Route{
+				ID:       "fallback",
+				Upstream: engine.Upstream{BaseURL: primaryURL, AuthStrategy: engine.AuthStrategyBearer, TokenEnv: "AIGIS_TEST_PRIMARY_KEY"},
+				Fallback: fallbackUpstream,
+			}
+			resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+			if err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			if !strings.Contains(string(resp), "from fallback") {
+				t.Errorf("Expected the fallback response, got %s", resp)
+			}
+			if got := fallbackAuth.Load(); got != "fallback-key" {
+				t.Errorf("Fallback auth = %v, want its own token", got)
+			}
+		})
+	}
+}
+
+func TestSendFallbackBothDownAndClientErrors(t *testing.T) {
+	var fallbackCalls atomic.Int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallbackCalls.Add(1)
+		http.Error(w, "also down", http.StatusBadGateway)
+	}))
+	defer fallback.Close()
+	badRequest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"bad"}}`, http.StatusBadRequest)
+	}))
+	defer badRequest.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	route := &engine.Route{
+		ID:       "both-down",
+		Upstream: engine.Upstream{BaseURL: down.URL},
+		Fallback: &engine.Upstream{BaseURL: fallback.URL},
+	}
+	_, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Status != http.StatusBadGateway {
+		t.Errorf("Expected a 502 when both upstreams fail, got %v", err)
+	}
+	if fallbackCalls.Load() != 1 {
+		t.Errorf("Fallback calls = %d, want 1", fallbackCalls.Load())
+	}
+
+	// 4xx responses are the client's fault and are not retried on the fallback
+	route.Upstream = engine.Upstream{BaseURL: badRequest.URL}
+	_, err = NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{})
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Status != http.StatusBadRequest {
+		t.Errorf("Expected the upstream 400, got %v", err)
+	}
+	if fallbackCalls.Load() != 1 {
+		t.Errorf("Fallback should not be called for a 4xx, calls = %d", fallbackCalls.Load())
+	}
+}
+
+func TestSendQueryAuthParamName(t *testing.T) {
+	t.Setenv("AIGIS_TEST_QUERY_KEY", "q-token")
+	queries := make(chan url.Values, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	for param, want := range map[string]string{"": "api_key", "key": "key"} {
+		route := &engine.Route{ID: "query", Upstream: engine.Upstream{
+			BaseURL:      upstream.URL,
+			Path:         "/v1/models/gemini:generateContent?alt=json",
+			AuthStrategy: engine.AuthStrategyQuery,
+			TokenEnv:     "AIGIS_TEST_QUERY_KEY",
+			QueryParam:   param,
+		}}
+		if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gemini"}`), http.Header{}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+
+		query := <-queries
+		if got := query.Get(want); got != "q-token" {
+			t.Errorf("query_param %q: %s = %q, want the token (query %v)", param, want, got, query)
+		}
+		if query.Get("alt") != "json" {
+			t.Errorf("Existing query parameters should be kept, got %v", query)
+		}
+		if param != "" && query.Has("api_key") {
+			t.Errorf("Token should not also be sent as api_key, got %v", query)
+		}
+	}
+}
+
+func TestSendAuthStrategyNoneAddsNoCredentials(t *testing.T) {
+	t.Setenv("AIGIS_TEST_NONE_KEY", "should-not-be-sent")
+	auth := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth <- r.Header.Get("Authorization")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{ID: "none", Upstream: engine.Upstream{
+		BaseURL:      upstream.URL,
+		AuthStrategy: engine.AuthStrategyNone,
+		TokenEnv:     "AIGIS_TEST_NONE_KEY",
+	}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := <-auth; got != "" {
+		t.Errorf("auth_strategy none should not send credentials, got Authorization %q", got)
+	}
+}
+
+func TestSendResolvesEnvInUpstreamFields(t *testing.T) {
+	paths := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path + " " + r.Header.Get("api-key")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("AIGIS_TEST_BASE_URL", upstream.URL)
+	t.Setenv("AIGIS_TEST_DEPLOYMENT_PATH", "/deployments/gpt-4o/chat/completions")
+	t.Setenv("AIGIS_TEST_AUTH_HEADER", "api-key")
+	t.Setenv("AIGIS_TEST_AZURE_KEY", "azure-secret")
+
+	route := &engine.Route{ID: "env", Upstream: engine.Upstream{
+		BaseURL:      "env:AIGIS_TEST_BASE_URL",
+		Path:         "env:AIGIS_TEST_DEPLOYMENT_PATH",
+		AuthStrategy: engine.AuthStrategyHeader,
+		HeaderName:   "env:AIGIS_TEST_AUTH_HEADER",
+		TokenEnv:     "AIGIS_TEST_AZURE_KEY",
+	}}
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4o"}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if got := <-paths; got != "/deployments/gpt-4o/chat/completions azure-secret" {
+		t.Errorf("Upstream received %q", got)
+	}
+
+	// An unset base URL variable fails clearly instead of sending to a malformed URL
+	route.Upstream.BaseURL = "env:AIGIS_TEST_UNSET_BASE_URL"
+	_, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4o"}`), http.Header{})
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Category != core.ErrCategoryInternal {
+		t.Errorf("Expected an internal configuration error, got %v", err)
+	}
+}
+
+func TestVaultOverflowFailsRequest(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "capped",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePII}},
+	}
+	body := []byte(`{"messages":[{"role":"user","content":"a@corp.io b@corp.io c@corp.io"}]}`)
+
+	ctx := newTestContext()
+	ctx.SetVaultLimit(2, true)
+	_, err := NewUniversalProvider(route, nil).Send(ctx, body, http.Header{})
+	if gwErr := core.AsGatewayError(err); gwErr == nil || gwErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a 413 error, got %v", err)
+	}
+	if called {
+		t.Error("the upstream must not be called when the vault overflows in fail mode")
+	}
+}
+
+func TestPassthroughAuthForwardsClientKey(t *testing.T) {
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Values("Authorization")
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("AIGIS_TEST_GATEWAY_TOKEN", "sk-gateway")
+	route := &engine.Route{
+		ID: "passthrough",
+		Upstream: engine.Upstream{
+			BaseURL:      upstream.URL,
+			AuthStrategy: engine.AuthStrategyPassthrough,
+			TokenEnv:     "AIGIS_TEST_GATEWAY_TOKEN", // ignored by passthrough
+		},
+		HeaderPolicy: engine.HeaderPolicy{
+			Allow:  []string{"Authorization"},
+			Remove: []string{"Authorization"},
+		},
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer sk-client-123")
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), headers); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(received) != 1 || received[0] != "Bearer sk-client-123" {
+		t.Errorf("upstream Authorization = %q, want the client's key verbatim", received)
+	}
+
+	// Without a client key no credentials are sent
+	received = nil
+	if _, err := NewUniversalProvider(route, nil).Send(newTestContext(), []byte(`{"model":"gpt-4"}`), http.Header{}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("upstream Authorization = %q, want none", received)
+	}
+}
+
+func TestClaudePIIToolResultAndInputTextBlocks(t *testing.T) {
+	var upstreamBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Reply with a text block and a tool_use block echoing the masked tool result
+		upstreamBody, _ = io.ReadAll(r.Body)
+		echoed := gjson.GetBytes(upstreamBody, "messages.1.content.0.content").String()
+		resp, _ := json.Marshal(map[string]any{
+			"type": "message",
+			"content": []any{
+				map[string]any{"type": "text", "text": "Forwarding " + echoed},
+				map[string]any{"type": "tool_use", "id": "toolu_2", "name": "send", "input": map[string]any{"to": echoed}},
+			},
+		})
+		w.Write(resp)
+	}))
+	defer upstream.Close()
+
+	route := &engine.Route{
+		ID:         "claude",
+		Upstream:   engine.Upstream{BaseURL: upstream.URL, Path: "/messages"},
+		Transforms: []engine.TransformStep{{Type: engine.TransformTypePIIClaude}},
+	}
+	body := []byte(`{
+		"model": "claude-3-5-sonnet",
+		"messages": [
+			{"role": "user", "content": [{"type": "input_text", "text": "Look up carol@corp.io"}]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "alice@corp.io"},
+				{"type": "tool_result", "tool_use_id": "toolu_3", "content": [
+					{"type": "text", "text": "manager: bob@corp.io"},
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aW1n"}}
+				]}
+			]}
+		]
+	}`)
+	resp, err := NewUniversalProvider(route, nil).Send(newTestContext(), body, http.Header{})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for _, secret := range []string{"carol@corp.io", "alice@corp.io", "bob@corp.io"} {
+		if strings.Contains(string(upstreamBody), secret) {
+			t.Errorf("Upstream received unmasked %s: %s", secret, upstreamBody)
+		}
+	}
+	if got := gjson.GetBytes(upstreamBody, "messages.1.content.1.content.1.source.data").String(); got != "aW1n" {
+		t.Errorf("Non-text blocks should be untouched, got data %q", got)
+	}
+
+	if got := gjson.GetBytes(resp, "content.0.text").String(); got != "Forwarding alice@corp.io" {
+		t.Errorf("text block = %q", got)
+	}
+	if got := gjson.GetBytes(resp, "content.1.input.to").String(); got != "alice@corp.io" {
+		t.Errorf("tool_use input = %q", got)
+	}
+}
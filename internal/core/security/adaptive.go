@@ -0,0 +1,71 @@
+package security
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// adaptiveOrdering 记录自适应排序的配置与扫描计数
+type adaptiveOrdering struct {
+	enabled atomic.Bool
+	every   atomic.Int64 // 每多少次扫描重排一次
+	scans   atomic.Int64
+}
+
+// EnableAdaptiveOrdering 开启自适应规则排序（可选）
+// 每 every 次扫描后，按命中次数在各自层级 (Tier) 内重新排序规则，命中越多越靠前。
+// 层级之间的顺序保持不变；同一层级内的规则互不重叠，因此输出与固定顺序一致。
+func (s *Scanner) EnableAdaptiveOrdering(every int) {
+	if every <= 0 {
+		every = 1000
+	}
+	s.adaptive.every.Store(int64(every))
+	s.adaptive.enabled.Store(true)
+}
+
+// snapshot 返回当前规则切片的快照（只读）
+func (s *Scanner) snapshot() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rules
+}
+
+// recordHit 记录一次规则命中（仅在自适应模式下计数）
+func (s *Scanner) recordHit(rule Rule) {
+	if s.adaptive.enabled.Load() && rule.hits != nil {
+		rule.hits.Add(1)
+	}
+}
+
+// scanDone 在一次完整扫描结束后调用，达到间隔时触发重排
+func (s *Scanner) scanDone() {
+	if !s.adaptive.enabled.Load() {
+		return
+	}
+	if s.adaptive.scans.Add(1)%s.adaptive.every.Load() == 0 {
+		s.reorder()
+	}
+}
+
+// reorder 在层级内按命中次数降序重排规则（稳定排序，命中相同则保持原顺序）
+func (s *Scanner) reorder() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]Rule, len(s.rules))
+	copy(rules, s.rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Tier != rules[j].Tier {
+			return rules[i].Tier < rules[j].Tier
+		}
+		return hitCount(rules[i]) > hitCount(rules[j])
+	})
+	s.rules = rules
+}
+
+func hitCount(rule Rule) int64 {
+	if rule.hits == nil {
+		return 0
+	}
+	return rule.hits.Load()
+}
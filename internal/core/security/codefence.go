@@ -0,0 +1,52 @@
+package security
+
+import "strings"
+
+// codeFence 是代码围栏的标记
+const codeFence = "```"
+
+// codeFenceRanges 返回输入中闭合的 ``` 围栏代码块的字节区间 [start, end)，包含两端的围栏行
+// 开始围栏是去掉行首空白后以 ``` 开头的行（可带语言标识，如 ```go），结束围栏是只含反引号的行。
+// 未闭合的围栏不算代码块：否则一个多余的 ``` 就能让其后的所有内容跳过脱敏
+func codeFenceRanges(input string) [][2]int {
+	var ranges [][2]int
+	open := -1
+	for start := 0; start < len(input); {
+		end := len(input)
+		if i := strings.IndexByte(input[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		line := strings.TrimSpace(input[start:end])
+		switch {
+		case open < 0 && strings.HasPrefix(line, codeFence):
+			open = start
+		case open >= 0 && strings.HasPrefix(line, codeFence) && strings.Trim(line, "`") == "":
+			ranges = append(ranges, [2]int{open, end})
+			open = -1
+		}
+		start = end
+	}
+	return ranges
+}
+
+// excludeCodeFences 去掉完全位于围栏代码块内的区间；跨越围栏边界的匹配照常脱敏
+func excludeCodeFences(input string, spans []scanSpan) []scanSpan {
+	if len(spans) == 0 || !strings.Contains(input, codeFence) {
+		return spans
+	}
+	fences := codeFenceRanges(input)
+	kept := spans[:0]
+	for _, span := range spans {
+		inside := false
+		for _, fence := range fences {
+			if fence[0] <= span.start && span.end <= fence[1] {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept = append(kept, span)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,49 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMaskSkipsCodeFences(t *testing.T) {
+	scanner := NewScanner()
+	input := "Is this key format valid?\n```python\nclient = OpenAI(api_key=\"sk-12345678901234567890\")\n```\nMy real key is sk-abcdefghijabcdefghij"
+
+	result := scanner.MaskWithOptions(nil, input, nil, MaskOptions{SkipCodeFences: true})
+	if !strings.Contains(result, "sk-12345678901234567890") {
+		t.Errorf("Key inside the code fence should be kept, got: %s", result)
+	}
+	if strings.Contains(result, "sk-abcdefghijabcdefghij") {
+		t.Errorf("Key outside the code fence should be masked, got: %s", result)
+	}
+
+	// Without the option both keys are masked
+	result = scanner.Mask(nil, input, nil)
+	if strings.Contains(result, "sk-12345678901234567890") || strings.Contains(result, "sk-abcdefghijabcdefghij") {
+		t.Errorf("Mask should mask code fences by default, got: %s", result)
+	}
+}
+
+func TestMaskSkipCodeFencesIgnoresUnclosedFence(t *testing.T) {
+	scanner := NewScanner()
+	input := "```\nsk-12345678901234567890\n```\nthen a stray fence\n```\nsk-abcdefghijabcdefghij"
+
+	result := scanner.MaskWithOptions(nil, input, nil, MaskOptions{SkipCodeFences: true})
+	if !strings.Contains(result, "sk-12345678901234567890") {
+		t.Errorf("Key inside the closed fence should be kept, got: %s", result)
+	}
+	if strings.Contains(result, "sk-abcdefghijabcdefghij") {
+		t.Errorf("An unclosed fence must not exempt the rest of the text, got: %s", result)
+	}
+}
+
+func TestCodeFenceRanges(t *testing.T) {
+	input := "text\n  ```go\nx := 1\n```go is not a closing fence\n````\nafter"
+	ranges := codeFenceRanges(input)
+	if len(ranges) != 1 {
+		t.Fatalf("Expected one fence, got %v", ranges)
+	}
+	if got := input[ranges[0][0]:ranges[0][1]]; got != "  ```go\nx := 1\n```go is not a closing fence\n````\n" {
+		t.Errorf("Fence covers %q", got)
+	}
+}
@@ -0,0 +1,97 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 部分脱敏模板中的变量
+const (
+	partialVarFirst  = "{first}"  // 匹配内容的第一个字符
+	partialVarLast4  = "{last4}"  // 匹配内容的最后 4 个字符
+	partialVarDomain = "{domain}" // 最后一个 @ 之后的部分（邮箱域名），没有 @ 时为空
+)
+
+// partialEmail 保留邮箱首字符和域名：john@example.com -> j***@example.com
+func partialEmail(match string) string {
+	at := strings.LastIndexByte(match, '@')
+	if at <= 0 {
+		return "***"
+	}
+	return match[:1] + "***" + match[at:]
+}
+
+// partialKeepLast4 将除最后 4 位以外的数字替换为 *，保留空格、横线等分隔符
+// 4111-1111-1111-1234 -> ****-****-****-1234
+func partialKeepLast4(match string) string {
+	digits := 0
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			digits++
+		}
+	}
+	out := []byte(match)
+	for i := range out {
+		if out[i] >= '0' && out[i] <= '9' {
+			if digits > 4 {
+				out[i] = '*'
+			}
+			digits--
+		}
+	}
+	return string(out)
+}
+
+// compilePartialFormat 将部分脱敏模板编译为替换函数，模板为空时返回 nil
+// 例如 "{first}***@{domain}" 或 "****-****-****-{last4}"
+func compilePartialFormat(format string) (func(string) string, error) {
+	if format == "" {
+		return nil, nil
+	}
+	if !strings.Contains(format, partialVarFirst) && !strings.Contains(format, partialVarLast4) && !strings.Contains(format, partialVarDomain) {
+		return nil, fmt.Errorf("partial format %q uses none of %s, %s, %s", format, partialVarFirst, partialVarLast4, partialVarDomain)
+	}
+	return func(match string) string {
+		first, last4, domain := "", match, ""
+		if match != "" {
+			first = match[:1]
+		}
+		if len(match) > 4 {
+			last4 = match[len(match)-4:]
+		}
+		if at := strings.LastIndexByte(match, '@'); at >= 0 {
+			domain = match[at+1:]
+		}
+		return strings.NewReplacer(partialVarFirst, first, partialVarLast4, last4, partialVarDomain, domain).Replace(format)
+	}, nil
+}
+
+// SetPartialFormat 为指定规则设置部分脱敏模板，供 SanitizePartial 使用（空字符串表示取消）
+func (s *Scanner) SetPartialFormat(name string, format string) error {
+	partial, err := compilePartialFormat(format)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]Rule, len(s.rules))
+	copy(rules, s.rules)
+	for i := range rules {
+		if rules[i].Name == name {
+			rules[i].Partial = partial
+			s.rules = rules
+			return nil
+		}
+	}
+	return fmt.Errorf("rule %q not found", name)
+}
+
+// SanitizePartial 与 Sanitize 相同，但设置了 Partial 的规则只做部分脱敏，保留格式便于排查问题
+// （如 j***@example.com、****-****-****-1234），其余规则仍使用 Replacement
+// 结果不写入保险库，也无法还原
+func (s *Scanner) SanitizePartial(input string) string {
+	result, _ := s.sanitize(input, nil, nil, true, false)
+	return result
+}
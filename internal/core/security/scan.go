@@ -0,0 +1,92 @@
+package security
+
+import (
+	"sort"
+	"strings"
+)
+
+// scanSpan 是一次扫描中被采纳的替换区间，rule 为规则在快照中的下标
+type scanSpan struct {
+	start, end int
+	rule       int
+}
+
+// scanRules 在原始输入上收集所有规则的匹配，并按规则顺序（优先级）解决冲突：
+// 前面的规则先占用区间，后面规则与已占用区间重叠的匹配被丢弃。
+// 所有规则都只匹配原文，因此不会出现后面的规则匹配到前面规则替换结果的情况。
+// apply 返回 false 的规则不参与扫描；accept 返回 false 的匹配（校验失败、白名单等）不占用区间。
+// 返回的区间互不重叠，按起始位置排序
+func scanRules(rules []Rule, input string, apply func(Rule) bool, accept func(rule Rule, text string) bool) []scanSpan {
+	var spans []scanSpan
+	for i, rule := range rules {
+		if !apply(rule) {
+			continue
+		}
+		for _, m := range rule.matchIndexes(input) {
+			start, end := m[0], m[1]
+			// 空匹配没有可脱敏的内容
+			if start < 0 || start == end {
+				continue
+			}
+			pos := sort.Search(len(spans), func(j int) bool { return spans[j].end > start })
+			if pos < len(spans) && spans[pos].start < end {
+				continue // 与优先级更高的规则重叠
+			}
+			if !accept(rule, input[start:end]) {
+				continue
+			}
+			spans = append(spans, scanSpan{})
+			copy(spans[pos+1:], spans[pos:])
+			spans[pos] = scanSpan{start: start, end: end, rule: i}
+		}
+	}
+	return spans
+}
+
+// matchIndexes 返回规则在输入中所有匹配的 [start, end]；设置了 Group 时为捕获组的位置
+// 捕获组未参与匹配时对应位置为 -1
+func (r Rule) matchIndexes(input string) [][]int {
+	if r.Group == 0 {
+		return r.Pattern.FindAllStringIndex(input, -1)
+	}
+	matches := r.Pattern.FindAllStringSubmatchIndex(input, -1)
+	indexes := make([][]int, 0, len(matches))
+	for _, m := range matches {
+		if 2*r.Group+1 >= len(m) {
+			return nil // 规则声明的捕获组不存在
+		}
+		indexes = append(indexes, m[2*r.Group:2*r.Group+2])
+	}
+	return indexes
+}
+
+// rebuild 按区间一次性拼接输出，区间外的内容原样保留
+func rebuild(input string, spans []scanSpan, replace func(span scanSpan, text string) string) string {
+	if len(spans) == 0 {
+		return input
+	}
+	var b strings.Builder
+	b.Grow(len(input))
+	last := 0
+	for _, span := range spans {
+		b.WriteString(input[last:span.start])
+		b.WriteString(replace(span, input[span.start:span.end]))
+		last = span.end
+	}
+	b.WriteString(input[last:])
+	return b.String()
+}
+
+// recordHits 为本次扫描中命中的每条规则记录一次命中
+func (s *Scanner) recordHits(rules []Rule, spans []scanSpan) {
+	if len(spans) == 0 || !s.adaptive.enabled.Load() {
+		return
+	}
+	hit := make([]bool, len(rules))
+	for _, span := range spans {
+		if !hit[span.rule] {
+			hit[span.rule] = true
+			s.recordHit(rules[span.rule])
+		}
+	}
+}
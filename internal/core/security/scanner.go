@@ -4,7 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Rule 定义了敏感信息检测规则
@@ -12,11 +16,53 @@ type Rule struct {
 	Name        string
 	Pattern     *regexp.Regexp
 	Replacement string
+	// MaskFormat 是保留格式脱敏时使用的占位符模板（为空则使用 __AIGIS_SEC_ 占位符）
+	// 支持 {hash}（12 位十六进制）和 {digits}（8 位数字），均由原值的哈希派生
+	MaskFormat string
+	// OptIn 为 true 的规则默认不启用，需通过 EnableRule 或在 tags 中显式指定规则名
+	OptIn bool
+	// Group 是敏感部分所在的捕获组序号（0 表示整个匹配）
+	// 大于 0 时只替换/存储该捕获组的内容，匹配的其余部分保持不变（如 KEY=value 只脱敏 value）
+	Group int
+	// Validate 是可选的匹配后校验（如 Luhn 校验），返回 false 时该匹配不做替换
+	// 设置了 Group 时校验的是捕获组的内容
+	Validate func(string) bool
+	// Partial 是 SanitizePartial 使用的部分脱敏函数（为空则使用 Replacement），参见 SetPartialFormat
+	Partial func(string) string
+	// Tier 是规则的优先级层级（越小越先执行）
+	// 自适应排序只会在同一层级内调整顺序；重叠的匹配按规则顺序取舍，
+	// 因此匹配可能相互重叠的规则必须各占一层，否则重排会改变脱敏结果
+	Tier int
+
+	formatPattern *regexp.Regexp // 由 MaskFormat 编译而来，用于 Unmask 识别
+	hits          *atomic.Int64  // 命中次数（按扫描计），用于自适应排序
+}
+
+// 格式模板中的变量及其匹配模式
+const (
+	formatVarHash   = "{hash}"
+	formatVarDigits = "{digits}"
+)
+
+// placeholderPattern 匹配 Mask 生成的占位符
+var placeholderPattern = regexp.MustCompile(`__AIGIS_SEC_[0-9a-f]{12}__`)
+
+// UnmaskStats 记录一次 Unmask 的占位符统计
+type UnmaskStats struct {
+	Found    int // 发现的占位符数量
+	Restored int // 从 vault 中成功还原的数量 (hit)
+	Missed   int // vault 中不存在、原样保留的数量 (miss)
 }
 
 // Scanner 扫描并清理文本中的敏感信息
 type Scanner struct {
+	// rules 采用写时复制：修改时整体替换切片，扫描时持有快照即可无锁遍历
+	mu    sync.RWMutex
 	rules []Rule
+	// allowlist 中的值即使被规则匹配也不会被脱敏（精确匹配，同样写时复制）
+	allowlist map[string]struct{}
+
+	adaptive adaptiveOrdering
 }
 
 // NewScanner 创建一个新的 Scanner 实例，内置所有检测规则
@@ -61,35 +107,219 @@ func NewScanner() *Scanner {
 		Replacement: "[GOOGLE_KEY_REDACTED]",
 	})
 
-	// 6. Email - 更精确的模式，需要在电话之前匹配
+	// 6. URL Credentials - 只脱敏 URL 中的密码部分（捕获组 1），保留协议、用户名和主机
+	// 例如 postgres://admin:s3cret@db:5432 -> postgres://admin:<placeholder>@db:5432
+	// 需要在 Email 之前执行，否则 "s3cret@db.host" 会被当作邮箱
+	scanner.rules = append(scanner.rules, Rule{
+		Name:        "URL Credentials",
+		Pattern:     regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s:/@]+:([^\s@/]+)@`),
+		Replacement: "[URL_PASSWORD_REDACTED]",
+		Group:       1,
+		Tier:        1,
+	})
+
+	// 7. Env Secret - KEY=value 形式的环境变量，只脱敏 value（捕获组 1）
+	// 变量名需包含 SECRET/TOKEN/PASSWORD 等关键词的完整片段，避免误伤 MAX_TOKENS=100 之类
+	scanner.rules = append(scanner.rules, Rule{
+		Name:        "Env Secret",
+		Pattern:     regexp.MustCompile(`\b(?:[A-Z0-9]+_)*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_KEY|ACCESS_KEY)(?:_[A-Z0-9]+)*\s*=\s*["']?([^\s"']+)`),
+		Replacement: "[ENV_SECRET_REDACTED]",
+		Group:       1,
+		Tier:        2,
+	})
+
+	// 8. Email - 更精确的模式，需要在电话之前匹配
 	scanner.rules = append(scanner.rules, Rule{
 		Name:        "Email",
 		Pattern:     regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`),
 		Replacement: "[EMAIL_REDACTED]",
+		MaskFormat:  "redacted+{hash}@example.com",
+		Partial:     partialEmail,
+		Tier:        3,
 	})
 
-	// 7. Mobile Phone - 放在最后
+	// 9. Credit Card - 13-19 位数字，允许空格或横线分隔
+	// 正则无法表达 Luhn 校验，因此通过 Validate 过滤掉订单号等随机长数字
+	// 需要在电话规则之前执行，避免卡号中的片段被当作电话
+	scanner.rules = append(scanner.rules, Rule{
+		Name:        "Credit Card",
+		Pattern:     regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Replacement: "[CC_REDACTED]",
+		Validate:    luhnValid,
+		Partial:     partialKeepLast4,
+		Tier:        4,
+	})
+
+	// 10. Mobile Phone - 放在最后
 	// 中国手机号：13x, 14x, 15x, 16x, 17x, 18x, 19x 开头，11位
 	// 使用 word boundary 避免匹配密钥中的内部数字
 	scanner.rules = append(scanner.rules, Rule{
 		Name:        "Mobile Phone",
 		Pattern:     regexp.MustCompile(`\b(?:\+?86)?\s*(?:1[3-9]\d{9})\b`),
 		Replacement: "[PHONE_REDACTED]",
+		MaskFormat:  "199{digits}",
+		Tier:        5,
 	})
 
+	// 11. US Phone (NANP) - 可选规则，需显式启用；与手机号规则分层，自适应排序不会调换它们
+	// 区号与交换码首位为 2-9；要求分隔符，避免把任意 10 位数字（订单号等）当作电话
+	// 匹配：(415) 555-0132、415-555-0132、415.555.0132、+1 415 555 0132
+	scanner.rules = append(scanner.rules, Rule{
+		Name:        "US Phone",
+		Pattern:     regexp.MustCompile(`(?:\+?1[\s.-]?)?(?:\([2-9]\d{2}\)\s?|\b[2-9]\d{2}[\s.-])[2-9]\d{2}[\s.-]\d{4}\b`),
+		Replacement: "[PHONE_REDACTED]",
+		OptIn:       true,
+		Tier:        6,
+	})
+
+	// 12. International Phone (E.164) - 可选规则，需显式启用
+	// 最通用的电话规则，单独放在最后一层，+86/+1 号码优先由上面更具体的规则处理
+	// 必须以 + 开头，国家码首位非 0，总位数 8-15，允许空格/点/横线分组
+	// 匹配：+44 20 7946 0958、+49-30-1234567、+8613800138000
+	scanner.rules = append(scanner.rules, Rule{
+		Name:        "International Phone",
+		Pattern:     regexp.MustCompile(`\+[1-9](?:[\s.-]?\d){7,14}\b`),
+		Replacement: "[PHONE_REDACTED]",
+		OptIn:       true,
+		Tier:        7,
+	})
+
+	// 只有各类密钥规则（层级 0）互不重叠，可以安全地按命中率重排；
+	// 其余规则（如邮箱与卡号、URL 凭据与环境变量）可能匹配同一段文本，各占一层
+	for i := range scanner.rules {
+		scanner.rules[i].formatPattern = compileMaskFormat(scanner.rules[i].MaskFormat)
+		scanner.rules[i].hits = new(atomic.Int64)
+	}
+
 	return scanner
 }
 
+// Match 描述 SanitizeWithReport 替换掉的一处敏感信息
+type Match struct {
+	Rule  string // 命中的规则名
+	Value string // 被替换的原值
+	// Start、End 是原值在输入中的字节偏移 [Start, End)
+	Start int
+	End   int
+}
+
 // Sanitize 清理文本中的所有敏感信息
-// 按顺序应用所有规则，返回清理后的文本
+// 所有规则在原文上一次性匹配，按规则顺序解决重叠后统一替换，返回清理后的文本
 func (s *Scanner) Sanitize(input string) string {
-	result := input
-	for _, rule := range s.rules {
-		result = rule.Pattern.ReplaceAllString(result, rule.Replacement)
+	result, _ := s.sanitize(input, nil, nil, false, false)
+	return result
+}
+
+// SanitizeWithReport 与 Sanitize 相同，同时按出现顺序返回每一处被替换的内容
+// 报告中含有敏感原值，调用方不应原样记录或返回给客户端
+func (s *Scanner) SanitizeWithReport(input string) (string, []Match) {
+	return s.sanitize(input, nil, nil, false, true)
+}
+
+// SanitizeWithReportTags 与 SanitizeWithReport 相同，但与 Mask 一样只使用 tags 选中的规则
+// （tags 为空时使用所有非可选规则），用于报告某个路由实际会脱敏的内容
+func (s *Scanner) SanitizeWithReportTags(input string, tags []string) (string, []Match) {
+	return s.sanitize(input, tags, nil, false, true)
+}
+
+// Redact 与 Sanitize 相同，但保留 ctx 保险库中已有的占位符（格式保持占位符本身也会命中规则），
+// 用于清理模型在响应中自行生成的敏感信息，之后仍可正常还原
+func (s *Scanner) Redact(ctx interface{}, input string) string {
+	type vaultContext interface {
+		VaultGet(placeholder string) (string, bool)
+	}
+	var keep func(match string) bool
+	if vaultCtx, ok := ctx.(vaultContext); ok {
+		keep = func(match string) bool {
+			_, found := vaultCtx.VaultGet(match)
+			return found
+		}
 	}
+	result, _ := s.sanitize(input, nil, keep, false, false)
 	return result
 }
 
+// sanitize 替换 tags 选中的规则（见 selectRule）命中的内容，keep 返回 true 的匹配保持原样；
+// partial 为 true 时优先使用规则的 Partial，report 为 true 时同时返回每一处替换的 Match
+func (s *Scanner) sanitize(input string, tags []string, keep func(match string) bool, partial bool, report bool) (string, []Match) {
+	allowlist := s.allowlistSnapshot()
+	rules := s.snapshot()
+	spans := scanRules(rules, input,
+		func(rule Rule) bool { return selectRule(rule, tags) },
+		func(rule Rule, match string) bool {
+			if _, allowed := allowlist[match]; allowed {
+				return false
+			}
+			if keep != nil && keep(match) {
+				return false
+			}
+			return rule.valid(match)
+		},
+	)
+	var matches []Match
+	if report {
+		matches = make([]Match, 0, len(spans))
+		for _, span := range spans {
+			matches = append(matches, Match{
+				Rule:  rules[span.rule].Name,
+				Value: input[span.start:span.end],
+				Start: span.start,
+				End:   span.end,
+			})
+		}
+	}
+	result := rebuild(input, spans, func(span scanSpan, match string) string {
+		if rule := rules[span.rule]; partial && rule.Partial != nil {
+			return rule.Partial(match)
+		}
+		return rules[span.rule].Replacement
+	})
+	s.recordHits(rules, spans)
+	s.scanDone()
+	return result, matches
+}
+
+// selectRule 判断规则是否被 tags 选中：tags 为空时选中所有非可选规则，"all" 同样不含可选规则，
+// 可选规则只有在 tags 中显式写出规则名时才会使用
+func selectRule(rule Rule, tags []string) bool {
+	if len(tags) == 0 && !rule.OptIn {
+		return true
+	}
+	for _, tag := range tags {
+		if (tag == "all" && !rule.OptIn) || tag == rule.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// valid 对匹配内容执行规则的 Validate 校验（未设置时总是通过）
+func (r Rule) valid(text string) bool {
+	return r.Validate == nil || r.Validate(text)
+}
+
+// luhnValid 对文本中的数字做 Luhn 校验（忽略空格和横线等分隔符）
+func luhnValid(text string) bool {
+	sum := 0
+	digits := 0
+	for i := len(text) - 1; i >= 0; i-- {
+		c := text[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
 // generatePlaceholder generates a unique placeholder for a secret using SHA256 hash
 // Format: __AIGIS_SEC_<first 12 chars of SHA256>__
 // Using hash ensures the same secret always gets the same placeholder within the request
@@ -99,54 +329,183 @@ func generatePlaceholder(original string) string {
 	return fmt.Sprintf("__AIGIS_SEC_%s__", hashHex)
 }
 
+// generateFormatPlaceholder fills a rule's MaskFormat template with values derived from the
+// SHA256 of the original, so the placeholder keeps the shape of the data (e.g. still an email)
+func generateFormatPlaceholder(format, original string) string {
+	hash := sha256.Sum256([]byte(original))
+	hashHex := hex.EncodeToString(hash[:])
+	digits := fmt.Sprintf("%08d", new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), big.NewInt(100000000)))
+	result := strings.ReplaceAll(format, formatVarHash, hashHex[:12])
+	return strings.ReplaceAll(result, formatVarDigits, digits)
+}
+
+// compileMaskFormat 将格式模板编译为匹配其占位符的正则，模板为空时返回 nil
+func compileMaskFormat(format string) *regexp.Regexp {
+	if format == "" {
+		return nil
+	}
+	pattern := regexp.QuoteMeta(format)
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(formatVarHash), `[0-9a-f]{12}`)
+	pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(formatVarDigits), `\d{8}`)
+	// 模板首尾为单词字符时加上边界，避免匹配到更长数字/单词的一部分
+	if isWordByte(format[0]) || strings.HasPrefix(format, formatVarHash) || strings.HasPrefix(format, formatVarDigits) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(format[len(format)-1]) || strings.HasSuffix(format, formatVarHash) || strings.HasSuffix(format, formatVarDigits) {
+		pattern += `\b`
+	}
+	return regexp.MustCompile(pattern)
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
 // Mask replaces sensitive information with placeholders and stores the mapping in the vault
 // This is for bidirectional tokenization - use Unmask() to restore the original values
 func (s *Scanner) Mask(ctx interface{}, input string, tags []string) string {
+	return s.MaskWithOptions(ctx, input, tags, MaskOptions{})
+}
+
+// MaskPreservingFormat works like Mask, but rules with a MaskFormat produce type-consistent
+// placeholders (e.g. email -> redacted+<hash>@example.com) that are friendlier to models.
+// Rules without a MaskFormat fall back to the standard __AIGIS_SEC_ placeholder.
+func (s *Scanner) MaskPreservingFormat(ctx interface{}, input string, tags []string) string {
+	return s.MaskWithOptions(ctx, input, tags, MaskOptions{PreserveFormat: true})
+}
+
+// MaskOptions adjusts how MaskWithOptions tokenizes text
+type MaskOptions struct {
+	// PreserveFormat produces type-consistent placeholders, as MaskPreservingFormat does
+	PreserveFormat bool
+	// SkipCodeFences leaves matches inside closed ``` fenced code blocks unmasked, e.g. an
+	// example key pasted with a question about its format
+	SkipCodeFences bool
+}
+
+// MaskWithOptions works like Mask with the given options
+func (s *Scanner) MaskWithOptions(ctx interface{}, input string, tags []string, opts MaskOptions) string {
 	// ctx should be *core.AIGisContext, but we use interface{} to avoid circular import
 	// We'll type-assert the vault methods
 
-	result := input
-	for _, rule := range s.rules {
-		// Check if this rule should be applied based on tags
-		if len(tags) > 0 {
-			shouldApply := false
-			for _, tag := range tags {
-				if tag == "all" || tag == rule.Name {
-					shouldApply = true
-					break
-				}
-			}
-			if !shouldApply {
-				continue
+	allowlist := s.allowlistSnapshot()
+	rules := s.snapshot()
+	spans := scanRules(rules, input,
+		func(rule Rule) bool { return selectRule(rule, tags) },
+		func(rule Rule, match string) bool {
+			// Never re-mask text that already contains a placeholder, or unmasking would break
+			if placeholderPattern.MatchString(match) || !rule.valid(match) {
+				return false
 			}
+			// Known-safe values (e.g. noreply addresses) keep their original text
+			_, allowed := allowlist[match]
+			return !allowed
+		},
+	)
+
+	if opts.SkipCodeFences {
+		spans = excludeCodeFences(input, spans)
+	}
+
+	// Generate unique placeholders for each match (or for the rule's capture group)
+	result := rebuild(input, spans, func(span scanSpan, match string) string {
+		rule := rules[span.rule]
+		placeholder := generatePlaceholder(match)
+		if opts.PreserveFormat && rule.MaskFormat != "" {
+			placeholder = generateFormatPlaceholder(rule.MaskFormat, match)
 		}
 
-		// Use ReplaceAllStringFunc to generate unique placeholders for each match
-		result = rule.Pattern.ReplaceAllStringFunc(result, func(match string) string {
-			placeholder := generatePlaceholder(match)
+		// Leave the match unmasked if the context's vault is full
+		if !vaultAdmits(ctx, placeholder) {
+			return match
+		}
 
-			// Store the mapping in the vault if ctx is valid
-			if ctx != nil {
-				// Type assertion to access VaultStore method
-				type vaultContext interface {
-					VaultStore(placeholder, original string)
-				}
-				if vaultCtx, ok := ctx.(vaultContext); ok {
-					vaultCtx.VaultStore(placeholder, match)
-				}
+		// Store the mapping in the vault if ctx is valid
+		if ctx != nil {
+			// Type assertion to access VaultStore method
+			type vaultContext interface {
+				VaultStore(placeholder, original string)
+			}
+			if vaultCtx, ok := ctx.(vaultContext); ok {
+				vaultCtx.VaultStore(placeholder, match)
 			}
 
-			return placeholder
-		})
-	}
+			// Record per-rule statistics if the context supports it
+			type maskRecorder interface {
+				RecordMask(rule string)
+			}
+			if recorder, ok := ctx.(maskRecorder); ok {
+				recorder.RecordMask(rule.Name)
+			}
+		}
+
+		return placeholder
+	})
+	s.recordHits(rules, spans)
+	s.scanDone()
 	return result
 }
 
+// escapedPlaceholderPrefix 用于中和客户端伪造的占位符，使其不再匹配 placeholderPattern
+const escapedPlaceholderPrefix = "__AIGIS_ESC_"
+
+// NeutralizePlaceholders 中和客户端输入中自带的占位符，防止伪造占位符干扰 Unmask
+// strip 为 true 时直接删除，否则改写为 __AIGIS_ESC_<hex>__（保留内容但不再被识别）
+// 返回处理后的文本以及被中和的占位符数量
+func (s *Scanner) NeutralizePlaceholders(input string, strip bool) (string, int) {
+	count := 0
+	result := placeholderPattern.ReplaceAllStringFunc(input, func(placeholder string) string {
+		count++
+		if strip {
+			return ""
+		}
+		return escapedPlaceholderPrefix + placeholder[len("__AIGIS_SEC_"):]
+	})
+	return result, count
+}
+
+// Tokenize replaces an entire value with a placeholder and stores the mapping in the vault
+// Unlike Mask, no rules are applied - the whole value is treated as sensitive
+func (s *Scanner) Tokenize(ctx interface{}, value string) string {
+	placeholder := generatePlaceholder(value)
+	if !vaultAdmits(ctx, placeholder) {
+		return value
+	}
+	type vaultContext interface {
+		VaultStore(placeholder, original string)
+	}
+	if vaultCtx, ok := ctx.(vaultContext); ok {
+		vaultCtx.VaultStore(placeholder, value)
+	}
+	return placeholder
+}
+
+// vaultAdmits reports whether ctx's vault accepts placeholder; contexts without a vault
+// limit always do
+func vaultAdmits(ctx interface{}, placeholder string) bool {
+	type vaultLimiter interface {
+		VaultAdmit(placeholder string) bool
+	}
+	if limiter, ok := ctx.(vaultLimiter); ok {
+		return limiter.VaultAdmit(placeholder)
+	}
+	return true
+}
+
 // Unmask restores placeholders back to their original secrets from the vault
 // It looks for the placeholder pattern: __AIGIS_SEC_[0-9a-f]{12}__
 func (s *Scanner) Unmask(ctx interface{}, input string) string {
+	result, _ := s.UnmaskWithStats(ctx, input)
+	return result
+}
+
+// UnmaskWithStats works like Unmask but also reports how many placeholders were found,
+// restored from the vault (hit), and left as-is (miss).
+// A high miss rate usually means the model is mangling placeholders.
+func (s *Scanner) UnmaskWithStats(ctx interface{}, input string) (string, UnmaskStats) {
+	var stats UnmaskStats
 	if ctx == nil {
-		return input
+		return input, stats
 	}
 
 	// Type assertion to access VaultGet method
@@ -155,22 +514,113 @@ func (s *Scanner) Unmask(ctx interface{}, input string) string {
 	}
 	vaultCtx, ok := ctx.(vaultContext)
 	if !ok {
-		return input
+		return input, stats
 	}
 
-	// Pattern to match our placeholders
-	placeholderPattern := regexp.MustCompile(`__AIGIS_SEC_[0-9a-f]{12}__`)
-
 	result := placeholderPattern.ReplaceAllStringFunc(input, func(placeholder string) string {
+		stats.Found++
 		if original, found := vaultCtx.VaultGet(placeholder); found {
+			stats.Restored++
 			return original
 		}
+		stats.Missed++
 		return placeholder // Keep placeholder if not found in vault
 	})
 
-	return result
+	// Format-preserving placeholders look like real data, so only vault hits are counted;
+	// anything else is assumed to be genuine content and left untouched
+	for _, rule := range s.snapshot() {
+		if rule.formatPattern == nil {
+			continue
+		}
+		result = rule.formatPattern.ReplaceAllStringFunc(result, func(placeholder string) string {
+			if original, found := vaultCtx.VaultGet(placeholder); found {
+				stats.Found++
+				stats.Restored++
+				return original
+			}
+			return placeholder
+		})
+	}
+
+	return result, stats
+}
+
+// EnableRule 启用一个可选 (OptIn) 规则，使其在默认扫描中生效
+func (s *Scanner) EnableRule(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]Rule, len(s.rules))
+	copy(rules, s.rules)
+	for i := range rules {
+		if rules[i].Name == name {
+			rules[i].OptIn = false
+			s.rules = rules
+			return nil
+		}
+	}
+	return fmt.Errorf("rule %q not found", name)
+}
+
+// AddAllowlist 添加白名单值：与规则匹配内容（或捕获组内容）完全相同时不做脱敏
+func (s *Scanner) AddAllowlist(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	allowlist := make(map[string]struct{}, len(s.allowlist)+len(values))
+	for value := range s.allowlist {
+		allowlist[value] = struct{}{}
+	}
+	for _, value := range values {
+		if value != "" {
+			allowlist[value] = struct{}{}
+		}
+	}
+	s.allowlist = allowlist
+}
+
+// allowlistSnapshot 返回当前白名单（只读，不可修改）
+func (s *Scanner) allowlistSnapshot() map[string]struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.allowlist
+}
+
+// DisableRule 移除指定规则，使其不再参与 Sanitize/Mask，GetRules 也不再返回
+// 规则不存在时返回 false
+func (s *Scanner) DisableRule(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.rules {
+		if s.rules[i].Name == name {
+			rules := make([]Rule, 0, len(s.rules)-1)
+			rules = append(rules, s.rules[:i]...)
+			s.rules = append(rules, s.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
 }
 
+// SetMaskFormat 为指定规则设置保留格式脱敏模板（空字符串表示取消）
+func (s *Scanner) SetMaskFormat(name string, format string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]Rule, len(s.rules))
+	copy(rules, s.rules)
+	for i := range rules {
+		if rules[i].Name == name {
+			rules[i].MaskFormat = format
+			rules[i].formatPattern = compileMaskFormat(format)
+			s.rules = rules
+			return nil
+		}
+	}
+	return fmt.Errorf("rule %q not found", name)
+}
 
 // AddRule 动态添加自定义规则
 func (s *Scanner) AddRule(name string, pattern string, replacement string) error {
@@ -178,17 +628,33 @@ func (s *Scanner) AddRule(name string, pattern string, replacement string) error
 	if err != nil {
 		return err
 	}
-	s.rules = append(s.rules, Rule{
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 自定义规则放在新的最低层级，自适应排序不会把它们提到内置规则之前
+	tier := 0
+	for _, rule := range s.rules {
+		if rule.Tier >= tier {
+			tier = rule.Tier + 1
+		}
+	}
+	rules := make([]Rule, len(s.rules), len(s.rules)+1)
+	copy(rules, s.rules)
+	s.rules = append(rules, Rule{
 		Name:        name,
 		Pattern:     compiled,
 		Replacement: replacement,
+		Tier:        tier,
+		hits:        new(atomic.Int64),
 	})
 	return nil
 }
 
 // GetRules 返回当前所有规则的副本（仅供查看，不可修改）
 func (s *Scanner) GetRules() []Rule {
-	rulesCopy := make([]Rule, len(s.rules))
-	copy(rulesCopy, s.rules)
+	rules := s.snapshot()
+	rulesCopy := make([]Rule, len(rules))
+	copy(rulesCopy, rules)
 	return rulesCopy
 }
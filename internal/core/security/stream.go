@@ -0,0 +1,137 @@
+package security
+
+import "strings"
+
+// placeholderPrefix 是所有标准占位符的固定前缀
+const placeholderPrefix = "__AIGIS_SEC_"
+
+// placeholderLen 是完整占位符的长度: __AIGIS_SEC_ + 12 位十六进制 + __
+const placeholderLen = len(placeholderPrefix) + 12 + 2
+
+// maxRedactHold 是脱敏流式输出时单行最多暂存的字节数，超过后在最后一个空白处输出
+const maxRedactHold = 4096
+
+// StreamUnmasker 对流式输出的增量文本进行占位符还原
+// 占位符可能被拆分到多个分片中，因此末尾可能属于占位符的部分会被暂存，
+// 直到能确定其完整（或确定不是占位符）后再输出
+type StreamUnmasker struct {
+	scanner *Scanner
+	ctx     interface{}
+	pending string
+	stats   UnmaskStats
+	// redact 为 true 时还原前先执行 Redact
+	redact bool
+}
+
+// NewStreamUnmasker 创建一个绑定到请求上下文（vault）的流式还原器
+func (s *Scanner) NewStreamUnmasker(ctx interface{}) *StreamUnmasker {
+	return &StreamUnmasker{scanner: s, ctx: ctx}
+}
+
+// NewStreamRedactor 与 NewStreamUnmasker 相同，但还原前先用 Redact 清理模型自行生成的敏感信息
+// 敏感信息同样可能被拆分到多个分片，因此按行输出：一行结束前暂存（最多 maxRedactHold 字节）
+func (s *Scanner) NewStreamRedactor(ctx interface{}) *StreamUnmasker {
+	return &StreamUnmasker{scanner: s, ctx: ctx, redact: true}
+}
+
+// Write 追加一段增量文本，返回可以安全输出的已还原文本（可能为空）
+func (u *StreamUnmasker) Write(delta string) string {
+	u.pending += delta
+
+	cut := partialPlaceholderStart(u.pending)
+	if u.redact {
+		cut = lineBoundary(u.pending, cut)
+	}
+	ready := u.pending[:cut]
+	u.pending = u.pending[cut:]
+
+	return u.unmask(ready)
+}
+
+// Flush 输出所有暂存的文本（流结束时调用）
+func (u *StreamUnmasker) Flush() string {
+	rest := u.pending
+	u.pending = ""
+	return u.unmask(rest)
+}
+
+// Stats 返回到目前为止的还原统计
+func (u *StreamUnmasker) Stats() UnmaskStats {
+	return u.stats
+}
+
+func (u *StreamUnmasker) unmask(text string) string {
+	if text == "" {
+		return ""
+	}
+	if u.redact {
+		text = u.scanner.Redact(u.ctx, text)
+	}
+	result, stats := u.scanner.UnmaskWithStats(u.ctx, text)
+	u.stats.Found += stats.Found
+	u.stats.Restored += stats.Restored
+	u.stats.Missed += stats.Missed
+	return result
+}
+
+// partialPlaceholderStart 返回 s 末尾未完成占位符的起始位置；没有时返回 len(s)
+// 完整的占位符不会被截断
+func partialPlaceholderStart(s string) int {
+	start := len(s) - placeholderLen + 1
+	if matches := placeholderPattern.FindAllStringIndex(s, -1); len(matches) > 0 {
+		if end := matches[len(matches)-1][1]; end > start {
+			start = end
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	for i := start; i < len(s); i++ {
+		if isPlaceholderPrefix(s[i:]) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// lineBoundary 返回 s[:cut] 中最后一个换行之后的位置，使未结束的行继续暂存
+// 暂存超过 maxRedactHold 时退而在最后一个空白处截断；都没有时返回 0
+func lineBoundary(s string, cut int) int {
+	if i := strings.LastIndexByte(s[:cut], '\n'); i >= 0 {
+		return i + 1
+	}
+	if len(s) <= maxRedactHold {
+		return 0
+	}
+	if i := strings.LastIndexAny(s[:cut], " \t"); i >= 0 {
+		return i + 1
+	}
+	return cut
+}
+
+// isPlaceholderPrefix 判断 t 是否为某个占位符的真前缀
+func isPlaceholderPrefix(t string) bool {
+	if len(t) >= placeholderLen {
+		return false
+	}
+	if len(t) <= len(placeholderPrefix) {
+		return strings.HasPrefix(placeholderPrefix, t)
+	}
+	if !strings.HasPrefix(t, placeholderPrefix) {
+		return false
+	}
+
+	rest := t[len(placeholderPrefix):]
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		if i < 12 {
+			if !(('0' <= c && c <= '9') || ('a' <= c && c <= 'f')) {
+				return false
+			}
+		} else if c != '_' {
+			return false
+		}
+	}
+	return true
+}
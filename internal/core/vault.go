@@ -0,0 +1,77 @@
+package core
+
+import "sync"
+
+// Vault stores the placeholder -> original secret mappings of one request, backing
+// AIGisContext.VaultStore/VaultGet. The default is in-memory; shared backends (see the vault
+// package) let another gateway instance unmask a response. Implementations must be safe for
+// concurrent use.
+type Vault interface {
+	// Store records the original value behind a placeholder
+	Store(placeholder, original string)
+	// Get returns the original value for a placeholder, or ("", false) if it is unknown
+	Get(placeholder string) (string, bool)
+	// All returns a copy of every mapping
+	All() map[string]string
+	// Len returns the number of mappings stored through this vault
+	Len() int
+	// Clear removes every mapping
+	Clear()
+	// Flush persists the mappings buffered by Store, e.g. to a shared backend. It is called
+	// once the request is masked; in-memory vaults have nothing to flush
+	Flush()
+}
+
+// MemoryVault is the default in-process Vault
+type MemoryVault struct {
+	mu      sync.RWMutex
+	entries map[string]string
+}
+
+// NewMemoryVault creates an empty in-memory vault
+func NewMemoryVault() *MemoryVault {
+	return &MemoryVault{entries: make(map[string]string)}
+}
+
+// Store records the original value behind a placeholder (thread-safe)
+func (v *MemoryVault) Store(placeholder, original string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.entries[placeholder] = original
+}
+
+// Get returns the original value for a placeholder (thread-safe)
+func (v *MemoryVault) Get(placeholder string) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	original, ok := v.entries[placeholder]
+	return original, ok
+}
+
+// All returns a copy of every mapping (thread-safe)
+func (v *MemoryVault) All() map[string]string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	copy := make(map[string]string, len(v.entries))
+	for k, val := range v.entries {
+		copy[k] = val
+	}
+	return copy
+}
+
+// Len returns the number of mappings (thread-safe)
+func (v *MemoryVault) Len() int {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.entries)
+}
+
+// Clear removes every mapping (thread-safe)
+func (v *MemoryVault) Clear() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.entries = make(map[string]string)
+}
+
+// Flush is a no-op: mappings are stored immediately
+func (v *MemoryVault) Flush() {}
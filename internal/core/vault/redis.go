@@ -0,0 +1,255 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+)
+
+// Vault backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// Config selects the vault backend
+type Config struct {
+	// Backend is "memory" (default, per-instance) or "redis" (shared across instances)
+	Backend string `mapstructure:"backend"`
+	// Redis configures the redis backend
+	Redis RedisConfig `mapstructure:"redis"`
+}
+
+// RedisConfig configures the Redis vault backend
+type RedisConfig struct {
+	// Addr is the Redis host:port (default: localhost:6379)
+	Addr string `mapstructure:"addr"`
+	// PasswordEnv names the environment variable holding the Redis password (optional)
+	PasswordEnv string `mapstructure:"password_env"`
+	// DB is the Redis database number (default 0)
+	DB int `mapstructure:"db"`
+	// KeyPrefix is prepended to the request ID to form each request's hash key (default: aigis:vault:)
+	KeyPrefix string `mapstructure:"key_prefix"`
+	// TTL bounds how long a request's mappings are kept (default: 10m)
+	TTL time.Duration `mapstructure:"ttl"`
+	// TimeoutMs is the dial and command timeout in milliseconds (default: 1000)
+	TimeoutMs int `mapstructure:"timeout_ms"`
+	// PoolSize is the number of idle connections kept open (default: 10)
+	PoolSize int `mapstructure:"pool_size"`
+}
+
+func (c RedisConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// RedisStore hands out Redis-backed vaults keyed by request. A request's mappings are written
+// to one hash (<key_prefix><request id>) when its vault is flushed once masking is done, and
+// expire after the TTL, so another instance that is handed the request ID (e.g. for a resumed
+// stream or an async callback, see POST /v1/unmask) can Open it. The gateway generates request
+// IDs randomly; vaults are only ever created for those IDs, never for client-supplied values.
+type RedisStore struct {
+	config RedisConfig
+	client *redis.Client
+	log    *zap.Logger
+}
+
+// NewRedisStore creates a store for the given config. Connections are opened lazily, so
+// Redis being unavailable at startup does not prevent the gateway from starting.
+func NewRedisStore(config RedisConfig, log *zap.Logger) (*RedisStore, error) {
+	if config.Addr == "" {
+		config.Addr = "localhost:6379"
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = "aigis:vault:"
+	}
+	if config.TTL <= 0 {
+		config.TTL = 10 * time.Minute
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = 10
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+
+	var password string
+	if config.PasswordEnv != "" {
+		password = os.Getenv(config.PasswordEnv)
+		if password == "" {
+			return nil, fmt.Errorf("environment variable %s (vault.redis.password_env) is not set", config.PasswordEnv)
+		}
+	}
+
+	timeout := config.timeout()
+	client := redis.NewClient(&redis.Options{
+		Addr:         config.Addr,
+		Password:     password,
+		DB:           config.DB,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		PoolSize:     config.PoolSize,
+		// A vault lookup that fails is logged and served from the local copy; retrying
+		// would only hold the request longer
+		MaxRetries: -1,
+	})
+	return &RedisStore{config: config, client: client, log: log}, nil
+}
+
+// Ping checks that Redis is reachable
+func (s *RedisStore) Ping() error {
+	ctx, cancel := s.commandContext()
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+// ForRequest returns the vault of a new request identified by id. Its hash cannot hold
+// anything this instance did not store, so lookups never leave the process.
+func (s *RedisStore) ForRequest(id string) core.Vault {
+	return &redisVault{store: s, key: s.config.KeyPrefix + id, local: core.NewMemoryVault()}
+}
+
+// Open returns the vault of an existing request identified by id, e.g. one masked by another
+// instance: lookups missing from the local copy are read from Redis.
+func (s *RedisStore) Open(id string) core.Vault {
+	return &redisVault{store: s, key: s.config.KeyPrefix + id, local: core.NewMemoryVault(), readThrough: true}
+}
+
+// commandContext bounds a Redis command by the configured timeout
+func (s *RedisStore) commandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.config.timeout())
+}
+
+// Close closes the connection pool
+func (s *RedisStore) Close() {
+	s.client.Close()
+}
+
+// redisVault keeps every mapping locally, so lookups made by the instance that masked the
+// request never leave the process, and buffers new mappings until Flush writes them to the
+// request's hash in one round trip. Redis failures are logged; the local copy keeps
+// same-instance unmasking working.
+type redisVault struct {
+	store *RedisStore
+	key   string
+	local *core.MemoryVault
+	// readThrough looks up local misses in Redis (vaults opened by another instance)
+	readThrough bool
+
+	mu      sync.Mutex
+	pending map[string]string
+	// flushed records that the request's hash may exist, so Clear only deletes what was written
+	flushed bool
+}
+
+// Store records the mapping locally and buffers it for the next Flush
+func (v *redisVault) Store(placeholder, original string) {
+	v.local.Store(placeholder, original)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.pending == nil {
+		v.pending = make(map[string]string)
+	}
+	v.pending[placeholder] = original
+}
+
+// Flush writes the buffered mappings to the request's hash and refreshes its TTL in one
+// pipeline. Mappings that fail to be written are dropped after logging.
+func (v *redisVault) Flush() {
+	v.mu.Lock()
+	pending := v.pending
+	v.pending = nil
+	if len(pending) > 0 {
+		v.flushed = true
+	}
+	v.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := v.store.commandContext()
+	defer cancel()
+	_, err := v.store.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, v.key, pending)
+		pipe.PExpire(ctx, v.key, v.store.config.TTL)
+		return nil
+	})
+	if err != nil {
+		v.store.log.Warn("Failed to store vault entries in Redis", zap.String("key", v.key), zap.Int("entries", len(pending)), zap.Error(err))
+	}
+}
+
+// Get returns the mapping from the local copy, falling back to Redis for opened vaults
+func (v *redisVault) Get(placeholder string) (string, bool) {
+	if original, ok := v.local.Get(placeholder); ok || !v.readThrough {
+		return original, ok
+	}
+	ctx, cancel := v.store.commandContext()
+	defer cancel()
+	original, err := v.store.client.HGet(ctx, v.key, placeholder).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			v.store.log.Warn("Failed to read vault entry from Redis", zap.String("key", v.key), zap.Error(err))
+		}
+		return "", false
+	}
+	v.local.Store(placeholder, original)
+	return original, true
+}
+
+// All returns every mapping of the request: the local copy, plus those stored in Redis for
+// opened vaults (the local copy alone if Redis fails)
+func (v *redisVault) All() map[string]string {
+	all := v.local.All()
+	if !v.readThrough {
+		return all
+	}
+	ctx, cancel := v.store.commandContext()
+	defer cancel()
+	stored, err := v.store.client.HGetAll(ctx, v.key).Result()
+	if err != nil {
+		v.store.log.Warn("Failed to read vault from Redis", zap.String("key", v.key), zap.Error(err))
+		return all
+	}
+	for placeholder, original := range stored {
+		if _, ok := all[placeholder]; !ok {
+			all[placeholder] = original
+		}
+	}
+	return all
+}
+
+// Len returns the number of mappings stored by this instance for the request
+func (v *redisVault) Len() int {
+	return v.local.Len()
+}
+
+// Clear removes the request's mappings locally and in Redis. Vaults that never flushed
+// anything do not reach Redis.
+func (v *redisVault) Clear() {
+	v.local.Clear()
+	v.mu.Lock()
+	v.pending = nil
+	flushed := v.flushed || v.readThrough
+	v.flushed = false
+	v.mu.Unlock()
+	if !flushed {
+		return
+	}
+	ctx, cancel := v.store.commandContext()
+	defer cancel()
+	if err := v.store.client.Del(ctx, v.key).Err(); err != nil {
+		v.store.log.Warn("Failed to clear vault in Redis", zap.String("key", v.key), zap.Error(err))
+	}
+}
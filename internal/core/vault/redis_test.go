@@ -0,0 +1,192 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+	"aigis/internal/core/security"
+)
+
+func newTestStore(t *testing.T, config RedisConfig) *RedisStore {
+	t.Helper()
+	store, err := NewRedisStore(config, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestRedisVaultSharedAcrossInstances(t *testing.T) {
+	redis := miniredis.RunT(t)
+	instanceA := newTestStore(t, RedisConfig{Addr: redis.Addr()})
+	instanceB := newTestStore(t, RedisConfig{Addr: redis.Addr()})
+
+	// 实例 A 脱敏，实例 B 凭同一 request ID 还原
+	scanner := security.NewScanner()
+	ctxA := core.NewGatewayContext(context.Background(), zap.NewNop())
+	ctxA.SetVault(instanceA.ForRequest("req-1"))
+	masked := scanner.Mask(ctxA, "mail bob@corp.io", nil)
+	if strings.Contains(masked, "bob@corp.io") {
+		t.Fatalf("Mask did not mask: %s", masked)
+	}
+	ctxA.VaultFlush()
+
+	ctxB := core.NewGatewayContext(context.Background(), zap.NewNop())
+	ctxB.SetVault(instanceB.Open("req-1"))
+	if got := scanner.Unmask(ctxB, masked); got != "mail bob@corp.io" {
+		t.Errorf("Unmask on another instance = %q", got)
+	}
+	if got := ctxB.VaultGetAll(); len(got) != 1 {
+		t.Errorf("VaultGetAll() = %v, want one entry", got)
+	}
+
+	// 其他请求看不到该映射
+	if _, ok := instanceB.Open("req-2").Get(strings.TrimPrefix(masked, "mail ")); ok {
+		t.Error("vaults of different requests must not share entries")
+	}
+}
+
+func TestRedisVaultBuffersStoresUntilFlush(t *testing.T) {
+	redis := miniredis.RunT(t)
+	store := newTestStore(t, RedisConfig{Addr: redis.Addr()})
+
+	// 先建立连接，排除握手命令
+	if err := store.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	// 脱敏过程中不访问 Redis，本实例的查询也只读本地副本
+	v := store.ForRequest("req-1")
+	before := redis.CommandCount()
+	for i, value := range []string{"a@corp.io", "b@corp.io", "c@corp.io"} {
+		v.Store(fmt.Sprintf("p%d", i), value)
+	}
+	if _, ok := v.Get("missing"); ok {
+		t.Error("unknown placeholders should miss")
+	}
+	if got := redis.CommandCount() - before; got != 0 {
+		t.Errorf("Store and Get should not reach Redis before Flush, got %d commands", got)
+	}
+	if redis.Exists("aigis:vault:req-1") {
+		t.Error("nothing should be written before Flush")
+	}
+
+	// Flush 用一个事务流水线写入全部映射：MULTI、HSET、PEXPIRE、EXEC
+	v.Flush()
+	if got := redis.CommandCount() - before; got != 4 {
+		t.Errorf("Flush should write every entry in one pipeline, got %d commands", got)
+	}
+	if fields, _ := redis.HKeys("aigis:vault:req-1"); len(fields) != 3 {
+		t.Errorf("expected 3 flushed entries, got %v", fields)
+	}
+
+	// 没有新映射时 Flush 不访问 Redis
+	before = redis.CommandCount()
+	v.Flush()
+	if got := redis.CommandCount() - before; got != 0 {
+		t.Errorf("an empty Flush should not reach Redis, got %d commands", got)
+	}
+}
+
+func TestRedisVaultTTLAndClear(t *testing.T) {
+	redis := miniredis.RunT(t)
+	store := newTestStore(t, RedisConfig{Addr: redis.Addr(), KeyPrefix: "test:", TTL: 90 * time.Second})
+
+	v := store.ForRequest("req-1")
+	v.Store("p1", "secret")
+	v.Flush()
+	if stored := redis.HGet("test:req-1", "p1"); stored != "secret" {
+		t.Errorf("expected hash test:req-1 to hold p1, got %q", stored)
+	}
+	if ttl := redis.TTL("test:req-1"); ttl != 90*time.Second {
+		t.Errorf("expected a 90s TTL, got %v", ttl)
+	}
+
+	// 过期后其他实例无法再还原
+	redis.FastForward(91 * time.Second)
+	if _, ok := store.Open("req-1").Get("p1"); ok {
+		t.Error("mappings should expire after the TTL")
+	}
+	v.Store("p1", "secret")
+	v.Flush()
+
+	v.Clear()
+	if _, ok := store.Open("req-1").Get("p1"); ok {
+		t.Error("Clear should delete the request's hash")
+	}
+	if v.Len() != 0 {
+		t.Errorf("Len() = %d after Clear", v.Len())
+	}
+}
+
+func TestRedisVaultClearWithoutFlush(t *testing.T) {
+	redis := miniredis.RunT(t)
+	store := newTestStore(t, RedisConfig{Addr: redis.Addr()})
+	if err := store.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	// 从未 Flush 的请求没有写入 Redis，Clear 也无需访问 Redis
+	v := store.ForRequest("req-1")
+	v.Store("p1", "secret")
+	before := redis.CommandCount()
+	v.Clear()
+	if got := redis.CommandCount() - before; got != 0 {
+		t.Errorf("Clear without a Flush should not reach Redis, got %d commands", got)
+	}
+	if _, ok := v.Get("p1"); ok {
+		t.Error("Clear should drop the local copy")
+	}
+}
+
+func TestRedisVaultAuth(t *testing.T) {
+	redis := miniredis.RunT(t)
+	redis.RequireAuth("s3cret")
+	t.Setenv("AIGIS_TEST_REDIS_PASSWORD", "s3cret")
+	store := newTestStore(t, RedisConfig{Addr: redis.Addr(), PasswordEnv: "AIGIS_TEST_REDIS_PASSWORD", DB: 2})
+	if err := store.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	v := store.ForRequest("req-1")
+	v.Store("p1", "secret")
+	v.Flush()
+	if stored := redis.DB(2).HGet("aigis:vault:req-1", "p1"); stored != "secret" {
+		t.Errorf("mappings should be written to the configured db, got %q", stored)
+	}
+
+	wrong := newTestStore(t, RedisConfig{Addr: redis.Addr()})
+	if err := wrong.Ping(); err == nil {
+		t.Error("Ping without the password should fail")
+	}
+
+	if _, err := NewRedisStore(RedisConfig{PasswordEnv: "AIGIS_TEST_REDIS_UNSET"}, nil); err == nil {
+		t.Error("an unset password_env should be rejected")
+	}
+}
+
+func TestRedisVaultUnavailable(t *testing.T) {
+	// 没有监听的端口：写入失败只记录日志，本实例仍可还原
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	store := newTestStore(t, RedisConfig{Addr: addr, TimeoutMs: 100})
+	v := store.ForRequest("req-1")
+	v.Store("p1", "secret")
+	v.Flush()
+	if got, ok := v.Get("p1"); !ok || got != "secret" {
+		t.Errorf("local copy should serve lookups when Redis is down, got %q, %v", got, ok)
+	}
+	if _, ok := v.Get("p2"); ok {
+		t.Error("unknown placeholders should miss")
+	}
+}
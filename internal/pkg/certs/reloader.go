@@ -0,0 +1,89 @@
+// Package certs loads TLS key pairs from disk and reloads them when the files change
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate from disk and reloads it when the files change,
+// so platforms that rotate certificates in place need no restart
+type Reloader struct {
+	certFile, keyFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the key pair, failing fast on unreadable or mismatched files
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is a tls.Config.GetCertificate callback for servers
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// GetClientCertificate is a tls.Config.GetClientCertificate callback for clients (mTLS)
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.current(), nil
+}
+
+// current returns the current certificate, reloading it first if either file changed.
+// A failed reload keeps serving the previous certificate (e.g. while files are half-written).
+func (r *Reloader) current() *tls.Certificate {
+	if modTime, err := r.latestModTime(); err == nil {
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if changed {
+			r.reload()
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// reload reads the key pair from disk
+func (r *Reloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the newer modification time of the certificate and key files
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -2,7 +2,10 @@ package logger
 
 import (
 	"fmt"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -20,11 +23,41 @@ func New(level string) (*zap.Logger, error) {
 // skip: 跳过的调用栈层数
 // 返回配置好的 logger 和可能的错误
 func NewWithCallerSkip(level string, skip int) (*zap.Logger, error) {
+	return build(Options{Level: level}, skip)
+}
+
+// Options 配置 logger 的级别和输出位置
+type Options struct {
+	// Level 是日志级别 (debug, info, warn, error)，默认 info
+	Level string
+	// Format 是输出格式：json (默认，适合生产采集) 或 console (带颜色和级别前缀，便于本地开发)
+	Format string
+	// File 非空时日志写入该文件并按大小滚动，否则输出到 stdout
+	File string
+	// MaxSizeMB 是单个日志文件的大小上限，超过后滚动 (默认 100)
+	MaxSizeMB int
+	// MaxBackups 是保留的滚动文件 (<file>.1 最新) 数量，默认 5，负数表示全部保留
+	MaxBackups int
+}
+
+// NewWithOptions 按 Options 创建 logger (对应配置 log.level、log.format、log.file、log.max_size_mb、log.max_backups)
+func NewWithOptions(opts Options) (*zap.Logger, error) {
+	return build(opts, 0)
+}
+
+// 日志输出格式
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// build 创建 logger：生产配置（默认 JSON 编码），输出到 stdout 或滚动文件
+func build(opts Options, skip int) (*zap.Logger, error) {
 	// 使用生产配置（JSON编码）
 	config := zap.NewProductionConfig()
 
 	// 设置日志级别
-	switch level {
+	switch opts.Level {
 	case "debug":
 		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
 	case "info":
@@ -51,9 +84,48 @@ func NewWithCallerSkip(level string, skip int) (*zap.Logger, error) {
 	// 保持 caller 信息启用
 	config.DisableCaller = false
 
+	// console 格式：级别大写并着色 (写入文件时不着色，避免转义序列进入日志文件)
+	switch opts.Format {
+	case "", FormatJSON:
+	case FormatConsole:
+		config.Encoding = FormatConsole
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if opts.File != "" {
+			config.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want %s or %s)", opts.Format, FormatJSON, FormatConsole)
+	}
+
+	// 写入文件时用滚动文件替换 stdout 输出
+	var file *rotatingWriter
+	if opts.File != "" {
+		maxSizeMB, maxBackups := opts.MaxSizeMB, opts.MaxBackups
+		if maxSizeMB <= 0 {
+			maxSizeMB = defaultMaxSizeMB
+		}
+		if maxBackups == 0 {
+			maxBackups = defaultMaxBackups
+		}
+		var err error
+		if file, err = newRotatingWriter(opts.File, int64(maxSizeMB)<<20, maxBackups); err != nil {
+			return nil, fmt.Errorf("failed to create logger: %w", err)
+		}
+	}
+
 	// 创建 logger
 	logger, err := config.Build(
 		zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			if file != nil {
+				encoder := zapcore.NewJSONEncoder(config.EncoderConfig)
+				if config.Encoding == FormatConsole {
+					encoder = zapcore.NewConsoleEncoder(config.EncoderConfig)
+				}
+				core = zapcore.NewCore(encoder, file, config.Level)
+				if sampling := config.Sampling; sampling != nil {
+					core = zapcore.NewSamplerWithOptions(core, time.Second, sampling.Initial, sampling.Thereafter)
+				}
+			}
 			return &funcCore{Core: core}
 		}),
 	)
@@ -91,16 +163,9 @@ func (c *funcCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *za
 }
 
 func (c *funcCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
-	// 获取调用栈，需要跳过更多层到真实的业务代码
-	// 跳过层级：Write -> funcCore.Write -> zap.Logger.Info -> 业务代码
-	pc, _, _, ok := runtime.Caller(4)
-	if ok {
-		fn := runtime.FuncForPC(pc)
-		if fn != nil {
-			funcName := fn.Name()
-			// 添加函数名字段
-			fields = append(fields, zap.String("func", funcName))
-		}
+	// 添加真实调用方的函数名字段
+	if funcName, ok := callerFunc(); ok {
+		fields = append(fields, zap.String("func", funcName))
 	}
 
 	// 调用原始的 Write
@@ -110,4 +175,36 @@ func (c *funcCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
 func (c *funcCore) With(fields []zapcore.Field) zapcore.Core {
 	clone := c.Core.With(fields)
 	return &funcCore{Core: clone}
-}
\ No newline at end of file
+}
+
+// loggerPackageDir 是本包源码所在的目录，用于在调用栈中识别本包的封装层
+var loggerPackageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// callerFunc 沿调用栈向上查找第一个不属于 zap 或本包封装层的帧，返回其函数名
+// 经过 Logger 封装、SugaredLogger 或 zap.Logger 直接调用时，层数各不相同，因此不能用固定的 skip
+func callerFunc() (string, bool) {
+	pcs := make([]uintptr, 32)
+	// 跳过 runtime.Callers 和 callerFunc 本身
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !isLoggingFrame(frame) {
+			return frame.Function, frame.Function != ""
+		}
+		if !more {
+			return "", false
+		}
+	}
+}
+
+// isLoggingFrame 判断调用栈帧是否属于日志实现：zap 本身，或本包中的非测试代码
+func isLoggingFrame(frame runtime.Frame) bool {
+	if strings.HasPrefix(frame.Function, "go.uber.org/zap") {
+		return true
+	}
+	return filepath.Dir(frame.File) == loggerPackageDir && !strings.HasSuffix(frame.File, "_test.go")
+}
@@ -1,10 +1,16 @@
 package logger
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestLoggerNew(t *testing.T) {
@@ -78,4 +84,54 @@ func TestCallerSkipDepth(t *testing.T) {
 	}()
 
 	t.Log("Caller skip depth test passed")
-}
\ No newline at end of file
+}
+
+func TestLoggerConsoleFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	logger, err := NewWithOptions(Options{Level: "info", Format: FormatConsole, File: path})
+	if err != nil {
+		t.Fatalf("console logger should build, got %v", err)
+	}
+	logger.Info("readable output", zap.String("route_id", "openai"))
+	logger.Sync()
+
+	data, _ := os.ReadFile(path)
+	line := strings.TrimSpace(string(data))
+	if json.Valid([]byte(line)) {
+		t.Errorf("console output should not be JSON: %s", line)
+	}
+	if !strings.Contains(line, "\tINFO\t") || !strings.Contains(line, "readable output") || !strings.Contains(line, `"route_id": "openai"`) {
+		t.Errorf("unexpected console output: %q", line)
+	}
+
+	// stdout 输出同样可以构建 (带颜色)
+	if _, err := NewWithOptions(Options{Format: FormatConsole}); err != nil {
+		t.Errorf("console logger on stdout should build, got %v", err)
+	}
+	if _, err := NewWithOptions(Options{Format: "xml"}); err == nil {
+		t.Error("unknown format should be rejected")
+	}
+}
+
+// TestFuncFieldNamesRealCaller 验证无论经过多少层封装，func 字段都是真实调用方
+func TestFuncFieldNamesRealCaller(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	base := zap.New(&funcCore{Core: observed})
+
+	base.Info("zap logger")
+	base.Sugar().Infow("sugared logger")
+	Wrap(base).Info("wrapper")
+	Wrap(base).With(zap.String("k", "v")).Named("sub").Warn("wrapper with fields")
+	Wrap(WithCallerSkip(base, 1)).SkipOne().Error("wrapper with caller skip")
+
+	const want = "aigis/internal/pkg/logger.TestFuncFieldNamesRealCaller"
+	entries := logs.All()
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if got := entry.ContextMap()["func"]; got != want {
+			t.Errorf("%q: func = %v, want %s", entry.Message, got, want)
+		}
+	}
+}
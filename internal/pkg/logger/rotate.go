@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 日志文件滚动的默认值
+const (
+	defaultMaxSizeMB  = 100
+	defaultMaxBackups = 5
+)
+
+// rotatingWriter 是按大小滚动的日志文件 (zapcore.WriteSyncer)
+// 写入后超过 maxBytes 时，当前文件改名为 <path>.1，已有的 <path>.N 依次后移为 <path>.N+1，
+// 超出 maxBackups 的最旧文件被删除 (maxBackups < 0 表示保留全部)
+type rotatingWriter struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// newRotatingWriter 以追加方式打开 path（目录不存在时自动创建）
+func newRotatingWriter(path string, maxBytes int64, maxBackups int) (*rotatingWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	w := &rotatingWriter{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// Write 写入一条日志；一条日志不会被拆分到两个文件中
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Sync 将文件内容刷到磁盘
+func (w *rotatingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Sync()
+}
+
+// Close 关闭当前文件
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// rotate 关闭当前文件、后移备份并打开新文件（调用方持有锁）
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	// 找到需要后移的最后一个备份
+	last := 1
+	for w.maxBackups < 0 || last < w.maxBackups {
+		if _, err := os.Stat(w.backupPath(last)); err != nil {
+			break
+		}
+		last++
+	}
+	for i := last; i > 1; i-- {
+		os.Rename(w.backupPath(i-1), w.backupPath(i))
+	}
+	if w.maxBackups != 0 {
+		if err := os.Rename(w.path, w.backupPath(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return w.open()
+}
+
+func (w *rotatingWriter) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingWriterRotatesPastSizeLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "aigis.log")
+	w, err := newRotatingWriter(path, 100, 2)
+	if err != nil {
+		t.Fatalf("newRotatingWriter failed: %v", err)
+	}
+	defer w.Close()
+
+	// 每行 30 字节，每个文件最多 3 行；写 10 行会滚动 3 次
+	for i := 0; i < 10; i++ {
+		if _, err := fmt.Fprintf(w, "line %02d %s\n", i, strings.Repeat("x", 21)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed: %v", name, err)
+		}
+		return string(data)
+	}
+	if got := read(path); !strings.HasPrefix(got, "line 09") || strings.Count(got, "\n") != 1 {
+		t.Errorf("current file should hold only the latest line, got %q", got)
+	}
+	if got := read(path + ".1"); !strings.HasPrefix(got, "line 06") || strings.Count(got, "\n") != 3 {
+		t.Errorf("%s.1 should hold lines 06-08, got %q", path, got)
+	}
+	if got := read(path + ".2"); !strings.HasPrefix(got, "line 03") {
+		t.Errorf("%s.2 should hold lines 03-05, got %q", path, got)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("only max_backups files should be kept, stat .3: %v", err)
+	}
+}
+
+func TestRotatingWriterAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aigis.log")
+	os.WriteFile(path, []byte("previous run\n"), 0o644)
+
+	w, err := newRotatingWriter(path, 1024, 1)
+	if err != nil {
+		t.Fatalf("newRotatingWriter failed: %v", err)
+	}
+	w.Write([]byte("this run\n"))
+	w.Close()
+
+	if data, _ := os.ReadFile(path); string(data) != "previous run\nthis run\n" {
+		t.Errorf("existing log should be appended to, got %q", data)
+	}
+}
+
+func TestNewWithOptionsWritesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aigis.log")
+	logger, err := NewWithOptions(Options{Level: "info", File: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %v", err)
+	}
+	logger.Info("to file")
+	logger.Debug("below level")
+	logger.Sync()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one log line, got %q", data)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("file output should be JSON: %v", err)
+	}
+	if entry["msg"] != "to file" || entry["func"] == nil {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
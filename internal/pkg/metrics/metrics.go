@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Result label values for UnmaskTotal
+const (
+	UnmaskHit  = "hit"
+	UnmaskMiss = "miss"
+)
+
+// UnmaskTotal counts placeholders seen while unmasking responses.
+// result="hit" means the placeholder was restored from the vault, "miss" means it was left as-is.
+var UnmaskTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "aigis_unmask_total",
+		Help: "Placeholders encountered during unmasking, by vault lookup result.",
+	},
+	[]string{"result"},
+)
+
+// RouteMatchersEvaluated records how many route matchers FindRoute evaluated per request.
+// A high count points at config bloat or a catch-all route placed too late.
+var RouteMatchersEvaluated = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name:    "aigis_route_matchers_evaluated",
+		Help:    "Number of route matchers evaluated to route a request.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	},
+)
+
+// PIIDetectedTotal counts sensitive values detected in request bodies, by scanner rule.
+// mode="observe" means the values were only counted and forwarded unmodified.
+var PIIDetectedTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "aigis_pii_detected_total",
+		Help: "Sensitive values detected in request bodies, by scanner rule and gateway mode.",
+	},
+	[]string{"rule", "mode"},
+)
+
+// Action label values for SecretsTotal
+const (
+	SecretsMasked   = "masked"
+	SecretsUnmasked = "unmasked"
+)
+
+// SecretsTotal counts sensitive values replaced with placeholders in requests (masked)
+// and placeholders restored in responses (unmasked).
+var SecretsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "aigis_secrets_total",
+		Help: "Sensitive values masked in requests and restored in responses.",
+	},
+	[]string{"action"},
+)
+
+// RouteUnmatched is the route label for requests that never matched a route
+const RouteUnmatched = "none"
+
+// RequestsTotal counts chat completion requests handled by the gateway, by route and response status.
+var RequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "aigis_requests_total",
+		Help: "Chat completion requests handled, by route ID and HTTP status.",
+	},
+	[]string{"route", "status"},
+)
+
+// RequestDuration records end-to-end request latency, by route and response status.
+// Buckets span 50ms to ~100s since LLM completions are slow and streams are long-lived.
+var RequestDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "aigis_request_duration_seconds",
+		Help:    "End-to-end chat completion request latency, by route ID and HTTP status.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	},
+	[]string{"route", "status"},
+)
+
+// UpstreamErrorsTotal counts failed upstream attempts (including retried ones), by route and reason.
+// reason is the upstream HTTP status, or "timeout"/"upstream" when no response was received.
+var UpstreamErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "aigis_upstream_errors_total",
+		Help: "Failed upstream attempts, by route ID and reason.",
+	},
+	[]string{"route", "reason"},
+)
+
+func init() {
+	prometheus.MustRegister(
+		UnmaskTotal, RouteMatchersEvaluated, PIIDetectedTotal,
+		SecretsTotal, RequestsTotal, RequestDuration, UpstreamErrorsTotal,
+	)
+}
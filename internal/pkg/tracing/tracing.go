@@ -0,0 +1,103 @@
+// Package tracing wires the gateway into OpenTelemetry: request spans from the global tracer
+// provider, W3C traceparent propagation, and an OTLP/HTTP exporter configured by the standard
+// OTEL_* environment variables. Until a provider is installed every span is a no-op.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader is the W3C trace context propagation header
+const TraceparentHeader = "traceparent"
+
+// tracerName identifies the gateway's instrumentation scope
+const tracerName = "aigis"
+
+// defaultServiceName is the service.name resource attribute when OTEL_SERVICE_NAME is not set
+const defaultServiceName = "aigis"
+
+// Span is an in-progress operation
+type Span = trace.Span
+
+// Span kinds used by the gateway
+const (
+	KindInternal = trace.SpanKindInternal
+	KindServer   = trace.SpanKindServer
+	KindClient   = trace.SpanKindClient
+)
+
+// propagator reads and writes W3C traceparent headers, independent of the global propagator
+var propagator = propagation.TraceContext{}
+
+// String creates a string attribute
+func String(key, value string) attribute.KeyValue { return attribute.String(key, value) }
+
+// Int creates an integer attribute
+func Int(key string, value int) attribute.KeyValue { return attribute.Int(key, value) }
+
+// Bool creates a boolean attribute
+func Bool(key string, value bool) attribute.KeyValue { return attribute.Bool(key, value) }
+
+// Start begins a span as a child of the active (or extracted remote) span in ctx, using the
+// global tracer provider
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// RecordError records err on the span and marks it as failed; nil errors are ignored
+func RecordError(span Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Extract returns a context carrying the remote parent from a traceparent header, if valid
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject sets the traceparent header for the active span so the upstream joins the trace
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// NewProviderFromEnv creates a tracer provider that batches spans to an OTLP/HTTP collector,
+// or returns nil when no endpoint is configured. The exporter reads the standard variables:
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (full URL) or OTEL_EXPORTER_OTLP_ENDPOINT (base URL,
+// "/v1/traces" is appended), and OTEL_SERVICE_NAME sets the service name (default "aigis").
+func NewProviderFromEnv(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return nil, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Later detectors win, so OTEL_SERVICE_NAME overrides the default service name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
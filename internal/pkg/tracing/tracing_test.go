@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useRecorder installs a tracer provider recording finished spans for the duration of the test
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+// spanNamed returns the first ended span with the given name
+func spanNamed(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q not found", name)
+	return nil
+}
+
+// attributeValue returns the value of the named span attribute
+func attributeValue(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestStartWithoutProviderIsNoop(t *testing.T) {
+	_, span := Start(context.Background(), "op", KindInternal)
+	if span.IsRecording() {
+		t.Fatal("Spans should not record without a tracer provider")
+	}
+	span.SetAttributes(String("k", "v"))
+	RecordError(span, errors.New("boom"))
+	span.End()
+}
+
+func TestStartBuildsSpanTree(t *testing.T) {
+	recorder := useRecorder(t)
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := Start(Extract(context.Background(), header), "root", KindServer)
+	childCtx, child := Start(ctx, "child", KindClient, String("a", "1"))
+	child.SetAttributes(Int("a", 2), Bool("b", true))
+	RecordError(child, errors.New("boom"))
+	RecordError(child, nil)
+
+	out := http.Header{}
+	Inject(childCtx, out)
+	child.End()
+	root.End()
+
+	if n := len(recorder.Ended()); n != 2 {
+		t.Fatalf("expected 2 spans, got %d", n)
+	}
+	rootData := spanNamed(t, recorder, "root")
+	childData := spanNamed(t, recorder, "child")
+
+	if got := rootData.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("root should join the remote trace, got %s", got)
+	}
+	if got := rootData.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("root parent = %s, want the remote span", got)
+	}
+	if childData.Parent().SpanID() != rootData.SpanContext().SpanID() || childData.SpanContext().TraceID() != rootData.SpanContext().TraceID() {
+		t.Error("child should be parented to root within the same trace")
+	}
+	if childData.SpanKind() != KindClient || attributeValue(childData, "a").AsInt64() != 2 || !attributeValue(childData, "b").AsBool() {
+		t.Errorf("unexpected child kind or attributes: %v %v", childData.SpanKind(), childData.Attributes())
+	}
+	if status := childData.Status(); status.Code != codes.Error || status.Description != "boom" {
+		t.Errorf("child status = %+v, want the recorded error", status)
+	}
+
+	sc := childData.SpanContext()
+	if want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"; out.Get(TraceparentHeader) != want {
+		t.Errorf("Inject set %q, want the child span's traceparent %q", out.Get(TraceparentHeader), want)
+	}
+}
+
+func TestStartHonorsUnsampledParent(t *testing.T) {
+	recorder := useRecorder(t)
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := Start(Extract(context.Background(), header), "root", KindServer)
+	span.End()
+	if n := len(recorder.Ended()); n != 0 {
+		t.Errorf("an unsampled remote parent should not record spans, got %d", n)
+	}
+}
+
+func TestProviderFromEnvExportsToCollector(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+	provider, err := NewProviderFromEnv(context.Background())
+	if err != nil || provider == nil {
+		t.Fatalf("NewProviderFromEnv() = %v, %v", provider, err)
+	}
+
+	_, span := provider.Tracer(tracerName).Start(context.Background(), "op")
+	span.End()
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	select {
+	case r := <-requests:
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("spans posted to %s, want /v1/traces", r.URL.Path)
+		}
+	default:
+		t.Fatal("Shutdown should flush the span to the collector")
+	}
+}
+
+func TestProviderFromEnvDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if provider, err := NewProviderFromEnv(context.Background()); provider != nil || err != nil {
+		t.Errorf("no provider should be created without an endpoint, got %v, %v", provider, err)
+	}
+}
@@ -0,0 +1,7 @@
+// Package version exposes the build version of AIGis.
+package version
+
+// Version is the AIGis build version, injected at build time:
+//
+//	go build -ldflags "-X aigis/internal/pkg/version.Version=v1.2.3" ./cmd/aigis
+var Version = "dev"
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"aigis/internal/core/engine"
+)
+
+// routeSummary is the admin view of a loaded route. It deliberately omits header values,
+// transform configs and credentials; only structure that is safe to expose is listed. Header
+// matchers are listed by name only, since their patterns may embed API keys or tokens.
+type routeSummary struct {
+	ID           string            `json:"id"`
+	Default      bool              `json:"default,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	Matcher      map[string]string `json:"matcher,omitempty"`
+	Upstream     string            `json:"upstream,omitempty"`
+	Upstreams    []string          `json:"upstreams,omitempty"`
+	Fallback     string            `json:"fallback,omitempty"`
+	AuthStrategy string            `json:"auth_strategy,omitempty"`
+	Transforms   []string          `json:"transforms"`
+}
+
+// summarizeRoute builds the admin view of a route
+func summarizeRoute(route engine.Route) routeSummary {
+	summary := routeSummary{
+		ID:           route.ID,
+		Default:      route.IsDefault(),
+		Priority:     route.Priority,
+		Matcher:      redactMatcher(route.Matcher),
+		Upstream:     redactURL(route.Upstream.BaseURL),
+		AuthStrategy: route.Upstream.AuthStrategy,
+		Transforms:   make([]string, 0, len(route.Transforms)),
+	}
+	for _, upstream := range route.Upstreams {
+		summary.Upstreams = append(summary.Upstreams, redactURL(upstream.BaseURL))
+	}
+	if route.Fallback != nil {
+		summary.Fallback = redactURL(route.Fallback.BaseURL)
+	}
+	for _, step := range route.Transforms {
+		summary.Transforms = append(summary.Transforms, step.Type)
+	}
+	return summary
+}
+
+// redactMatcher copies a route matcher with the patterns of header matchers replaced, e.g. a
+// "header:Authorization" pattern that pins a bearer token
+func redactMatcher(matcher map[string]string) map[string]string {
+	if len(matcher) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(matcher))
+	for key, pattern := range matcher {
+		if strings.HasPrefix(key, engine.HeaderMatcherPrefix) {
+			pattern = "[REDACTED]"
+		}
+		redacted[key] = pattern
+	}
+	return redacted
+}
+
+// redactURL masks userinfo passwords in a base URL (env: references are shown as-is, unresolved)
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// handleAdminRoutes lists the routes of the currently loaded engine
+func (s *HTTPServer) handleAdminRoutes(w http.ResponseWriter, r *http.Request) {
+	routes := s.engine.Load().GetConfig().Routes
+	summaries := make([]routeSummary, 0, len(routes))
+	for _, route := range routes {
+		summaries = append(summaries, summarizeRoute(route))
+	}
+	writeJSON(w, map[string]interface{}{"routes": summaries})
+}
+
+// handleAdminHealth reports detailed health: loaded route count, mode and uptime
+func (s *HTTPServer) handleAdminHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"status":         "ok",
+		"mode":           s.mode,
+		"routes":         len(s.engine.Load().GetConfig().Routes),
+		"started_at":     s.started.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(s.started).Seconds()),
+	})
+}
+
+// requireAuth wraps a handler so it only runs for requests with an accepted gateway API key
+func (s *HTTPServer) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authorize(w, r) {
+			next(w, r)
+		}
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "failed to encode response", "server_error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// gatewayAuth validates inbound client keys against the configured set
+type gatewayAuth struct {
+	// digests holds SHA-256 sums of the accepted keys so every comparison has the same length
+	digests [][sha256.Size]byte
+}
+
+// newGatewayAuth builds the authenticator from a key list and an optional env var holding
+// comma-separated keys. It returns nil (auth disabled) when no keys are configured.
+func newGatewayAuth(keys []string, env string) *gatewayAuth {
+	if env != "" {
+		keys = append(keys, strings.Split(os.Getenv(env), ",")...)
+	}
+
+	a := &gatewayAuth{}
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			a.digests = append(a.digests, sha256.Sum256([]byte(key)))
+		}
+	}
+	if len(a.digests) == 0 {
+		return nil
+	}
+	return a
+}
+
+// allow reports whether the request carries an accepted key in Authorization (Bearer) or X-API-Key.
+// The header that carried an accepted key is removed, so routes never forward the gateway key
+// upstream (e.g. through header_policy.allow). A nil authenticator accepts every request.
+func (a *gatewayAuth) allow(r *http.Request) bool {
+	if a == nil {
+		return true
+	}
+
+	key, header := requestAPIKey(r)
+	if key == "" {
+		return false
+	}
+	digest := sha256.Sum256([]byte(key))
+
+	// Compare against every key without short-circuiting so timing does not reveal which one matched
+	match := 0
+	for _, accepted := range a.digests {
+		match |= subtle.ConstantTimeCompare(digest[:], accepted[:])
+	}
+	if match != 1 {
+		return false
+	}
+	r.Header.Del(header)
+	return true
+}
+
+// requestAPIKey extracts the client key from Authorization: Bearer <key> or X-API-Key, and
+// returns the name of the header it came from
+func requestAPIKey(r *http.Request) (key, header string) {
+	if auth := strings.TrimSpace(r.Header.Get("Authorization")); auth != "" {
+		if scheme, token, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token), "Authorization"
+		}
+		return "", ""
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key")), "X-API-Key"
+}
+
+// authorize checks the gateway API key and writes a 401 when it is missing or not accepted.
+// It returns false if the request was rejected.
+func (s *HTTPServer) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.auth.allow(r) {
+		return true
+	}
+	s.logger.Warn("Unauthorized request", zap.String("client", clientIP(r)), zap.String("path", r.URL.Path))
+	writeOpenAIError(w, http.StatusUnauthorized, "Invalid or missing API key", "authentication_error")
+	return false
+}
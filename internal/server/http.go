@@ -1,35 +1,76 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 
 	"aigis/internal/config"
 	"aigis/internal/core"
+	"aigis/internal/core/audit"
 	"aigis/internal/core/engine"
 	"aigis/internal/core/processors"
 	"aigis/internal/core/providers"
+	"aigis/internal/core/security"
+	"aigis/internal/core/vault"
 	"aigis/internal/pkg/logger"
+	"aigis/internal/pkg/tracing"
 )
 
 // HTTPServer extends the basic server with gateway functionality
 type HTTPServer struct {
 	*Server
 	pipeline *core.Pipeline
-	engine   *engine.Engine
-	mux      *http.ServeMux
-	logger   *logger.Logger
+	engine   atomic.Pointer[engine.Engine]
+	audit    *audit.Emitter
+	streams  *streamLimiter
+	// vaults provides shared per-request vaults (nil = in-memory vault per request)
+	vaults *vault.RedisStore
+	// tracerProvider exports spans to an OTLP collector (nil = tracing disabled)
+	tracerProvider *sdktrace.TracerProvider
+	auth           *gatewayAuth
+	// defaultScanner serves /v1/scan requests that match no route
+	defaultScanner *security.Scanner
+	mode           string
+	started        time.Time
+	// maxBodyBytes caps the request body size (<= 0 disables the limit)
+	maxBodyBytes int64
+	// maxVaultEntries caps the secrets masked per request (0 = unlimited); past the cap
+	// requests fail when failOnVaultOverflow is set, otherwise further secrets stay unmasked
+	maxVaultEntries     int
+	failOnVaultOverflow bool
+	// writeTimeout is the server-wide write timeout; LLM responses get their route's
+	// upstream timeout on top of it
+	writeTimeout time.Duration
+	mux          *http.ServeMux
+	logger       *logger.Logger
 }
 
+// defaultMaxBodyBytes is the request body limit when server.max_body_bytes is not set
+const defaultMaxBodyBytes = 10 << 20
+
+// defaultWriteTimeout is the server write timeout when server.write_timeout is not set
+const defaultWriteTimeout = 15 * time.Second
+
 // NewHTTPServer creates a new HTTP server with gateway capabilities
 func NewHTTPServer(addr string, zapLogger *zap.Logger) (*HTTPServer, error) {
 	baseServer := New(addr)
@@ -40,45 +81,209 @@ func NewHTTPServer(addr string, zapLogger *zap.Logger) (*HTTPServer, error) {
 	// Initialize pipeline (for logging processor only, transforms are in engine)
 	pipeline := core.NewPipeline()
 
-	// Register RequestLogger processor
-	pipeline.AddProcessor(processors.NewRequestLogger())
+	// Register RequestLogger processor; log.log_bodies adds the (sanitized) bodies
+	requestLogger := processors.NewRequestLogger()
+	if viper.GetBool("log.log_bodies") {
+		requestLogger.SetLogBodies(true)
+		extLogger.Warn("Body logging enabled: request and response bodies are logged after secret redaction")
+	}
+	pipeline.AddProcessor(requestLogger)
+
+	pipelineConfig, err := config.LoadPipelineConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pipeline config: %w", err)
+	}
+	pipeline.SetStepTimeout(time.Duration(pipelineConfig.StepTimeoutMs)*time.Millisecond, pipelineConfig.FailOpen)
+
+	s := &HTTPServer{
+		Server:   baseServer,
+		pipeline: pipeline,
+		logger:   extLogger,
+		started:  time.Now(),
+
+		defaultScanner: security.NewScanner(),
+	}
+
+	// Inbound client authentication (disabled when no keys are configured); set up first
+	// because it decides which upstream auth strategies the engine config may use
+	s.auth = newGatewayAuth(viper.GetStringSlice("server.api_keys"), viper.GetString("server.api_keys_env"))
+	if s.auth != nil {
+		extLogger.Info("Gateway API key authentication enabled", zap.Int("keys", len(s.auth.digests)))
+	}
+
+	// Create transformation engine
+	eng, err := s.buildEngine()
+	if err != nil {
+		return nil, err
+	}
+	s.engine.Store(eng)
+
+	// Deployment mode: "observe" detects and reports but forwards requests unmodified
+	switch mode := viper.GetString("mode"); mode {
+	case "", core.ModeEnforce:
+		s.mode = core.ModeEnforce
+	case core.ModeObserve:
+		s.mode = mode
+		extLogger.Warn("Observe mode enabled: requests are forwarded without masking")
+	default:
+		return nil, fmt.Errorf("invalid mode %q (expected %q or %q)", mode, core.ModeEnforce, core.ModeObserve)
+	}
+
+	// Per-client cap on concurrent streaming requests (0 = unlimited)
+	if maxStreams := viper.GetInt("server.max_streams_per_client"); maxStreams > 0 {
+		s.streams = newStreamLimiter(maxStreams)
+		extLogger.Info("Stream limit enabled", zap.Int("max_streams_per_client", maxStreams))
+	}
+
+	// Request body size limit (0 = default, negative = unlimited)
+	s.maxBodyBytes = viper.GetInt64("server.max_body_bytes")
+	if s.maxBodyBytes == 0 {
+		s.maxBodyBytes = defaultMaxBodyBytes
+	}
+
+	// Write timeout for responses that do not wait on an upstream (admin, scan, health)
+	s.writeTimeout = viper.GetDuration("server.write_timeout")
+	if s.writeTimeout <= 0 {
+		s.writeTimeout = defaultWriteTimeout
+	}
+
+	// Per-request vault size cap: "skip" (default) stops masking new secrets, "fail" rejects the request
+	s.maxVaultEntries = viper.GetInt("server.max_vault_entries")
+	switch overflow := viper.GetString("server.vault_overflow"); overflow {
+	case "", "skip":
+	case "fail":
+		s.failOnVaultOverflow = true
+	default:
+		return nil, fmt.Errorf("invalid server.vault_overflow %q (expected \"skip\" or \"fail\")", overflow)
+	}
+
+	// Secret vault backend: in-memory per request (default) or shared through Redis
+	vaultConfig, err := config.LoadVaultConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vault config: %w", err)
+	}
+	switch vaultConfig.Backend {
+	case "", vault.BackendMemory:
+	case vault.BackendRedis:
+		s.vaults, err = vault.NewRedisStore(vaultConfig.Redis, zapLogger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create redis vault: %w", err)
+		}
+		if err := s.vaults.Ping(); err != nil {
+			extLogger.Warn("Redis vault is not reachable yet", zap.Error(err))
+		}
+		extLogger.Info("Redis vault enabled", zap.String("addr", vaultConfig.Redis.Addr))
+	default:
+		return nil, fmt.Errorf("invalid vault.backend %q (expected %q or %q)", vaultConfig.Backend, vault.BackendMemory, vault.BackendRedis)
+	}
+
+	// Audit webhook for PII detection events (optional)
+	auditConfig, err := config.LoadAuditConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load audit config: %w", err)
+	}
+	if auditConfig.WebhookURL != "" {
+		s.audit = audit.NewEmitter(*auditConfig, zapLogger)
+		extLogger.Info("Audit webhook enabled", zap.Int("rules", len(auditConfig.Rules)))
+	}
+
+	// OpenTelemetry tracing (no-op unless an OTLP endpoint is set in the environment)
+	s.tracerProvider, err = tracing.NewProviderFromEnv(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	if s.tracerProvider != nil {
+		otel.SetTracerProvider(s.tracerProvider)
+		extLogger.Info("Tracing enabled")
+	}
+
+	// TLS termination (optional); certificates are reloaded when the files change
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize mux
+	s.mux = s.setupRoutes()
+
+	s.server = &http.Server{
+		Addr:         s.addr,
+		Handler:      s.mux,
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: s.writeTimeout,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	return s, nil
+}
 
-	// Load engine configuration
+// buildEngine loads the engine configuration and creates a validated engine from it
+func (s *HTTPServer) buildEngine() (*engine.Engine, error) {
 	engineConfig, err := config.LoadEngineConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load engine config: %w", err)
 	}
+	if err := config.Validate(engineConfig, s.auth != nil); err != nil {
+		return nil, fmt.Errorf("invalid engine config:\n%w", err)
+	}
 
-	// Create transformation engine
 	eng, err := engine.NewEngine(engineConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create engine: %w", err)
 	}
+	eng.SetLogger(s.logger.Logger)
 
-	extLogger.Info("Engine initialized",
+	s.logger.Info("Engine initialized",
 		zap.Int("routes", len(engineConfig.Routes)),
 	)
 
 	// Log configured routes
 	for _, route := range engineConfig.Routes {
-		extLogger.Info("Route configured",
+		s.logger.Info("Route configured",
 			zap.String("id", route.ID),
 			zap.String("upstream", route.Upstream.BaseURL),
+			zap.Int("upstreams", len(route.Upstreams)),
 			zap.Int("transforms", len(route.Transforms)),
 		)
 	}
 
-	s := &HTTPServer{
-		Server:   baseServer,
-		pipeline: pipeline,
-		engine:   eng,
-		logger:   extLogger,
-	}
+	return eng, nil
+}
 
-	// Initialize mux
-	s.mux = s.setupRoutes()
+// Reload re-reads the configuration and atomically swaps in a freshly built engine.
+// If the new configuration is invalid the current engine is kept and the error returned.
+// In-flight requests finish on the engine (and route) they started with.
+func (s *HTTPServer) Reload() error {
+	if err := config.Reload(); err != nil {
+		return err
+	}
+	eng, err := s.buildEngine()
+	if err != nil {
+		return err
+	}
+	s.engine.Store(eng)
+	return nil
+}
 
-	return s, nil
+// watchReload reloads the engine on every SIGHUP until stop is closed
+func (s *HTTPServer) watchReload(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := s.Reload(); err != nil {
+				s.logger.Error("Config reload failed, keeping current engine", zap.Error(err))
+				continue
+			}
+			s.logger.Info("Engine reloaded")
+		case <-stop:
+			return
+		}
+	}
 }
 
 // setupRoutes creates and configures the HTTP routes
@@ -91,8 +296,22 @@ func (s *HTTPServer) setupRoutes() *http.ServeMux {
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
-	// Gateway endpoint for LLM requests
+	// Prometheus metrics from the default registry
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// Gateway endpoints for LLM requests
 	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/embeddings", s.handleEmbeddings)
+
+	// Dry-run redaction: no upstream call (behind the gateway API key when auth is enabled)
+	mux.HandleFunc("POST /v1/scan", s.requireAuth(s.handleScan))
+
+	// Unmask a callback of an earlier request from the shared vault (redis backend only)
+	mux.HandleFunc("POST /v1/unmask", s.requireAuth(s.handleUnmask))
+
+	// Admin introspection (behind the gateway API key when auth is enabled)
+	mux.HandleFunc("GET /admin/routes", s.requireAuth(s.handleAdminRoutes))
+	mux.HandleFunc("GET /admin/health/detailed", s.requireAuth(s.handleAdminHealth))
 
 	// Root endpoint
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -108,23 +327,26 @@ func (s *HTTPServer) Handler() http.Handler {
 	return s.mux
 }
 
-// Start starts the HTTP server with gateway endpoints
+// Start starts the HTTP server with gateway endpoints and blocks until SIGINT/SIGTERM,
+// then shuts down gracefully
 func (s *HTTPServer) Start() error {
-	s.server = &http.Server{
-		Addr:         s.addr,
-		Handler:      s.mux,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
 	}
 
 	// Graceful shutdown
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	// Hot-reload the engine configuration on SIGHUP
+	stopReload := make(chan struct{})
+	defer close(stopReload)
+	go s.watchReload(stopReload)
+
 	go func() {
-		s.logger.Info("Starting AIGis", zap.String("addr", s.addr))
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Info("Starting AIGis", zap.String("addr", s.addr), zap.Bool("tls", s.server.TLSConfig != nil))
+		if err := s.Serve(ln); err != nil && err != http.ErrServerClosed {
 			s.logger.Fatal("Server error", zap.Error(err))
 		}
 	}()
@@ -134,32 +356,121 @@ func (s *HTTPServer) Start() error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	return s.Shutdown(ctx)
+}
 
-	return s.server.Shutdown(ctx)
+// Serve accepts connections on ln until Shutdown is called, terminating TLS when
+// server.tls is enabled. It returns http.ErrServerClosed after a graceful shutdown.
+func (s *HTTPServer) Serve(ln net.Listener) error {
+	if s.server.TLSConfig != nil {
+		return s.server.ServeTLS(ln, "", "")
+	}
+	return s.server.Serve(ln)
 }
 
-// handleChatCompletions processes LLM requests through the engine
+// Shutdown gracefully stops the listener, waiting for in-flight requests until ctx is done,
+// then flushes audit events and traces and closes the vault connections
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if s.audit != nil {
+		s.audit.Close()
+	}
+	if s.vaults != nil {
+		s.vaults.Close()
+	}
+	if s.tracerProvider != nil {
+		s.tracerProvider.Shutdown(ctx)
+	}
+	return err
+}
+
+// handleChatCompletions processes chat completion requests through the engine
 func (s *HTTPServer) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	s.handleLLMRequest(w, r, "/v1/chat/completions", false)
+}
+
+// handleEmbeddings processes embedding requests through the engine: the same routing,
+// transforms (PII steps mask "input") and provider flow, sent to the upstream's embeddings path
+func (s *HTTPServer) handleEmbeddings(w http.ResponseWriter, r *http.Request) {
+	s.handleLLMRequest(w, r, "/v1/embeddings", true)
+}
+
+// handleLLMRequest runs a request for the given client path through auth, the pipeline,
+// route matching and the route's provider
+func (s *HTTPServer) handleLLMRequest(w http.ResponseWriter, r *http.Request, path string, embeddings bool) {
+	// Record request count and latency once the response is complete (streams included)
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	var routeID string
+	defer func() { observeRequest(routeID, rec.status, start) }()
+
+	// Root span for the request, joining the caller's trace when a traceparent is present
+	spanCtx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), "POST "+path, tracing.KindServer)
+	defer func() {
+		span.SetAttributes(tracing.Int("http.response.status_code", rec.status))
+		span.End()
+	}()
+	r = r.WithContext(spanCtx)
+
 	// Only accept POST requests
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "Method not allowed", "invalid_request_error")
+		return
+	}
+
+	// Identify the client before authorization removes the gateway key from the headers
+	client := clientKey(r)
+
+	// Reject clients without an accepted gateway API key before doing any work
+	if !s.authorize(w, r) {
 		return
 	}
 
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
-	// Read the raw body into []byte
+	// Read the raw body into []byte, refusing oversized bodies before any work is done on them
+	if s.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.logger.Warn("Request body too large", zap.Int64("limit", tooLarge.Limit))
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit), "invalid_request_error")
+			return
+		}
 		s.logger.Error("Failed to read body", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Failed to read body: %v", err), http.StatusBadRequest)
+		writeError(w, core.NewValidationError("failed to read request body", err))
 		return
 	}
 
+	// Some clients prepend a UTF-8 BOM or whitespace, which stricter parsers reject
+	body = trimBodyPrefix(body)
+
+	// Enforce the per-client concurrent stream limit; the slot is held until the response completes
+	// or the client disconnects (the handler returns in both cases)
+	streaming := !embeddings && gjson.GetBytes(body, "stream").Bool()
+	if streaming {
+		release, ok := s.streams.acquire(client)
+		if !ok {
+			s.logger.Warn("Too many concurrent streams", zap.String("client", client))
+			writeError(w, core.NewGatewayError(core.ErrCategoryValidation, http.StatusTooManyRequests, "too many concurrent streams", nil))
+			return
+		}
+		defer release()
+	}
+
 	// Generate request and trace IDs
 	requestID := generateRequestID()
 	traceID := uuid.New().String()
+	if span.IsRecording() {
+		// Use the ID the trace is known by in the collector
+		traceID = span.SpanContext().TraceID().String()
+	}
 
 	// Create a logger with request context
 	reqLogger := s.logger.With(
@@ -171,51 +482,123 @@ func (s *HTTPServer) handleChatCompletions(w http.ResponseWriter, r *http.Reques
 	ctx := core.NewGatewayContext(r.Context(), reqLogger.Logger)
 	ctx.RequestID = requestID
 	ctx.TraceID = traceID
+	if s.vaults != nil {
+		// Keyed by the server-generated request ID: the trace ID comes from the client's
+		// traceparent, so a client reusing one would share another request's secrets
+		ctx.SetVault(s.vaults.ForRequest(requestID))
+		// The client needs the ID to unmask callbacks of this request on any instance
+		w.Header().Set(requestIDHeader, requestID)
+	}
+	ctx.SetVaultLimit(s.maxVaultEntries, s.failOnVaultOverflow)
+	ctx.SetMetadata(core.MetaRequestMethod, r.Method)
+	ctx.SetMetadata(core.MetaRequestPath, path)
+
+	// Failed and streamed requests never reach the response pipeline below; run it once the
+	// handler is done so the request logger still reports their final status
+	responsePipelineRan := false
+	defer func() {
+		if !responsePipelineRan {
+			ctx.SetMetadata(core.MetaResponseStatus, rec.status)
+			s.pipeline.ExecuteResponse(ctx, nil)
+		}
+	}()
+
+	// Find the matching route before the request pipeline runs: the request logger sanitizes
+	// logged bodies with the route's scanner, so its custom rules apply
+	_, matchSpan := tracing.Start(ctx, "route.match", tracing.KindInternal)
+	route, matchErr := s.engine.Load().FindRoute(body, r.Header)
+	tracing.RecordError(matchSpan, matchErr)
+	ctx.SetSanitizer(s.defaultScanner)
+	if route != nil {
+		matchSpan.SetAttributes(tracing.String("aigis.route_id", route.ID))
+		// Routes from the engine always have a scanner; the provider reports an invalid one
+		if scanner, err := route.PIIScanner(); err == nil {
+			ctx.SetSanitizer(scanner)
+		}
+	}
+	matchSpan.End()
 
 	// Execute the pipeline for request logging
 	processedBody, err := s.pipeline.ExecuteRequest(ctx, body)
 	if err != nil {
 		reqLogger.Error("Pipeline error", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Pipeline error: %v", err), http.StatusInternalServerError)
+		writeRequestError(w, ctx, err)
 		return
 	}
 
-	// Find matching route using engine
-	route, err := s.engine.FindRoute(processedBody)
-	if err != nil {
-		reqLogger.Error("Route matching error", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Route matching error: %v", err), http.StatusBadRequest)
+	if matchErr != nil {
+		reqLogger.Error("Route matching error", zap.Error(matchErr))
+		writeRequestError(w, ctx, core.NewValidationError("request body is not valid JSON", matchErr))
 		return
 	}
 
 	if route == nil {
 		reqLogger.Warn("No matching route found")
-		http.Error(w, "No matching route configured", http.StatusNotFound)
+		writeRequestError(w, ctx, core.NewGatewayError(core.ErrCategoryValidation, http.StatusNotFound, "No matching route configured", nil))
 		return
 	}
 
+	routeID = route.ID
+	span.SetAttributes(
+		tracing.String("aigis.route_id", route.ID),
+		tracing.String("gen_ai.request.model", gjson.GetBytes(processedBody, "model").String()),
+	)
+	defer func() { span.SetAttributes(tracing.Int("aigis.masked_secrets", ctx.MaskedTotal())) }()
+
+	// Enforce the route's max_concurrency; the deferred release frees the slot on every exit
+	// path (errors, panics and finished streams included)
+	release, ok := route.AcquireSlot(r.Context())
+	if !ok {
+		reqLogger.Warn("Route concurrency limit reached", zap.String("route_id", route.ID), zap.Int("max_concurrency", route.MaxConcurrency))
+		writeRequestError(w, ctx, core.NewGatewayError(core.ErrCategoryValidation, http.StatusTooManyRequests, "route concurrency limit reached", nil))
+		return
+	}
+	defer release()
+
+	// Create universal provider for this route (picks the upstream for this request)
+	provider := providers.NewUniversalProvider(route, reqLogger)
+	provider.SetObserveOnly(s.mode == core.ModeObserve)
+	provider.SetClientIP(clientIP(r))
+	provider.SetEmbeddings(embeddings)
+
 	reqLogger.Info("Route matched",
 		zap.String("route_id", route.ID),
-		zap.String("upstream", route.Upstream.BaseURL),
+		zap.String("upstream", provider.Upstream().BaseURL),
 	)
 
-	// Create universal provider for this route
-	provider := providers.NewUniversalProvider(route, reqLogger)
+	if streaming {
+		s.streamChatCompletions(w, ctx, route.ID, provider, processedBody, r.Header, reqLogger)
+		return
+	}
+
+	// The server-wide write timeout would cut off slow generations; give the response as long
+	// as the route's upstreams (retries and fallback included) may take
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(route.ResponseTimeout() + s.writeTimeout))
 
 	// Send request through provider (includes transforms and header handling)
 	// Pass the AIGisContext (ctx) instead of r.Context() for bidirectional tokenization
 	resp, err := provider.Send(ctx, processedBody, r.Header)
+
+	// Emit audit events for detected PII (async, never blocks the request)
+	if s.audit != nil {
+		s.audit.Emit(ctx, route.ID)
+	}
+
+	copyResponseHeaders(w, ctx)
+
 	if err != nil {
 		reqLogger.Error("Provider error", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Provider error: %v", err), http.StatusBadGateway)
+		writeRequestError(w, ctx, err)
 		return
 	}
 
 	// Execute the pipeline for response processing (logging)
+	responsePipelineRan = true
+	ctx.SetMetadata(core.MetaResponseStatus, http.StatusOK)
 	finalResp, err := s.pipeline.ExecuteResponse(ctx, resp)
 	if err != nil {
 		reqLogger.Error("Response pipeline error", zap.Error(err))
-		http.Error(w, fmt.Sprintf("Response pipeline error: %v", err), http.StatusInternalServerError)
+		writeRequestError(w, ctx, err)
 		return
 	}
 
@@ -224,7 +607,135 @@ func (s *HTTPServer) handleChatCompletions(w http.ResponseWriter, r *http.Reques
 	w.Write(finalResp)
 }
 
-// generateRequestID generates a simple request ID for tracking
+// streamChatCompletions relays an upstream SSE stream to the client, flushing each event.
+// Errors before the first chunk are returned as regular error responses.
+func (s *HTTPServer) streamChatCompletions(w http.ResponseWriter, ctx *core.AIGisContext, routeID string, provider core.Provider, body []byte, headers http.Header, reqLogger *logger.Logger) {
+	chunks, err := provider.Stream(ctx, body, headers)
+
+	// Emit audit events for detected PII (async, never blocks the request)
+	if s.audit != nil {
+		s.audit.Emit(ctx, routeID)
+	}
+
+	copyResponseHeaders(w, ctx)
+
+	if err != nil {
+		reqLogger.Error("Provider stream error", zap.Error(err))
+		writeRequestError(w, ctx, err)
+		return
+	}
+
+	// The server-wide write timeout would cut long streams short; the request context bounds them
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	// Raw streams (e.g. Claude's named events) arrive as complete frames and end on their own
+	raw := false
+	if streamer, ok := provider.(core.RawStreamer); ok {
+		raw = streamer.RawStream()
+	}
+
+	for chunk := range chunks {
+		var err error
+		if raw {
+			_, err = w.Write(chunk)
+		} else {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		if err != nil {
+			reqLogger.Warn("Client stream write failed", zap.Error(err))
+			return
+		}
+		rc.Flush()
+	}
+
+	if !raw && ctx.Err() == nil {
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		rc.Flush()
+	}
+}
+
+// copyResponseHeaders adds the headers the provider wants returned to the client
+// (e.g. normalized rate limits)
+func copyResponseHeaders(w http.ResponseWriter, ctx *core.AIGisContext) {
+	for key, values := range ctx.ResponseHeaders() {
+		// Replace rather than append, e.g. the default Content-Type for non-JSON pass-through
+		w.Header().Del(key)
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
+
+// writeRequestError records err on the request context for the request logger, then writes it
+func writeRequestError(w http.ResponseWriter, ctx *core.AIGisContext, err error) {
+	ctx.SetMetadata(core.MetaError, core.AsGatewayError(err).Message)
+	writeError(w, err)
+}
+
+// writeError maps err to its GatewayError status and writes the client-safe message
+func writeError(w http.ResponseWriter, err error) {
+	gwErr := core.AsGatewayError(err)
+	writeOpenAIError(w, gwErr.Status, gwErr.Message, openAIErrorType(gwErr))
+}
+
+// openAIError is the error envelope OpenAI SDKs expect
+type openAIError struct {
+	Error openAIErrorDetail `json:"error"`
+}
+
+type openAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// writeOpenAIError writes an OpenAI-style JSON error body with the given status
+func writeOpenAIError(w http.ResponseWriter, status int, message, errType string) {
+	body, _ := json.Marshal(openAIError{Error: openAIErrorDetail{Message: message, Type: errType}})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// openAIErrorType maps a gateway error to the closest OpenAI error type
+func openAIErrorType(gwErr *core.GatewayError) string {
+	switch gwErr.Status {
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	}
+	switch gwErr.Category {
+	case core.ErrCategoryValidation:
+		return "invalid_request_error"
+	case core.ErrCategoryAuth:
+		return "authentication_error"
+	case core.ErrCategoryUpstream:
+		return "upstream_error"
+	case core.ErrCategoryTimeout:
+		return "timeout_error"
+	default:
+		return "server_error"
+	}
+}
+
+// trimBodyPrefix strips a leading UTF-8 byte order mark and whitespace from a request body
+func trimBodyPrefix(body []byte) []byte {
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	return bytes.TrimLeft(body, " \t\r\n")
+}
+
+// generateRequestID generates a random request ID for tracking. It keys the request's shared
+// vault, so it must not be guessable.
 func generateRequestID() string {
-	return fmt.Sprintf("req_%d", time.Now().UnixNano())
+	return "req_" + strings.ReplaceAll(uuid.NewString(), "-", "")
 }
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"aigis/internal/pkg/metrics"
+)
+
+// statusRecorder captures the response status for request metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController (flushing, write deadlines)
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// observeRequest records the request count and latency for a finished request
+func observeRequest(routeID string, status int, start time.Time) {
+	if routeID == "" {
+		routeID = metrics.RouteUnmatched
+	}
+	code := strconv.Itoa(status)
+	metrics.RequestsTotal.WithLabelValues(routeID, code).Inc()
+	metrics.RequestDuration.WithLabelValues(routeID, code).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"aigis/internal/core"
+)
+
+// scanMatch reports how many values one rule redacted
+type scanMatch struct {
+	Rule  string `json:"rule"`
+	Count int    `json:"count"`
+}
+
+// scanResponse is the body returned by POST /v1/scan. Sanitized is a string for text input
+// and the sanitized messages array for a messages body.
+type scanResponse struct {
+	Route     string      `json:"route,omitempty"`
+	Sanitized interface{} `json:"sanitized"`
+	Matches   []scanMatch `json:"matches"`
+}
+
+// handleScan redacts {"text": "..."} or the message contents of a chat completions body and
+// reports the matches per rule, without calling an upstream. When the body matches a route
+// (e.g. it carries a model) that route's scanner and scanner.tags are used, so its custom rules,
+// enable_rules, tags and allowlist can be checked in CI; otherwise the built-in rules apply.
+func (s *HTTPServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	if s.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit), "invalid_request_error")
+			return
+		}
+		writeError(w, core.NewValidationError("failed to read request body", err))
+		return
+	}
+	body = trimBodyPrefix(body)
+	if !gjson.ValidBytes(body) {
+		writeError(w, core.NewValidationError("request body is not valid JSON", nil))
+		return
+	}
+
+	var resp scanResponse
+	scanner := s.defaultScanner
+	var tags []string
+	if route, err := s.engine.Load().FindRoute(body, r.Header); err == nil && route != nil {
+		resp.Route = route.ID
+		// Report what the route's PII steps mask: the same rules scanner.tags selects
+		tags = route.ScannerTags()
+		if scanner, err = route.PIIScanner(); err != nil {
+			writeError(w, core.NewInternalError("invalid scanner configuration", err))
+			return
+		}
+	}
+
+	counts := make(map[string]int)
+	sanitize := func(text string) string {
+		// Only the per-rule counts are returned; the report's original values never leave the gateway
+		sanitized, matches := scanner.SanitizeWithReportTags(text, tags)
+		for _, match := range matches {
+			counts[match.Rule]++
+		}
+		return sanitized
+	}
+
+	if text := gjson.GetBytes(body, "text"); text.Type == gjson.String {
+		resp.Sanitized = sanitize(text.String())
+	} else if messages := gjson.GetBytes(body, "messages"); messages.IsArray() {
+		sanitized, err := sanitizeMessages(messages, sanitize)
+		if err != nil {
+			writeError(w, core.NewInternalError("failed to sanitize messages", err))
+			return
+		}
+		resp.Sanitized = json.RawMessage(sanitized)
+	} else {
+		writeError(w, core.NewValidationError(`request must contain a "text" string or a "messages" array`, nil))
+		return
+	}
+
+	resp.Matches = make([]scanMatch, 0, len(counts))
+	for rule, count := range counts {
+		resp.Matches = append(resp.Matches, scanMatch{Rule: rule, Count: count})
+	}
+	sort.Slice(resp.Matches, func(i, j int) bool { return resp.Matches[i].Rule < resp.Matches[j].Rule })
+	writeJSON(w, resp)
+}
+
+// sanitizeMessages applies sanitize to every string content and text content part of an
+// OpenAI messages array, returning the rewritten array
+func sanitizeMessages(messages gjson.Result, sanitize func(string) string) ([]byte, error) {
+	out := []byte(messages.Raw)
+	var err error
+	messages.ForEach(func(i, message gjson.Result) bool {
+		content := message.Get("content")
+		if content.Type == gjson.String {
+			out, err = sjson.SetBytes(out, fmt.Sprintf("%d.content", i.Int()), sanitize(content.String()))
+			return err == nil
+		}
+		content.ForEach(func(j, part gjson.Result) bool {
+			if text := part.Get("text"); part.Get("type").String() == "text" && text.Type == gjson.String {
+				out, err = sjson.SetBytes(out, fmt.Sprintf("%d.content.%d.text", i.Int(), j.Int()), sanitize(text.String()))
+			}
+			return err == nil
+		})
+		return err == nil
+	})
+	return out, err
+}
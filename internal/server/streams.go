@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// streamLimiter caps the number of concurrent open streams per client
+type streamLimiter struct {
+	max    int
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// newStreamLimiter creates a limiter allowing max concurrent streams per client (max <= 0 disables it)
+func newStreamLimiter(max int) *streamLimiter {
+	return &streamLimiter{
+		max:    max,
+		active: make(map[string]int),
+	}
+}
+
+// acquire reserves a stream slot for key. It returns a release function (safe to call
+// more than once) and false if the client already has the maximum number of open streams.
+func (l *streamLimiter) acquire(key string) (func(), bool) {
+	if l == nil || l.max <= 0 {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key] >= l.max {
+		return nil, false
+	}
+	l.active[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[key]--; l.active[key] <= 0 {
+				delete(l.active, key)
+			}
+		})
+	}, true
+}
+
+// clientKey identifies the client for per-client limits: its API key when present
+// (hashed, so secrets are never held in limiter state), otherwise its IP address
+func clientKey(r *http.Request) string {
+	credential := r.Header.Get("Authorization")
+	if credential == "" {
+		credential = r.Header.Get("x-api-key")
+	}
+	if credential != "" {
+		sum := sha256.Sum256([]byte(strings.TrimSpace(credential)))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the IP address of the request's direct peer
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
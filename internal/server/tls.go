@@ -0,0 +1,33 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"aigis/internal/pkg/certs"
+)
+
+// loadTLSConfig builds the listener TLS config from server.tls.* (nil when TLS is disabled).
+// The paths may also come from AIGIS_SERVER_TLS_CERT_FILE / AIGIS_SERVER_TLS_KEY_FILE.
+// The certificate is reloaded when the files change.
+func loadTLSConfig() (*tls.Config, error) {
+	if !viper.GetBool("server.tls.enabled") {
+		return nil, nil
+	}
+	certFile := viper.GetString("server.tls.cert_file")
+	keyFile := viper.GetString("server.tls.key_file")
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("server.tls.enabled requires server.tls.cert_file and server.tls.key_file")
+	}
+
+	reloader, err := certs.NewReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
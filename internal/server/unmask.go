@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/tidwall/gjson"
+	"go.uber.org/zap"
+
+	"aigis/internal/core"
+)
+
+// requestIDHeader carries the gateway request ID when the Redis vault is enabled, so the
+// client can unmask callbacks of the request on any instance
+const requestIDHeader = "X-AIGis-Request-ID"
+
+// requestIDPattern matches the IDs generated by generateRequestID
+var requestIDPattern = regexp.MustCompile(`^req_[0-9a-f]{32}$`)
+
+// unmaskResponse is the body returned by POST /v1/unmask
+type unmaskResponse struct {
+	Text string `json:"text"`
+}
+
+// handleUnmask restores the placeholders of {"request_id": "...", "text": "..."} from the
+// shared vault of that request, e.g. for an async callback or a resumed stream that lands on
+// another instance than the one that masked the request. The request ID comes from the
+// X-AIGis-Request-ID header of the original response; only the Redis vault backend keeps
+// mappings beyond the request.
+func (s *HTTPServer) handleUnmask(w http.ResponseWriter, r *http.Request) {
+	if s.vaults == nil {
+		writeError(w, core.NewGatewayError(core.ErrCategoryValidation, http.StatusNotFound,
+			"unmasking by request ID requires the redis vault backend (vault.backend)", nil))
+		return
+	}
+
+	if s.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds the %d byte limit", tooLarge.Limit), "invalid_request_error")
+			return
+		}
+		writeError(w, core.NewValidationError("failed to read request body", err))
+		return
+	}
+	body = trimBodyPrefix(body)
+
+	requestID := gjson.GetBytes(body, "request_id").String()
+	text := gjson.GetBytes(body, "text")
+	if !requestIDPattern.MatchString(requestID) || text.Type != gjson.String {
+		writeError(w, core.NewValidationError(`request must contain a gateway "request_id" and a "text" string`, nil))
+		return
+	}
+
+	ctx := core.NewGatewayContext(context.Background(), s.logger.Logger)
+	ctx.RequestID = requestID
+	ctx.SetVault(s.vaults.Open(requestID))
+	unmasked, stats := s.defaultScanner.UnmaskWithStats(ctx, text.String())
+	if stats.Missed > 0 {
+		s.logger.Warn("Placeholders not found in the request's vault",
+			zap.String("request_id", requestID),
+			zap.Int("missed", stats.Missed),
+		)
+	}
+	writeJSON(w, unmaskResponse{Text: unmasked})
+}
@@ -0,0 +1,341 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestMaxStreamsPerClient(t *testing.T) {
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`
+server:
+  max_streams_per_client: 2
+engine:
+  routes:
+    - id: "stream"
+      matcher:
+        model: ".*"
+      upstream:
+        base_url: %q
+`, upstream.URL))
+
+	send := func(key string) (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/chat/completions",
+			strings.NewReader(`{"model":"gpt-4","stream":true,"messages":[]}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		return http.DefaultClient.Do(req)
+	}
+
+	// 打开两个流，等待它们都到达上游（仍处于打开状态）
+	results := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := send("client-a")
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(2 * time.Second):
+			t.Fatal("流式请求未到达上游")
+		}
+	}
+
+	// 第三个流超出限制，应被拒绝
+	resp, err := send("client-a")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("期望状态 429，得到 %d", resp.StatusCode)
+	}
+
+	// 其他客户端不受影响
+	go func() {
+		if resp, err := send("client-b"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Error("其他客户端的流式请求应被允许")
+	}
+
+	// 之前的流仍然正常完成
+	close(release)
+	for i := 0; i < 2; i++ {
+		if status := <-results; status != http.StatusOK {
+			t.Errorf("已打开的流应正常完成，得到状态 %d", status)
+		}
+	}
+
+	// 流结束后名额释放
+	resp, err = send("client-a")
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("流结束后应允许新的流，得到状态 %d", resp.StatusCode)
+	}
+}
+
+func TestChatCompletionsStreaming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`
+engine:
+  routes:
+    - id: "stream"
+      matcher:
+        model: ".*"
+      upstream:
+        base_url: %q
+`, upstream.URL))
+
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("期望状态 200，得到 %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("期望 Content-Type 为 text/event-stream，得到 %q", ct)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	events := strings.Split(strings.TrimSpace(string(body)), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("期望 3 个事件，得到 %d: %s", len(events), body)
+	}
+	if !strings.Contains(events[0], `"content":"Hi"`) || events[2] != "data: [DONE]" {
+		t.Errorf("事件内容不符合预期: %s", body)
+	}
+}
+
+// Claude 格式的路由按原始 SSE 帧转发：保留 event: 行，不追加 [DONE]，跨帧拆分的占位符被还原
+func TestClaudeStreamingRestoresPlaceholders(t *testing.T) {
+	upstreamSaw := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		placeholder := gjson.GetBytes(body, "messages.0.content").String()
+		upstreamSaw <- placeholder
+
+		// 占位符被拆到两个 text_delta 事件中
+		half := len(placeholder) / 2
+		delta := func(text string) string {
+			return fmt.Sprintf("event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":%q}}\n\n", text)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\"}\n\n")
+		fmt.Fprint(w, delta("Mail "+placeholder[:half]))
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, delta(placeholder[half:]+" now"))
+		fmt.Fprint(w, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`
+engine:
+  routes:
+    - id: "claude"
+      matcher:
+        model: "claude-.*"
+      upstream:
+        base_url: %q
+      transforms:
+        - type: "pii_claude"
+`, upstream.URL))
+
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"claude-3","stream":true,"messages":[{"role":"user","content":"alice@example.com"}]}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("期望状态 200，得到 %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	if placeholder := <-upstreamSaw; strings.Contains(placeholder, "alice@example.com") {
+		t.Fatalf("上游不应收到原始邮箱: %s", placeholder)
+	}
+	var text strings.Builder
+	for _, line := range strings.Split(string(body), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			text.WriteString(gjson.Get(data, "delta.text").String())
+		}
+	}
+	if got := text.String(); got != "Mail alice@example.com now" {
+		t.Errorf("期望还原后的文本，得到 %q\n%s", got, body)
+	}
+	for _, want := range []string{"event: message_start\n", "event: content_block_delta\n", "event: message_stop\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("缺少 %q: %s", want, body)
+		}
+	}
+	if strings.Contains(string(body), "[DONE]") || strings.Contains(string(body), "data: event:") {
+		t.Errorf("Claude 流应原样转发事件帧: %s", body)
+	}
+}
+
+func TestChatCompletionsStreamingUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":{"message":"boom"}}`))
+	}))
+	defer upstream.Close()
+
+	ts := newTestServerWithConfig(t, fmt.Sprintf(`
+engine:
+  routes:
+    - id: "stream"
+      matcher:
+        model: ".*"
+      upstream:
+        base_url: %q
+`, upstream.URL))
+
+	resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4","stream":true,"messages":[]}`))
+	if err != nil {
+		t.Fatalf("请求失败: %v", err)
+	}
+	resp.Body.Close()
+
+	// 首个分片之前的上游错误以普通错误响应返回
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("期望状态 502，得到 %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("错误响应不应使用 SSE，得到 %q", ct)
+	}
+}
+
+func TestRouteMaxConcurrency(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		// 部分请求以上游错误结束，确保错误路径同样释放并发槽位
+		if r.Header.Get("X-Test-Fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"message":"bad"}}`))
+			return
+		}
+		w.Write([]byte(`{"choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	for _, tc := range []struct {
+		name   string
+		waitMs int
+	}{
+		{"reject", 0},
+		{"wait", 5000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			peak.Store(0)
+			ts := newTestServerWithConfig(t, fmt.Sprintf(`
+engine:
+  routes:
+    - id: "limited"
+      matcher:
+        model: ".*"
+      max_concurrency: 2
+      concurrency_wait_ms: %d
+      upstream:
+        base_url: %q
+      header_policy:
+        allow: ["x-test-*"]
+`, tc.waitMs, upstream.URL))
+
+			// 并发发送超过上限的请求
+			const total = 8
+			statuses := make(chan int, total)
+			var wg sync.WaitGroup
+			for i := 0; i < total; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					headers := map[string]string{}
+					if i%2 == 0 {
+						headers["X-Test-Fail"] = "1"
+					}
+					resp := postWithHeaders(t, ts.URL, headers)
+					resp.Body.Close()
+					statuses <- resp.StatusCode
+				}(i)
+			}
+			wg.Wait()
+			close(statuses)
+
+			if got := peak.Load(); got > 2 {
+				t.Errorf("上游同时处理的请求数为 %d，超过 max_concurrency 2", got)
+			}
+			rejected := 0
+			for status := range statuses {
+				if status == http.StatusTooManyRequests {
+					rejected++
+				}
+			}
+			if tc.waitMs == 0 && rejected == 0 {
+				t.Error("未等待模式下超出上限的请求应返回 429")
+			}
+			if tc.waitMs > 0 && rejected != 0 {
+				t.Errorf("等待模式下所有请求都应完成，得到 %d 个 429", rejected)
+			}
+
+			// 所有请求结束后槽位应全部释放
+			resp := postWithHeaders(t, ts.URL, nil)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("期望状态 200，得到 %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"aigis/internal/config"
+	"aigis/internal/pkg/logger"
+	"aigis/internal/server"
+)
+
+// writeSelfSignedCert 生成 127.0.0.1 的自签名证书并写入 certFile/keyFile，返回证书
+func writeSelfSignedCert(t *testing.T, certFile, keyFile, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("写入证书失败: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("写入私钥失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// httpsHealth 通过 HTTPS 请求 /health，仅信任 trusted 证书，返回服务端出示的证书 CN
+func httpsHealth(t *testing.T, addr string, trusted *x509.Certificate) string {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(trusted)
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		DisableKeepAlives: true,
+	}}
+
+	resp, err := client.Get("https://" + addr + "/health")
+	if err != nil {
+		t.Fatalf("HTTPS 请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("期望 200，得到 %d", resp.StatusCode)
+	}
+	return resp.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func TestTLSListener(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := writeSelfSignedCert(t, certFile, keyFile, "first")
+
+	srv, _ := newTestHTTPServer(t, `
+server:
+  tls:
+    enabled: true
+    cert_file: "`+certFile+`"
+    key_file: "`+keyFile+`"
+`)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	if cn := httpsHealth(t, ln.Addr().String(), first); cn != "first" {
+		t.Errorf("期望证书 first，得到 %q", cn)
+	}
+
+	// 原地替换证书文件后，新连接使用新证书，无需重启
+	second := writeSelfSignedCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if cn := httpsHealth(t, ln.Addr().String(), second); cn != "second" {
+		t.Errorf("证书轮换后期望 second，得到 %q", cn)
+	}
+
+	// 明文请求会被拒绝
+	if resp, err := http.Get("http://" + ln.Addr().String() + "/health"); err == nil {
+		if resp.StatusCode == http.StatusOK {
+			t.Error("TLS 监听不应接受明文 HTTP 请求")
+		}
+		resp.Body.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("优雅关闭失败: %v", err)
+	}
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve 应返回 ErrServerClosed，得到 %v", err)
+	}
+}
+
+func TestTLSRequiresCertificateFiles(t *testing.T) {
+	yaml := `
+server:
+  tls:
+    enabled: true
+    cert_file: "/nonexistent/tls.crt"
+    key_file: "/nonexistent/tls.key"
+`
+	for _, cfg := range []string{yaml, "server:\n  tls:\n    enabled: true\n"} {
+		viper.Reset()
+		loadTestConfig(t, cfg)
+		log, _ := logger.New("info")
+		if _, err := server.NewHTTPServer(":0", log); err == nil {
+			t.Errorf("证书缺失时应启动失败，配置:\n%s", cfg)
+		}
+	}
+	viper.Reset()
+	config.Init("")
+}